@@ -5,7 +5,10 @@ import (
 	"github.com/foldn/bi-go/internal/config"     // Update
 	"github.com/foldn/bi-go/internal/database"   // Update
 	"github.com/foldn/bi-go/internal/repository" // Update
+	"github.com/foldn/bi-go/internal/secrets"
 	"github.com/foldn/bi-go/internal/service"
+	"github.com/foldn/bi-go/internal/services"
+	"github.com/foldn/bi-go/internal/storage"
 	"log"
 )
 
@@ -29,13 +32,51 @@ func main() {
 	log.Println("Database connected and migrated successfully.")
 
 	// 3. Initialize Repositories
-	dsRepo := repository.NewDataSourceRepository(db)
+	keyring, err := secrets.NewKeyring(cfg.Security)
+	if err != nil {
+		log.Fatalf("Failed to load credential encryption keys: %v", err)
+	}
+	dsRepo := repository.NewDataSourceRepository(db, keyring)
+	reportRepo := repository.NewReportRepository(db)
+	jobRepo := repository.NewReportJobRepository(db)
+	scheduleRepo := repository.NewReportScheduleRepository(db)
+	targetRepo := repository.NewDeliveryTargetRepository(db, keyring)
+	deliveryRepo := repository.NewReportDeliveryRepository(db)
 
 	// 4. Initialize Services
-	dsService := service.NewDataSourceService(dsRepo /*, pass other dependencies if any, like schemaService */)
+	connections := database.NewConnectionManager(cfg.Pool)
+	defer connections.Close()
+	dsService := service.NewDataSourceService(dsRepo, connections)
+	files, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatalf("Failed to initialize report file storage: %v", err)
+	}
+	generator := services.NewReportGenerator(reportRepo, dsRepo, connections, files, cfg.Report)
+	deliverer := services.NewDeliverer(targetRepo, deliveryRepo, reportRepo, jobRepo, files, cfg.Delivery)
+	if err := deliverer.Start(); err != nil {
+		log.Fatalf("Failed to start report deliverer: %v", err)
+	}
+	defer deliverer.Stop()
+	processor := services.NewProcessor(dsRepo, connections, files, cfg.Process)
+	queue := services.NewJobQueue(jobRepo, generator, processor, deliverer, cfg.Queue)
+	if err := queue.Start(); err != nil {
+		log.Fatalf("Failed to start report job queue: %v", err)
+	}
+	defer queue.Stop()
+	reportService := service.NewReportService(reportRepo, jobRepo, dsRepo, queue, files, cfg.Storage)
+	scheduler := services.NewScheduler(scheduleRepo, reportRepo, queue, cfg.Scheduler)
+	scheduler.Start()
+	defer scheduler.Stop()
+	scheduleService := service.NewScheduleService(scheduleRepo, reportRepo)
+	deliveryService := service.NewDeliveryService(targetRepo, deliveryRepo, reportRepo, jobRepo, cfg.Delivery)
+	janitor := services.NewJanitor(jobRepo, reportRepo, deliveryRepo, files, cfg.Retention)
+	janitor.Start()
+	defer janitor.Stop()
+	retentionService := service.NewRetentionService(janitor, reportRepo)
+	processService := service.NewProcessService(jobRepo, dsRepo, processor, queue, files, cfg.Process)
 
 	// 5. Setup Router (and inject services into handlers via router setup)
-	router := api.SetupRouter(dsService)
+	router := api.SetupRouter(dsService, reportService, scheduleService, deliveryService, retentionService, processService)
 	log.Printf("Starting server on port %s", cfg.Server.Port)
 
 	// 6. Start Server
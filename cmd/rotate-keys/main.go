@@ -0,0 +1,56 @@
+// rotate-keys 使用当前主密钥重新加密所有数据源凭据和投递目标的 webhook 签名密钥。
+//
+// 轮换步骤：
+//  1. 在配置中将旧的 masterkeyid/masterkey 移入 security.previouskeys；
+//  2. 设置新的 masterkeyid/masterkey；
+//  3. 执行本命令，完成后即可从 previouskeys 中移除旧密钥。
+//
+// 启用加密前以明文保存的密码也会在此过程中被加密。
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/secrets"
+)
+
+func main() {
+	configPath := flag.String("config", "./configs", "directory containing config.yaml")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	keyring, err := secrets.NewKeyring(cfg.Security)
+	if err != nil {
+		log.Fatalf("Failed to load credential encryption keys: %v", err)
+	}
+
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	if err := database.AutoMigrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	dsRepo := repository.NewDataSourceRepository(db, keyring)
+	rotated, err := dsRepo.RotateCredentials()
+	if err != nil {
+		log.Fatalf("Failed to rotate credentials, no rows were changed: %v", err)
+	}
+	log.Printf("Re-encrypted credentials of %d datasources with key %q", rotated, keyring.CurrentKeyID())
+
+	targetRepo := repository.NewDeliveryTargetRepository(db, keyring)
+	rotated, err = targetRepo.RotateSecrets()
+	if err != nil {
+		log.Fatalf("Failed to rotate delivery target secrets, no targets were changed: %v", err)
+	}
+	log.Printf("Re-encrypted secrets of %d delivery targets with key %q", rotated, keyring.CurrentKeyID())
+}
@@ -1,84 +1,154 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
 	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
 	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/secrets"
 	"github.com/foldn/bi-go/internal/services"
 	"github.com/foldn/bi-go/internal/storage"
-	"time"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func main() {
 	// 初始化配置
 	config.Init()
 
+	// 元数据库使用临时目录中的SQLite，无需外部数据库即可运行
+	dir, err := os.MkdirTemp("", "bi-go-example")
+	if err != nil {
+		log.Fatalf("创建临时目录失败: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "meta.db")), &gorm.Config{})
+	if err != nil {
+		log.Fatalf("打开元数据库失败: %v", err)
+	}
+	if err := database.AutoMigrate(db); err != nil {
+		log.Fatalf("迁移元数据库失败: %v", err)
+	}
+
+	keyring, err := newExampleKeyring()
+	if err != nil {
+		log.Fatalf("创建密钥环失败: %v", err)
+	}
+	dsRepo := repository.NewDataSourceRepository(db, keyring)
+	reportRepo := repository.NewReportRepository(db)
+	jobRepo := repository.NewReportJobRepository(db)
+
+	connections := database.NewConnectionManager(config.PoolConfig{})
+	defer connections.Close()
+	generator := services.NewReportGenerator(reportRepo, dsRepo, connections, storage.NewLocal(config.OutputDir), config.ReportConfig{})
+	queue := services.NewJobQueue(jobRepo, generator, nil, nil, config.QueueConfig{Workers: 1, PollInterval: 100 * time.Millisecond})
+	if err := queue.Start(); err != nil {
+		log.Fatalf("启动任务队列失败: %v", err)
+	}
+	defer queue.Stop()
+
 	// 创建示例数据源
-	dataSource := createExampleDataSource()
+	dataSource := createExampleDataSource(dsRepo)
 	fmt.Printf("创建数据源: %s\n", dataSource.Name)
 
 	// 创建示例报表
-	report := createExampleReport(dataSource.ID)
+	report := createExampleReport(reportRepo, dataSource.ID)
 	fmt.Printf("创建报表: %s\n", report.Name)
 
-	// 创建报表生成任务
-	job := models.NewReportJob(report.ID, "csv")
-	storage.SaveReportJob(job)
-	fmt.Printf("创建报表任务: %s, 格式: %s\n", job.ID, job.Format)
-
-	// 生成报表
-	fmt.Println("开始生成报表...")
-	services.GenerateReport(job)
+	// 校验参数值并创建报表生成任务，由队列中的 worker 执行
+	parameters, err := services.ResolveParameters(report.Parameters, map[string]interface{}{
+		"month": map[string]interface{}{"from": "2023-01-01", "to": "2023-01-31"},
+	})
+	if err != nil {
+		log.Fatalf("报表参数不合法: %v", err)
+	}
+	job, err := queue.Enqueue(report.ID, "csv", parameters)
+	if err != nil {
+		log.Fatalf("创建报表任务失败: %v", err)
+	}
+	fmt.Printf("创建报表任务: %d, 格式: %s, 参数: %v\n", job.ID, job.Format, job.Parameters)
 
 	// 等待报表生成完成
-	time.Sleep(1 * time.Second)
-
-	// 获取更新后的任务状态
-	updatedJob, _ := storage.GetReportJob(job.ID)
+	fmt.Println("开始生成报表...")
+	updatedJob := waitForJob(jobRepo, job.ID, 30*time.Second)
 	fmt.Printf("报表生成状态: %s\n", updatedJob.Status)
 
-	if updatedJob.Status == "completed" {
-		fmt.Printf("报表文件路径: %s\n", updatedJob.FilePath)
-	} else if updatedJob.Status == "failed" {
+	if updatedJob.Status == models.JobStatusCompleted {
+		fmt.Printf("报表文件: %s/%s, %d 字节, SHA-256: %s\n", config.OutputDir, updatedJob.StorageKey, updatedJob.FileSize, updatedJob.Checksum)
+	} else if updatedJob.Status == models.JobStatusFailed {
 		fmt.Printf("报表生成失败: %s\n", updatedJob.Error)
 	}
 }
 
+// 轮询任务状态直到完成、失败或超时
+func waitForJob(repo repository.ReportJobRepository, id uint, timeout time.Duration) *models.ReportJob {
+	deadline := time.Now().Add(timeout)
+	for {
+		job, err := repo.GetByID(id)
+		if err != nil {
+			log.Fatalf("获取报表任务失败: %v", err)
+		}
+		if job.Status == models.JobStatusCompleted || job.Status == models.JobStatusFailed || time.Now().After(deadline) {
+			return job
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// 使用随机主密钥创建密钥环，仅用于示例
+func newExampleKeyring() (*secrets.Keyring, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return secrets.NewKeyring(config.SecurityConfig{
+		MasterKeyID: "example",
+		MasterKey:   base64.StdEncoding.EncodeToString(key),
+	})
+}
+
 // 创建示例数据源
-func createExampleDataSource() *models.DataSource {
-	// MySQL数据源配置示例
-	configJSON := `{
-		"host": "localhost",
-		"port": 3306,
-		"username": "root",
-		"password": "password",
-		"database": "example_db"
-	}`
-
-	dataSource := models.NewDataSource(
-		"示例MySQL数据源",
-		"mysql",
-		configJSON,
-	)
-
-	storage.SaveDataSource(dataSource)
+func createExampleDataSource(repo repository.DataSourceRepository) *models.DataSource {
+	// CSV数据源，文件名（sales）即查询时使用的表名
+	dataSource := &models.DataSource{
+		Name:     "示例CSV数据源",
+		Type:     models.CSV,
+		FilePath: "examples/data/sales.csv",
+	}
+
+	if err := repo.Create(dataSource); err != nil {
+		log.Fatalf("创建数据源失败: %v", err)
+	}
 	return dataSource
 }
 
 // 创建示例报表
-func createExampleReport(dataSourceID string) *models.Report {
-	// 定义报表列
-	columns := []string{"id", "name", "value", "date"}
-
-	// 创建报表定义
-	report := models.NewReport(
-		"月度销售报表",
-		"展示每月销售数据统计",
-		dataSourceID,
-		"SELECT id, name, value, date FROM sales WHERE date >= '2023-01-01' AND date <= '2023-01-31'",
-		columns,
-	)
-
-	storage.SaveReport(report)
+func createExampleReport(repo repository.ReportRepository, dataSourceID uint) *models.Report {
+	report := &models.Report{
+		Name:         "月度销售报表",
+		Description:  "展示每月销售数据统计",
+		DataSourceID: dataSourceID,
+		Query:        "SELECT id, name, value, date FROM sales WHERE date >= :month.from AND date <= :month.to",
+		Columns:      []string{"id", "name", "value", "date"},
+		Parameters: []models.ReportParameter{
+			{Name: "month", Label: "统计月份", Type: models.ParamDateRange, Required: true},
+		},
+	}
+	if err := services.ValidateReportParameters(report.Query, report.Parameters); err != nil {
+		log.Fatalf("报表参数定义不合法: %v", err)
+	}
+
+	if err := repo.Create(report); err != nil {
+		log.Fatalf("创建报表失败: %v", err)
+	}
 	return report
 }
@@ -3,11 +3,14 @@ package api
 import (
 	"github.com/foldn/bi-go/internal/api/v1"
 	"github.com/foldn/bi-go/internal/service"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRouter(dsService service.DataSourceService /*, other services can be passed here */) *gin.Engine {
+func SetupRouter(dsService service.DataSourceService, reportService service.ReportService, scheduleService service.ScheduleService,
+	deliveryService service.DeliveryService, retentionService service.RetentionService,
+	processService service.ProcessService) *gin.Engine {
 	// gin.SetMode(gin.ReleaseMode) // Uncomment for production
 	router := gin.Default() // Includes logger and recovery middleware
 
@@ -18,6 +21,21 @@ func SetupRouter(dsService service.DataSourceService /*, other services can be p
 
 	// Instantiate handlers
 	dsHandler := v1.NewDataSourceHandler(dsService)
+	reportHandler := v1.NewReportHandler(reportService)
+	jobHandler := v1.NewJobHandler(reportService, processService)
+	scheduleHandler := v1.NewScheduleHandler(scheduleService)
+	deliveryHandler := v1.NewDeliveryHandler(deliveryService)
+	retentionHandler := v1.NewRetentionHandler(retentionService)
+
+	// 健康检查
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// 未匹配的路由同样返回统一的错误结构
+	router.NoRoute(func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, v1.ErrorResponse{Error: "Route not found"})
+	})
 
 	// Base API group
 	apiV1 := router.Group("/api/v1")
@@ -26,14 +44,70 @@ func SetupRouter(dsService service.DataSourceService /*, other services can be p
 		dsRoutes := apiV1.Group("/datasources")
 		{
 			dsRoutes.POST("", dsHandler.CreateDataSource)
+			dsRoutes.POST("/test", dsHandler.TestConnection)
 			dsRoutes.GET("", dsHandler.GetDataSources)
+			dsRoutes.GET("/pools", dsHandler.GetPoolStats)
 			dsRoutes.GET("/:id", dsHandler.GetDataSourceByID)
 			dsRoutes.PUT("/:id", dsHandler.UpdateDataSource)
 			dsRoutes.DELETE("/:id", dsHandler.DeleteDataSource)
 			dsRoutes.GET("/:id/schema", dsHandler.GetDataSourceSchema)
 			dsRoutes.GET("/:id/schema/:entity_name", dsHandler.GetDataSourceEntitySchema)
+			dsRoutes.GET("/:id/pool", dsHandler.GetDataSourcePoolStats)
+			dsRoutes.POST("/:id/test", dsHandler.TestDataSourceConnection)
+		}
+
+		// Report routes
+		reportRoutes := apiV1.Group("/reports")
+		{
+			reportRoutes.POST("", reportHandler.CreateReport)
+			reportRoutes.GET("", reportHandler.GetReports)
+			reportRoutes.GET("/:id", reportHandler.GetReportByID)
+			reportRoutes.PUT("/:id", reportHandler.UpdateReport)
+			reportRoutes.DELETE("/:id", reportHandler.DeleteReport)
+
+			// 报表生成和下载
+			reportRoutes.POST("/:id/generate", reportHandler.GenerateReport)
+			reportRoutes.GET("/:id/status", reportHandler.GetReportStatus)
+			reportRoutes.GET("/:id/download", reportHandler.DownloadReport)
+		}
+
+		// Report job routes
+		jobRoutes := apiV1.Group("/jobs")
+		{
+			jobRoutes.GET("", jobHandler.GetJobs)
+			jobRoutes.POST("/process", jobHandler.ProcessData)
+			jobRoutes.POST("/process/explain", jobHandler.ExplainProcess)
+			jobRoutes.GET("/:id", jobHandler.GetJobByID)
+			jobRoutes.GET("/:id/status", jobHandler.GetJobStatus)
+			jobRoutes.GET("/:id/result", jobHandler.GetJobResult)
+			jobRoutes.GET("/:id/events", jobHandler.GetJobEvents)
+			jobRoutes.POST("/:id/cancel", jobHandler.CancelJob)
+			jobRoutes.GET("/:id/download", jobHandler.DownloadJob)
+			jobRoutes.GET("/:id/deliveries", deliveryHandler.GetJobDeliveries)
+		}
+
+		// Report schedule routes
+		scheduleRoutes := apiV1.Group("/schedules")
+		{
+			scheduleRoutes.POST("", scheduleHandler.CreateSchedule)
+			scheduleRoutes.GET("", scheduleHandler.GetSchedules)
+			scheduleRoutes.GET("/:id", scheduleHandler.GetScheduleByID)
+			scheduleRoutes.PUT("/:id", scheduleHandler.UpdateSchedule)
+			scheduleRoutes.DELETE("/:id", scheduleHandler.DeleteSchedule)
+		}
+
+		// Delivery target routes
+		deliveryRoutes := apiV1.Group("/delivery-targets")
+		{
+			deliveryRoutes.POST("", deliveryHandler.CreateDeliveryTarget)
+			deliveryRoutes.GET("", deliveryHandler.GetDeliveryTargets)
+			deliveryRoutes.GET("/:id", deliveryHandler.GetDeliveryTargetByID)
+			deliveryRoutes.PUT("/:id", deliveryHandler.UpdateDeliveryTarget)
+			deliveryRoutes.DELETE("/:id", deliveryHandler.DeleteDeliveryTarget)
 		}
 
+		// 保留策略清理预览
+		apiV1.GET("/retention/preview", retentionHandler.PreviewRetention)
 	}
 
 	return router
@@ -1,13 +1,10 @@
 package v1
 
 import (
-	"errors"
 	"github.com/foldn/bi-go/internal/service"
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 type DataSourceHandler struct {
@@ -18,29 +15,6 @@ func NewDataSourceHandler(s service.DataSourceService) *DataSourceHandler {
 	return &DataSourceHandler{service: s}
 }
 
-// ErrorResponse represents a generic JSON error response.
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
-
-// Helper to return standardized error responses
-func handleError(c *gin.Context, err error, defaultStatusCode int) {
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Resource not found"})
-		return
-	}
-	// You might want to check for specific validation errors or other known error types
-	// from your service layer to return different status codes (e.g., http.StatusBadRequest)
-	// For now, a simple check for "already exists" or known validation style errors.
-	if err != nil && (err.Error() == "datasource with this name already exists" ||
-		(len(err.Error()) > 0 && err.Error()[0] == '{')) { // Crude check for JSON validation errors from Gin
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
-		return
-	}
-
-	c.JSON(defaultStatusCode, ErrorResponse{Error: err.Error()})
-}
-
 // CreateDataSource godoc
 // @Summary Create a new data source
 // @Description Add a new data source configuration to the system
@@ -48,8 +22,9 @@ func handleError(c *gin.Context, err error, defaultStatusCode int) {
 // @Accept  json
 // @Produce  json
 // @Param   datasource  body   service.CreateDataSourceInput  true  "Data Source Configuration"
-// @Success 201 {object} models.DataSource
+// @Success 201 {object} service.DataSourceResponse
 // @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 409 {object} ErrorResponse "Data source name already exists"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /datasources [post]
 func (h *DataSourceHandler) CreateDataSource(c *gin.Context) {
@@ -61,15 +36,10 @@ func (h *DataSourceHandler) CreateDataSource(c *gin.Context) {
 
 	ds, err := h.service.CreateDataSource(input)
 	if err != nil {
-		// Example of more specific error handling
-		if err.Error() == "datasource with this name already exists" {
-			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
-			return
-		}
 		handleError(c, err, http.StatusInternalServerError)
 		return
 	}
-	c.JSON(http.StatusCreated, ds)
+	c.JSON(http.StatusCreated, service.NewDataSourceResponse(ds))
 }
 
 // GetDataSources godoc
@@ -79,25 +49,11 @@ func (h *DataSourceHandler) CreateDataSource(c *gin.Context) {
 // @Produce  json
 // @Param page query int false "Page number" default(1)
 // @Param pageSize query int false "Number of items per page" default(10)
-// @Success 200 {object} map[string]interface{} "data, total, page, pageSize"
+// @Success 200 {object} PageResponse "data: list of service.DataSourceResponse"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /datasources [get]
 func (h *DataSourceHandler) GetDataSources(c *gin.Context) {
-	pageStr := c.DefaultQuery("page", "1")
-	pageSizeStr := c.DefaultQuery("pageSize", "10")
-
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 {
-		page = 1
-	}
-
-	pageSize, err := strconv.Atoi(pageSizeStr)
-	if err != nil || pageSize < 1 {
-		pageSize = 10
-	}
-	if pageSize > 100 { // Max page size limit
-		pageSize = 100
-	}
+	page, pageSize := parsePagination(c)
 
 	dataSources, total, err := h.service.GetDataSources(page, pageSize)
 	if err != nil {
@@ -105,11 +61,11 @@ func (h *DataSourceHandler) GetDataSources(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"data":     dataSources,
-		"total":    total,
-		"page":     page,
-		"pageSize": pageSize,
+	c.JSON(http.StatusOK, PageResponse{
+		Data:     service.NewDataSourceResponses(dataSources),
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
 	})
 }
 
@@ -119,24 +75,22 @@ func (h *DataSourceHandler) GetDataSources(c *gin.Context) {
 // @Tags datasources
 // @Produce  json
 // @Param   id   path   int  true  "Data Source ID"
-// @Success 200 {object} models.DataSource
+// @Success 200 {object} service.DataSourceResponse
 // @Failure 404 {object} ErrorResponse "Data source not found"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /datasources/{id} [get]
 func (h *DataSourceHandler) GetDataSourceByID(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid ID format"})
+	id, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
-	ds, err := h.service.GetDataSourceByID(uint(id))
+	ds, err := h.service.GetDataSourceByID(id)
 	if err != nil {
 		handleError(c, err, http.StatusInternalServerError)
 		return
 	}
-	c.JSON(http.StatusOK, ds)
+	c.JSON(http.StatusOK, service.NewDataSourceResponse(ds))
 }
 
 // UpdateDataSource godoc
@@ -147,16 +101,15 @@ func (h *DataSourceHandler) GetDataSourceByID(c *gin.Context) {
 // @Produce  json
 // @Param   id   path   int  true  "Data Source ID"
 // @Param   datasource  body   service.UpdateDataSourceInput  true  "Data Source Configuration Update"
-// @Success 200 {object} models.DataSource
+// @Success 200 {object} service.DataSourceResponse
 // @Failure 400 {object} ErrorResponse "Invalid input"
 // @Failure 404 {object} ErrorResponse "Data source not found"
+// @Failure 409 {object} ErrorResponse "Data source name already exists"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /datasources/{id} [put]
 func (h *DataSourceHandler) UpdateDataSource(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid ID format"})
+	id, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
@@ -166,16 +119,12 @@ func (h *DataSourceHandler) UpdateDataSource(c *gin.Context) {
 		return
 	}
 
-	ds, err := h.service.UpdateDataSource(uint(id), input)
+	ds, err := h.service.UpdateDataSource(id, input)
 	if err != nil {
-		if err.Error() == "datasource with this name already exists" {
-			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
-			return
-		}
 		handleError(c, err, http.StatusInternalServerError)
 		return
 	}
-	c.JSON(http.StatusOK, ds)
+	c.JSON(http.StatusOK, service.NewDataSourceResponse(ds))
 }
 
 // DeleteDataSource godoc
@@ -189,15 +138,12 @@ func (h *DataSourceHandler) UpdateDataSource(c *gin.Context) {
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /datasources/{id} [delete]
 func (h *DataSourceHandler) DeleteDataSource(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid ID format"})
+	id, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
-	err = h.service.DeleteDataSource(uint(id))
-	if err != nil {
+	if err := h.service.DeleteDataSource(id); err != nil {
 		handleError(c, err, http.StatusInternalServerError)
 		return
 	}
@@ -210,27 +156,24 @@ func (h *DataSourceHandler) DeleteDataSource(c *gin.Context) {
 // @Tags datasources
 // @Produce  json
 // @Param   id   path   int  true  "Data Source ID"
-// @Success 200 {object} interface{} "Schema Information"
+// @Success 200 {object} service.DataSourceSchema "Schema Information"
 // @Failure 400 {object} ErrorResponse "Invalid ID format"
 // @Failure 404 {object} ErrorResponse "Data source not found"
 // @Failure 500 {object} ErrorResponse "Error fetching schema"
 // @Router /datasources/{id}/schema [get]
 func (h *DataSourceHandler) GetDataSourceSchema(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid ID format"})
+	id, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
 	// Ensure the datasource exists first (optional, service might do this)
-	_, err = h.service.GetDataSourceByID(uint(id))
-	if err != nil {
+	if _, err := h.service.GetDataSourceByID(id); err != nil {
 		handleError(c, err, http.StatusInternalServerError) // Catches Not Found as well
 		return
 	}
 
-	schema, err := h.service.GetDataSourceSchema(uint(id))
+	schema, err := h.service.GetDataSourceSchema(id)
 	if err != nil {
 		handleError(c, err, http.StatusInternalServerError)
 		return
@@ -245,16 +188,14 @@ func (h *DataSourceHandler) GetDataSourceSchema(c *gin.Context) {
 // @Produce  json
 // @Param   id   path   int  true  "Data Source ID"
 // @Param   entity_name   path   string  true  "Entity Name (e.g., table name)"
-// @Success 200 {object} interface{} "Entity Schema Information"
+// @Success 200 {object} service.EntitySchema "Entity Schema Information"
 // @Failure 400 {object} ErrorResponse "Invalid ID or entity name"
 // @Failure 404 {object} ErrorResponse "Data source or entity not found"
 // @Failure 500 {object} ErrorResponse "Error fetching entity schema"
 // @Router /datasources/{id}/schema/{entity_name} [get]
 func (h *DataSourceHandler) GetDataSourceEntitySchema(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid ID format"})
+	id, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
@@ -265,13 +206,12 @@ func (h *DataSourceHandler) GetDataSourceEntitySchema(c *gin.Context) {
 	}
 
 	// Ensure the datasource exists first (optional, service might do this)
-	_, err = h.service.GetDataSourceByID(uint(id))
-	if err != nil {
+	if _, err := h.service.GetDataSourceByID(id); err != nil {
 		handleError(c, err, http.StatusInternalServerError) // Catches Not Found as well
 		return
 	}
 
-	schema, err := h.service.GetDataSourceEntitySchema(uint(id), entityName)
+	schema, err := h.service.GetDataSourceEntitySchema(id, entityName)
 	if err != nil {
 		// Potentially more specific error if entity itself is not found vs. general error
 		handleError(c, err, http.StatusInternalServerError)
@@ -279,3 +219,82 @@ func (h *DataSourceHandler) GetDataSourceEntitySchema(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, schema)
 }
+
+// GetPoolStats godoc
+// @Summary Get connection pool statistics
+// @Description Retrieve statistics of all established datasource connection pools
+// @Tags datasources
+// @Produce  json
+// @Success 200 {object} map[string]interface{} "data: list of database.PoolStats"
+// @Router /datasources/pools [get]
+func (h *DataSourceHandler) GetPoolStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.service.GetPoolStats()})
+}
+
+// GetDataSourcePoolStats godoc
+// @Summary Get connection pool statistics of a data source
+// @Description Retrieve the connection pool statistics of a specific data source
+// @Tags datasources
+// @Produce  json
+// @Param   id   path   int  true  "Data Source ID"
+// @Success 200 {object} database.PoolStats
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Data source not found"
+// @Router /datasources/{id}/pool [get]
+func (h *DataSourceHandler) GetDataSourcePoolStats(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	stats, err := h.service.GetDataSourcePoolStats(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// TestConnection godoc
+// @Summary Test an unsaved data source configuration
+// @Description Connect to a data source with a timeout and run a trivial probe without saving it
+// @Tags datasources
+// @Accept  json
+// @Produce  json
+// @Param   datasource  body   service.CreateDataSourceInput  true  "Data Source Configuration"
+// @Success 200 {object} database.ConnectionTestResult
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Router /datasources/test [post]
+func (h *DataSourceHandler) TestConnection(c *gin.Context) {
+	var input service.CreateDataSourceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.service.TestConnection(input))
+}
+
+// TestDataSourceConnection godoc
+// @Summary Test a saved data source
+// @Description Connect to a saved data source with a timeout and run a trivial probe
+// @Tags datasources
+// @Produce  json
+// @Param   id   path   int  true  "Data Source ID"
+// @Success 200 {object} database.ConnectionTestResult
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Data source not found"
+// @Router /datasources/{id}/test [post]
+func (h *DataSourceHandler) TestDataSourceConnection(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	result, err := h.service.TestDataSourceConnection(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
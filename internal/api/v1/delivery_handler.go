@@ -0,0 +1,179 @@
+package v1
+
+import (
+	"github.com/foldn/bi-go/internal/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DeliveryHandler struct {
+	service service.DeliveryService
+}
+
+func NewDeliveryHandler(s service.DeliveryService) *DeliveryHandler {
+	return &DeliveryHandler{service: s}
+}
+
+// CreateDeliveryTarget godoc
+// @Summary Create a delivery target
+// @Description Deliver every completed job of a report by email (attachment or download link), signed HTTP webhook or copy to a local directory. Failed deliveries are retried with backoff.
+// @Tags delivery-targets
+// @Accept  json
+// @Produce  json
+// @Param   target  body   service.CreateDeliveryTargetInput  true  "Delivery Target Definition"
+// @Success 201 {object} service.DeliveryTargetResponse
+// @Failure 400 {object} ErrorResponse "Invalid input, report, address, URL, directory or template"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /delivery-targets [post]
+func (h *DeliveryHandler) CreateDeliveryTarget(c *gin.Context) {
+	var input service.CreateDeliveryTargetInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	target, err := h.service.CreateTarget(input)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusCreated, service.NewDeliveryTargetResponse(target))
+}
+
+// GetDeliveryTargets godoc
+// @Summary Get all delivery targets
+// @Description Retrieve a paginated list of delivery targets. Webhook secrets are never returned.
+// @Tags delivery-targets
+// @Produce  json
+// @Param reportId query int false "Only targets of this report"
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Number of items per page" default(10)
+// @Success 200 {object} PageResponse "data: list of service.DeliveryTargetResponse"
+// @Failure 400 {object} ErrorResponse "Invalid filter"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /delivery-targets [get]
+func (h *DeliveryHandler) GetDeliveryTargets(c *gin.Context) {
+	var filter service.DeliveryTargetFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	page, pageSize := parsePagination(c)
+
+	targets, total, err := h.service.GetTargets(filter, page, pageSize)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, PageResponse{
+		Data:     service.NewDeliveryTargetResponses(targets),
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
+}
+
+// GetDeliveryTargetByID godoc
+// @Summary Get a delivery target by ID
+// @Description Retrieve a delivery target
+// @Tags delivery-targets
+// @Produce  json
+// @Param   id   path   int  true  "Delivery Target ID"
+// @Success 200 {object} service.DeliveryTargetResponse
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Delivery target not found"
+// @Router /delivery-targets/{id} [get]
+func (h *DeliveryHandler) GetDeliveryTargetByID(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	target, err := h.service.GetTargetByID(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewDeliveryTargetResponse(target))
+}
+
+// UpdateDeliveryTarget godoc
+// @Summary Update a delivery target
+// @Description Update a delivery target. A new config replaces the old one; an empty secret keeps the current secret.
+// @Tags delivery-targets
+// @Accept  json
+// @Produce  json
+// @Param   id      path   int                                true  "Delivery Target ID"
+// @Param   target  body   service.UpdateDeliveryTargetInput  true  "Fields to update"
+// @Success 200 {object} service.DeliveryTargetResponse
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 404 {object} ErrorResponse "Delivery target not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /delivery-targets/{id} [put]
+func (h *DeliveryHandler) UpdateDeliveryTarget(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var input service.UpdateDeliveryTargetInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	target, err := h.service.UpdateTarget(id, input)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewDeliveryTargetResponse(target))
+}
+
+// DeleteDeliveryTarget godoc
+// @Summary Delete a delivery target
+// @Description Delete a delivery target; pending deliveries to it fail and its delivery log is kept
+// @Tags delivery-targets
+// @Param   id   path   int  true  "Delivery Target ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Delivery target not found"
+// @Router /delivery-targets/{id} [delete]
+func (h *DeliveryHandler) DeleteDeliveryTarget(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteTarget(id); err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetJobDeliveries godoc
+// @Summary Get the deliveries of a report job
+// @Description List the deliveries of a completed job to each target of its report, with a log of every attempt
+// @Tags jobs
+// @Produce  json
+// @Param   id   path   int  true  "Job ID"
+// @Success 200 {array} service.DeliveryResponse
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Job not found"
+// @Router /jobs/{id}/deliveries [get]
+func (h *DeliveryHandler) GetJobDeliveries(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.service.GetJobDeliveries(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewDeliveryResponses(deliveries))
+}
@@ -0,0 +1,303 @@
+package v1
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/service"
+	"github.com/foldn/bi-go/pkg/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JobHandler struct {
+	service service.ReportService
+	process service.ProcessService
+}
+
+func NewJobHandler(s service.ReportService, process service.ProcessService) *JobHandler {
+	return &JobHandler{service: s, process: process}
+}
+
+// ProcessData godoc
+// @Summary Run a data processing job
+// @Description Apply a list of operations (select, filter, groupBy) to an entity of a datasource. In auto mode small results are returned directly with 200; larger or slower ones become an asynchronous job returned with 202, whose result is read from /jobs/{id}/result.
+// @Tags jobs
+// @Accept  json
+// @Produce  json
+// @Param   job  body   service.ProcessInput  true  "Datasource, entity, operations and mode"
+// @Success 200 {object} service.ProcessResult
+// @Success 202 {object} service.ReportJobResponse
+// @Failure 400 {object} ErrorResponse "Invalid input, datasource or operation, or result too large for sync mode"
+// @Failure 504 {object} ErrorResponse "Sync mode timed out"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /jobs/process [post]
+func (h *JobHandler) ProcessData(c *gin.Context) {
+	var input service.ProcessInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	outcome, err := h.process.Process(c.Request.Context(), input)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	if outcome.Job != nil {
+		c.Header("Location", fmt.Sprintf("/api/v1/jobs/%d", outcome.Job.ID))
+		c.JSON(http.StatusAccepted, service.NewReportJobResponse(outcome.Job))
+		return
+	}
+	c.JSON(http.StatusOK, outcome.Result)
+}
+
+// ExplainProcess godoc
+// @Summary Explain a data processing job
+// @Description Show how a list of operations would run without executing it: the SQL sent to the datasource and, for each operation, whether it is pushed down into that SQL or executed in memory and why.
+// @Tags jobs
+// @Accept  json
+// @Produce  json
+// @Param   job  body   service.ProcessInput  true  "Datasource, entity and operations; mode is ignored"
+// @Success 200 {object} pipeline.Plan
+// @Failure 400 {object} ErrorResponse "Invalid input, datasource or operation"
+// @Failure 404 {object} ErrorResponse "Entity not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /jobs/process/explain [post]
+func (h *JobHandler) ExplainProcess(c *gin.Context) {
+	var input service.ProcessInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	plan, err := h.process.Explain(c.Request.Context(), input)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+// GetJobs godoc
+// @Summary Get all report jobs
+// @Description Retrieve a paginated list of report generation jobs, newest first
+// @Tags jobs
+// @Produce  json
+// @Param kind query string false "Only jobs of this kind" Enums(report, process)
+// @Param reportId query int false "Only jobs of this report"
+// @Param scheduleId query int false "Only jobs created by this schedule"
+// @Param status query string false "Only jobs in this status" Enums(pending, running, completed, failed, cancelled)
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Number of items per page" default(10)
+// @Success 200 {object} PageResponse "data: list of service.ReportJobResponse"
+// @Failure 400 {object} ErrorResponse "Invalid filter"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /jobs [get]
+func (h *JobHandler) GetJobs(c *gin.Context) {
+	var filter service.JobFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	page, pageSize := parsePagination(c)
+
+	jobs, total, err := h.service.GetJobs(filter, page, pageSize)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, PageResponse{
+		Data:     service.NewReportJobResponses(jobs),
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
+}
+
+// GetJobByID godoc
+// @Summary Get a report job by ID
+// @Description Retrieve a specific report generation or data processing job
+// @Tags jobs
+// @Produce  json
+// @Param   id   path   int  true  "Job ID"
+// @Success 200 {object} service.ReportJobResponse
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Job not found"
+// @Router /jobs/{id} [get]
+func (h *JobHandler) GetJobByID(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	job, err := h.service.GetJobByID(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewReportJobResponse(job))
+}
+
+// GetJobStatus godoc
+// @Summary Get the status of a job
+// @Description Retrieve the status of a report or data processing job, with links to its result once completed
+// @Tags jobs
+// @Produce  json
+// @Param   id   path   int  true  "Job ID"
+// @Success 200 {object} service.JobStatusResponse
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Job not found"
+// @Router /jobs/{id}/status [get]
+func (h *JobHandler) GetJobStatus(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	job, err := h.service.GetJobByID(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewJobStatusResponse(job))
+}
+
+// GetJobResult godoc
+// @Summary Get the result of a data processing job
+// @Description Read a page of the rows produced by a completed data processing job. The whole result can be downloaded as NDJSON from /jobs/{id}/download.
+// @Tags jobs
+// @Produce  json
+// @Param   id   path   int  true  "Job ID"
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Number of rows per page" default(10)
+// @Success 200 {object} service.ProcessResultPage
+// @Failure 400 {object} ErrorResponse "Invalid ID, not a processing job or job not completed"
+// @Failure 404 {object} ErrorResponse "Job not found"
+// @Failure 410 {object} ErrorResponse "Job result no longer exists"
+// @Router /jobs/{id}/result [get]
+func (h *JobHandler) GetJobResult(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	page, pageSize := parsePagination(c)
+
+	result, err := h.process.GetJobResult(c.Request.Context(), id, page, pageSize)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetJobEvents godoc
+// @Summary Get state transitions of a report job
+// @Description Retrieve the recorded state transitions of a job in the order they happened
+// @Tags jobs
+// @Produce  json
+// @Param   id   path   int  true  "Job ID"
+// @Success 200 {object} map[string]interface{} "data: list of service.ReportJobEventResponse"
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Job not found"
+// @Router /jobs/{id}/events [get]
+func (h *JobHandler) GetJobEvents(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	events, err := h.service.GetJobEvents(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": service.NewReportJobEventResponses(events)})
+}
+
+// CancelJob godoc
+// @Summary Cancel a report job
+// @Description Cancel a pending or running job; a running query is aborted
+// @Tags jobs
+// @Produce  json
+// @Param   id   path   int  true  "Job ID"
+// @Success 200 {object} service.ReportJobResponse
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Job not found"
+// @Failure 409 {object} ErrorResponse "Job has already finished"
+// @Router /jobs/{id}/cancel [post]
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	job, err := h.service.CancelJob(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewReportJobResponse(job))
+}
+
+// DownloadJob godoc
+// @Summary Download the output of a report job
+// @Description Download the file produced by a completed report generation job. With object storage and storage.presign enabled the response redirects to a pre-signed URL.
+// @Tags jobs
+// @Produce  octet-stream
+// @Param   id   path   int  true  "Job ID"
+// @Success 200 {file} file
+// @Success 302 "Redirect to a pre-signed download URL"
+// @Failure 400 {object} ErrorResponse "Invalid ID or job not completed"
+// @Failure 404 {object} ErrorResponse "Job not found"
+// @Failure 410 {object} ErrorResponse "Job output file no longer exists"
+// @Router /jobs/{id}/download [get]
+func (h *JobHandler) DownloadJob(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	job, err := h.service.GetJobByID(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	serveJobFile(c, h.service, job)
+}
+
+// serveJobFile 返回已完成任务的输出文件：对象存储支持时重定向到预签名地址，否则转发文件内容
+func serveJobFile(c *gin.Context, svc service.ReportService, job *models.ReportJob) {
+	// 检查任务状态
+	if job.Status != models.JobStatusCompleted {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Job is %s, not completed", job.Status)})
+		return
+	}
+
+	file, err := svc.OpenJobFile(c.Request.Context(), job)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	if file.URL != "" {
+		c.Redirect(http.StatusFound, file.URL)
+		return
+	}
+	defer file.Body.Close()
+
+	// 设置Content-Disposition，有摘要时通过 Digest 头供客户端校验
+	fileName := service.JobFileName(job)
+	headers := map[string]string{"Content-Disposition": fmt.Sprintf("attachment; filename=%s", fileName)}
+	if sum, err := hex.DecodeString(job.Checksum); err == nil && len(sum) > 0 {
+		headers["Digest"] = "sha-256=" + base64.StdEncoding.EncodeToString(sum)
+	}
+	size := job.FileSize
+	if job.StorageKey == "" {
+		// 旧版本生成的任务没有记录大小
+		size = -1
+	}
+	c.DataFromReader(http.StatusOK, size, utils.ContentType(job.Format), file.Body, headers)
+}
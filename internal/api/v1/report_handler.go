@@ -0,0 +1,266 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/foldn/bi-go/internal/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReportHandler struct {
+	service service.ReportService
+}
+
+func NewReportHandler(s service.ReportService) *ReportHandler {
+	return &ReportHandler{service: s}
+}
+
+// CreateReport godoc
+// @Summary Create a new report
+// @Description Add a new report definition bound to a data source
+// @Tags reports
+// @Accept  json
+// @Produce  json
+// @Param   report  body   service.CreateReportInput  true  "Report Definition"
+// @Success 201 {object} service.ReportResponse
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 409 {object} ErrorResponse "Report name already exists"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /reports [post]
+func (h *ReportHandler) CreateReport(c *gin.Context) {
+	var input service.CreateReportInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	report, err := h.service.CreateReport(input)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusCreated, service.NewReportResponse(report))
+}
+
+// GetReports godoc
+// @Summary Get all reports
+// @Description Retrieve a paginated list of report definitions
+// @Tags reports
+// @Produce  json
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Number of items per page" default(10)
+// @Success 200 {object} PageResponse "data: list of service.ReportResponse"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /reports [get]
+func (h *ReportHandler) GetReports(c *gin.Context) {
+	page, pageSize := parsePagination(c)
+
+	reports, total, err := h.service.GetReports(page, pageSize)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, PageResponse{
+		Data:     service.NewReportResponses(reports),
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
+}
+
+// GetReportByID godoc
+// @Summary Get a report by ID
+// @Description Retrieve a specific report definition by its ID
+// @Tags reports
+// @Produce  json
+// @Param   id   path   int  true  "Report ID"
+// @Success 200 {object} service.ReportResponse
+// @Failure 404 {object} ErrorResponse "Report not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /reports/{id} [get]
+func (h *ReportHandler) GetReportByID(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	report, err := h.service.GetReportByID(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewReportResponse(report))
+}
+
+// UpdateReport godoc
+// @Summary Update an existing report
+// @Description Update an existing report definition by its ID
+// @Tags reports
+// @Accept  json
+// @Produce  json
+// @Param   id   path   int  true  "Report ID"
+// @Param   report  body   service.UpdateReportInput  true  "Report Definition Update"
+// @Success 200 {object} service.ReportResponse
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 404 {object} ErrorResponse "Report not found"
+// @Failure 409 {object} ErrorResponse "Report name already exists"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /reports/{id} [put]
+func (h *ReportHandler) UpdateReport(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var input service.UpdateReportInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	report, err := h.service.UpdateReport(id, input)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewReportResponse(report))
+}
+
+// DeleteReport godoc
+// @Summary Delete a report
+// @Description Delete a report definition by its ID
+// @Tags reports
+// @Produce  json
+// @Param   id   path   int  true  "Report ID"
+// @Success 204 "Successfully deleted"
+// @Failure 404 {object} ErrorResponse "Report not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /reports/{id} [delete]
+func (h *ReportHandler) DeleteReport(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteReport(id); err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GenerateReport godoc
+// @Summary Generate a report
+// @Description Create a report generation job that runs in the background. Parameter values are validated against the report's parameter definitions and bound as query placeholders
+// @Tags reports
+// @Accept  json
+// @Produce  json
+// @Param   id   path   int  true  "Report ID"
+// @Param   request  body   service.GenerateReportInput  true  "Output format and parameter values"
+// @Success 202 {object} service.ReportJobResponse
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 404 {object} ErrorResponse "Report not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /reports/{id}/generate [post]
+func (h *ReportHandler) GenerateReport(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var input service.GenerateReportInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	job, err := h.service.GenerateReport(id, input)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.Header("Location", fmt.Sprintf("/api/v1/jobs/%d", job.ID))
+	c.JSON(http.StatusAccepted, service.NewReportJobResponse(job))
+}
+
+// GetReportStatus godoc
+// @Summary Get report generation status
+// @Description Retrieve a specific generation job with job_id, or a paginated list of the report's jobs
+// @Tags reports
+// @Produce  json
+// @Param   id   path   int  true  "Report ID"
+// @Param   job_id   query   int  false  "Job ID"
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Number of items per page" default(10)
+// @Success 200 {object} service.ReportJobResponse "A single job, or a PageResponse of jobs when job_id is omitted"
+// @Failure 400 {object} ErrorResponse "Invalid ID or job does not belong to report"
+// @Failure 404 {object} ErrorResponse "Report or job not found"
+// @Router /reports/{id}/status [get]
+func (h *ReportHandler) GetReportStatus(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if c.Query("job_id") == "" {
+		// 如果没有指定任务ID，返回该报表的所有任务
+		page, pageSize := parsePagination(c)
+		jobs, total, err := h.service.GetReportJobs(id, page, pageSize)
+		if err != nil {
+			handleError(c, err, http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, PageResponse{
+			Data:     service.NewReportJobResponses(jobs),
+			Total:    total,
+			Page:     page,
+			PageSize: pageSize,
+		})
+		return
+	}
+
+	jobID, ok := parseQueryID(c, "job_id")
+	if !ok {
+		return
+	}
+	job, err := h.service.GetReportJob(id, jobID)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewReportJobResponse(job))
+}
+
+// DownloadReport godoc
+// @Summary Download a generated report
+// @Description Download the file produced by a completed generation job
+// @Tags reports
+// @Produce  octet-stream
+// @Param   id   path   int  true  "Report ID"
+// @Param   job_id   query   int  true  "Job ID"
+// @Success 200 {file} file
+// @Success 302 "Redirect to a pre-signed download URL"
+// @Failure 400 {object} ErrorResponse "Missing job_id or report not ready"
+// @Failure 404 {object} ErrorResponse "Report or job not found"
+// @Failure 410 {object} ErrorResponse "Job output file no longer exists"
+// @Router /reports/{id}/download [get]
+func (h *ReportHandler) DownloadReport(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	jobID, ok := parseQueryID(c, "job_id")
+	if !ok {
+		return
+	}
+
+	job, err := h.service.GetReportJob(id, jobID)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	serveJobFile(c, h.service, job)
+}
@@ -0,0 +1,121 @@
+package v1
+
+import (
+	"errors"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/pipeline"
+	"github.com/foldn/bi-go/internal/service"
+	"github.com/foldn/bi-go/internal/sqlguard"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 分页参数默认值与上限
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// ErrorResponse represents a generic JSON error response.
+// Line and Column locate the offending part of a rejected report query,
+// Operation the index of a rejected data processing operation.
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	Operation *int   `json:"operation,omitempty"`
+}
+
+// PageResponse is the envelope returned by every paginated list endpoint.
+type PageResponse struct {
+	Data     interface{} `json:"data"`
+	Total    int64       `json:"total"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"pageSize"`
+}
+
+// Helper to return standardized error responses
+func handleError(c *gin.Context, err error, defaultStatusCode int) {
+	var violation *sqlguard.Violation
+	var opErr *pipeline.OperationError
+	switch {
+	case errors.As(err, &violation):
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error(), Line: violation.Line, Column: violation.Column})
+	case errors.As(err, &opErr):
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error(), Operation: &opErr.Index})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Resource not found"})
+	case errors.Is(err, database.ErrEntityNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Entity not found"})
+	case errors.Is(err, service.ErrJobFileMissing):
+		c.JSON(http.StatusGone, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, service.ErrDataSourceExists),
+		errors.Is(err, service.ErrReportExists),
+		errors.Is(err, service.ErrJobNotCancellable):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, service.ErrInvalidDataSource),
+		errors.Is(err, service.ErrInvalidDataSourceParams),
+		errors.Is(err, service.ErrUnsupportedFormat),
+		errors.Is(err, service.ErrJobNotInReport),
+		errors.Is(err, service.ErrInvalidLayout),
+		errors.Is(err, service.ErrInvalidParameter),
+		errors.Is(err, service.ErrUnsafeQuery),
+		errors.Is(err, service.ErrInvalidReport),
+		errors.Is(err, service.ErrInvalidSchedule),
+		errors.Is(err, service.ErrInvalidDeliveryTarget),
+		errors.Is(err, service.ErrInvalidRetention),
+		errors.Is(err, service.ErrInvalidPipeline),
+		errors.Is(err, service.ErrSyncResultTooLarge),
+		errors.Is(err, service.ErrNotProcessJob),
+		errors.Is(err, service.ErrJobNotCompleted):
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, service.ErrSyncTimeout):
+		c.JSON(http.StatusGatewayTimeout, ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(defaultStatusCode, ErrorResponse{Error: err.Error()})
+	}
+}
+
+// parseID 解析路径参数中的数字ID，失败时写入 400 响应
+func parseID(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid ID format"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// parseQueryID 解析查询参数中的数字ID，失败时写入 400 响应
+func parseQueryID(c *gin.Context, name string) (uint, bool) {
+	value := c.Query(name)
+	if value == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: name + " is required"})
+		return 0, false
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid " + name + " format"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// parsePagination 读取 page/pageSize 查询参数，非法值回退为默认值
+func parsePagination(c *gin.Context) (int, int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultPageSize)))
+	if err != nil || pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return page, pageSize
+}
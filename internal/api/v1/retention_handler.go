@@ -0,0 +1,42 @@
+package v1
+
+import (
+	"github.com/foldn/bi-go/internal/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RetentionHandler struct {
+	service service.RetentionService
+}
+
+func NewRetentionHandler(s service.RetentionService) *RetentionHandler {
+	return &RetentionHandler{service: s}
+}
+
+// PreviewRetention godoc
+// @Summary Preview the retention cleanup
+// @Description Dry run of the retention janitor: list the finished jobs whose files and records would be removed by the next cleanup under the global and per-report retention policies. Nothing is deleted.
+// @Tags retention
+// @Produce  json
+// @Param reportId query int false "Only jobs of this report"
+// @Success 200 {object} service.RetentionPreview
+// @Failure 400 {object} ErrorResponse "Invalid filter"
+// @Failure 404 {object} ErrorResponse "Report not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /retention/preview [get]
+func (h *RetentionHandler) PreviewRetention(c *gin.Context) {
+	var filter service.RetentionPreviewFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	preview, err := h.service.Preview(filter.ReportID)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
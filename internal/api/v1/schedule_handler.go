@@ -0,0 +1,155 @@
+package v1
+
+import (
+	"github.com/foldn/bi-go/internal/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ScheduleHandler struct {
+	service service.ScheduleService
+}
+
+func NewScheduleHandler(s service.ScheduleService) *ScheduleHandler {
+	return &ScheduleHandler{service: s}
+}
+
+// CreateSchedule godoc
+// @Summary Create a report schedule
+// @Description Generate a report periodically according to a cron expression evaluated in the given time zone. Each run creates a normal report job.
+// @Tags schedules
+// @Accept  json
+// @Produce  json
+// @Param   schedule  body   service.CreateScheduleInput  true  "Schedule Definition"
+// @Success 201 {object} service.ScheduleResponse
+// @Failure 400 {object} ErrorResponse "Invalid input, cron expression, time zone, format or parameters"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /schedules [post]
+func (h *ScheduleHandler) CreateSchedule(c *gin.Context) {
+	var input service.CreateScheduleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	schedule, err := h.service.CreateSchedule(input)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusCreated, service.NewScheduleResponse(schedule))
+}
+
+// GetSchedules godoc
+// @Summary Get all report schedules
+// @Description Retrieve a paginated list of report schedules
+// @Tags schedules
+// @Produce  json
+// @Param reportId query int false "Only schedules of this report"
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Number of items per page" default(10)
+// @Success 200 {object} PageResponse "data: list of service.ScheduleResponse"
+// @Failure 400 {object} ErrorResponse "Invalid filter"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /schedules [get]
+func (h *ScheduleHandler) GetSchedules(c *gin.Context) {
+	var filter service.ScheduleFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	page, pageSize := parsePagination(c)
+
+	schedules, total, err := h.service.GetSchedules(filter, page, pageSize)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, PageResponse{
+		Data:     service.NewScheduleResponses(schedules),
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
+}
+
+// GetScheduleByID godoc
+// @Summary Get a report schedule by ID
+// @Description Retrieve a schedule with its next run time and the result of its last run
+// @Tags schedules
+// @Produce  json
+// @Param   id   path   int  true  "Schedule ID"
+// @Success 200 {object} service.ScheduleResponse
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Schedule not found"
+// @Router /schedules/{id} [get]
+func (h *ScheduleHandler) GetScheduleByID(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	schedule, err := h.service.GetScheduleByID(id)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewScheduleResponse(schedule))
+}
+
+// UpdateSchedule godoc
+// @Summary Update a report schedule
+// @Description Update a schedule; the next run time is recalculated from now
+// @Tags schedules
+// @Accept  json
+// @Produce  json
+// @Param   id        path   int                          true  "Schedule ID"
+// @Param   schedule  body   service.UpdateScheduleInput  true  "Fields to update"
+// @Success 200 {object} service.ScheduleResponse
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 404 {object} ErrorResponse "Schedule not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /schedules/{id} [put]
+func (h *ScheduleHandler) UpdateSchedule(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var input service.UpdateScheduleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	schedule, err := h.service.UpdateSchedule(id, input)
+	if err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, service.NewScheduleResponse(schedule))
+}
+
+// DeleteSchedule godoc
+// @Summary Delete a report schedule
+// @Description Delete a schedule; jobs it already created are kept
+// @Tags schedules
+// @Param   id   path   int  true  "Schedule ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse "Invalid ID format"
+// @Failure 404 {object} ErrorResponse "Schedule not found"
+// @Router /schedules/{id} [delete]
+func (h *ScheduleHandler) DeleteSchedule(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteSchedule(id); err != nil {
+		handleError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -1,10 +1,28 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// OutputDir 报表文件输出目录
+var OutputDir = "./output"
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
+	Server    ServerConfig
+	Database  DatabaseConfig
+	Report    ReportConfig
+	Pool      PoolConfig
+	Security  SecurityConfig
+	Queue     QueueConfig
+	Scheduler SchedulerConfig
+	Delivery  DeliveryConfig
+	Storage   StorageConfig
+	Retention RetentionConfig
+	Process   ProcessConfig
 }
 
 type ServerConfig struct {
@@ -20,11 +38,180 @@ type DatabaseConfig struct {
 	SSLMode  string
 }
 
+// PoolConfig 业务数据源连接池配置，零值表示使用默认值
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// ConnectTimeout 连接测试的超时时间
+	ConnectTimeout time.Duration
+}
+
+// SecurityConfig 数据源凭据加密配置。MasterKey 为 base64 编码的 32 字节密钥，
+// 轮换时将旧密钥移入 PreviousKeys（键为密钥ID）后执行 rotate-keys 命令
+type SecurityConfig struct {
+	MasterKeyID  string
+	MasterKey    string
+	PreviousKeys map[string]string
+}
+
+// QueueConfig 报表生成任务队列配置，零值表示使用默认值
+type QueueConfig struct {
+	Workers      int           // 并发执行任务的 worker 数
+	PollInterval time.Duration // 没有新任务通知时轮询数据库的间隔
+	MaxAttempts  int           // 每个任务的最大执行次数（含首次）
+	// RetryBackoff 首次重试的等待时间，之后每次翻倍，不超过 MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// JobTimeout 单个任务的最长执行时间，报表自身的超时只能比它更短
+	JobTimeout time.Duration
+	// LeaseDuration 实例领取任务后持有的租约时长，执行中每隔三分之一租约续约一次；
+	// 租约到期仍为 running 的任务视为执行实例已退出，由其他实例重新排队
+	LeaseDuration time.Duration
+}
+
+// SchedulerConfig 定时计划调度配置，零值表示使用默认值
+type SchedulerConfig struct {
+	// PollInterval 检查到期计划的间隔，也是计划触发的最大延迟
+	PollInterval time.Duration
+}
+
+// DeliveryConfig 报表投递配置，零值表示使用默认值
+type DeliveryConfig struct {
+	Workers      int           // 并发投递的 worker 数
+	PollInterval time.Duration // 没有新投递通知时轮询数据库的间隔
+	MaxAttempts  int           // 每个投递的最大尝试次数（含首次）
+	// RetryBackoff 首次重试的等待时间，之后每次翻倍，不超过 MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// Timeout 单次发送邮件或请求 webhook 的超时时间
+	Timeout time.Duration
+	// LeaseDuration 实例领取投递后持有的租约时长，发送中每隔三分之一租约续约一次；
+	// 租约到期仍为 sending 的投递视为发送实例已退出，由其他实例重新排队
+	LeaseDuration time.Duration
+	// BaseURL 服务对外的访问地址（如 https://bi.example.com），用于生成下载链接
+	BaseURL string
+	// AllowedDirs directory 投递只能写入这些目录及其子目录，为空时禁用 directory 投递
+	AllowedDirs []string
+	SMTP        SMTPConfig
+}
+
+// SMTPConfig 发送报表邮件使用的 SMTP 服务器
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// TLS 为 true 时直接建立 TLS 连接（通常为 465 端口），否则在服务器支持时使用 STARTTLS
+	TLS bool
+}
+
+// RetentionConfig 报表任务和文件的保留策略，作为各报表的默认值；各项为 0 表示不限制
+type RetentionConfig struct {
+	Interval     time.Duration // 清理的执行间隔，0 表示使用默认值 1 小时
+	KeepLast     int           // 每个报表保留最近结束的任务数
+	MaxAge       time.Duration // 任务结束后的保留时间
+	MaxTotalSize int64         // 每个报表的文件总字节数上限
+}
+
+// ProcessConfig 数据处理任务（/jobs/process）配置，零值表示使用默认值
+type ProcessConfig struct {
+	// SyncMaxRows 同步执行时最多返回的行数，结果更多时转为异步任务
+	SyncMaxRows int
+	// SyncTimeout 同步执行的最长时间，超时后转为异步任务
+	SyncTimeout time.Duration
+	// MemoryBudget 列式执行（CSV 数据源）时排序、分组、去重和连接可以占用的内存字节数，
+	// 超出后写入临时文件；0 表示使用默认值 256MB，小于 0 表示不限制
+	MemoryBudget int64
+	// SpillDir 超出内存预算时写入临时文件的目录，为空时使用系统临时目录
+	SpillDir string
+	// FederatedMaxRows 跨数据源执行时从每个数据源的每个输入最多读取的行数，超出时报错；
+	// 0 表示使用默认值 1000000，小于 0 表示不限制
+	FederatedMaxRows int
+}
+
+// StorageConfig 报表文件存储配置
+type StorageConfig struct {
+	// Type local（默认，保存在 report.outputdir 下）或 s3（S3 兼容的对象存储）
+	Type string
+	// Presign 为 true 且存储支持时，下载接口重定向到预签名 URL，否则由服务转发文件内容
+	Presign bool
+	// PresignExpiry 预签名 URL 的有效期；0 表示默认 15 分钟
+	PresignExpiry time.Duration
+	S3            S3Config
+}
+
+// S3Config S3 兼容对象存储（AWS S3、MinIO 等）的连接配置
+type S3Config struct {
+	Endpoint        string // 如 s3.amazonaws.com、minio:9000，不含协议
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	// PathStyle 使用 path-style 地址（endpoint/bucket/key），MinIO 等自建服务通常需要
+	PathStyle bool
+	// Prefix 对象键的前缀，如 reports/
+	Prefix string
+}
+
+type ReportConfig struct {
+	OutputDir string
+	XLSX      XLSXConfig
+	Parquet   ParquetConfig
+	Arrow     ArrowConfig
+	Render    RenderConfig
+}
+
+// XLSXConfig Excel 输出配置
+type XLSXConfig struct {
+	// SheetRows 每个工作表的最大数据行数（不含表头），超出后写入新的工作表；
+	// 0 表示使用 Excel 的上限
+	SheetRows int
+}
+
+// ParquetConfig Parquet 输出配置
+type ParquetConfig struct {
+	// Compression 压缩算法：snappy（默认）、zstd、gzip 或 none
+	Compression string
+	// RowGroupRows 每个行组的行数，写出时内存中最多缓存一个行组；0 表示使用默认值 100000
+	RowGroupRows int
+}
+
+// ArrowConfig Arrow IPC 输出配置
+type ArrowConfig struct {
+	// Compression 压缩算法：none（默认）、zstd 或 lz4
+	Compression string
+	// BatchRows 每个记录批的行数；0 表示使用默认值 10000
+	BatchRows int
+}
+
+// RenderConfig html、pdf 等渲染格式的配置
+type RenderConfig struct {
+	// MaxRows 渲染格式的最大行数，结果超出时任务失败；0 表示使用默认值 10000
+	MaxRows int
+	// FontPath PDF 使用的 TrueType 字体文件，需包含报表中出现的字符（如中文）；
+	// 为空时使用内置的 Helvetica，只能显示西文字符
+	FontPath string
+	// PageSize PDF 纸张大小：A4（默认）、A3、Letter 或 Legal
+	PageSize string
+}
+
+// Init 不加载配置文件时使用默认配置，可通过环境变量 OUTPUT_DIR 覆盖输出目录
+func Init() {
+	if dir := os.Getenv("OUTPUT_DIR"); dir != "" {
+		OutputDir = dir
+	}
+}
+
 func LoadConfig(path string) (config Config, err error) {
 	viper.AddConfigPath(path)
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	err = viper.ReadInConfig()
@@ -33,5 +220,12 @@ func LoadConfig(path string) (config Config, err error) {
 	}
 
 	err = viper.Unmarshal(&config)
+	if err != nil {
+		return
+	}
+
+	if config.Report.OutputDir != "" {
+		OutputDir = config.Report.OutputDir
+	}
 	return
 }
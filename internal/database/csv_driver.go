@@ -0,0 +1,271 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// csvDriver 将 CSV 文件加载到内存 SQLite 中，使 CSV 数据源可以直接执行 SQL。
+// FilePath 可以是单个文件，也可以是目录（目录下每个 .csv 文件对应一张表），
+// 表名为去掉扩展名的文件名。分隔符、引号和编码由 OtherParams 中的 CSVOptions 指定
+type csvDriver struct {
+	sqlDriver
+}
+
+func init() {
+	RegisterDriver(csvDriver{})
+}
+
+func (csvDriver) Type() models.DataSourceType {
+	return models.CSV
+}
+
+func (csvDriver) DSN(ds *models.DataSource) (string, error) {
+	if ds.FilePath == "" {
+		return "", fmt.Errorf("csv datasource %q has no file path", ds.Name)
+	}
+	return ds.FilePath, nil
+}
+
+func (d csvDriver) Connect(ds *models.DataSource) (*gorm.DB, error) {
+	path, err := d.DSN(ds)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := ParseCSVOptions(ds.OtherParams)
+	if err != nil {
+		return nil, err
+	}
+	files, err := csvFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), sourceGormConfig())
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	d.ConfigurePool(sqlDB, config.PoolConfig{})
+
+	for _, file := range files {
+		if err := loadCSV(db, file, opts); err != nil {
+			sqlDB.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// ConfigurePool 内存数据库只存在于单个连接中，连接不能被回收
+func (csvDriver) ConfigurePool(sqlDB *sql.DB, cfg config.PoolConfig) {
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetMaxIdleConns(1)
+	sqlDB.SetConnMaxLifetime(0)
+	sqlDB.SetConnMaxIdleTime(0)
+}
+
+func (csvDriver) ListEntities(db *gorm.DB, ds *models.DataSource) ([]Entity, error) {
+	rows, err := db.Raw(`SELECT name, 'file', NULL FROM sqlite_master WHERE type = 'table' ORDER BY name`).Rows()
+	if err != nil {
+		return nil, err
+	}
+	entities, err := scanEntities(rows)
+	if err != nil {
+		return nil, err
+	}
+	return countRows(db, entities)
+}
+
+func (csvDriver) DescribeColumns(db *gorm.DB, ds *models.DataSource, entity string) ([]Column, error) {
+	rows, err := db.Raw(`SELECT name, type, 1, 0, NULL FROM pragma_table_info(?) ORDER BY cid`, entity).Rows()
+	if err != nil {
+		return nil, err
+	}
+	return scanColumns(rows)
+}
+
+// Probe 检查文件存在、可读并能解析表头，CSV 没有服务端版本
+func (d csvDriver) Probe(ctx context.Context, ds *models.DataSource) (string, error) {
+	path, err := d.DSN(ds)
+	if err != nil {
+		return "", err
+	}
+	opts, err := ParseCSVOptions(ds.OtherParams)
+	if err != nil {
+		return "", err
+	}
+	files, err := csvFiles(path)
+	if err != nil {
+		return "", err
+	}
+	for _, filePath := range files {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if _, err := ReadCSVHeader(filePath, opts); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// CSVEntityFile 返回 CSV 数据源中实体对应的文件，实体不存在时返回 ErrEntityNotFound
+func CSVEntityFile(ds *models.DataSource, entity string) (string, error) {
+	if ds.FilePath == "" {
+		return "", fmt.Errorf("csv datasource %q has no file path", ds.Name)
+	}
+	files, err := csvFiles(ds.FilePath)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		if csvTableName(file) == entity {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrEntityNotFound, entity)
+}
+
+// csvFiles 返回路径对应的 CSV 文件列表
+func csvFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("csv path is not accessible: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	files, err := filepath.Glob(filepath.Join(path, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no csv files found in %s", path)
+	}
+	return files, nil
+}
+
+var nonIdentChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// csvTableName 以文件名（不含扩展名）作为查询时使用的表名
+func csvTableName(filePath string) string {
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	name = nonIdentChars.ReplaceAllString(name, "_")
+	if name == "" {
+		name = "data"
+	}
+	return name
+}
+
+// loadCSV 将CSV文件加载为一张表，列类型根据数据推断
+func loadCSV(db *gorm.DB, filePath string, opts CSVOptions) error {
+	reader, err := OpenCSV(filePath, opts)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read csv header of %s: %w", filePath, err)
+	}
+	var records [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read csv file %s: %w", filePath, err)
+		}
+		records = append(records, record)
+	}
+
+	types := inferColumnTypes(len(header), records)
+	table := quoteIdent(csvTableName(filePath))
+	defs := make([]string, len(header))
+	placeholders := make([]string, len(header))
+	for i, col := range header {
+		defs[i] = quoteIdent(strings.TrimSpace(col)) + " " + types[i]
+		placeholders[i] = "?"
+	}
+	if err := db.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(defs, ", "))).Error; err != nil {
+		return err
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s VALUES (%s)", table, strings.Join(placeholders, ", "))
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, record := range records {
+			values := make([]interface{}, len(header))
+			for i := range header {
+				if i < len(record) {
+					values[i] = csvValue(record[i], types[i])
+				}
+			}
+			if err := tx.Exec(insert, values...).Error; err != nil {
+				return fmt.Errorf("failed to load csv file %s: %w", filePath, err)
+			}
+		}
+		return nil
+	})
+}
+
+// inferColumnTypes 推断每列的SQLite类型：全部为整数则INTEGER，全部为数字则REAL，否则TEXT
+func inferColumnTypes(columnCount int, records [][]string) []string {
+	types := make([]string, columnCount)
+	for i := range types {
+		isInt, isFloat := true, true
+		for _, record := range records {
+			if i >= len(record) || record[i] == "" {
+				continue
+			}
+			if _, err := strconv.ParseInt(record[i], 10, 64); err != nil {
+				isInt = false
+			}
+			if _, err := strconv.ParseFloat(record[i], 64); err != nil {
+				isFloat = false
+			}
+		}
+		switch {
+		case isInt:
+			types[i] = "INTEGER"
+		case isFloat:
+			types[i] = "REAL"
+		default:
+			types[i] = "TEXT"
+		}
+	}
+	return types
+}
+
+func csvValue(raw, columnType string) interface{} {
+	if raw == "" {
+		return nil
+	}
+	switch columnType {
+	case "INTEGER":
+		v, _ := strconv.ParseInt(raw, 10, 64)
+		return v
+	case "REAL":
+		v, _ := strconv.ParseFloat(raw, 64)
+		return v
+	default:
+		return raw
+	}
+}
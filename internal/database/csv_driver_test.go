@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/foldn/bi-go/internal/models"
+)
+
+func TestCSVQuery(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"orders.csv":        "id;region;amount\n1;east;10.5\n2;west;20\n3;east;\n",
+		"customer-list.csv": "id;name\n1;Alice\n",
+		"notes.txt":         "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ds := &models.DataSource{Name: "csv", Type: models.CSV, FilePath: dir, OtherParams: `{"delimiter": ";"}`}
+	driver, err := GetDriver(models.CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := driver.Connect(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB(db)
+
+	entities, err := driver.ListEntities(db, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 2 || entities[0].Name != "customer_list" || entities[1].Name != "orders" || entities[1].Kind != EntityFile {
+		t.Fatalf("got entities %+v", entities)
+	}
+
+	columns, err := driver.DescribeColumns(db, ds, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 3 || columns[0].Type != TypeInteger || columns[1].Type != TypeString || columns[2].Type != TypeFloat {
+		t.Fatalf("got columns %+v", columns)
+	}
+
+	rows, err := driver.Query(context.Background(), db, `SELECT region, SUM(amount), COUNT(amount) FROM orders GROUP BY region ORDER BY region`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	type group struct {
+		region string
+		total  float64
+		count  int64
+	}
+	var got []group
+	for rows.Next() {
+		var g group
+		if err := rows.Scan(&g.region, &g.total, &g.count); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, g)
+	}
+	want := []group{{"east", 10.5, 1}, {"west", 20, 1}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
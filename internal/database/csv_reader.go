@@ -0,0 +1,354 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// CSVOptions CSV 数据源的解析选项，保存在数据源的 OtherParams 中，如
+// {"delimiter": ";", "quote": "'", "encoding": "gbk", "inferRows": 1000}
+type CSVOptions struct {
+	// Delimiter 字段分隔符，默认为逗号，可以写作 \t
+	Delimiter string `json:"delimiter"`
+	// Quote 引用字段的字符，默认为双引号，字段中的引号写作两个引号；为 none 时不识别引号
+	Quote string `json:"quote"`
+	// Encoding 文件编码，如 utf-8（默认）、gbk、gb18030、big5、shift_jis、latin1
+	Encoding string `json:"encoding"`
+	// InferRows 推断列类型时读取的行数，0 表示读取全部行
+	InferRows int `json:"inferRows"`
+}
+
+// ParseCSVOptions 解析数据源的 OtherParams，为空时使用默认选项
+func ParseCSVOptions(otherParams string) (CSVOptions, error) {
+	var opts CSVOptions
+	if strings.TrimSpace(otherParams) != "" {
+		if err := json.Unmarshal([]byte(otherParams), &opts); err != nil {
+			return opts, fmt.Errorf("otherParams must be a JSON object of csv options: %w", err)
+		}
+	}
+	if _, err := opts.delimiter(); err != nil {
+		return opts, err
+	}
+	if _, err := opts.quote(); err != nil {
+		return opts, err
+	}
+	if opts.Encoding != "" {
+		if _, err := htmlindex.Get(opts.Encoding); err != nil {
+			return opts, fmt.Errorf("unsupported csv encoding %q", opts.Encoding)
+		}
+	}
+	if opts.InferRows < 0 {
+		return opts, errors.New("inferRows must not be negative")
+	}
+	return opts, nil
+}
+
+func (o CSVOptions) delimiter() (rune, error) {
+	switch o.Delimiter {
+	case "":
+		return ',', nil
+	case `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(o.Delimiter)
+	if size != len(o.Delimiter) || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("csv delimiter must be a single character, got %q", o.Delimiter)
+	}
+	return r, nil
+}
+
+// quote 引用字符，不识别引号时为 0
+func (o CSVOptions) quote() (rune, error) {
+	switch o.Quote {
+	case "":
+		return '"', nil
+	case "none":
+		return 0, nil
+	}
+	r, size := utf8.DecodeRuneInString(o.Quote)
+	if size != len(o.Quote) || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("csv quote must be a single character, got %q", o.Quote)
+	}
+	if d, _ := o.delimiter(); d == r {
+		return 0, errors.New("csv quote and delimiter must differ")
+	}
+	return r, nil
+}
+
+// CSVReader 按选项逐条读取 CSV 记录。文件开头的 UTF-8 BOM 会被跳过
+type CSVReader struct {
+	file  *os.File
+	r     *bufio.Reader
+	delim rune
+	quote rune
+	line  int
+	field strings.Builder
+}
+
+// OpenCSV 打开 CSV 文件，opts 应已通过 ParseCSVOptions 检查
+func OpenCSV(path string, opts CSVOptions) (*CSVReader, error) {
+	delim, err := opts.delimiter()
+	if err != nil {
+		return nil, err
+	}
+	quote, err := opts.quote()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open csv file: %w", err)
+	}
+	var src io.Reader = file
+	if opts.Encoding != "" {
+		enc, err := htmlindex.Get(opts.Encoding)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("unsupported csv encoding %q", opts.Encoding)
+		}
+		src = transform.NewReader(file, enc.NewDecoder())
+	}
+	r := bufio.NewReader(src)
+	if bom, _, err := r.ReadRune(); err == nil && bom != '\uFEFF' {
+		r.UnreadRune()
+	}
+	return &CSVReader{file: file, r: r, delim: delim, quote: quote, line: 1}, nil
+}
+
+// Read 读取下一条记录，文件结束时返回 io.EOF。空行被跳过
+func (c *CSVReader) Read() ([]string, error) {
+	for {
+		record, err := c.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 1 && record[0] == "" {
+			continue
+		}
+		return record, nil
+	}
+}
+
+func (c *CSVReader) readRecord() ([]string, error) {
+	var record []string
+	start := c.line
+	c.field.Reset()
+	quoted := false // 当前字段位于引号内
+	empty := true   // 当前记录还没有读到任何字符
+	for {
+		r, _, err := c.r.ReadRune()
+		if err == io.EOF {
+			if quoted {
+				return nil, fmt.Errorf("line %d: %w: unterminated quoted field", start, ErrInvalidFile)
+			}
+			if empty {
+				return nil, io.EOF
+			}
+			return append(record, c.field.String()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		empty = false
+		switch {
+		case quoted && r == c.quote:
+			// 引号内的两个引号表示一个引号，否则引用结束
+			if next, _, err := c.r.ReadRune(); err == nil && next == c.quote {
+				c.field.WriteRune(r)
+			} else {
+				if err == nil {
+					c.r.UnreadRune()
+				}
+				quoted = false
+			}
+		case quoted:
+			if r == '\n' {
+				c.line++
+			}
+			c.field.WriteRune(r)
+		case r == c.quote && c.quote != 0 && c.field.Len() == 0:
+			quoted = true
+		case r == c.delim:
+			record = append(record, c.field.String())
+			c.field.Reset()
+		case r == '\r':
+			if next, _, err := c.r.ReadRune(); err == nil && next != '\n' {
+				c.r.UnreadRune()
+			}
+			c.line++
+			return append(record, c.field.String()), nil
+		case r == '\n':
+			c.line++
+			return append(record, c.field.String()), nil
+		default:
+			c.field.WriteRune(r)
+		}
+	}
+}
+
+// Close 关闭文件
+func (c *CSVReader) Close() error {
+	return c.file.Close()
+}
+
+// ReadCSVHeader 读取 CSV 文件的表头，列名去掉首尾空白
+func ReadCSVHeader(path string, opts CSVOptions) ([]string, error) {
+	reader, err := OpenCSV(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header of %s: %w: %w", path, ErrInvalidFile, err)
+	}
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+	}
+	return header, nil
+}
+
+// InferCSVTypes 读取 CSV 文件推断每列的归一化类型，opts.InferRows 大于 0 时只读取该行数。
+// 非空值全部为整数时为 integer，全部为数字时为 float，全部为 true/false 时为 boolean，
+// 全部为日期或日期时间时为 date 或 datetime，否则为 string；全部为空时为 string
+func InferCSVTypes(path string, opts CSVOptions) ([]string, []string, error) {
+	reader, err := OpenCSV(path, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read csv header of %s: %w: %w", path, ErrInvalidFile, err)
+	}
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+	}
+
+	candidates := make([]csvTypeSet, len(header))
+	for i := range candidates {
+		candidates[i] = csvAllTypes
+	}
+	for n := 0; opts.InferRows == 0 || n < opts.InferRows; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read csv file %s: %w", path, err)
+		}
+		for i := range header {
+			if i < len(record) && record[i] != "" {
+				candidates[i] &= csvTypesOf(record[i])
+			}
+		}
+	}
+	types := make([]string, len(header))
+	for i, set := range candidates {
+		types[i] = set.best()
+	}
+	return header, types, nil
+}
+
+// csvTypeSet 一列的值都能解析成的类型集合
+type csvTypeSet uint8
+
+const (
+	csvInteger csvTypeSet = 1 << iota
+	csvFloat
+	csvBoolean
+	csvDate
+	csvDateTime
+	csvAllTypes = csvInteger | csvFloat | csvBoolean | csvDate | csvDateTime
+)
+
+// csvDateTimeLayouts 可以识别的日期时间格式
+var csvDateTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006/01/02 15:04:05"}
+
+// csvDateLayouts 可以识别的日期格式
+var csvDateLayouts = []string{"2006-01-02", "2006/01/02"}
+
+func csvTypesOf(s string) csvTypeSet {
+	var set csvTypeSet
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		set |= csvInteger
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		set |= csvFloat
+	}
+	if strings.EqualFold(s, "true") || strings.EqualFold(s, "false") {
+		set |= csvBoolean
+	}
+	if _, ok := parseCSVTime(s, csvDateLayouts); ok {
+		set |= csvDate
+	}
+	if _, ok := parseCSVTime(s, csvDateTimeLayouts); ok {
+		set |= csvDateTime
+	}
+	return set
+}
+
+func (s csvTypeSet) best() string {
+	switch {
+	case s == csvAllTypes: // 没有非空值
+		return TypeString
+	case s&csvInteger != 0:
+		return TypeInteger
+	case s&csvFloat != 0:
+		return TypeFloat
+	case s&csvBoolean != 0:
+		return TypeBoolean
+	case s&csvDate != 0:
+		return TypeDate
+	case s&csvDateTime != 0:
+		return TypeDateTime
+	}
+	return TypeString
+}
+
+func parseCSVTime(s string, layouts []string) (time.Time, bool) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseCSVValue 按归一化类型解析 CSV 中的值：空字符串为空值，integer 为 int64，float 为 float64，
+// boolean 为 bool，date、datetime 为 time.Time，其他为字符串
+func ParseCSVValue(raw, columnType string) (interface{}, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	switch columnType {
+	case TypeInteger:
+		return strconv.ParseInt(raw, 10, 64)
+	case TypeFloat:
+		return strconv.ParseFloat(raw, 64)
+	case TypeBoolean:
+		return strconv.ParseBool(strings.ToLower(raw))
+	case TypeDate:
+		if t, ok := parseCSVTime(raw, csvDateLayouts); ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("%q is not a date", raw)
+	case TypeDateTime:
+		if t, ok := parseCSVTime(raw, csvDateTimeLayouts); ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("%q is not a datetime", raw)
+	}
+	return raw, nil
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DataSourceDriver 数据源驱动，每种 models.DataSourceType 对应一个实现，
+// 在各自文件的 init 中通过 RegisterDriver 注册
+type DataSourceDriver interface {
+	// Type 驱动负责的数据源类型
+	Type() models.DataSourceType
+	// DSN 根据数据源配置构造连接串
+	DSN(ds *models.DataSource) (string, error)
+	// Connect 打开到数据源的连接
+	Connect(ds *models.DataSource) (*gorm.DB, error)
+	// ListEntities 列出数据源中的表、视图或文件
+	ListEntities(db *gorm.DB, ds *models.DataSource) ([]Entity, error)
+	// DescribeColumns 返回指定实体的列定义
+	DescribeColumns(db *gorm.DB, ds *models.DataSource, entity string) ([]Column, error)
+	// Query 执行查询语句，ctx 取消或超时时中止查询
+	Query(ctx context.Context, db *gorm.DB, query string, args ...interface{}) (*sql.Rows, error)
+	// Probe 建立一次性连接并执行轻量探测，返回服务端版本；文件型数据源只检查文件
+	Probe(ctx context.Context, ds *models.DataSource) (version string, err error)
+}
+
+var (
+	drivers    = make(map[models.DataSourceType]DataSourceDriver)
+	driverLock sync.RWMutex
+)
+
+// RegisterDriver 注册数据源驱动，同一类型重复注册会 panic
+func RegisterDriver(driver DataSourceDriver) {
+	driverLock.Lock()
+	defer driverLock.Unlock()
+
+	if _, exists := drivers[driver.Type()]; exists {
+		panic(fmt.Sprintf("database: driver for %s already registered", driver.Type()))
+	}
+	drivers[driver.Type()] = driver
+}
+
+// GetDriver 获取指定类型的数据源驱动
+func GetDriver(dsType models.DataSourceType) (DataSourceDriver, error) {
+	driverLock.RLock()
+	defer driverLock.RUnlock()
+
+	driver, ok := drivers[dsType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported datasource type: %s", dsType)
+	}
+	return driver, nil
+}
+
+// RegisteredTypes 返回已注册的数据源类型
+func RegisteredTypes() []models.DataSourceType {
+	driverLock.RLock()
+	defer driverLock.RUnlock()
+
+	types := make([]models.DataSourceType, 0, len(drivers))
+	for t := range drivers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// sqlDriver 提供基于 SQL 的驱动共用的查询实现
+type sqlDriver struct{}
+
+func (sqlDriver) Query(ctx context.Context, db *gorm.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.WithContext(ctx).Raw(query, args...).Rows()
+}
+
+// quoteIdent 使用双引号引用标识符（SQLite、PostgreSQL 及 ANSI 模式通用）
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sourceGormConfig 连接业务数据源时使用的 GORM 配置，只记录慢查询和错误
+func sourceGormConfig() *gorm.Config {
+	return &gorm.Config{
+		Logger: logger.New(
+			log.New(os.Stdout, "\r\n", log.LstdFlags),
+			logger.Config{
+				SlowThreshold: time.Second,
+				LogLevel:      logger.Warn,
+				Colorful:      true,
+			},
+		),
+	}
+}
@@ -12,10 +12,6 @@ import (
 	"time"
 )
 
-type DataSourceDriver interface {
-	Connect(cfg config.DatabaseConfig) (*gorm.DB, error)
-}
-
 var DB *gorm.DB
 
 func Connect(cfg config.DatabaseConfig) (*gorm.DB, error) {
@@ -44,7 +40,8 @@ func Connect(cfg config.DatabaseConfig) (*gorm.DB, error) {
 }
 
 func AutoMigrate(db *gorm.DB) error {
-	err := db.AutoMigrate(&models.DataSource{})
+	err := db.AutoMigrate(&models.DataSource{}, &models.Report{}, &models.ReportJob{}, &models.ReportJobEvent{}, &models.ReportSchedule{},
+		&models.DeliveryTarget{}, &models.ReportDelivery{}, &models.ReportDeliveryEvent{})
 	if err != nil {
 		return fmt.Errorf("failed to auto-migrate database: %w", err)
 	}
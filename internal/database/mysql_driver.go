@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/foldn/bi-go/internal/models"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type mysqlDriver struct {
+	sqlDriver
+}
+
+func init() {
+	RegisterDriver(mysqlDriver{})
+}
+
+func (mysqlDriver) Type() models.DataSourceType {
+	return models.MySQL
+}
+
+// DSN 由驱动的 Config 生成连接串，用户名、密码中的 @、/、: 等字符不会破坏连接串
+func (mysqlDriver) DSN(ds *models.DataSource) (string, error) {
+	return mysqlConfig(ds).FormatDSN(), nil
+}
+
+// mysqlConfig 数据源对应的驱动配置，等价于 user:pass@tcp(host:port)/dbname?charset=utf8mb4&parseTime=True&loc=Local
+func mysqlConfig(ds *models.DataSource) *mysqldriver.Config {
+	cfg := mysqldriver.NewConfig()
+	cfg.User = ds.Username
+	cfg.Passwd = ds.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(ds.Host, ds.Port)
+	cfg.DBName = ds.DBName
+	cfg.ParseTime = true
+	cfg.Loc = time.Local
+	cfg.Params = map[string]string{"charset": "utf8mb4"}
+	return cfg
+}
+
+func (d mysqlDriver) Connect(ds *models.DataSource) (*gorm.DB, error) {
+	dsn, err := d.DSN(ds)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(mysql.Open(dsn), sourceGormConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to mysql: %w", err)
+	}
+	return db, nil
+}
+
+func (mysqlDriver) ListEntities(db *gorm.DB, ds *models.DataSource) ([]Entity, error) {
+	rows, err := db.Raw(`SELECT TABLE_NAME,
+		CASE TABLE_TYPE WHEN 'VIEW' THEN 'view' ELSE 'table' END,
+		CASE TABLE_TYPE WHEN 'VIEW' THEN NULL ELSE TABLE_ROWS END
+		FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = DATABASE()
+		ORDER BY TABLE_NAME`).Rows()
+	if err != nil {
+		return nil, err
+	}
+	return scanEntities(rows)
+}
+
+func (mysqlDriver) DescribeColumns(db *gorm.DB, ds *models.DataSource, entity string) ([]Column, error) {
+	rows, err := db.Raw(`SELECT COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE = 'YES', COLUMN_KEY = 'PRI', COLUMN_DEFAULT
+		FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+		ORDER BY ORDINAL_POSITION`, entity).Rows()
+	if err != nil {
+		return nil, err
+	}
+	return scanColumns(rows)
+}
+
+// Probe 建立连接时驱动不使用 ctx，按 ctx 的截止时间设置连接和读写超时，
+// 使超时后的拨号和读取也随之结束
+func (d mysqlDriver) Probe(ctx context.Context, ds *models.DataSource) (string, error) {
+	cfg := mysqlConfig(ds)
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return "", context.DeadlineExceeded
+		}
+		cfg.Timeout, cfg.ReadTimeout, cfg.WriteTimeout = timeout, timeout, timeout
+	}
+	db, err := gorm.Open(mysql.Open(cfg.FormatDSN()), sourceGormConfig())
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to mysql: %w", err)
+	}
+	defer closeDB(db)
+
+	return queryVersion(ctx, db, "SELECT VERSION()")
+}
+
+func (mysqlDriver) ClassifyError(err error) string {
+	var mysqlErr *mysqldriver.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return ""
+	}
+	switch mysqlErr.Number {
+	case 1044, 1045, 1698: // ER_DBACCESS_DENIED_ERROR, ER_ACCESS_DENIED_ERROR, ER_ACCESS_DENIED_NO_PASSWORD_ERROR
+		return ErrorCategoryAuth
+	case 1049: // ER_BAD_DB_ERROR
+		return ErrorCategoryUnknownDatabase
+	}
+	return ""
+}
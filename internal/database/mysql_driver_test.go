@@ -0,0 +1,62 @@
+package database
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/models"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLDSN(t *testing.T) {
+	ds := &models.DataSource{Type: models.MySQL, Host: "db.internal", Port: "3307", DBName: "sales",
+		Username: "report@bi", Password: "p@ss/w:rd?x=1&y"}
+	dsn, err := mysqlDriver{}.DSN(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("%s: %v", dsn, err)
+	}
+	if cfg.User != ds.Username || cfg.Passwd != ds.Password || cfg.Net != "tcp" || cfg.Addr != "db.internal:3307" || cfg.DBName != "sales" {
+		t.Fatalf("got %+v", cfg)
+	}
+	if !cfg.ParseTime || cfg.Loc != time.Local || cfg.Params["charset"] != "utf8mb4" {
+		t.Fatalf("got parseTime=%v loc=%v params=%v", cfg.ParseTime, cfg.Loc, cfg.Params)
+	}
+}
+
+// TestMySQLProbeTimeout 服务端接受连接但不响应时，探测在超时后返回，拨号协程也随之结束
+func TestMySQLProbeTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		// 不发送握手包，直到客户端因读超时关闭连接
+		io.Copy(io.Discard, conn)
+		conn.Close()
+		close(closed)
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	ds := &models.DataSource{Type: models.MySQL, Host: host, Port: port, Username: "u", Password: "p", DBName: "d"}
+	result := TestConnection(ds, 200*time.Millisecond)
+	if result.Success || result.ErrorCategory != ErrorCategoryTimeout {
+		t.Fatalf("got %+v, want timeout", result)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("probe connection still open after the timeout")
+	}
+}
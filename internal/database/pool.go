@@ -0,0 +1,265 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/gorm"
+)
+
+// 连接池默认参数，配置项为零值时使用
+const (
+	defaultMaxOpenConns    = 10
+	defaultMaxIdleConns    = 2
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+	defaultConnectTimeout  = 5 * time.Second
+)
+
+// PoolConfigurer 由需要自定义连接池参数的驱动实现，
+// 例如只能使用单个常驻连接的内存数据库
+type PoolConfigurer interface {
+	ConfigurePool(sqlDB *sql.DB, cfg config.PoolConfig)
+}
+
+// PoolStats 单个数据源连接池的统计信息
+type PoolStats struct {
+	DataSourceID       uint                  `json:"dataSourceId"`
+	Type               models.DataSourceType `json:"type"`
+	Connected          bool                  `json:"connected"`
+	MaxOpenConnections int                   `json:"maxOpenConnections"`
+	OpenConnections    int                   `json:"openConnections"`
+	InUse              int                   `json:"inUse"`
+	Idle               int                   `json:"idle"`
+	WaitCount          int64                 `json:"waitCount"`
+	WaitDurationMs     int64                 `json:"waitDurationMs"`
+	MaxIdleClosed      int64                 `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64                 `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64                 `json:"maxLifetimeClosed"`
+	CreatedAt          *time.Time            `json:"createdAt,omitempty"`
+	LastUsedAt         *time.Time            `json:"lastUsedAt,omitempty"`
+}
+
+// ConnectionManager 为每个数据源维护一个共享的连接池。
+// 数据源配置变更（UpdatedAt 变化）时会自动重建连接池，
+// 数据源更新或删除后应调用 Evict 立即关闭旧连接。
+type ConnectionManager struct {
+	cfg   config.PoolConfig
+	mu    sync.Mutex
+	pools map[uint]*pool
+}
+
+type pool struct {
+	mu        sync.Mutex
+	driver    DataSourceDriver
+	db        *gorm.DB
+	dsType    models.DataSourceType
+	version   time.Time
+	createdAt time.Time
+	lastUsed  time.Time
+	closed    bool
+}
+
+// NewConnectionManager 创建连接池管理器
+func NewConnectionManager(cfg config.PoolConfig) *ConnectionManager {
+	if cfg.MaxOpenConns <= 0 {
+		cfg.MaxOpenConns = defaultMaxOpenConns
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = defaultMaxIdleConns
+	}
+	if cfg.ConnMaxLifetime <= 0 {
+		cfg.ConnMaxLifetime = defaultConnMaxLifetime
+	}
+	if cfg.ConnMaxIdleTime <= 0 {
+		cfg.ConnMaxIdleTime = defaultConnMaxIdleTime
+	}
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = defaultConnectTimeout
+	}
+	return &ConnectionManager{cfg: cfg, pools: make(map[uint]*pool)}
+}
+
+// Get 返回数据源的驱动和共享连接，调用方不应关闭返回的连接
+func (m *ConnectionManager) Get(ds *models.DataSource) (DataSourceDriver, *gorm.DB, error) {
+	if ds.ID == 0 {
+		return nil, nil, errors.New("datasource must be saved before it can be pooled")
+	}
+
+	for {
+		m.mu.Lock()
+		p, ok := m.pools[ds.ID]
+		if !ok {
+			p = &pool{}
+			m.pools[ds.ID] = p
+		}
+		m.mu.Unlock()
+
+		p.mu.Lock()
+		if p.closed {
+			// 等待期间被 Evict，重新获取
+			p.mu.Unlock()
+			continue
+		}
+		driver, db, err := m.ensure(p, ds)
+		p.mu.Unlock()
+		return driver, db, err
+	}
+}
+
+// ensure 在持有 p.mu 时调用，必要时建立或重建连接
+func (m *ConnectionManager) ensure(p *pool, ds *models.DataSource) (DataSourceDriver, *gorm.DB, error) {
+	now := time.Now()
+	if p.db != nil && p.version.Equal(ds.UpdatedAt) {
+		p.lastUsed = now
+		return p.driver, p.db, nil
+	}
+	if p.db != nil {
+		closeDB(p.db)
+		p.db = nil
+	}
+
+	driver, err := GetDriver(ds.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := driver.Connect(ds)
+	if err != nil {
+		return nil, nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, nil, err
+	}
+	if configurer, ok := driver.(PoolConfigurer); ok {
+		configurer.ConfigurePool(sqlDB, m.cfg)
+	} else {
+		sqlDB.SetMaxOpenConns(m.cfg.MaxOpenConns)
+		sqlDB.SetMaxIdleConns(m.cfg.MaxIdleConns)
+		sqlDB.SetConnMaxLifetime(m.cfg.ConnMaxLifetime)
+		sqlDB.SetConnMaxIdleTime(m.cfg.ConnMaxIdleTime)
+	}
+
+	p.driver = driver
+	p.db = db
+	p.dsType = ds.Type
+	p.version = ds.UpdatedAt
+	p.createdAt = now
+	p.lastUsed = now
+	return driver, db, nil
+}
+
+// ConnectTimeout 连接测试使用的超时时间
+func (m *ConnectionManager) ConnectTimeout() time.Duration {
+	return m.cfg.ConnectTimeout
+}
+
+// Evict 关闭并移除数据源的连接池
+func (m *ConnectionManager) Evict(dataSourceID uint) error {
+	m.mu.Lock()
+	p, ok := m.pools[dataSourceID]
+	delete(m.pools, dataSourceID)
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.db == nil {
+		return nil
+	}
+	err := closeDB(p.db)
+	p.db = nil
+	return err
+}
+
+// Close 关闭所有连接池
+func (m *ConnectionManager) Close() error {
+	m.mu.Lock()
+	ids := make([]uint, 0, len(m.pools))
+	for id := range m.pools {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, id := range ids {
+		if err := m.Evict(id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stats 返回所有已建立连接池的统计信息，按数据源ID排序
+func (m *ConnectionManager) Stats() []PoolStats {
+	m.mu.Lock()
+	ids := make([]uint, 0, len(m.pools))
+	for id := range m.pools {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	stats := make([]PoolStats, 0, len(ids))
+	for _, id := range ids {
+		if s := m.PoolStats(id); s.Connected {
+			stats = append(stats, s)
+		}
+	}
+	return stats
+}
+
+// PoolStats 返回单个数据源的连接池统计信息，尚未建立连接时 Connected 为 false
+func (m *ConnectionManager) PoolStats(dataSourceID uint) PoolStats {
+	stats := PoolStats{DataSourceID: dataSourceID}
+
+	m.mu.Lock()
+	p, ok := m.pools[dataSourceID]
+	m.mu.Unlock()
+	if !ok {
+		return stats
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.db == nil {
+		return stats
+	}
+	sqlDB, err := p.db.DB()
+	if err != nil {
+		return stats
+	}
+
+	s := sqlDB.Stats()
+	createdAt, lastUsed := p.createdAt, p.lastUsed
+	stats.Type = p.dsType
+	stats.Connected = true
+	stats.MaxOpenConnections = s.MaxOpenConnections
+	stats.OpenConnections = s.OpenConnections
+	stats.InUse = s.InUse
+	stats.Idle = s.Idle
+	stats.WaitCount = s.WaitCount
+	stats.WaitDurationMs = s.WaitDuration.Milliseconds()
+	stats.MaxIdleClosed = s.MaxIdleClosed
+	stats.MaxIdleTimeClosed = s.MaxIdleTimeClosed
+	stats.MaxLifetimeClosed = s.MaxLifetimeClosed
+	stats.CreatedAt = &createdAt
+	stats.LastUsedAt = &lastUsed
+	return stats
+}
+
+func closeDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
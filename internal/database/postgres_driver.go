@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type postgresDriver struct {
+	sqlDriver
+}
+
+func init() {
+	RegisterDriver(postgresDriver{})
+}
+
+func (postgresDriver) Type() models.DataSourceType {
+	return models.PostgreSQL
+}
+
+// DSN 生成 postgres:// 形式的连接串，用户名、密码中的空格、引号、= 等字符经过转义，不会破坏连接串或加入其他参数。
+// sslmode 默认为 prefer，OtherParams 中的 libpq 参数（如 sslmode=verify-full&TimeZone=UTC）覆盖默认值
+func (postgresDriver) DSN(ds *models.DataSource) (string, error) {
+	params, err := ParsePostgresParams(ds.OtherParams)
+	if err != nil {
+		return "", err
+	}
+	if params.Get("sslmode") == "" {
+		params.Set("sslmode", "prefer")
+	}
+	return (&url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(ds.Username, ds.Password),
+		Host:     net.JoinHostPort(ds.Host, ds.Port),
+		Path:     "/" + ds.DBName,
+		RawQuery: params.Encode(),
+	}).String(), nil
+}
+
+// ParsePostgresParams 解析 PostgreSQL 数据源的 OtherParams，格式为 URL 查询串，为空时没有额外参数。
+// 连接目标和凭据只能由数据源的字段指定
+func ParsePostgresParams(otherParams string) (url.Values, error) {
+	params, err := url.ParseQuery(strings.TrimSpace(otherParams))
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgresql params: %w", err)
+	}
+	for name := range params {
+		switch strings.ToLower(name) {
+		case "host", "hostaddr", "port", "dbname", "user", "password", "passfile", "service", "servicefile":
+			return nil, fmt.Errorf("invalid postgresql params: %s is set by the datasource", name)
+		}
+	}
+	return params, nil
+}
+
+func (d postgresDriver) Connect(ds *models.DataSource) (*gorm.DB, error) {
+	dsn, err := d.DSN(ds)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(postgres.Open(dsn), sourceGormConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgresDb: %w", err)
+	}
+	return db, nil
+}
+
+func (postgresDriver) ListEntities(db *gorm.DB, ds *models.DataSource) ([]Entity, error) {
+	// reltuples 为 ANALYZE 后的统计值，未统计过时为 -1
+	rows, err := db.Raw(`SELECT t.table_name,
+		CASE t.table_type WHEN 'VIEW' THEN 'view' ELSE 'table' END,
+		CASE WHEN t.table_type <> 'VIEW' AND c.reltuples >= 0 THEN c.reltuples::bigint END
+		FROM information_schema.tables t
+		LEFT JOIN pg_class c ON c.relname = t.table_name
+			AND c.relnamespace = (SELECT oid FROM pg_namespace WHERE nspname = t.table_schema)
+		WHERE t.table_schema = current_schema()
+		ORDER BY t.table_name`).Rows()
+	if err != nil {
+		return nil, err
+	}
+	return scanEntities(rows)
+}
+
+func (postgresDriver) DescribeColumns(db *gorm.DB, ds *models.DataSource, entity string) ([]Column, error) {
+	rows, err := db.Raw(`SELECT c.column_name, c.data_type, c.is_nullable = 'YES',
+		EXISTS (
+			SELECT 1 FROM information_schema.table_constraints tc
+			JOIN information_schema.key_column_usage kcu
+				ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema
+			WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = c.table_schema
+				AND tc.table_name = c.table_name AND kcu.column_name = c.column_name
+		),
+		c.column_default
+		FROM information_schema.columns c
+		WHERE c.table_schema = current_schema() AND c.table_name = ?
+		ORDER BY c.ordinal_position`, entity).Rows()
+	if err != nil {
+		return nil, err
+	}
+	return scanColumns(rows)
+}
+
+func (d postgresDriver) Probe(ctx context.Context, ds *models.DataSource) (string, error) {
+	return probeSQL(ctx, d, ds, "SHOW server_version")
+}
+
+func (postgresDriver) ClassifyError(err error) string {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return ""
+	}
+	switch pgErr.Code {
+	case "28000", "28P01": // invalid_authorization_specification, invalid_password
+		return ErrorCategoryAuth
+	case "3D000": // invalid_catalog_name
+		return ErrorCategoryUnknownDatabase
+	}
+	return ""
+}
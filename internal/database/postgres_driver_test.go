@@ -0,0 +1,48 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestPostgresDSN(t *testing.T) {
+	ds := &models.DataSource{Type: models.PostgreSQL, Host: "db.internal", Port: "5433", DBName: "sales",
+		Username: "report user", Password: "p@ss w'rd=x sslmode=disable/?#&"}
+	dsn, err := postgresDriver{}.DSN(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("%s: %v", dsn, err)
+	}
+	if cfg.User != ds.Username || cfg.Password != ds.Password || cfg.Host != "db.internal" || cfg.Port != 5433 || cfg.Database != "sales" {
+		t.Fatalf("got user=%q password=%q host=%s port=%d database=%s", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Database)
+	}
+	// sslmode=prefer 先尝试 TLS，失败时回退到明文连接
+	if cfg.TLSConfig == nil || len(cfg.Fallbacks) == 0 || cfg.Fallbacks[0].TLSConfig != nil {
+		t.Fatalf("got TLS %v, fallbacks %d", cfg.TLSConfig, len(cfg.Fallbacks))
+	}
+
+	ds.OtherParams = "sslmode=disable&TimeZone=Asia/Shanghai&application_name=bi-go"
+	dsn, err = postgresDriver{}.DSN(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = pgconn.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("%s: %v", dsn, err)
+	}
+	if cfg.TLSConfig != nil || cfg.RuntimeParams["TimeZone"] != "Asia/Shanghai" || cfg.RuntimeParams["application_name"] != "bi-go" {
+		t.Fatalf("got TLS %v, runtime params %v", cfg.TLSConfig, cfg.RuntimeParams)
+	}
+
+	for _, params := range []string{"password=x", "host=evil&sslmode=disable", "sslmode=%zz"} {
+		ds.OtherParams = params
+		if _, err := (postgresDriver{}).DSN(ds); err == nil {
+			t.Errorf("%s: got no error", params)
+		}
+	}
+}
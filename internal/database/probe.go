@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/gorm"
+)
+
+// 连接测试失败的错误分类
+const (
+	ErrorCategoryAuth            = "auth"
+	ErrorCategoryNetwork         = "network"
+	ErrorCategoryTimeout         = "timeout"
+	ErrorCategoryUnknownDatabase = "unknown_database"
+	ErrorCategoryTLS             = "tls"
+	ErrorCategoryFile            = "file"
+	ErrorCategoryConfig          = "config"
+	ErrorCategoryUnknown         = "unknown"
+)
+
+// ErrInvalidFile 文件型数据源的文件内容无法识别
+var ErrInvalidFile = errors.New("invalid datasource file")
+
+// ErrorClassifier 由驱动实现，识别驱动特有的错误类型，无法识别时返回空串
+type ErrorClassifier interface {
+	ClassifyError(err error) string
+}
+
+// ConnectionTestResult 连接测试结果
+type ConnectionTestResult struct {
+	Success       bool                  `json:"success"`
+	Type          models.DataSourceType `json:"type"`
+	LatencyMs     int64                 `json:"latencyMs"`
+	ServerVersion string                `json:"serverVersion,omitempty"`
+	ErrorCategory string                `json:"errorCategory,omitempty"`
+	Error         string                `json:"error,omitempty"`
+}
+
+// TestConnection 在超时时间内连接数据源并执行探测，不使用也不影响连接池
+func TestConnection(ds *models.DataSource, timeout time.Duration) *ConnectionTestResult {
+	result := &ConnectionTestResult{Type: ds.Type}
+
+	driver, err := GetDriver(ds.Type)
+	if err != nil {
+		result.ErrorCategory = ErrorCategoryConfig
+		result.Error = err.Error()
+		return result
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type probeResult struct {
+		version string
+		err     error
+	}
+	done := make(chan probeResult, 1)
+	start := time.Now()
+	go func() {
+		version, err := driver.Probe(ctx, ds)
+		done <- probeResult{version: version, err: err}
+	}()
+
+	var res probeResult
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		// 部分驱动建立连接时不支持 context，超时后直接返回，探测协程自行结束
+		res.err = fmt.Errorf("connection test timed out after %s", timeout)
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+
+	if res.err != nil {
+		result.ErrorCategory = classifyError(ctx, driver, res.err)
+		result.Error = res.err.Error()
+		return result
+	}
+	result.Success = true
+	result.ServerVersion = res.version
+	return result
+}
+
+// classifyError 先由驱动识别特有错误，再按通用的超时、网络、TLS、文件错误归类
+func classifyError(ctx context.Context, driver DataSourceDriver, err error) string {
+	if classifier, ok := driver.(ErrorClassifier); ok {
+		if category := classifier.ClassifyError(err); category != "" {
+			return category
+		}
+	}
+
+	var (
+		netErr      net.Error
+		recordErr   tls.RecordHeaderError
+		unknownAuth x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		certInvalid x509.CertificateInvalidError
+		pathErr     *os.PathError
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil:
+		return ErrorCategoryTimeout
+	case errors.As(err, &recordErr), errors.As(err, &unknownAuth),
+		errors.As(err, &hostnameErr), errors.As(err, &certInvalid),
+		strings.Contains(err.Error(), "tls:"), strings.Contains(err.Error(), "x509:"):
+		return ErrorCategoryTLS
+	case errors.Is(err, ErrInvalidFile), errors.As(err, &pathErr),
+		errors.Is(err, os.ErrNotExist), errors.Is(err, os.ErrPermission):
+		return ErrorCategoryFile
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorCategoryTimeout
+		}
+		return ErrorCategoryNetwork
+	case strings.Contains(err.Error(), "connection refused"), strings.Contains(err.Error(), "no such host"):
+		return ErrorCategoryNetwork
+	}
+	return ErrorCategoryUnknown
+}
+
+// probeSQL 使用驱动建立一次性连接并执行版本查询，结束后关闭连接
+func probeSQL(ctx context.Context, driver DataSourceDriver, ds *models.DataSource, versionQuery string) (string, error) {
+	db, err := driver.Connect(ds)
+	if err != nil {
+		return "", err
+	}
+	defer closeDB(db)
+
+	return queryVersion(ctx, db, versionQuery)
+}
+
+func queryVersion(ctx context.Context, db *gorm.DB, versionQuery string) (string, error) {
+	var version string
+	if err := db.WithContext(ctx).Raw(versionQuery).Row().Scan(&version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
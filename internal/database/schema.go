@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// 实体类型
+const (
+	EntityTable = "table"
+	EntityView  = "view"
+	EntityFile  = "file"
+)
+
+// 归一化后的列类型，与具体数据库无关，供前端字段选择器使用
+const (
+	TypeString   = "string"
+	TypeInteger  = "integer"
+	TypeFloat    = "float"
+	TypeDecimal  = "decimal"
+	TypeBoolean  = "boolean"
+	TypeDate     = "date"
+	TypeDateTime = "datetime"
+	TypeTime     = "time"
+	TypeBinary   = "binary"
+	TypeJSON     = "json"
+	TypeUnknown  = "unknown"
+)
+
+// ErrEntityNotFound 数据源中不存在指定的表、视图或文件
+var ErrEntityNotFound = errors.New("entity not found")
+
+// Entity 数据源中的表、视图或文件
+type Entity struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// RowEstimate 行数估计值，数据源无法提供时为 null
+	RowEstimate *int64 `json:"rowEstimate"`
+}
+
+// Column 实体中的列
+type Column struct {
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	NativeType string  `json:"nativeType"`
+	Nullable   bool    `json:"nullable"`
+	PrimaryKey bool    `json:"primaryKey"`
+	Default    *string `json:"default"`
+}
+
+// typeWrappers 匹配 ClickHouse 的 Nullable(...) / LowCardinality(...) 包装类型
+var typeWrappers = regexp.MustCompile(`^(?:nullable|lowcardinality)\((.*)\)$`)
+
+// NormalizeType 将数据库原生类型映射为归一化类型
+func NormalizeType(nativeType string) string {
+	t := strings.ToLower(strings.TrimSpace(nativeType))
+	for {
+		m := typeWrappers.FindStringSubmatch(t)
+		if m == nil {
+			break
+		}
+		t = m[1]
+	}
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = t[:i]
+	}
+	t = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(t, " unsigned"), " zerofill"))
+
+	switch t {
+	case "char", "varchar", "character", "character varying", "text", "tinytext", "mediumtext", "longtext",
+		"string", "fixedstring", "uuid", "enum", "enum8", "enum16", "set", "citext", "name", "bpchar", "clob":
+		return TypeString
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "int2", "int4", "int8",
+		"serial", "bigserial", "smallserial", "int16", "int32", "int64", "int128", "int256",
+		"uint8", "uint16", "uint32", "uint64", "uint128", "uint256":
+		return TypeInteger
+	case "float", "double", "double precision", "real", "float4", "float8", "float32", "float64":
+		return TypeFloat
+	case "decimal", "numeric", "decimal32", "decimal64", "decimal128", "decimal256", "money":
+		return TypeDecimal
+	case "bool", "boolean", "bit":
+		return TypeBoolean
+	case "date", "date32":
+		return TypeDate
+	case "datetime", "datetime64", "timestamp", "timestamptz",
+		"timestamp without time zone", "timestamp with time zone":
+		return TypeDateTime
+	case "time", "timetz", "time without time zone", "time with time zone":
+		return TypeTime
+	case "binary", "varbinary", "blob", "tinyblob", "mediumblob", "longblob", "bytea":
+		return TypeBinary
+	case "json", "jsonb":
+		return TypeJSON
+	}
+	return TypeUnknown
+}
+
+// scanEntities 读取 name, kind, row_estimate 三列的结果集
+func scanEntities(rows *sql.Rows) ([]Entity, error) {
+	defer rows.Close()
+
+	entities := []Entity{}
+	for rows.Next() {
+		var e Entity
+		var estimate sql.NullInt64
+		if err := rows.Scan(&e.Name, &e.Kind, &estimate); err != nil {
+			return nil, err
+		}
+		if estimate.Valid {
+			e.RowEstimate = &estimate.Int64
+		}
+		entities = append(entities, e)
+	}
+	return entities, rows.Err()
+}
+
+// scanColumns 读取 name, native_type, nullable, primary_key, default 五列的结果集，
+// 结果为空视为实体不存在
+func scanColumns(rows *sql.Rows) ([]Column, error) {
+	defer rows.Close()
+
+	columns := []Column{}
+	for rows.Next() {
+		var c Column
+		var def sql.NullString
+		if err := rows.Scan(&c.Name, &c.NativeType, &c.Nullable, &c.PrimaryKey, &def); err != nil {
+			return nil, err
+		}
+		c.Type = NormalizeType(c.NativeType)
+		if def.Valid {
+			c.Default = &def.String
+		}
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, ErrEntityNotFound
+	}
+	return columns, nil
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type sqliteDriver struct {
+	sqlDriver
+}
+
+func init() {
+	RegisterDriver(sqliteDriver{})
+}
+
+func (sqliteDriver) Type() models.DataSourceType {
+	return models.Sqlite
+}
+
+// DSN 以只读方式打开数据库文件，避免误建空库
+func (sqliteDriver) DSN(ds *models.DataSource) (string, error) {
+	if ds.FilePath == "" {
+		return "", fmt.Errorf("sqlite datasource %q has no file path", ds.Name)
+	}
+	return "file:" + ds.FilePath + "?mode=ro", nil
+}
+
+func (d sqliteDriver) Connect(ds *models.DataSource) (*gorm.DB, error) {
+	dsn, err := d.DSN(ds)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(ds.FilePath); err != nil {
+		return nil, fmt.Errorf("sqlite file is not accessible: %w", err)
+	}
+	db, err := gorm.Open(sqlite.Open(dsn), sourceGormConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to sqlite: %w", err)
+	}
+	return db, nil
+}
+
+// ListEntities 表的行数估计来自 ANALYZE 生成的 sqlite_stat1，没有统计信息时为空，
+// 避免每次读取元数据都全表扫描
+func (sqliteDriver) ListEntities(db *gorm.DB, ds *models.DataSource) ([]Entity, error) {
+	rows, err := db.Raw(`SELECT name, type, NULL FROM sqlite_master
+		WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%'
+		ORDER BY name`).Rows()
+	if err != nil {
+		return nil, err
+	}
+	entities, err := scanEntities(rows)
+	if err != nil {
+		return nil, err
+	}
+	return estimateRows(db, entities)
+}
+
+func (sqliteDriver) DescribeColumns(db *gorm.DB, ds *models.DataSource, entity string) ([]Column, error) {
+	rows, err := db.Raw(`SELECT name, type, "notnull" = 0 AND pk = 0, pk > 0, dflt_value
+		FROM pragma_table_info(?) ORDER BY cid`, entity).Rows()
+	if err != nil {
+		return nil, err
+	}
+	return scanColumns(rows)
+}
+
+// sqliteHeader SQLite 数据库文件的魔数
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// Probe 检查文件可读且为 SQLite 数据库后查询库版本
+func (d sqliteDriver) Probe(ctx context.Context, ds *models.DataSource) (string, error) {
+	if _, err := d.DSN(ds); err != nil {
+		return "", err
+	}
+	file, err := os.Open(ds.FilePath)
+	if err != nil {
+		return "", err
+	}
+	header := make([]byte, len(sqliteHeader))
+	_, err = io.ReadFull(file, header)
+	file.Close()
+	if err != nil || !bytes.Equal(header, sqliteHeader) {
+		return "", fmt.Errorf("%s is not a sqlite database file: %w", ds.FilePath, ErrInvalidFile)
+	}
+	return probeSQL(ctx, d, ds, "SELECT sqlite_version()")
+}
+
+// estimateRows 从 sqlite_stat1 读取表的行数估计。每个索引一行统计，stat 的第一个数为表的行数；
+// 数据库未执行过 ANALYZE 时没有该表，行数估计保持为空
+func estimateRows(db *gorm.DB, entities []Entity) ([]Entity, error) {
+	var hasStats int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'sqlite_stat1'").Scan(&hasStats).Error; err != nil {
+		return nil, err
+	}
+	if hasStats == 0 {
+		return entities, nil
+	}
+	var stats []struct {
+		Tbl  string
+		Rows int64
+	}
+	if err := db.Raw("SELECT tbl, MAX(CAST(stat AS INTEGER)) AS rows FROM sqlite_stat1 GROUP BY tbl").Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+	for _, stat := range stats {
+		for i := range entities {
+			if entities[i].Kind == EntityTable && entities[i].Name == stat.Tbl {
+				count := stat.Rows
+				entities[i].RowEstimate = &count
+			}
+		}
+	}
+	return entities, nil
+}
+
+// countRows 统计表的精确行数，视图不统计。只用于已全部加载到内存的 CSV 表
+func countRows(db *gorm.DB, entities []Entity) ([]Entity, error) {
+	for i := range entities {
+		if entities[i].Kind == EntityView {
+			continue
+		}
+		var count int64
+		if err := db.Raw("SELECT COUNT(*) FROM " + quoteIdent(entities[i].Name)).Scan(&count).Error; err != nil {
+			return nil, err
+		}
+		entities[i].RowEstimate = &count
+	}
+	return entities, nil
+}
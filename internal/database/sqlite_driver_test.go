@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newSQLiteFile 在临时目录中创建 SQLite 数据库文件并执行 statements，返回指向该文件的数据源
+func newSQLiteFile(t *testing.T, statements ...string) *models.DataSource {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	closeDB(db)
+	return &models.DataSource{Name: "test", Type: models.Sqlite, FilePath: path}
+}
+
+func connectSQLite(t *testing.T, ds *models.DataSource) (DataSourceDriver, *gorm.DB) {
+	t.Helper()
+	driver, err := GetDriver(models.Sqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := driver.Connect(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeDB(db) })
+	return driver, db
+}
+
+func TestSQLiteQuery(t *testing.T) {
+	ds := newSQLiteFile(t,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, region TEXT NOT NULL, amount REAL)",
+		"INSERT INTO orders VALUES (1, 'east', 10.5), (2, 'west', 20), (3, 'east', NULL)")
+	driver, db := connectSQLite(t, ds)
+
+	rows, err := driver.Query(context.Background(), db, "SELECT region, SUM(amount) FROM orders WHERE id > ? GROUP BY region ORDER BY region", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var region string
+		var total float64
+		if err := rows.Scan(&region, &total); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, region)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "east" || got[1] != "west" {
+		t.Fatalf("got regions %v", got)
+	}
+}
+
+func TestSQLiteQueryCancelled(t *testing.T) {
+	ds := newSQLiteFile(t, "CREATE TABLE t (id INTEGER)")
+	driver, db := connectSQLite(t, ds)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := driver.Query(ctx, db, "SELECT * FROM t"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestSQLiteReadOnly(t *testing.T) {
+	ds := newSQLiteFile(t, "CREATE TABLE t (id INTEGER)")
+	_, db := connectSQLite(t, ds)
+
+	if err := db.Exec("INSERT INTO t VALUES (1)").Error; err == nil {
+		t.Fatal("write to a read-only datasource succeeded")
+	}
+}
+
+func TestSQLiteSchema(t *testing.T) {
+	ds := newSQLiteFile(t,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, region TEXT NOT NULL DEFAULT 'east', amount DECIMAL(10,2), created_at DATETIME)",
+		"CREATE VIEW big_orders AS SELECT * FROM orders WHERE amount > 100")
+	driver, db := connectSQLite(t, ds)
+
+	entities, err := driver.ListEntities(db, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 2 || entities[0].Name != "big_orders" || entities[0].Kind != EntityView ||
+		entities[1].Name != "orders" || entities[1].Kind != EntityTable {
+		t.Fatalf("got entities %+v", entities)
+	}
+
+	columns, err := driver.DescribeColumns(db, ds, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Column{
+		{Name: "id", Type: TypeInteger, NativeType: "INTEGER", Nullable: false, PrimaryKey: true},
+		{Name: "region", Type: TypeString, NativeType: "TEXT", Nullable: false},
+		{Name: "amount", Type: TypeDecimal, NativeType: "DECIMAL(10,2)", Nullable: true},
+		{Name: "created_at", Type: TypeDateTime, NativeType: "DATETIME", Nullable: true},
+	}
+	if len(columns) != len(want) {
+		t.Fatalf("got columns %+v", columns)
+	}
+	for i, c := range columns {
+		c.Default = nil
+		if c != want[i] {
+			t.Errorf("column %d: got %+v, want %+v", i, c, want[i])
+		}
+	}
+	if d := columns[1].Default; d == nil || *d != "'east'" {
+		t.Errorf("region default: got %v", d)
+	}
+}
+
+func TestSQLiteProbe(t *testing.T) {
+	ds := newSQLiteFile(t, "CREATE TABLE t (id INTEGER)")
+	result := TestConnection(ds, 5*time.Second)
+	if !result.Success || result.ServerVersion == "" {
+		t.Fatalf("got %+v", result)
+	}
+
+	notSQLite := filepath.Join(t.TempDir(), "data.db")
+	if err := os.WriteFile(notSQLite, []byte("id,name\n1,a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	result = TestConnection(&models.DataSource{Type: models.Sqlite, FilePath: notSQLite}, 5*time.Second)
+	if result.Success || result.ErrorCategory != ErrorCategoryFile {
+		t.Fatalf("got %+v, want file error", result)
+	}
+
+	result = TestConnection(&models.DataSource{Type: models.Sqlite, FilePath: filepath.Join(t.TempDir(), "missing.db")}, 5*time.Second)
+	if result.Success || result.ErrorCategory != ErrorCategoryFile {
+		t.Fatalf("got %+v, want file error", result)
+	}
+}
+
+func TestSQLiteRowEstimates(t *testing.T) {
+	ds := newSQLiteFile(t,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, region TEXT)",
+		"CREATE INDEX orders_region ON orders (region)",
+		"CREATE TABLE empty (id INTEGER)",
+		"INSERT INTO orders (region) VALUES ('east'), ('west'), ('east')")
+	driver, db := connectSQLite(t, ds)
+
+	entities, err := driver.ListEntities(db, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entities {
+		if e.RowEstimate != nil {
+			t.Fatalf("%s: got estimate %d without statistics", e.Name, *e.RowEstimate)
+		}
+	}
+
+	ds = newSQLiteFile(t,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, region TEXT)",
+		"CREATE INDEX orders_region ON orders (region)",
+		"CREATE TABLE empty (id INTEGER)",
+		"INSERT INTO orders (region) VALUES ('east'), ('west'), ('east')",
+		"ANALYZE")
+	driver, db = connectSQLite(t, ds)
+	entities, err = driver.ListEntities(db, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 2 || entities[0].Name != "empty" || entities[0].RowEstimate != nil ||
+		entities[1].Name != "orders" || entities[1].RowEstimate == nil || *entities[1].RowEstimate != 3 {
+		t.Fatalf("got entities %+v", entities)
+	}
+}
@@ -26,10 +26,19 @@ type DataSource struct {
 	Host        string         `gorm:"type:varchar(255)"`
 	Port        string         `gorm:"type:varchar(10)"`
 	Username    string         `gorm:"type:varchar(255)"`
-	Password    string         `gorm:"type:varchar(255)"`
+	Password    string         `gorm:"type:varchar(255)"` // 明文仅存在于内存，该列只残留启用加密前的旧数据
 	DBName      string         `gorm:"type:varchar(255)"`
 	FilePath    string         `gorm:"type:text"`
 	OtherParams string         `gorm:"type:text"`
 	Description string         `gorm:"type:text"`
 	IsDelete    IsDeleteType   `gorm:"type:tinyint"`
+
+	// 报表查询的限制：MaxRows 大于 0 时查询必须带有不超过该值的 LIMIT，ForbiddenTables 中的表不允许查询
+	MaxRows         int      `gorm:"not null;default:0"`
+	ForbiddenTables []string `gorm:"type:text;serializer:json"`
+
+	// 密码的信封加密结果，由 repository 在读写时加解密
+	EncryptedPassword string `gorm:"type:text"`
+	EncryptedDataKey  string `gorm:"type:text"`
+	KeyID             string `gorm:"type:varchar(64)"`
 }
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeliveryType 投递方式
+type DeliveryType string
+
+const (
+	DeliveryEmail     DeliveryType = "email"
+	DeliveryWebhook   DeliveryType = "webhook"
+	DeliveryDirectory DeliveryType = "directory"
+)
+
+// DeliveryTarget 报表的投递目标，报表任务完成后按目标发送或复制生成的文件
+type DeliveryTarget struct {
+	gorm.Model
+	ReportID uint                 `gorm:"index;not null"`
+	Name     string               `gorm:"type:varchar(255)"`
+	Type     DeliveryType         `gorm:"type:varchar(20);not null"`
+	Enabled  bool                 `gorm:"not null"`
+	Config   DeliveryTargetConfig `gorm:"type:text;serializer:json"`
+
+	// webhook 签名密钥的信封加密结果，由 repository 在读写时加解密
+	EncryptedSecret  string `gorm:"type:text"`
+	EncryptedDataKey string `gorm:"type:text"`
+	KeyID            string `gorm:"type:varchar(64)"`
+}
+
+// DeliveryTargetConfig 投递目标的配置，按 Type 使用其中的字段。
+// 以 JSON 保存在投递目标中，字段名同时也是 API 中的字段名
+type DeliveryTargetConfig struct {
+	// email：收件人、抄送，Subject、Body 为 text/template 模板
+	To      []string `json:"to,omitempty"`
+	Cc      []string `json:"cc,omitempty"`
+	Subject string   `json:"subject,omitempty"`
+	Body    string   `json:"body,omitempty"`
+	Attach  bool     `json:"attach,omitempty"` // true 时附带报表文件，否则只发送下载链接
+
+	// webhook：Secret 用于对请求体签名，只以加密形式保存
+	URL     string            `json:"url,omitempty"`
+	Secret  string            `json:"-"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// directory：复制到的目录，FileName 为 text/template 模板
+	Path     string `json:"path,omitempty"`
+	FileName string `json:"fileName,omitempty"`
+}
+
+// DeliveryStatus 投递状态
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySending   DeliveryStatus = "sending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// ReportDelivery 一个报表任务向一个投递目标的投递
+type ReportDelivery struct {
+	gorm.Model
+	JobID       uint           `gorm:"index;not null"`
+	TargetID    uint           `gorm:"index;not null"`
+	Type        DeliveryType   `gorm:"type:varchar(20);not null"`
+	Destination string         `gorm:"type:text"` // 收件人、URL 或目录，便于查看投递记录
+	Status      DeliveryStatus `gorm:"type:varchar(20);index;not null"`
+	Error       string         `gorm:"type:text"` // 最近一次失败的原因
+
+	Attempts      int        `gorm:"not null;default:0"`
+	MaxAttempts   int        `gorm:"not null;default:1"`
+	NextAttemptAt *time.Time `gorm:"index"` // pending 投递最早可执行的时间
+	DeliveredAt   *time.Time
+	// ClaimedBy 最近一次领取投递的实例，LeaseUntil 为其租约的到期时间。
+	// 发送中的实例定期续约，租约到期的 sending 投递视为发送实例已退出，由其他实例恢复
+	ClaimedBy  string     `gorm:"type:varchar(64)"`
+	LeaseUntil *time.Time `gorm:"index"`
+
+	Events []ReportDeliveryEvent `gorm:"foreignKey:DeliveryID"`
+}
+
+// ReportDeliveryEvent 每次投递尝试的记录
+type ReportDeliveryEvent struct {
+	ID         uint           `gorm:"primarykey"`
+	DeliveryID uint           `gorm:"index;not null"`
+	Attempt    int            `gorm:"not null"`
+	Status     DeliveryStatus `gorm:"type:varchar(20);not null"`
+	Message    string         `gorm:"type:text"`
+	CreatedAt  time.Time
+}
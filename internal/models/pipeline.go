@@ -0,0 +1,111 @@
+package models
+
+// OperationType 数据处理操作的类型
+type OperationType string
+
+const (
+	OpSelect  OperationType = "select"  // 选择并排列输出列
+	OpFilter  OperationType = "filter"  // 按条件过滤行
+	OpGroupBy OperationType = "groupBy" // 分组并计算聚合值
+
+	OpJoin      OperationType = "join"      // 与另一个实体连接，实体可以位于其他数据源
+	OpCalculate OperationType = "calculate" // 按表达式计算新列
+	OpSort      OperationType = "sort"      // 按一列或多列排序
+	OpLimit     OperationType = "limit"     // 跳过和截取行
+	OpDistinct  OperationType = "distinct"  // 去除重复行
+	OpUnion     OperationType = "union"     // 追加另一个实体的行，实体可以位于其他数据源
+)
+
+// Pipeline 对一个数据源实体依次执行的数据处理操作，以 JSON 保存在处理任务中
+type Pipeline struct {
+	DataSourceID uint        `json:"dataSourceId"`
+	Entity       string      `json:"entity"` // 表、视图或文件名，可带 schema 前缀
+	Operations   []Operation `json:"operations"`
+}
+
+// Operation 一个数据处理操作，按 Type 使用其中的字段。
+// 字段名同时也是 API 中的字段名
+type Operation struct {
+	Type OperationType `json:"type"`
+
+	// select：输出的列，按给出的顺序排列
+	Columns []string `json:"columns,omitempty"`
+
+	// filter：Match 为 all（默认）时所有条件都满足，为 any 时任一条件满足
+	Conditions []FilterCondition `json:"conditions,omitempty"`
+	Match      string            `json:"match,omitempty"`
+
+	// groupBy：分组列和聚合，没有分组列时整个输入聚合为一行
+	GroupBy    []string    `json:"groupBy,omitempty"`
+	Aggregates []Aggregate `json:"aggregates,omitempty"`
+
+	// join、union：另一个实体，Operations 为连接或合并前在该实体上执行的操作。
+	// DataSourceID 为该实体所在的数据源，默认与外层相同；位于其他数据源时 MaxRows 为最多从该侧读取的行数
+	Entity       string      `json:"entity,omitempty"`
+	Operations   []Operation `json:"operations,omitempty"`
+	DataSourceID uint        `json:"dataSourceId,omitempty"`
+	MaxRows      int         `json:"maxRows,omitempty"`
+
+	// join：JoinType 为 inner（默认）、left 或 full，On 为连接列。
+	// 右侧与左侧同名的列输出为 别名.列名，Alias 默认为实体名中最后一段。
+	// Strategy 为在内存中连接时的算法：hash（默认）或 merge（两侧按连接键排序后归并）
+	JoinType string    `json:"joinType,omitempty"`
+	On       []JoinKey `json:"on,omitempty"`
+	Alias    string    `json:"alias,omitempty"`
+	Strategy string    `json:"strategy,omitempty"`
+
+	// calculate：依次计算的新列，后面的表达式可以引用前面的新列
+	Fields []CalculatedField `json:"fields,omitempty"`
+
+	// sort：排序列，前面的列优先
+	Keys []SortKey `json:"keys,omitempty"`
+
+	// limit：跳过前 Offset 行后最多输出 Limit 行，Limit 为 0 时不限制行数
+	Limit  int `json:"limit,omitempty"`
+	Offset int `json:"offset,omitempty"`
+
+	// union：All 为 false 时与 SQL 的 UNION 相同去除重复行
+	All bool `json:"all,omitempty"`
+}
+
+// FilterCondition 过滤条件。Operator 为 eq、ne、gt、gte、lt、lte、in、notIn、between、
+// contains、startsWith、endsWith、isNull 或 notNull；in、notIn 的 Value 为数组，
+// between 的 Value 为 [下限, 上限]（均包含），isNull、notNull 不需要 Value
+type FilterCondition struct {
+	Column   string      `json:"column"`
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+// Aggregate 聚合。Function 为 count、countDistinct、sum、avg、min 或 max；
+// count 不指定 Column 时统计行数。As 为输出列名，默认为 函数_列名
+type Aggregate struct {
+	Function string `json:"function"`
+	Column   string `json:"column,omitempty"`
+	As       string `json:"as,omitempty"`
+}
+
+// JoinKey 连接条件，左侧列等于右侧列，任一侧为空值时不匹配
+type JoinKey struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// CalculatedField 计算列。Expression 的语法见 README 中的数据处理部分，
+// 例如 (price - cost) * quantity、if(amount > 100, 'large', 'small')
+type CalculatedField struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// SortKey 排序列，Direction 为 asc（默认）或 desc。升序时空值排在最前，降序时排在最后
+type SortKey struct {
+	Column    string `json:"column"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// ResultColumn 处理结果中的列，Type 为归一化后的列类型
+type ResultColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
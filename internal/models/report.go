@@ -0,0 +1,141 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ReportJobStatus string
+
+const (
+	JobStatusPending   ReportJobStatus = "pending"
+	JobStatusRunning   ReportJobStatus = "running"
+	JobStatusCompleted ReportJobStatus = "completed"
+	JobStatusFailed    ReportJobStatus = "failed"
+	JobStatusCancelled ReportJobStatus = "cancelled"
+)
+
+// JobKind 任务类型
+type JobKind string
+
+const (
+	JobKindReport  JobKind = "report"  // 生成报表文件
+	JobKindProcess JobKind = "process" // 对数据源实体执行数据处理操作
+)
+
+// Report 报表定义
+type Report struct {
+	gorm.Model
+	Name         string       `gorm:"type:varchar(255);index;not null"`
+	Description  string       `gorm:"type:text"`
+	DataSourceID uint         `gorm:"index;not null"`
+	Query        string       `gorm:"type:text;not null"`        // SQL查询或其他查询语句
+	Columns      []string     `gorm:"type:text;serializer:json"` // 输出列定义，为空时使用查询返回的列
+	Timeout      int          `gorm:"not null;default:0"`        // 执行超时（秒），0 表示使用全局超时
+	Layout       ReportLayout `gorm:"type:text;serializer:json"` // html、pdf 等渲染格式的版式
+	// Parameters 查询参数定义，查询中以 :name 引用，执行时绑定为驱动占位符
+	Parameters []ReportParameter `gorm:"type:text;serializer:json"`
+	// Retention 该报表任务和文件的保留策略，未设置的项使用全局配置
+	Retention ReportRetention `gorm:"type:text;serializer:json"`
+	IsDelete  IsDeleteType    `gorm:"type:tinyint"`
+}
+
+// ReportRetention 报表的保留策略，以 JSON 保存在报表定义中。
+// 字段为空时使用全局配置 retention 中的对应项，为 0 时不限制
+type ReportRetention struct {
+	KeepLast     *int   `json:"keepLast,omitempty"`     // 保留最近结束的任务数
+	MaxAgeDays   *int   `json:"maxAgeDays,omitempty"`   // 任务结束后保留的天数
+	MaxTotalSize *int64 `json:"maxTotalSize,omitempty"` // 报表文件的总字节数上限
+}
+
+// ParameterType 报表参数类型
+type ParameterType string
+
+const (
+	ParamString      ParameterType = "string"
+	ParamInt         ParameterType = "int"
+	ParamFloat       ParameterType = "float"
+	ParamDate        ParameterType = "date"        // yyyy-mm-dd
+	ParamDateRange   ParameterType = "daterange"   // {"from": "yyyy-mm-dd", "to": "yyyy-mm-dd"}，查询中以 :name.from、:name.to 引用
+	ParamEnum        ParameterType = "enum"        // Options 中的一个值
+	ParamMultiSelect ParameterType = "multiselect" // Options 中的多个值，查询中写作 IN (:name)
+)
+
+// ReportParameter 报表查询参数定义，以 JSON 保存在报表定义中
+type ReportParameter struct {
+	Name     string        `json:"name"`
+	Label    string        `json:"label,omitempty"`
+	Type     ParameterType `json:"type"`
+	Required bool          `json:"required,omitempty"`
+	// Default 未传入参数值时使用，格式与参数值相同
+	Default interface{} `json:"default,omitempty"`
+	Options []string    `json:"options,omitempty"` // enum、multiselect 的可选值
+	Min     *float64    `json:"min,omitempty"`     // int、float 的取值范围
+	Max     *float64    `json:"max,omitempty"`
+}
+
+// ReportLayout html、pdf 等渲染格式的版式，零值使用默认版式。
+// 以 JSON 保存在报表定义中，字段名同时也是 API 中的字段名
+type ReportLayout struct {
+	Title     string                  `json:"title,omitempty"`     // 为空时使用报表名称
+	Header    string                  `json:"header,omitempty"`    // 页眉，text/template 模板
+	Footer    string                  `json:"footer,omitempty"`    // 页脚，text/template 模板
+	Template  string                  `json:"template,omitempty"`  // 替换默认的 HTML 模板，html/template 模板
+	Landscape bool                    `json:"landscape,omitempty"` // PDF 横向排版
+	Columns   map[string]ColumnLayout `json:"columns,omitempty"`   // 按列名设置表头和格式
+}
+
+// ColumnLayout 列的显示设置
+type ColumnLayout struct {
+	Label string `json:"label,omitempty"` // 表头，为空时使用列名
+	// Format 数值列为 "0"、"#,##0.00"、"0.0%" 形式的数字格式，日期时间列为 Go 时间格式
+	Format string `json:"format,omitempty"`
+}
+
+// ReportJob 报表生成任务，Kind 为 process 时为对数据源实体执行的数据处理任务
+type ReportJob struct {
+	gorm.Model
+	Kind        JobKind         `gorm:"type:varchar(20);not null;default:report"`
+	ReportID    uint            `gorm:"index;not null"` // 处理任务为 0
+	Status      ReportJobStatus `gorm:"type:varchar(20);index;not null"`
+	Format      string          `gorm:"type:varchar(20);not null"` // csv, json等
+	FilePath    string          `gorm:"type:text"`                 // 旧版本生成的本地文件路径，新任务使用 StorageKey
+	Error       string          `gorm:"type:text"`                 // 错误信息
+	RowsWritten int64           `gorm:"not null;default:0"`        // 已写出的行数，执行中定期更新
+	// Parameters 本次执行使用的参数值（已填充默认值）
+	Parameters map[string]interface{} `gorm:"type:text;serializer:json"`
+	// ScheduleID 由定时计划触发时为该计划的 ID
+	ScheduleID *uint `gorm:"index"`
+	// Pipeline 处理任务执行的操作，报表任务为空
+	Pipeline *Pipeline `gorm:"type:text;serializer:json"`
+	// ResultColumns 处理任务结果的列，结果按 ndjson 保存在报表存储中
+	ResultColumns []ResultColumn `gorm:"type:text;serializer:json"`
+
+	// 生成的报表文件
+	StorageKey string `gorm:"type:varchar(255)"`  // 文件在报表存储中的键
+	FileSize   int64  `gorm:"not null;default:0"` // 文件字节数
+	Checksum   string `gorm:"type:varchar(64)"`   // 文件内容的 SHA-256，十六进制
+
+	// 队列调度字段
+	Attempts    int        `gorm:"not null;default:0"` // 已开始执行的次数
+	MaxAttempts int        `gorm:"not null;default:1"`
+	NextRunAt   *time.Time `gorm:"index"` // pending 任务最早可执行的时间，为空表示立即执行
+	StartedAt   *time.Time // 最近一次开始执行的时间
+	FinishedAt  *time.Time // 进入 completed/failed/cancelled 的时间
+	// ClaimedBy 最近一次领取任务的实例，LeaseUntil 为其租约的到期时间。
+	// 执行中的实例定期续约，租约到期的 running 任务视为执行实例已退出，由其他实例恢复
+	ClaimedBy  string     `gorm:"type:varchar(64)"`
+	LeaseUntil *time.Time `gorm:"index"`
+}
+
+// ReportJobEvent 任务状态变更记录
+type ReportJobEvent struct {
+	ID         uint            `gorm:"primarykey"`
+	JobID      uint            `gorm:"index;not null"`
+	FromStatus ReportJobStatus `gorm:"type:varchar(20)"` // 新建任务时为空
+	ToStatus   ReportJobStatus `gorm:"type:varchar(20);not null"`
+	Attempt    int             `gorm:"not null"`
+	Message    string          `gorm:"type:text"`
+	CreatedAt  time.Time
+}
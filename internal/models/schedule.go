@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReportSchedule 报表的定时生成计划，每次触发创建一个普通的 ReportJob
+type ReportSchedule struct {
+	gorm.Model
+	ReportID uint   `gorm:"index;not null"`
+	Cron     string `gorm:"type:varchar(100);not null"` // 五段式 cron 表达式或 @daily 等描述符
+	Timezone string `gorm:"type:varchar(64);not null"`  // IANA 时区名，cron 表达式按该时区解释
+	Format   string `gorm:"type:varchar(20);not null"`
+	// Parameters 生成报表使用的参数值，触发时按报表当前的参数定义填充默认值
+	Parameters map[string]interface{} `gorm:"type:text;serializer:json"`
+	Enabled    bool                   `gorm:"not null"`
+
+	// NextRunAt 下一次触发的时间，停用时为空
+	NextRunAt *time.Time `gorm:"index"`
+	LastRunAt *time.Time // 最近一次触发的计划时间
+	LastJobID *uint      // 最近一次触发创建的任务
+	LastError string     `gorm:"type:text"` // 最近一次触发未能创建任务的原因
+	// Revision 每次触发或修改时加一，多个实例同时触发时只有一个能以旧值更新成功
+	Revision int `gorm:"not null;default:0"`
+}
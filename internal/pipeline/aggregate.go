@@ -0,0 +1,296 @@
+package pipeline
+
+import (
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// accumulator 一个分组中一个聚合的中间状态，add 收到无法聚合的值时返回 false
+type accumulator interface {
+	add(v interface{}) bool
+	result() interface{}
+}
+
+// aggregateSpec 一个聚合：函数、输入列下标（count 统计行数时为 -1）和输出列
+type aggregateSpec struct {
+	function string
+	column   int
+	output   models.ResultColumn
+}
+
+func (s *aggregateSpec) newAccumulator() accumulator {
+	switch s.function {
+	case aggCount:
+		return &countAcc{}
+	case aggCountDistinct:
+		return &countDistinctAcc{seen: make(map[string]struct{})}
+	case aggSum:
+		return &sumAcc{isInt: true}
+	case aggAvg:
+		return &avgAcc{}
+	case aggMin:
+		return &extremeAcc{sign: -1}
+	default: // aggMax
+		return &extremeAcc{sign: 1}
+	}
+}
+
+// aggregation groupBy 操作：读完输入后按分组列输出每组一行，分组按首次出现的顺序排列。
+// 没有分组列时输出一行，输入为空时 count 为 0、其他聚合为空值
+type aggregation struct {
+	input      Rows
+	index      int
+	keys       []int
+	aggregates []aggregateSpec
+	columns    []models.ResultColumn
+
+	groups [][]interface{} // 每组的分组列值，后接各聚合的 accumulator
+	pos    int
+	row    []interface{}
+	done   bool
+	err    error
+}
+
+func newAggregation(index int, input Rows, op *models.Operation) (Rows, error) {
+	keys, aggregates, columns, err := aggregationSpecs(index, input.Columns(), op)
+	if err != nil {
+		return nil, err
+	}
+	return &aggregation{input: input, index: index, pos: -1, keys: keys, aggregates: aggregates, columns: columns,
+		row: make([]interface{}, len(columns))}, nil
+}
+
+// aggregationSpecs 检查 groupBy 操作，返回分组列的下标、各聚合和输出列
+func aggregationSpecs(index int, in []models.ResultColumn, op *models.Operation) ([]int, []aggregateSpec, []models.ResultColumn, error) {
+	var keys []int
+	var aggregates []aggregateSpec
+	var columns []models.ResultColumn
+	for _, name := range op.GroupBy {
+		j, ok := columnIndex(in, name)
+		if !ok {
+			return nil, nil, nil, opError(index, "unknown group column %q", name)
+		}
+		keys = append(keys, j)
+		columns = append(columns, in[j])
+	}
+	for i, agg := range op.Aggregates {
+		spec := aggregateSpec{function: agg.Function, column: -1, output: models.ResultColumn{Name: aggregateName(agg)}}
+		inputType := ""
+		if agg.Column != "" {
+			j, ok := columnIndex(in, agg.Column)
+			if !ok {
+				return nil, nil, nil, opError(index, "aggregates[%d]: unknown column %q", i, agg.Column)
+			}
+			spec.column = j
+			inputType = in[j].Type
+		}
+		switch agg.Function {
+		case aggCount, aggCountDistinct:
+			spec.output.Type = database.TypeInteger
+		case aggSum, aggAvg:
+			if !numericType(inputType) {
+				return nil, nil, nil, opError(index, "aggregates[%d]: %s requires a numeric column, %q is %s", i, agg.Function, agg.Column, inputType)
+			}
+			spec.output.Type = database.TypeFloat
+			if agg.Function == aggSum && inputType != database.TypeFloat {
+				// 整数求和保持整数，类型未知时由实际的值决定
+				spec.output.Type = inputType
+			}
+		default:
+			spec.output.Type = inputType
+		}
+		aggregates = append(aggregates, spec)
+		columns = append(columns, spec.output)
+	}
+	return keys, aggregates, columns, nil
+}
+
+// numericType 可以求和的列类型，类型未知时在执行时检查每个值
+func numericType(t string) bool {
+	switch t {
+	case database.TypeInteger, database.TypeFloat, database.TypeDecimal, database.TypeUnknown:
+		return true
+	}
+	return false
+}
+
+func (a *aggregation) Columns() []models.ResultColumn {
+	return a.columns
+}
+
+func (a *aggregation) Next() bool {
+	if !a.done {
+		a.done = true
+		a.err = a.consume()
+	}
+	if a.err != nil {
+		return false
+	}
+	a.pos++
+	if a.pos >= len(a.groups) {
+		return false
+	}
+	group := a.groups[a.pos]
+	copy(a.row, group[:len(a.keys)])
+	for i := range a.aggregates {
+		a.row[len(a.keys)+i] = group[len(a.keys)+i].(accumulator).result()
+	}
+	return true
+}
+
+// consume 读完输入并累计每组的聚合值
+func (a *aggregation) consume() error {
+	index := make(map[string]int)
+	var key []byte
+	for a.input.Next() {
+		row := a.input.Row()
+		key = key[:0]
+		for _, j := range a.keys {
+			key = appendKey(key, row[j])
+		}
+		g, ok := index[string(key)]
+		if !ok {
+			group := make([]interface{}, len(a.keys)+len(a.aggregates))
+			for i, j := range a.keys {
+				group[i] = row[j]
+			}
+			for i := range a.aggregates {
+				group[len(a.keys)+i] = a.aggregates[i].newAccumulator()
+			}
+			g = len(a.groups)
+			index[string(key)] = g
+			a.groups = append(a.groups, group)
+		}
+		for i, spec := range a.aggregates {
+			var v interface{} = true // count 统计行数时每行都计入
+			if spec.column >= 0 {
+				v = row[spec.column]
+			}
+			if v == nil {
+				continue
+			}
+			if !a.groups[g][len(a.keys)+i].(accumulator).add(v) {
+				return opError(a.index, "cannot compute %s of value %v", spec.function, v)
+			}
+		}
+	}
+	if err := a.input.Err(); err != nil {
+		return err
+	}
+	if len(a.keys) == 0 && len(a.groups) == 0 {
+		group := make([]interface{}, len(a.aggregates))
+		for i := range a.aggregates {
+			group[i] = a.aggregates[i].newAccumulator()
+		}
+		a.groups = append(a.groups, group)
+	}
+	return nil
+}
+
+func (a *aggregation) Row() []interface{} {
+	return a.row
+}
+
+func (a *aggregation) Err() error {
+	return a.err
+}
+
+func (a *aggregation) Close() error {
+	return a.input.Close()
+}
+
+type countAcc struct{ n int64 }
+
+func (c *countAcc) add(interface{}) bool { c.n++; return true }
+func (c *countAcc) result() interface{}  { return c.n }
+
+type countDistinctAcc struct{ seen map[string]struct{} }
+
+func (c *countDistinctAcc) add(v interface{}) bool {
+	c.seen[string(appendKey(nil, v))] = struct{}{}
+	return true
+}
+
+func (c *countDistinctAcc) result() interface{} { return int64(len(c.seen)) }
+
+// sumAcc 全部为整数时按整数求和，否则按浮点数
+type sumAcc struct {
+	isInt bool
+	i     int64
+	f     float64
+	n     int64
+}
+
+func (s *sumAcc) add(v interface{}) bool {
+	if n, ok := toInt64(v); ok && s.isInt {
+		s.i += n
+		s.n++
+		return true
+	}
+	f, ok := toFloat64(v)
+	if !ok {
+		return false
+	}
+	if s.isInt {
+		s.isInt = false
+		s.f = float64(s.i)
+	}
+	s.f += f
+	s.n++
+	return true
+}
+
+func (s *sumAcc) result() interface{} {
+	switch {
+	case s.n == 0:
+		return nil
+	case s.isInt:
+		return s.i
+	}
+	return s.f
+}
+
+type avgAcc struct {
+	sum float64
+	n   int64
+}
+
+func (a *avgAcc) add(v interface{}) bool {
+	f, ok := toFloat64(v)
+	if !ok {
+		return false
+	}
+	a.sum += f
+	a.n++
+	return true
+}
+
+func (a *avgAcc) result() interface{} {
+	if a.n == 0 {
+		return nil
+	}
+	return a.sum / float64(a.n)
+}
+
+// extremeAcc sign 为 -1 时取最小值，为 1 时取最大值
+type extremeAcc struct {
+	sign  int
+	value interface{}
+}
+
+func (e *extremeAcc) add(v interface{}) bool {
+	if e.value == nil {
+		e.value = v
+		return true
+	}
+	c, ok := compareValues(v, e.value)
+	if !ok {
+		return false
+	}
+	if c == e.sign {
+		e.value = v
+	}
+	return true
+}
+
+func (e *extremeAcc) result() interface{} { return e.value }
@@ -0,0 +1,438 @@
+package pipeline
+
+import (
+	"strings"
+	"time"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// batchSize 列式执行时每批的行数
+const batchSize = 1024
+
+// nullValue kindAny 向量中空值位置的占位值，读取时由 Nulls 判断
+const nullValue = false
+
+// vectorKind 向量中值的存储方式
+type vectorKind uint8
+
+const (
+	kindAny vectorKind = iota // 任意类型的值，保存在 Values 中
+	kindInt
+	kindFloat
+	kindString
+	kindBool
+	kindTime
+)
+
+// kindOf 归一化列类型对应的存储方式，类型未知或值的 Go 类型不固定时为 kindAny
+func kindOf(columnType string) vectorKind {
+	switch columnType {
+	case database.TypeInteger:
+		return kindInt
+	case database.TypeFloat:
+		return kindFloat
+	case database.TypeString:
+		return kindString
+	case database.TypeBoolean:
+		return kindBool
+	case database.TypeDate, database.TypeDateTime:
+		return kindTime
+	}
+	return kindAny
+}
+
+// vector 一批行中一列的值，按 Kind 保存在对应的切片中，Nulls[i] 为 true 时第 i 个值为空值。
+// 字段导出以便溢写时用 gob 编码；gob 不能编码 nil 的 interface 值，Values 中的空值保存为 nullValue
+type vector struct {
+	Kind    vectorKind
+	Nulls   []bool
+	Ints    []int64
+	Floats  []float64
+	Strings []string
+	Bools   []bool
+	Times   []time.Time
+	Values  []interface{}
+}
+
+func newVector(kind vectorKind, capacity int) *vector {
+	v := &vector{Kind: kind, Nulls: make([]bool, 0, capacity)}
+	switch kind {
+	case kindInt:
+		v.Ints = make([]int64, 0, capacity)
+	case kindFloat:
+		v.Floats = make([]float64, 0, capacity)
+	case kindString:
+		v.Strings = make([]string, 0, capacity)
+	case kindBool:
+		v.Bools = make([]bool, 0, capacity)
+	case kindTime:
+		v.Times = make([]time.Time, 0, capacity)
+	default:
+		v.Values = make([]interface{}, 0, capacity)
+	}
+	return v
+}
+
+func (v *vector) len() int {
+	return len(v.Nulls)
+}
+
+// value 第 i 个值，空值为 nil
+func (v *vector) value(i int) interface{} {
+	if v.Nulls[i] {
+		return nil
+	}
+	switch v.Kind {
+	case kindInt:
+		return v.Ints[i]
+	case kindFloat:
+		return v.Floats[i]
+	case kindString:
+		return v.Strings[i]
+	case kindBool:
+		return v.Bools[i]
+	case kindTime:
+		return v.Times[i]
+	}
+	return v.Values[i]
+}
+
+// append 追加一个值。值与向量的存储方式不符时，向量转为 kindAny 保存原值
+func (v *vector) append(x interface{}) {
+	if x == nil {
+		v.appendNull()
+		return
+	}
+	ok := true
+	switch v.Kind {
+	case kindInt:
+		var n int64
+		if n, ok = toInt64(x); ok {
+			v.Ints = append(v.Ints, n)
+		}
+	case kindFloat:
+		var f float64
+		if f, ok = x.(float64); ok {
+			v.Floats = append(v.Floats, f)
+		}
+	case kindString:
+		var s string
+		if s, ok = x.(string); ok {
+			v.Strings = append(v.Strings, s)
+		}
+	case kindBool:
+		var b bool
+		if b, ok = x.(bool); ok {
+			v.Bools = append(v.Bools, b)
+		}
+	case kindTime:
+		var t time.Time
+		if t, ok = x.(time.Time); ok {
+			v.Times = append(v.Times, t)
+		}
+	default:
+		v.Values = append(v.Values, x)
+	}
+	if !ok {
+		v.generalize()
+		v.Values = append(v.Values, x)
+	}
+	v.Nulls = append(v.Nulls, false)
+}
+
+func (v *vector) appendNull() {
+	switch v.Kind {
+	case kindInt:
+		v.Ints = append(v.Ints, 0)
+	case kindFloat:
+		v.Floats = append(v.Floats, 0)
+	case kindString:
+		v.Strings = append(v.Strings, "")
+	case kindBool:
+		v.Bools = append(v.Bools, false)
+	case kindTime:
+		v.Times = append(v.Times, time.Time{})
+	default:
+		v.Values = append(v.Values, nullValue)
+	}
+	v.Nulls = append(v.Nulls, true)
+}
+
+// generalize 将向量转为 kindAny
+func (v *vector) generalize() {
+	if v.Kind == kindAny {
+		return
+	}
+	values := make([]interface{}, len(v.Nulls), cap(v.Nulls))
+	for i := range v.Nulls {
+		if values[i] = v.value(i); values[i] == nil {
+			values[i] = nullValue
+		}
+	}
+	*v = vector{Kind: kindAny, Nulls: v.Nulls, Values: values}
+}
+
+// gather 按下标取出值组成新的向量，下标为 -1 时为空值
+func (v *vector) gather(indexes []int) *vector {
+	out := newVector(v.Kind, len(indexes))
+	for _, i := range indexes {
+		if i < 0 || v.Nulls[i] {
+			out.appendNull()
+			continue
+		}
+		out.Nulls = append(out.Nulls, false)
+		switch v.Kind {
+		case kindInt:
+			out.Ints = append(out.Ints, v.Ints[i])
+		case kindFloat:
+			out.Floats = append(out.Floats, v.Floats[i])
+		case kindString:
+			out.Strings = append(out.Strings, v.Strings[i])
+		case kindBool:
+			out.Bools = append(out.Bools, v.Bools[i])
+		case kindTime:
+			out.Times = append(out.Times, v.Times[i])
+		default:
+			out.Values = append(out.Values, v.Values[i])
+		}
+	}
+	return out
+}
+
+// size 向量占用内存的估计值（字节）
+func (v *vector) size() int64 {
+	n := int64(len(v.Nulls))
+	switch v.Kind {
+	case kindInt, kindFloat:
+		n += 8 * int64(len(v.Nulls))
+	case kindBool:
+		n += int64(len(v.Nulls))
+	case kindTime:
+		n += 24 * int64(len(v.Nulls))
+	case kindString:
+		for _, s := range v.Strings {
+			n += 16 + int64(len(s))
+		}
+	default:
+		for _, x := range v.Values {
+			n += 16
+			if s, ok := x.(string); ok {
+				n += int64(len(s))
+			}
+		}
+	}
+	return n
+}
+
+// compareAt 按排序规则比较 a 的第 i 个值和 b 的第 j 个值，与 orderValues 相同
+func compareAt(a *vector, i int, b *vector, j int) int {
+	switch na, nb := a.Nulls[i], b.Nulls[j]; {
+	case na && nb:
+		return 0
+	case na:
+		return -1
+	case nb:
+		return 1
+	}
+	if a.Kind == b.Kind {
+		switch a.Kind {
+		case kindInt:
+			return compareOrdered(a.Ints[i], b.Ints[j])
+		case kindFloat:
+			return compareOrdered(a.Floats[i], b.Floats[j])
+		case kindString:
+			return strings.Compare(a.Strings[i], b.Strings[j])
+		case kindTime:
+			return a.Times[i].Compare(b.Times[j])
+		}
+	}
+	return orderValues(a.value(i), b.value(j))
+}
+
+// batch 一批行，各列的向量长度都为 Len
+type batch struct {
+	Vectors []*vector
+	Len     int
+}
+
+// newBatch 按列类型创建空的一批行
+func newBatch(columns []models.ResultColumn, capacity int) *batch {
+	b := &batch{Vectors: make([]*vector, len(columns))}
+	for i, c := range columns {
+		b.Vectors[i] = newVector(kindOf(c.Type), capacity)
+	}
+	return b
+}
+
+// appendRow 追加一行
+func (b *batch) appendRow(row []interface{}) {
+	for i, v := range b.Vectors {
+		v.append(row[i])
+	}
+	b.Len++
+}
+
+// row 将第 i 行的值写入 dst
+func (b *batch) row(i int, dst []interface{}) {
+	for c, v := range b.Vectors {
+		dst[c] = v.value(i)
+	}
+}
+
+// gather 按下标取出行组成新的一批
+func (b *batch) gather(indexes []int) *batch {
+	out := &batch{Vectors: make([]*vector, len(b.Vectors)), Len: len(indexes)}
+	for i, v := range b.Vectors {
+		out.Vectors[i] = v.gather(indexes)
+	}
+	return out
+}
+
+// slice 第 from 行到第 to 行（不含）
+func (b *batch) slice(from, to int) *batch {
+	indexes := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		indexes = append(indexes, i)
+	}
+	return b.gather(indexes)
+}
+
+func (b *batch) size() int64 {
+	var n int64
+	for _, v := range b.Vectors {
+		n += v.size()
+	}
+	return n
+}
+
+// concatBatches 将多批行合并为一批
+func concatBatches(columns []models.ResultColumn, batches []*batch) *batch {
+	total := 0
+	for _, b := range batches {
+		total += b.Len
+	}
+	out := newBatch(columns, total)
+	row := make([]interface{}, len(columns))
+	for _, b := range batches {
+		for i := 0; i < b.Len; i++ {
+			b.row(i, row)
+			out.appendRow(row)
+		}
+	}
+	return out
+}
+
+// batchReader 逐批读取的数据，列式执行中各操作的输入和输出
+type batchReader interface {
+	Columns() []models.ResultColumn
+	// next 返回下一批行，没有更多行时返回 nil
+	next() (*batch, error)
+	Close() error
+}
+
+// rowBatches 将逐行读取的数据按批读取
+type rowBatches struct {
+	rows Rows
+}
+
+func (r *rowBatches) Columns() []models.ResultColumn {
+	return r.rows.Columns()
+}
+
+func (r *rowBatches) next() (*batch, error) {
+	b := newBatch(r.rows.Columns(), batchSize)
+	for b.Len < batchSize && r.rows.Next() {
+		b.appendRow(r.rows.Row())
+	}
+	if err := r.rows.Err(); err != nil {
+		return nil, err
+	}
+	if b.Len == 0 {
+		return nil, nil
+	}
+	return b, nil
+}
+
+func (r *rowBatches) Close() error {
+	return r.rows.Close()
+}
+
+// batchRows 将逐批读取的数据适配为 Rows，关闭时同时执行 cleanup
+type batchRows struct {
+	input   batchReader
+	cleanup func()
+	current *batch
+	pos     int
+	row     []interface{}
+	err     error
+}
+
+func newBatchRows(input batchReader, cleanup func()) *batchRows {
+	return &batchRows{input: input, cleanup: cleanup, row: make([]interface{}, len(input.Columns()))}
+}
+
+func (r *batchRows) Columns() []models.ResultColumn {
+	return r.input.Columns()
+}
+
+func (r *batchRows) Next() bool {
+	for r.err == nil {
+		if r.current != nil && r.pos+1 < r.current.Len {
+			r.pos++
+			r.current.row(r.pos, r.row)
+			return true
+		}
+		r.current, r.err = r.input.next()
+		r.pos = -1
+		if r.current == nil {
+			return false
+		}
+	}
+	return false
+}
+
+func (r *batchRows) Row() []interface{} {
+	return r.row
+}
+
+func (r *batchRows) Err() error {
+	return r.err
+}
+
+func (r *batchRows) Close() error {
+	err := r.input.Close()
+	if r.cleanup != nil {
+		r.cleanup()
+		r.cleanup = nil
+	}
+	return err
+}
+
+// sliceBatches 依次返回已在内存中的批次
+type sliceBatches struct {
+	columns []models.ResultColumn
+	batches []*batch
+	close   func() error
+}
+
+func (s *sliceBatches) Columns() []models.ResultColumn {
+	return s.columns
+}
+
+func (s *sliceBatches) next() (*batch, error) {
+	if len(s.batches) == 0 {
+		return nil, nil
+	}
+	b := s.batches[0]
+	s.batches = s.batches[1:]
+	return b, nil
+}
+
+func (s *sliceBatches) Close() error {
+	if s.close != nil {
+		return s.close()
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package pipeline
+
+import (
+	"hash/fnv"
+
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// 超出内存预算时，分组和连接按键的哈希值将行分为 spillPartitions 个分区写入临时文件，
+// 再逐个分区处理。分区仍超出预算时继续分区，超过 maxSpillDepth 层后不再检查预算
+const (
+	spillPartitions = 16
+	maxSpillDepth   = 4
+)
+
+// partitionOf 键在第 depth 层分区中所属的分区
+func partitionOf(key []byte, depth int) int {
+	h := fnv.New32a()
+	h.Write([]byte{byte(depth)})
+	h.Write(key)
+	return int(h.Sum32() % spillPartitions)
+}
+
+// partitioner 将行按分区写入临时文件，每个分区攒满一批后写入
+type partitioner struct {
+	mem     *memory
+	columns []models.ResultColumn
+	buffers []*batch
+	files   []*spillFile
+	row     []interface{}
+}
+
+// newPartitioner 创建 n 个分区
+func newPartitioner(mem *memory, columns []models.ResultColumn, n int) *partitioner {
+	return &partitioner{mem: mem, columns: columns, buffers: make([]*batch, n), files: make([]*spillFile, n),
+		row: make([]interface{}, len(columns))}
+}
+
+// add 将 b 的第 i 行写入分区 p
+func (w *partitioner) add(p int, b *batch, i int) error {
+	if w.buffers[p] == nil {
+		w.buffers[p] = newBatch(w.columns, batchSize)
+	}
+	b.row(i, w.row)
+	w.buffers[p].appendRow(w.row)
+	if w.buffers[p].Len < batchSize {
+		return nil
+	}
+	return w.flush(p)
+}
+
+func (w *partitioner) flush(p int) error {
+	if w.buffers[p] == nil {
+		return nil
+	}
+	if w.files[p] == nil {
+		var err error
+		if w.files[p], err = w.mem.spill(w.columns); err != nil {
+			return err
+		}
+	}
+	err := w.files[p].write(w.buffers[p])
+	w.buffers[p] = nil
+	return err
+}
+
+// readers 写完全部分区，返回各分区的读取器，没有行的分区为 nil
+func (w *partitioner) readers() ([]batchReader, error) {
+	readers := make([]batchReader, len(w.files))
+	for p := range w.files {
+		if err := w.flush(p); err != nil {
+			return readers, err
+		}
+		if w.files[p] == nil {
+			continue
+		}
+		r, err := w.files[p].reader()
+		if err != nil {
+			return readers, err
+		}
+		readers[p] = r
+	}
+	return readers, nil
+}
+
+// batchAggregation groupBy、distinct 操作的列式执行。分组在内存预算内按首次出现的顺序保存；
+// 超出预算后，属于已有分组的行继续累计，属于新分组的行按分组键分区写入临时文件，
+// 输出内存中的分组后再逐个分区聚合
+type batchAggregation struct {
+	x          *columnarExec
+	index      int
+	input      batchReader
+	keys       []int
+	aggregates []aggregateSpec
+	columns    []models.ResultColumn
+	depth      int
+
+	lookup     map[string]int
+	groups     [][]interface{} // 每组的分组列值，后接各聚合的 accumulator
+	reserved   int64
+	partitions *partitioner
+	pending    []batchReader // 尚未聚合的分区
+	child      batchReader   // 正在输出的分区
+	pos        int
+	done       bool
+	key        []byte
+	row        []interface{}
+}
+
+func (x *columnarExec) aggregate(index int, in batchReader, keys []int, aggregates []aggregateSpec, columns []models.ResultColumn, depth int) batchReader {
+	return &batchAggregation{x: x, index: index, input: in, keys: keys, aggregates: aggregates, columns: columns, depth: depth,
+		lookup: make(map[string]int), row: make([]interface{}, len(columns))}
+}
+
+func (a *batchAggregation) Columns() []models.ResultColumn {
+	return a.columns
+}
+
+func (a *batchAggregation) next() (*batch, error) {
+	if !a.done {
+		a.done = true
+		if err := a.consume(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		if a.pos < len(a.groups) {
+			return a.output(), nil
+		}
+		a.releaseGroups()
+		if a.child != nil {
+			b, err := a.child.next()
+			if b != nil || err != nil {
+				return b, err
+			}
+			a.child.Close()
+			a.child = nil
+		}
+		if len(a.pending) == 0 {
+			return nil, nil
+		}
+		a.child = a.x.aggregate(a.index, a.pending[0], a.keys, a.aggregates, a.columns, a.depth+1)
+		a.pending = a.pending[1:]
+	}
+}
+
+// consume 读完输入并累计内存中各组的聚合值
+func (a *batchAggregation) consume() error {
+	for {
+		if err := a.x.ctx.Err(); err != nil {
+			return err
+		}
+		b, err := a.input.next()
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			break
+		}
+		for i := 0; i < b.Len; i++ {
+			if err := a.add(b, i); err != nil {
+				return err
+			}
+		}
+	}
+	if len(a.keys) == 0 && len(a.groups) == 0 {
+		a.newGroup(nil, 0)
+	}
+	if a.partitions != nil {
+		readers, err := a.partitions.readers()
+		if err != nil {
+			return err
+		}
+		for _, r := range readers {
+			if r != nil {
+				a.pending = append(a.pending, r)
+			}
+		}
+	}
+	return nil
+}
+
+// add 将 b 的第 i 行计入所属的分组
+func (a *batchAggregation) add(b *batch, i int) error {
+	a.key = a.key[:0]
+	for _, j := range a.keys {
+		a.key = appendKey(a.key, b.Vectors[j].value(i))
+	}
+	g, ok := a.lookup[string(a.key)]
+	if !ok {
+		n := int64(2*len(a.key) + 64 + 48*len(a.aggregates))
+		if !a.x.mem.reserve(n) {
+			if a.depth < maxSpillDepth {
+				if a.partitions == nil {
+					a.partitions = newPartitioner(a.x.mem, a.input.Columns(), spillPartitions)
+				}
+				return a.partitions.add(partitionOf(a.key, a.depth), b, i)
+			}
+			a.x.mem.take(n)
+		}
+		a.reserved += n
+		g = a.newGroup(b, i)
+		a.lookup[string(a.key)] = g
+	}
+	for k, spec := range a.aggregates {
+		var v interface{} = true // count 统计行数时每行都计入
+		if spec.column >= 0 {
+			v = b.Vectors[spec.column].value(i)
+		}
+		if v == nil {
+			continue
+		}
+		if !a.groups[g][len(a.keys)+k].(accumulator).add(v) {
+			return opError(a.index, "cannot compute %s of value %v", spec.function, v)
+		}
+	}
+	return nil
+}
+
+// newGroup 以 b 的第 i 行的分组列值创建分组，b 为 nil 时创建没有分组列的分组
+func (a *batchAggregation) newGroup(b *batch, i int) int {
+	group := make([]interface{}, len(a.keys)+len(a.aggregates))
+	for k, j := range a.keys {
+		group[k] = b.Vectors[j].value(i)
+	}
+	for k := range a.aggregates {
+		group[len(a.keys)+k] = a.aggregates[k].newAccumulator()
+	}
+	a.groups = append(a.groups, group)
+	return len(a.groups) - 1
+}
+
+// output 输出内存中的下一批分组
+func (a *batchAggregation) output() *batch {
+	end := min(a.pos+batchSize, len(a.groups))
+	out := newBatch(a.columns, end-a.pos)
+	for ; a.pos < end; a.pos++ {
+		group := a.groups[a.pos]
+		copy(a.row, group[:len(a.keys)])
+		for k := range a.aggregates {
+			a.row[len(a.keys)+k] = group[len(a.keys)+k].(accumulator).result()
+		}
+		out.appendRow(a.row)
+	}
+	return out
+}
+
+// releaseGroups 内存中的分组输出完后释放占用的预算
+func (a *batchAggregation) releaseGroups() {
+	if a.groups == nil {
+		return
+	}
+	a.x.mem.release(a.reserved)
+	a.reserved = 0
+	a.groups, a.lookup, a.pos = nil, nil, 0
+}
+
+func (a *batchAggregation) Close() error {
+	a.releaseGroups()
+	if a.child != nil {
+		a.child.Close()
+	}
+	return a.input.Close()
+}
@@ -0,0 +1,474 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// ExecuteColumnar 与 Execute 相同，但按批在列式保存的行上执行操作，用于没有 SQL 可下推的实体（如 CSV 文件）。
+// 过滤、分组、连接、排序等按列批量处理，结果与 Execute 相同；排序、分组、去重和连接保存的行
+// 超出 opts.MemoryBudget 时写入 opts.SpillDir 下的临时文件，此时分组、去重和连接的输出顺序不再保证。
+// 排序、分组和连接读取输入、溢写和归并时检查 ctx，取消后返回 ctx 的错误。
+// 返回的行迭代器需由调用方关闭，关闭时删除临时文件
+func ExecuteColumnar(ctx context.Context, p *models.Pipeline, open Source, opts ColumnarOptions) (Rows, error) {
+	if err := Validate(p); err != nil {
+		return nil, err
+	}
+	x := &columnarExec{ctx: ctx, mem: newMemory(opts), open: open}
+	src, err := open(p.Entity)
+	if err != nil {
+		return nil, err
+	}
+	out, err := x.apply(&rowBatches{rows: src}, p.Operations, 0)
+	if err != nil {
+		x.mem.cleanup()
+		return nil, err
+	}
+	return newBatchRows(out, x.mem.cleanup), nil
+}
+
+// ColumnarPlan 由 ExecuteColumnar 执行时的执行计划，没有下推的操作
+func ColumnarPlan(p *models.Pipeline) (*Plan, error) {
+	if err := Validate(p); err != nil {
+		return nil, err
+	}
+	plan := &Plan{Engine: EngineColumnar, Steps: make([]PlanStep, len(p.Operations))}
+	for i, op := range p.Operations {
+		plan.Steps[i] = PlanStep{Index: i, Type: op.Type, Reason: "datasource has no SQL engine, executed by the columnar engine"}
+	}
+	return plan, nil
+}
+
+// columnarExec 一次列式执行
+type columnarExec struct {
+	ctx  context.Context
+	mem  *memory
+	open Source
+	// 跨数据源执行时 join、union 的另一侧由 fed 读取，federation 为执行计划
+	fed        *Federation
+	federation *federation
+}
+
+// apply 在 in 上从第 first 个起依次应用操作，出错时关闭 in
+func (x *columnarExec) apply(in batchReader, ops []models.Operation, first int) (batchReader, error) {
+	for i := first; i < len(ops); i++ {
+		next, err := x.operator(i, in, &ops[i])
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in = next
+	}
+	return in, nil
+}
+
+func (x *columnarExec) operator(index int, in batchReader, op *models.Operation) (batchReader, error) {
+	columns := in.Columns()
+	switch op.Type {
+	case models.OpSelect:
+		p := &batchProjection{input: in}
+		for _, name := range op.Columns {
+			j, ok := columnIndex(columns, name)
+			if !ok {
+				return nil, opError(index, "unknown column %q", name)
+			}
+			p.columns = append(p.columns, columns[j])
+			p.indexes = append(p.indexes, j)
+		}
+		return p, nil
+	case models.OpFilter:
+		if x.federation != nil {
+			if rest, ok := x.federation.filters[index]; ok {
+				// 部分条件已下推到连接的输入
+				if len(rest) == 0 {
+					return in, nil
+				}
+				filter := *op
+				filter.Conditions = rest
+				return newBatchFilter(index, in, &filter)
+			}
+		}
+		return newBatchFilter(index, in, op)
+	case models.OpCalculate:
+		out, exprs, err := calculationExprs(index, columns, op)
+		if err != nil {
+			return nil, err
+		}
+		return &batchCalculation{input: in, columns: out, exprs: exprs}, nil
+	case models.OpGroupBy:
+		keys, aggregates, out, err := aggregationSpecs(index, columns, op)
+		if err != nil {
+			return nil, err
+		}
+		return x.aggregate(index, in, keys, aggregates, out, 0), nil
+	case models.OpDistinct:
+		// 去重即按全部列分组，每组保留第一行
+		keys := make([]int, len(columns))
+		for i := range keys {
+			keys[i] = i
+		}
+		return x.aggregate(index, in, keys, nil, columns, 0), nil
+	case models.OpSort:
+		keys, desc, err := sortKeys(index, columns, op)
+		if err != nil {
+			return nil, err
+		}
+		return &batchSorter{ctx: x.ctx, mem: x.mem, input: in, keys: keys, desc: desc}, nil
+	case models.OpLimit:
+		return &batchLimiter{input: in, limit: op.Limit, offset: op.Offset}, nil
+	case models.OpJoin:
+		return x.join(index, in, op)
+	case models.OpUnion:
+		return x.union(index, in, op)
+	}
+	return nil, opError(index, "unsupported operation type %q", op.Type)
+}
+
+// input 打开 join、union 的另一个实体并执行其上的操作，错误归到第 index 个操作
+func (x *columnarExec) input(index int, op *models.Operation) (batchReader, error) {
+	if x.federation != nil {
+		return x.remoteInput(index, op)
+	}
+	rows, err := x.open(op.Entity)
+	if errors.Is(err, database.ErrEntityNotFound) {
+		return nil, opError(index, "unknown entity %q", op.Entity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	in, err := x.apply(&rowBatches{rows: rows}, op.Operations, 0)
+	if err != nil {
+		return nil, nestedError(index, err)
+	}
+	return in, nil
+}
+
+func (x *columnarExec) union(index int, left batchReader, op *models.Operation) (batchReader, error) {
+	right, err := x.input(index, op)
+	if err != nil {
+		return nil, err
+	}
+	mapping, columns, err := unionLayout(index, left.Columns(), right.Columns(), op)
+	if err != nil {
+		right.Close()
+		return nil, err
+	}
+	var out batchReader = &batchUnion{columns: columns, left: left, right: right, mapping: mapping}
+	if !op.All {
+		keys := make([]int, len(columns))
+		for i := range keys {
+			keys[i] = i
+		}
+		out = x.aggregate(index, out, keys, nil, columns, 0)
+	}
+	return out, nil
+}
+
+// batchProjection select 操作：按给出的顺序取出部分列的向量
+type batchProjection struct {
+	input   batchReader
+	columns []models.ResultColumn
+	indexes []int
+}
+
+func (p *batchProjection) Columns() []models.ResultColumn {
+	return p.columns
+}
+
+func (p *batchProjection) next() (*batch, error) {
+	b, err := p.input.next()
+	if b == nil || err != nil {
+		return nil, err
+	}
+	out := &batch{Vectors: make([]*vector, len(p.indexes)), Len: b.Len}
+	for i, j := range p.indexes {
+		out.Vectors[i] = b.Vectors[j]
+	}
+	return out, nil
+}
+
+func (p *batchProjection) Close() error {
+	return p.input.Close()
+}
+
+// vectorCondition 在一批行上计算过滤条件，第 i 行的结果写入 out[i]
+type vectorCondition func(b *batch, out []bool)
+
+// batchFilter filter 操作：逐个条件计算整批行的结果，再取出满足条件的行
+type batchFilter struct {
+	input      batchReader
+	conditions []vectorCondition
+	matchAny   bool
+	result     []bool
+	current    []bool
+}
+
+func newBatchFilter(index int, input batchReader, op *models.Operation) (batchReader, error) {
+	columns := input.Columns()
+	f := &batchFilter{input: input, matchAny: op.Match == "any"}
+	for i, cond := range op.Conditions {
+		j, ok := columnIndex(columns, cond.Column)
+		if !ok {
+			return nil, opError(index, "conditions[%d]: unknown column %q", i, cond.Column)
+		}
+		f.conditions = append(f.conditions, newVectorCondition(j, columns[j].Type, cond))
+	}
+	return f, nil
+}
+
+func (f *batchFilter) Columns() []models.ResultColumn {
+	return f.input.Columns()
+}
+
+func (f *batchFilter) next() (*batch, error) {
+	for {
+		b, err := f.input.next()
+		if b == nil || err != nil {
+			return nil, err
+		}
+		f.result = resize(f.result, b.Len)
+		f.current = resize(f.current, b.Len)
+		for i := range f.result {
+			f.result[i] = !f.matchAny
+		}
+		for _, cond := range f.conditions {
+			cond(b, f.current)
+			for i, ok := range f.current {
+				if f.matchAny {
+					f.result[i] = f.result[i] || ok
+				} else {
+					f.result[i] = f.result[i] && ok
+				}
+			}
+		}
+		var selected []int
+		for i, ok := range f.result {
+			if ok {
+				selected = append(selected, i)
+			}
+		}
+		switch len(selected) {
+		case 0:
+			continue
+		case b.Len:
+			return b, nil
+		}
+		return b.gather(selected), nil
+	}
+}
+
+func (f *batchFilter) Close() error {
+	return f.input.Close()
+}
+
+func resize(s []bool, n int) []bool {
+	if cap(s) < n {
+		return make([]bool, n)
+	}
+	return s[:n]
+}
+
+// newVectorCondition 构造列 col 上的条件，与 newPredicate 的结果相同。
+// 比较和文本匹配在列的值类型与字面量相符时直接在向量上计算，其他情况逐个值调用 newPredicate 的条件
+func newVectorCondition(col int, columnType string, cond models.FilterCondition) vectorCondition {
+	generic := newPredicate(0, columnType, cond)
+	fallback := func(v *vector, out []bool) {
+		row := make([]interface{}, 1)
+		for i := range out {
+			row[0] = v.value(i)
+			out[i] = generic(row)
+		}
+	}
+
+	switch cond.Operator {
+	case opIsNull, opNotNull:
+		want := cond.Operator == opIsNull
+		return func(b *batch, out []bool) {
+			for i, null := range b.Vectors[col].Nulls {
+				out[i] = null == want
+			}
+		}
+	case opContains, opStartsWith, opEndsWith:
+		pattern := cond.Value.(string)
+		match := textMatcher(cond.Operator)
+		return func(b *batch, out []bool) {
+			v := b.Vectors[col]
+			if v.Kind != kindString {
+				fallback(v, out)
+				return
+			}
+			for i, s := range v.Strings {
+				out[i] = !v.Nulls[i] && match(s, pattern)
+			}
+		}
+	case opEq, opNe, opGt, opGte, opLt, opLte:
+		value := coerceLiteral(cond.Value, columnType)
+		test := comparisonTest(cond.Operator)
+		return func(b *batch, out []bool) {
+			v := b.Vectors[col]
+			if !compareVector(v, value, test, out) {
+				fallback(v, out)
+			}
+		}
+	}
+	return func(b *batch, out []bool) {
+		fallback(b.Vectors[col], out)
+	}
+}
+
+// compareVector 在向量上计算与字面量的比较，与 compareValues 的结果相同。
+// 值类型与字面量不是数值与数值、文本与文本或时间与时间时返回 false
+func compareVector(v *vector, value interface{}, test func(int) bool, out []bool) bool {
+	switch x := value.(type) {
+	case float64:
+		switch v.Kind {
+		case kindInt:
+			for i, n := range v.Ints {
+				out[i] = !v.Nulls[i] && test(compareOrdered(float64(n), x))
+			}
+			return true
+		case kindFloat:
+			for i, f := range v.Floats {
+				out[i] = !v.Nulls[i] && test(compareOrdered(f, x))
+			}
+			return true
+		}
+	case string:
+		if v.Kind == kindString {
+			for i, s := range v.Strings {
+				out[i] = !v.Nulls[i] && test(strings.Compare(s, x))
+			}
+			return true
+		}
+	case time.Time:
+		if v.Kind == kindTime {
+			for i, t := range v.Times {
+				out[i] = !v.Nulls[i] && test(t.Compare(x))
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// batchCalculation calculate 操作：逐行计算表达式，计算列作为新的向量追加在输入的向量之后
+type batchCalculation struct {
+	input   batchReader
+	columns []models.ResultColumn
+	exprs   []evaluator
+	row     []interface{}
+}
+
+func (c *batchCalculation) Columns() []models.ResultColumn {
+	return c.columns
+}
+
+func (c *batchCalculation) next() (*batch, error) {
+	b, err := c.input.next()
+	if b == nil || err != nil {
+		return nil, err
+	}
+	width := len(b.Vectors)
+	if c.row == nil {
+		c.row = make([]interface{}, len(c.columns))
+	}
+	out := &batch{Vectors: append([]*vector(nil), b.Vectors...), Len: b.Len}
+	for _, col := range c.columns[width:] {
+		out.Vectors = append(out.Vectors, newVector(kindOf(col.Type), b.Len))
+	}
+	for i := 0; i < b.Len; i++ {
+		b.row(i, c.row[:width])
+		for k, eval := range c.exprs {
+			c.row[width+k] = eval(c.row)
+			out.Vectors[width+k].append(c.row[width+k])
+		}
+	}
+	return out, nil
+}
+
+func (c *batchCalculation) Close() error {
+	return c.input.Close()
+}
+
+// batchLimiter limit 操作：跳过前 offset 行后最多输出 limit 行，达到行数后不再读取输入
+type batchLimiter struct {
+	input         batchReader
+	limit, offset int
+	count         int
+}
+
+func (l *batchLimiter) Columns() []models.ResultColumn {
+	return l.input.Columns()
+}
+
+func (l *batchLimiter) next() (*batch, error) {
+	for l.limit == 0 || l.count < l.limit {
+		b, err := l.input.next()
+		if b == nil || err != nil {
+			return nil, err
+		}
+		if l.offset >= b.Len {
+			l.offset -= b.Len
+			continue
+		}
+		from, to := l.offset, b.Len
+		l.offset = 0
+		if l.limit > 0 && to-from > l.limit-l.count {
+			to = from + l.limit - l.count
+		}
+		l.count += to - from
+		if from == 0 && to == b.Len {
+			return b, nil
+		}
+		return b.slice(from, to), nil
+	}
+	return nil, nil
+}
+
+func (l *batchLimiter) Close() error {
+	return l.input.Close()
+}
+
+// batchUnion union ALL：先输出左侧的批次，再输出按左侧列顺序重排向量后的右侧批次
+type batchUnion struct {
+	columns     []models.ResultColumn
+	left, right batchReader
+	mapping     []int
+	leftDone    bool
+}
+
+func (u *batchUnion) Columns() []models.ResultColumn {
+	return u.columns
+}
+
+func (u *batchUnion) next() (*batch, error) {
+	if !u.leftDone {
+		b, err := u.left.next()
+		if b != nil || err != nil {
+			return b, err
+		}
+		u.leftDone = true
+	}
+	b, err := u.right.next()
+	if b == nil || err != nil {
+		return nil, err
+	}
+	out := &batch{Vectors: make([]*vector, len(u.mapping)), Len: b.Len}
+	for i, r := range u.mapping {
+		out.Vectors[i] = b.Vectors[r]
+	}
+	return out, nil
+}
+
+func (u *batchUnion) Close() error {
+	err := u.left.Close()
+	if rerr := u.right.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
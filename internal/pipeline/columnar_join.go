@@ -0,0 +1,312 @@
+package pipeline
+
+import (
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// batchJoin join 操作的列式执行。右侧在内存预算内时读入右侧建立哈希表，再逐批读取左侧查找匹配的行，
+// 输出顺序与 hashJoin 相同。右侧超出预算时两侧都按连接键分区写入临时文件，再逐个分区连接，
+// 连接列为空值的行不会匹配，直接补齐另一侧的空值后输出
+type batchJoin struct {
+	*joinLayout
+	x           *columnarExec
+	index       int
+	left, right batchReader
+	depth       int
+
+	built     bool
+	reserved  int64
+	rows      *batch           // 右侧的全部行
+	table     map[string][]int // 连接键到右侧行下标
+	matched   []bool           // full 连接中右侧各行是否已匹配
+	leftDone  bool
+	unmatched int         // full 连接输出右侧未匹配行时的位置
+	spilled   batchReader // 分区连接时的输出
+	key       []byte
+}
+
+func (x *columnarExec) join(index int, left batchReader, op *models.Operation) (batchReader, error) {
+	right, err := x.input(index, op)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := newJoinLayout(index, left.Columns(), right.Columns(), op)
+	if err != nil {
+		right.Close()
+		return nil, err
+	}
+	if op.Strategy == strategyMerge {
+		return newMergeJoin(x, layout, left, right), nil
+	}
+	return &batchJoin{joinLayout: layout, x: x, index: index, left: left, right: right}, nil
+}
+
+func (j *batchJoin) Columns() []models.ResultColumn {
+	return j.columns
+}
+
+func (j *batchJoin) next() (*batch, error) {
+	if !j.built {
+		j.built = true
+		if err := j.build(); err != nil {
+			return nil, err
+		}
+	}
+	if j.spilled != nil {
+		return j.spilled.next()
+	}
+	for !j.leftDone {
+		if err := j.x.ctx.Err(); err != nil {
+			return nil, err
+		}
+		b, err := j.left.next()
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			j.leftDone = true
+			break
+		}
+		if out := j.probe(b); out != nil {
+			return out, nil
+		}
+	}
+	return j.nextUnmatched(), nil
+}
+
+// build 读入右侧的全部行。超出内存预算时改为分区连接
+func (j *batchJoin) build() error {
+	var batches []*batch
+	for {
+		if err := j.x.ctx.Err(); err != nil {
+			return err
+		}
+		b, err := j.right.next()
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			break
+		}
+		n := b.size() + 32*int64(b.Len)
+		if !j.x.mem.reserve(n) {
+			if j.depth < maxSpillDepth {
+				return j.partition(append(batches, b))
+			}
+			j.x.mem.take(n)
+		}
+		j.reserved += n
+		batches = append(batches, b)
+	}
+
+	j.rows = concatBatches(j.right.Columns(), batches)
+	j.table = make(map[string][]int)
+	for i := 0; i < j.rows.Len; i++ {
+		if key, ok := j.joinKey(j.rows, i, j.rightKeys); ok {
+			j.table[key] = append(j.table[key], i)
+		}
+	}
+	if j.kind == joinFull {
+		j.matched = make([]bool, j.rows.Len)
+	}
+	return nil
+}
+
+// joinKey b 的第 i 行的连接键，任一连接列为空值时 ok 为 false
+func (j *batchJoin) joinKey(b *batch, i int, keys []joinKeyColumn) (string, bool) {
+	var ok bool
+	j.key, ok = appendJoinKey(j.key[:0], keys, func(col int) interface{} { return b.Vectors[col].value(i) })
+	return string(j.key), ok
+}
+
+// probe 在哈希表中查找左侧一批行的匹配行，没有输出的行时返回 nil
+func (j *batchJoin) probe(b *batch) *batch {
+	var leftIndexes, rightIndexes []int
+	for i := 0; i < b.Len; i++ {
+		var matches []int
+		if key, ok := j.joinKey(b, i, j.leftKeys); ok {
+			matches = j.table[key]
+		}
+		for _, r := range matches {
+			leftIndexes = append(leftIndexes, i)
+			rightIndexes = append(rightIndexes, r)
+			if j.matched != nil {
+				j.matched[r] = true
+			}
+		}
+		if len(matches) == 0 && j.kind != joinInner {
+			leftIndexes = append(leftIndexes, i)
+			rightIndexes = append(rightIndexes, -1)
+		}
+	}
+	if len(leftIndexes) == 0 {
+		return nil
+	}
+	out := b.gather(leftIndexes)
+	out.Vectors = append(out.Vectors, j.rows.gather(rightIndexes).Vectors...)
+	return out
+}
+
+// nextUnmatched full 连接中输出右侧没有匹配的下一批行
+func (j *batchJoin) nextUnmatched() *batch {
+	var indexes []int
+	for ; j.unmatched < len(j.matched) && len(indexes) < batchSize; j.unmatched++ {
+		if !j.matched[j.unmatched] {
+			indexes = append(indexes, j.unmatched)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+	return padLeft(j.columns, j.rows.gather(indexes))
+}
+
+// partition 将右侧已读入的批次、右侧剩余的行和左侧的全部行按连接键分区写入临时文件，
+// 连接列为空值的行写入最后一个分区
+func (j *batchJoin) partition(read []*batch) error {
+	rights := newPartitioner(j.x.mem, j.right.Columns(), spillPartitions+1)
+	lefts := newPartitioner(j.x.mem, j.left.Columns(), spillPartitions+1)
+	for _, b := range read {
+		if err := j.split(rights, b, j.rightKeys); err != nil {
+			return err
+		}
+	}
+	j.x.mem.release(j.reserved)
+	j.reserved = 0
+	if err := j.splitAll(rights, j.right, j.rightKeys); err != nil {
+		return err
+	}
+	if err := j.splitAll(lefts, j.left, j.leftKeys); err != nil {
+		return err
+	}
+	rightParts, err := rights.readers()
+	if err != nil {
+		return err
+	}
+	leftParts, err := lefts.readers()
+	if err != nil {
+		return err
+	}
+
+	var inputs []batchReader
+	for p := 0; p < spillPartitions; p++ {
+		if leftParts[p] == nil && (rightParts[p] == nil || j.kind != joinFull) {
+			continue
+		}
+		l, r := leftParts[p], rightParts[p]
+		if l == nil {
+			l = &sliceBatches{columns: j.left.Columns()}
+		}
+		if r == nil {
+			r = &sliceBatches{columns: j.right.Columns()}
+		}
+		inputs = append(inputs, &batchJoin{joinLayout: j.joinLayout, x: j.x, index: j.index, left: l, right: r, depth: j.depth + 1})
+	}
+	if l := leftParts[spillPartitions]; l != nil && j.kind != joinInner {
+		inputs = append(inputs, &padBatches{input: l, columns: j.columns, left: true})
+	}
+	if r := rightParts[spillPartitions]; r != nil && j.kind == joinFull {
+		inputs = append(inputs, &padBatches{input: r, columns: j.columns})
+	}
+	j.spilled = &chainBatches{columns: j.columns, inputs: inputs}
+	return nil
+}
+
+// splitAll 将 input 剩余的行写入分区
+func (j *batchJoin) splitAll(w *partitioner, input batchReader, keys []joinKeyColumn) error {
+	for {
+		if err := j.x.ctx.Err(); err != nil {
+			return err
+		}
+		b, err := input.next()
+		if b == nil || err != nil {
+			return err
+		}
+		if err := j.split(w, b, keys); err != nil {
+			return err
+		}
+	}
+}
+
+// split 将一批行按连接键写入分区
+func (j *batchJoin) split(w *partitioner, b *batch, keys []joinKeyColumn) error {
+	for i := 0; i < b.Len; i++ {
+		p := spillPartitions
+		if key, ok := j.joinKey(b, i, keys); ok {
+			p = partitionOf([]byte(key), j.depth)
+		}
+		if err := w.add(p, b, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *batchJoin) Close() error {
+	j.x.mem.release(j.reserved)
+	j.reserved = 0
+	j.rows, j.table = nil, nil
+	if j.spilled != nil {
+		j.spilled.Close()
+	}
+	err := j.left.Close()
+	if rerr := j.right.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// padBatches 输出一侧没有匹配的行，另一侧的列全部为空值。left 为 true 时 input 为左侧
+type padBatches struct {
+	input   batchReader
+	columns []models.ResultColumn
+	left    bool
+}
+
+func (p *padBatches) Columns() []models.ResultColumn {
+	return p.columns
+}
+
+func (p *padBatches) next() (*batch, error) {
+	b, err := p.input.next()
+	if b == nil || err != nil {
+		return nil, err
+	}
+	if p.left {
+		return padRight(p.columns, b), nil
+	}
+	return padLeft(p.columns, b), nil
+}
+
+func (p *padBatches) Close() error {
+	return p.input.Close()
+}
+
+// padLeft 在右侧的行之前补齐左侧的空值列
+func padLeft(columns []models.ResultColumn, right *batch) *batch {
+	width := len(columns) - len(right.Vectors)
+	out := &batch{Len: right.Len}
+	for _, c := range columns[:width] {
+		out.Vectors = append(out.Vectors, nullVector(kindOf(c.Type), right.Len))
+	}
+	out.Vectors = append(out.Vectors, right.Vectors...)
+	return out
+}
+
+// padRight 在左侧的行之后补齐右侧的空值列
+func padRight(columns []models.ResultColumn, left *batch) *batch {
+	out := &batch{Vectors: append([]*vector(nil), left.Vectors...), Len: left.Len}
+	for _, c := range columns[len(left.Vectors):] {
+		out.Vectors = append(out.Vectors, nullVector(kindOf(c.Type), left.Len))
+	}
+	return out
+}
+
+// nullVector n 个空值组成的向量
+func nullVector(kind vectorKind, n int) *vector {
+	v := newVector(kind, n)
+	for i := 0; i < n; i++ {
+		v.appendNull()
+	}
+	return v
+}
@@ -0,0 +1,209 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// mergeJoin strategy 为 merge 的 join 操作：两侧按连接键的编码排序（超出内存预算时外部排序），再同时顺序读取两侧归并。
+// 匹配规则与 hashJoin 相同；输出按连接键的编码排列，连接列为空值的行排在最前。
+// 右侧连接键相同的行需要同时保存在内存中
+type mergeJoin struct {
+	*joinLayout
+	ctx         context.Context
+	left, right *mergeCursor
+
+	group    *batch // 右侧连接键为 groupKey 的全部行
+	groupKey string
+	row      []interface{}
+}
+
+func newMergeJoin(x *columnarExec, layout *joinLayout, left, right batchReader) *mergeJoin {
+	return &mergeJoin{
+		joinLayout: layout,
+		ctx:        x.ctx,
+		left:       newMergeCursor(x, left, layout.leftKeys),
+		right:      newMergeCursor(x, right, layout.rightKeys),
+		row:        make([]interface{}, len(layout.columns)),
+	}
+}
+
+func (j *mergeJoin) Columns() []models.ResultColumn {
+	return j.columns
+}
+
+func (j *mergeJoin) next() (*batch, error) {
+	if err := j.ctx.Err(); err != nil {
+		return nil, err
+	}
+	out := newBatch(j.columns, batchSize)
+	for out.Len < batchSize {
+		lb, li, err := j.left.peek()
+		if err != nil {
+			return nil, err
+		}
+		rb, ri, err := j.right.peek()
+		if err != nil {
+			return nil, err
+		}
+		if lb == nil && rb == nil {
+			break
+		}
+		var lk, rk string
+		var lok, rok bool
+		if lb != nil {
+			lk, lok = j.left.key(lb, li)
+		}
+		if rb != nil {
+			rk, rok = j.right.key(rb, ri)
+		}
+		switch {
+		case lb != nil && lok && j.group != nil && lk == j.groupKey:
+			for g := 0; g < j.group.Len; g++ {
+				j.emit(out, lb, li, j.group, g)
+			}
+			j.left.pos++
+		case lb != nil && (!lok || rb == nil || (rok && lk < rk)):
+			if j.kind != joinInner {
+				j.emit(out, lb, li, nil, 0)
+			}
+			j.left.pos++
+		case rb != nil && (!rok || lb == nil || lk > rk):
+			if j.kind == joinFull {
+				j.emit(out, nil, 0, rb, ri)
+			}
+			j.right.pos++
+		default:
+			if j.group, err = j.right.readGroup(rk); err != nil {
+				return nil, err
+			}
+			j.groupKey = rk
+		}
+	}
+	if out.Len == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// emit 输出左侧 lb 的第 li 行与右侧 rb 的第 ri 行拼接的行，lb 或 rb 为 nil 时该侧为空值
+func (j *mergeJoin) emit(out *batch, lb *batch, li int, rb *batch, ri int) {
+	width := j.left.width
+	for c := range j.row {
+		switch {
+		case c < width && lb != nil:
+			j.row[c] = lb.Vectors[c].value(li)
+		case c >= width && rb != nil:
+			j.row[c] = rb.Vectors[c-width].value(ri)
+		default:
+			j.row[c] = nil
+		}
+	}
+	out.appendRow(j.row)
+}
+
+func (j *mergeJoin) Close() error {
+	err := j.left.input.Close()
+	if rerr := j.right.input.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// mergeCursor 逐行读取按连接键排序后的一侧。排序前在每批行之后追加一列连接键的编码
+type mergeCursor struct {
+	input batchReader
+	width int // 不含连接键的列数
+	b     *batch
+	pos   int
+	done  bool
+}
+
+func newMergeCursor(x *columnarExec, input batchReader, keys []joinKeyColumn) *mergeCursor {
+	width := len(input.Columns())
+	keyed := &keyedBatches{input: input, keys: keys,
+		columns: append(append([]models.ResultColumn(nil), input.Columns()...), models.ResultColumn{Type: database.TypeString})}
+	sorted := &batchSorter{ctx: x.ctx, mem: x.mem, input: keyed, keys: []int{width}, desc: []bool{false}}
+	return &mergeCursor{input: sorted, width: width}
+}
+
+// peek 当前行所在的批次和下标，读完时批次为 nil
+func (c *mergeCursor) peek() (*batch, int, error) {
+	for !c.done && (c.b == nil || c.pos >= c.b.Len) {
+		b, err := c.input.next()
+		if err != nil {
+			return nil, 0, err
+		}
+		c.b, c.pos, c.done = b, 0, b == nil
+	}
+	if c.done {
+		return nil, 0, nil
+	}
+	return c.b, c.pos, nil
+}
+
+// key b 的第 i 行的连接键编码，连接列有空值时 ok 为 false
+func (c *mergeCursor) key(b *batch, i int) (string, bool) {
+	v := b.Vectors[c.width]
+	if v.Nulls[i] {
+		return "", false
+	}
+	return v.Strings[i], true
+}
+
+// readGroup 读取从当前行起连接键为 key 的全部行，不含连接键列
+func (c *mergeCursor) readGroup(key string) (*batch, error) {
+	columns := c.input.Columns()[:c.width]
+	group := newBatch(columns, 0)
+	row := make([]interface{}, c.width+1)
+	for {
+		b, i, err := c.peek()
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			return group, nil
+		}
+		if k, ok := c.key(b, i); !ok || k != key {
+			return group, nil
+		}
+		b.row(i, row)
+		group.appendRow(row[:c.width])
+		c.pos++
+	}
+}
+
+// keyedBatches 在每批行之后追加一列连接键的编码，任一连接列为空值时为空值
+type keyedBatches struct {
+	input   batchReader
+	keys    []joinKeyColumn
+	columns []models.ResultColumn
+	key     []byte
+}
+
+func (k *keyedBatches) Columns() []models.ResultColumn {
+	return k.columns
+}
+
+func (k *keyedBatches) next() (*batch, error) {
+	b, err := k.input.next()
+	if b == nil || err != nil {
+		return nil, err
+	}
+	v := newVector(kindString, b.Len)
+	for i := 0; i < b.Len; i++ {
+		var ok bool
+		if k.key, ok = appendJoinKey(k.key[:0], k.keys, func(col int) interface{} { return b.Vectors[col].value(i) }); ok {
+			v.append(string(k.key))
+		} else {
+			v.appendNull()
+		}
+	}
+	return &batch{Vectors: append(append([]*vector(nil), b.Vectors...), v), Len: b.Len}, nil
+}
+
+func (k *keyedBatches) Close() error {
+	return k.input.Close()
+}
@@ -0,0 +1,243 @@
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// batchSorter sort 操作的列式执行：在内存预算内读入输入后稳定排序。超出预算时将已读入的行排序后
+// 作为一个有序段写入临时文件，读完输入后归并各段；相等的行按输入的顺序输出
+type batchSorter struct {
+	ctx   context.Context
+	mem   *memory
+	input batchReader
+	keys  []int
+	desc  []bool
+
+	output   batchReader
+	reserved int64
+}
+
+func (s *batchSorter) Columns() []models.ResultColumn {
+	return s.input.Columns()
+}
+
+func (s *batchSorter) next() (*batch, error) {
+	if s.output == nil {
+		var err error
+		if s.output, err = s.sort(); err != nil {
+			return nil, err
+		}
+	}
+	return s.output.next()
+}
+
+// sort 读完输入，返回排序后的行
+func (s *batchSorter) sort() (batchReader, error) {
+	columns := s.input.Columns()
+	var run []*batch
+	var runs []batchReader
+	for {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
+		b, err := s.input.next()
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			break
+		}
+		n := b.size()
+		if !s.mem.reserve(n) {
+			if len(run) > 0 {
+				spilled, err := s.spillRun(run)
+				if err != nil {
+					return nil, err
+				}
+				runs = append(runs, spilled)
+				run = nil
+				s.mem.release(s.reserved)
+				s.reserved = 0
+			}
+			// 每个有序段至少读入一批，即使这一批超出预算
+			s.mem.take(n)
+		}
+		s.reserved += n
+		run = append(run, b)
+	}
+
+	sorted := s.sortRun(columns, run)
+	if len(runs) == 0 {
+		return &sliceBatches{columns: columns, batches: sorted}, nil
+	}
+	runs = append(runs, &sliceBatches{columns: columns, batches: sorted})
+	return newMergeBatches(s.ctx, columns, runs, s.keys, s.desc), nil
+}
+
+// sortRun 将一段批次合并后稳定排序，按 batchSize 切分为新的批次
+func (s *batchSorter) sortRun(columns []models.ResultColumn, run []*batch) []*batch {
+	if len(run) == 0 {
+		return nil
+	}
+	all := concatBatches(columns, run)
+	order := make([]int, all.Len)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return compareRows(all, order[a], all, order[b], s.keys, s.desc) < 0
+	})
+	var sorted []*batch
+	for from := 0; from < len(order); from += batchSize {
+		to := min(from+batchSize, len(order))
+		sorted = append(sorted, all.gather(order[from:to]))
+	}
+	return sorted
+}
+
+// spillRun 排序一段批次并写入临时文件
+func (s *batchSorter) spillRun(run []*batch) (batchReader, error) {
+	columns := s.input.Columns()
+	f, err := s.mem.spill(columns)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range s.sortRun(columns, run) {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := f.write(b); err != nil {
+			return nil, err
+		}
+	}
+	return f.reader()
+}
+
+func (s *batchSorter) Close() error {
+	s.mem.release(s.reserved)
+	s.reserved = 0
+	if s.output != nil {
+		s.output.Close()
+	}
+	return s.input.Close()
+}
+
+// compareRows 按排序列比较 a 的第 i 行和 b 的第 j 行
+func compareRows(a *batch, i int, b *batch, j int, keys []int, desc []bool) int {
+	for k, col := range keys {
+		c := compareAt(a.Vectors[col], i, b.Vectors[col], j)
+		if desc[k] {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// mergeBatches 归并多个有序段。相等的行先输出靠前的段中的行，段按输入的顺序排列，因此排序是稳定的
+type mergeBatches struct {
+	ctx     context.Context
+	columns []models.ResultColumn
+	runs    []batchReader
+	keys    []int
+	desc    []bool
+	cursors cursorHeap
+	started bool
+	row     []interface{}
+}
+
+// runCursor 一个有序段中的当前行
+type runCursor struct {
+	run   int
+	batch *batch
+	pos   int
+}
+
+func newMergeBatches(ctx context.Context, columns []models.ResultColumn, runs []batchReader, keys []int, desc []bool) *mergeBatches {
+	m := &mergeBatches{ctx: ctx, columns: columns, runs: runs, keys: keys, desc: desc, row: make([]interface{}, len(columns))}
+	m.cursors.less = func(a, b *runCursor) bool {
+		if c := compareRows(a.batch, a.pos, b.batch, b.pos, keys, desc); c != 0 {
+			return c < 0
+		}
+		return a.run < b.run
+	}
+	return m
+}
+
+func (m *mergeBatches) Columns() []models.ResultColumn {
+	return m.columns
+}
+
+func (m *mergeBatches) next() (*batch, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !m.started {
+		m.started = true
+		for i, run := range m.runs {
+			b, err := run.next()
+			if err != nil {
+				return nil, err
+			}
+			if b != nil {
+				m.cursors.items = append(m.cursors.items, &runCursor{run: i, batch: b})
+			}
+		}
+		heap.Init(&m.cursors)
+	}
+	out := newBatch(m.columns, batchSize)
+	for out.Len < batchSize && m.cursors.Len() > 0 {
+		c := m.cursors.items[0]
+		c.batch.row(c.pos, m.row)
+		out.appendRow(m.row)
+		c.pos++
+		if c.pos == c.batch.Len {
+			b, err := m.runs[c.run].next()
+			if err != nil {
+				return nil, err
+			}
+			if b == nil {
+				heap.Pop(&m.cursors)
+				continue
+			}
+			c.batch, c.pos = b, 0
+		}
+		heap.Fix(&m.cursors, 0)
+	}
+	if out.Len == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+func (m *mergeBatches) Close() error {
+	var err error
+	for _, run := range m.runs {
+		if cerr := run.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// cursorHeap 按当前行排序的有序段，实现 heap.Interface
+type cursorHeap struct {
+	items []*runCursor
+	less  func(a, b *runCursor) bool
+}
+
+func (h *cursorHeap) Len() int           { return len(h.items) }
+func (h *cursorHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *cursorHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *cursorHeap) Push(x interface{}) { h.items = append(h.items, x.(*runCursor)) }
+func (h *cursorHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
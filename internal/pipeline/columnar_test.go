@@ -0,0 +1,256 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// sliceRows 保存在切片中的行
+type sliceRows struct {
+	columns []models.ResultColumn
+	rows    [][]interface{}
+	pos     int
+}
+
+func (r *sliceRows) Columns() []models.ResultColumn { return r.columns }
+func (r *sliceRows) Next() bool                     { r.pos++; return r.pos <= len(r.rows) }
+func (r *sliceRows) Row() []interface{}             { return r.rows[r.pos-1] }
+func (r *sliceRows) Err() error                     { return nil }
+func (r *sliceRows) Close() error                   { return nil }
+
+// testTable 测试用的实体
+type testTable struct {
+	columns []models.ResultColumn
+	rows    [][]interface{}
+}
+
+// tables 按实体名读取 tables 中的表
+func tables(m map[string]testTable) Source {
+	return func(entity string) (Rows, error) {
+		t, ok := m[entity]
+		if !ok {
+			return nil, fmt.Errorf("entity %s not found", entity)
+		}
+		return &sliceRows{columns: t.columns, rows: t.rows}, nil
+	}
+}
+
+// schemas 返回 tables 中表的列
+func schemas(m map[string]testTable) SchemaFunc {
+	return func(entity string) ([]models.ResultColumn, error) {
+		t, ok := m[entity]
+		if !ok {
+			return nil, fmt.Errorf("entity %s not found", entity)
+		}
+		return t.columns, nil
+	}
+}
+
+// testTables 订单表和地区表，订单的地区有的不在地区表中，金额有空值
+func testTables(orders int) map[string]testTable {
+	regions := []string{"east", "west", "north", "south", "central"}
+	o := testTable{columns: []models.ResultColumn{
+		{Name: "id", Type: database.TypeInteger},
+		{Name: "region", Type: database.TypeString},
+		{Name: "amount", Type: database.TypeFloat},
+	}}
+	for i := 0; i < orders; i++ {
+		var amount interface{} = float64(i%97) + 0.5
+		if i%13 == 0 {
+			amount = nil
+		}
+		o.rows = append(o.rows, []interface{}{int64(i), regions[i%len(regions)] + fmt.Sprint(i%7), amount})
+	}
+	r := testTable{columns: []models.ResultColumn{
+		{Name: "region", Type: database.TypeString},
+		{Name: "manager", Type: database.TypeString},
+	}}
+	for i, name := range regions[:4] {
+		for j := 0; j < 7; j += 2 {
+			r.rows = append(r.rows, []interface{}{name + fmt.Sprint(j), fmt.Sprintf("m%d", i*7+j)})
+		}
+	}
+	return map[string]testTable{"orders": o, "regions": r}
+}
+
+func collect(t *testing.T, rows Rows, err error) [][]interface{} {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var out [][]interface{}
+	for rows.Next() {
+		out = append(out, append([]interface{}(nil), rows.Row()...))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// sorted 按行的文本排序，用于比较不保证顺序的结果
+func sorted(rows [][]interface{}) [][]interface{} {
+	out := append([][]interface{}(nil), rows...)
+	sort.Slice(out, func(i, j int) bool { return fmt.Sprint(out[i]) < fmt.Sprint(out[j]) })
+	return out
+}
+
+var columnarPipelines = []struct {
+	name    string
+	ops     []models.Operation
+	ordered bool
+	// spills 为 true 时操作保存的行超出测试中的内存预算
+	spills bool
+}{
+	{"filter calculate sort limit", []models.Operation{
+		{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "amount", Operator: "gt", Value: 20.0}}},
+		{Type: models.OpCalculate, Fields: []models.CalculatedField{{Name: "double", Expression: "amount * 2"}}},
+		{Type: models.OpSort, Keys: []models.SortKey{{Column: "double", Direction: "desc"}, {Column: "id"}}},
+		{Type: models.OpLimit, Limit: 100, Offset: 10},
+	}, true, true},
+	{"sort with nulls", []models.Operation{
+		{Type: models.OpSort, Keys: []models.SortKey{{Column: "amount"}, {Column: "id", Direction: "desc"}}},
+	}, true, true},
+	{"group by", []models.Operation{
+		{Type: models.OpGroupBy, GroupBy: []string{"region"}, Aggregates: []models.Aggregate{
+			{Function: "count"}, {Function: "sum", Column: "amount"}, {Function: "max", Column: "id"},
+			{Function: "countDistinct", Column: "amount"},
+		}},
+	}, false, false},
+	{"group by many keys", []models.Operation{
+		{Type: models.OpGroupBy, GroupBy: []string{"id", "region"}, Aggregates: []models.Aggregate{
+			{Function: "sum", Column: "amount"}, {Function: "avg", Column: "amount"},
+		}},
+	}, false, true},
+	{"distinct", []models.Operation{
+		{Type: models.OpSelect, Columns: []string{"region", "amount"}},
+		{Type: models.OpDistinct},
+	}, false, true},
+	{"inner join", []models.Operation{
+		{Type: models.OpJoin, Entity: "regions", On: []models.JoinKey{{Left: "region", Right: "region"}}},
+	}, false, false},
+	{"full join", []models.Operation{
+		{Type: models.OpJoin, Entity: "regions", JoinType: "full", On: []models.JoinKey{{Left: "region", Right: "region"}}},
+	}, false, false},
+	{"large left join", []models.Operation{
+		{Type: models.OpJoin, Entity: "orders", JoinType: "left", On: []models.JoinKey{{Left: "id", Right: "id"}},
+			Operations: []models.Operation{{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "amount", Operator: "gt", Value: 50.0}}}}},
+	}, false, true},
+	{"large full join", []models.Operation{
+		{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "amount", Operator: "lt", Value: 70.0}}},
+		{Type: models.OpJoin, Entity: "orders", JoinType: "full", On: []models.JoinKey{{Left: "amount", Right: "amount"}, {Left: "id", Right: "id"}},
+			Operations: []models.Operation{{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "amount", Operator: "gt", Value: 50.0}}}}},
+	}, false, true},
+	{"union", []models.Operation{
+		{Type: models.OpSelect, Columns: []string{"region"}},
+		{Type: models.OpUnion, Entity: "regions", Operations: []models.Operation{{Type: models.OpSelect, Columns: []string{"region"}}}},
+	}, false, false},
+}
+
+func TestExecuteColumnarMatchesExecute(t *testing.T) {
+	open := tables(testTables(5000))
+	for _, tt := range columnarPipelines {
+		p := &models.Pipeline{Entity: "orders", Operations: tt.ops}
+		rows, err := Execute(p, open)
+		want := collect(t, rows, err)
+		rows, err = ExecuteColumnar(context.Background(), p, open, ColumnarOptions{})
+		got := collect(t, rows, err)
+		if !tt.ordered {
+			want, got = sorted(want), sorted(got)
+		}
+		if len(want) == 0 || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %d rows, want %d rows", tt.name, len(got), len(want))
+		}
+	}
+}
+
+func TestExecuteColumnarSpill(t *testing.T) {
+	open := tables(testTables(10000))
+	for _, tt := range columnarPipelines {
+		p := &models.Pipeline{Entity: "orders", Operations: tt.ops}
+		rows, err := ExecuteColumnar(context.Background(), p, open, ColumnarOptions{})
+		want := collect(t, rows, err)
+
+		dir := t.TempDir()
+		rows, err = ExecuteColumnar(context.Background(), p, open, ColumnarOptions{MemoryBudget: 64 << 10, SpillDir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]interface{}
+		for rows.Next() {
+			got = append(got, append([]interface{}(nil), rows.Row()...))
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		spilled, _ := os.ReadDir(dir)
+		rows.Close()
+		if left, _ := os.ReadDir(dir); len(left) != 0 {
+			t.Errorf("%s: %d spill files left after close", tt.name, len(left))
+		}
+
+		if tt.spills && len(spilled) == 0 {
+			t.Errorf("%s: nothing was spilled", tt.name)
+		}
+		if !tt.ordered {
+			want, got = sorted(want), sorted(got)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %d rows, want %d rows", tt.name, len(got), len(want))
+		}
+	}
+}
+
+func TestExecuteColumnarCancelled(t *testing.T) {
+	open := tables(testTables(10000))
+	for _, tt := range columnarPipelines {
+		if !tt.spills {
+			continue
+		}
+		p := &models.Pipeline{Entity: "orders", Operations: tt.ops}
+		ctx, cancel := context.WithCancel(context.Background())
+		rows, err := ExecuteColumnar(ctx, p, open, ColumnarOptions{MemoryBudget: 64 << 10, SpillDir: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		for rows.Next() {
+		}
+		if err := rows.Err(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want context.Canceled", tt.name, err)
+		}
+		rows.Close()
+	}
+}
+
+func TestExecuteColumnarJoinStrategies(t *testing.T) {
+	open := tables(testTables(10000))
+	for _, tt := range columnarPipelines {
+		last := &tt.ops[len(tt.ops)-1]
+		if last.Type != models.OpJoin {
+			continue
+		}
+		p := &models.Pipeline{Entity: "orders", Operations: tt.ops}
+		rows, err := Execute(p, open)
+		want := sorted(collect(t, rows, err))
+
+		merge := *last
+		merge.Strategy = strategyMerge
+		p = &models.Pipeline{Entity: "orders", Operations: append(append([]models.Operation(nil), tt.ops[:len(tt.ops)-1]...), merge)}
+		for _, budget := range []int64{0, 64 << 10} {
+			rows, err := ExecuteColumnar(context.Background(), p, open, ColumnarOptions{MemoryBudget: budget, SpillDir: t.TempDir()})
+			if got := sorted(collect(t, rows, err)); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s with merge strategy, budget %d: got %d rows, want %d rows", tt.name, budget, len(got), len(want))
+			}
+		}
+	}
+}
@@ -0,0 +1,629 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/sqlguard"
+)
+
+// Plan 处理操作的执行计划：前 Pushed 个操作编译进 SQL 在数据源中执行，其余操作在内存中执行。
+// 下推在第一个无法用该方言准确表达的操作处停止，之后的操作都在内存中执行
+type Plan struct {
+	Engine  string           `json:"engine"`
+	Dialect sqlguard.Dialect `json:"dialect,omitempty"`
+	SQL     string           `json:"sql,omitempty"`
+	Pushed  int              `json:"-"`
+	// Columns SQL 返回的列及编译时推导的类型，驱动不报告聚合、计算列的类型时以此为准
+	Columns []models.ResultColumn `json:"-"`
+	Steps   []PlanStep            `json:"steps"`
+	Inputs  []PlanInput           `json:"inputs,omitempty"`
+}
+
+// 执行计划的执行方式
+const (
+	EngineSQL       = "sql"       // 部分操作下推为数据源的 SQL，其余操作由 Resume 逐行处理
+	EngineColumnar  = "columnar"  // 全部操作由 ExecuteColumnar 在内存中列式执行
+	EngineFederated = "federated" // 各数据源的输入按 Inputs 中的计划执行，其余操作由 ExecuteFederated 列式执行
+)
+
+// PlanInput 跨数据源执行时一个输入的执行计划。Index 为输入所属的 join、union 操作的下标，主输入为 -1
+type PlanInput struct {
+	Index    int              `json:"index"`
+	Pipeline *models.Pipeline `json:"pipeline"`
+	Plan     *Plan            `json:"plan"`
+}
+
+// PlanStep 一个操作的执行方式，Reason 为未下推的原因
+type PlanStep struct {
+	Index      int                  `json:"index"`
+	Type       models.OperationType `json:"type"`
+	PushedDown bool                 `json:"pushedDown"`
+	Reason     string               `json:"reason,omitempty"`
+}
+
+// SchemaFunc 返回实体的列，实体不存在时返回 database.ErrEntityNotFound
+type SchemaFunc func(entity string) ([]models.ResultColumn, error)
+
+// Compile 为处理操作生成执行计划。maxRows 大于 0 时 SQL 最多返回 maxRows+1 行，
+// 由调用方检查是否超出。操作应已通过 Check
+func Compile(p *models.Pipeline, dialect sqlguard.Dialect, maxRows int, schema SchemaFunc) (*Plan, error) {
+	if err := Validate(p); err != nil {
+		return nil, err
+	}
+	c := &compiler{dialect: dialect, schema: schema}
+	q, err := c.base(p.Entity)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Engine: EngineSQL, Dialect: dialect, Steps: make([]PlanStep, len(p.Operations))}
+	reason := ""
+	for i := range p.Operations {
+		op := &p.Operations[i]
+		plan.Steps[i] = PlanStep{Index: i, Type: op.Type}
+		if reason != "" {
+			plan.Steps[i].Reason = "follows a step executed in memory"
+			continue
+		}
+		var next *sqlQuery
+		if next, reason, err = c.push(i, q, op); err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			plan.Steps[i].Reason = reason
+			continue
+		}
+		q = next
+		plan.Steps[i].PushedDown = true
+		plan.Pushed++
+	}
+	if maxRows > 0 {
+		q.restrict(maxRows+1, 0)
+	}
+	plan.SQL = c.render(q)
+	plan.Columns = q.columns()
+	return plan, nil
+}
+
+// sqlQuery 一层 SELECT。每个操作尽量合并到当前这一层，无法合并时将当前层作为子查询
+type sqlQuery struct {
+	from     string
+	table    bool // from 为实体本身，而不是子查询或连接
+	star     bool // 输出 from 的全部列，不需要列出
+	items    []sqlItem
+	where    []string
+	grouped  bool
+	groupBy  []string
+	distinct bool
+	orderBy  []orderKey
+	limit    int // 0 表示不限制
+	offset   int
+}
+
+// sqlItem 输出列：基于 from 中的列的 SQL 表达式及其列名、类型
+type sqlItem struct {
+	expr   string
+	column models.ResultColumn
+}
+
+type orderKey struct {
+	expr string
+	desc bool
+}
+
+func (q *sqlQuery) columns() []models.ResultColumn {
+	columns := make([]models.ResultColumn, len(q.items))
+	for i, item := range q.items {
+		columns[i] = item.column
+	}
+	return columns
+}
+
+func (q *sqlQuery) item(name string) (sqlItem, bool) {
+	for _, item := range q.items {
+		if item.column.Name == name {
+			return item, true
+		}
+	}
+	return sqlItem{}, false
+}
+
+// windowed 已截取行数，之后的过滤、排序、分组等需要在子查询外进行
+func (q *sqlQuery) windowed() bool {
+	return q.limit != 0 || q.offset > 0
+}
+
+// restrict 在已有的行数限制上再跳过 offset 行并最多保留 limit 行，limit 为 0 时不限制。
+// 已没有剩余的行时 limit 记为 -1
+func (q *sqlQuery) restrict(limit, offset int) {
+	switch {
+	case q.limit < 0:
+		return
+	case q.limit > 0:
+		remaining := q.limit - offset
+		if remaining <= 0 {
+			q.limit = -1
+			return
+		}
+		if limit == 0 || limit > remaining {
+			limit = remaining
+		}
+	}
+	q.offset += offset
+	q.limit = limit
+}
+
+type compiler struct {
+	dialect sqlguard.Dialect
+	schema  SchemaFunc
+	aliases int
+}
+
+func (c *compiler) alias() string {
+	c.aliases++
+	return "t" + strconv.Itoa(c.aliases)
+}
+
+func (c *compiler) quote(name string) string {
+	return QuoteIdent(c.dialect, name)
+}
+
+// base 读取实体全部列的查询
+func (c *compiler) base(entity string) (*sqlQuery, error) {
+	columns, err := c.schema(entity)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(entity, ".")
+	for i, part := range parts {
+		parts[i] = c.quote(part)
+	}
+	q := &sqlQuery{from: strings.Join(parts, "."), table: true, star: true}
+	for _, col := range columns {
+		q.items = append(q.items, sqlItem{expr: c.quote(col.Name), column: col})
+	}
+	return q, nil
+}
+
+// wrap 将 q 作为子查询，返回在其上继续操作的新一层。q 已排序时外层不保证保持顺序，不能包装
+func (c *compiler) wrap(q *sqlQuery) (*sqlQuery, string) {
+	if len(q.orderBy) > 0 {
+		return nil, "would lose the order of a previous sort"
+	}
+	outer := &sqlQuery{from: "(" + c.render(q) + ") AS " + c.alias(), star: true}
+	for _, item := range q.items {
+		outer.items = append(outer.items, sqlItem{expr: c.quote(item.column.Name), column: item.column})
+	}
+	return outer, ""
+}
+
+// source 将 q 用作连接或合并的一侧，返回 FROM 中的引用和列名前缀
+func (c *compiler) source(q *sqlQuery) (string, string) {
+	alias := c.alias()
+	if q.table && len(q.where) == 0 && !q.grouped && !q.distinct && len(q.orderBy) == 0 && !q.windowed() {
+		plain := true
+		for _, item := range q.items {
+			plain = plain && item.expr == c.quote(item.column.Name)
+		}
+		if plain {
+			return q.from + " AS " + alias, alias
+		}
+	}
+	return "(" + c.render(q) + ") AS " + alias, alias
+}
+
+// push 将第 index 个操作合并到 q，返回新的查询；无法下推时返回原因
+func (c *compiler) push(index int, q *sqlQuery, op *models.Operation) (*sqlQuery, string, error) {
+	// 内存中的算子给出操作的输出列，同时再次检查列引用
+	out, err := newOperator(index, &emptyRows{columns: q.columns()}, op, c.schemaSource)
+	if err != nil {
+		return nil, "", err
+	}
+	columns := out.Columns()
+	out.Close()
+
+	switch op.Type {
+	case models.OpSelect:
+		return c.pushSelect(q, op, columns)
+	case models.OpFilter:
+		return c.pushFilter(q, op)
+	case models.OpCalculate:
+		return c.pushCalculate(q, op, columns)
+	case models.OpGroupBy:
+		return c.pushGroupBy(q, op, columns)
+	case models.OpSort:
+		return c.pushSort(q, op)
+	case models.OpLimit:
+		next := *q
+		next.restrict(op.Limit, op.Offset)
+		return &next, "", nil
+	case models.OpDistinct:
+		return c.pushDistinct(q)
+	case models.OpJoin:
+		return c.pushJoin(index, q, op, columns)
+	case models.OpUnion:
+		return c.pushUnion(index, q, op, columns)
+	}
+	return nil, fmt.Sprintf("%s is not supported", op.Type), nil
+}
+
+// schemaSource 用于构造内存算子的实体，只有列没有行
+func (c *compiler) schemaSource(entity string) (Rows, error) {
+	columns, err := c.schema(entity)
+	if err != nil {
+		return nil, err
+	}
+	return &emptyRows{columns: columns}, nil
+}
+
+func (c *compiler) pushSelect(q *sqlQuery, op *models.Operation, columns []models.ResultColumn) (*sqlQuery, string, error) {
+	var reason string
+	if q.distinct {
+		// 去重后再减少列会改变去重的结果
+		if q, reason = c.wrap(q); reason != "" {
+			return nil, reason, nil
+		}
+	}
+	next := *q
+	next.star = false
+	next.items = make([]sqlItem, len(op.Columns))
+	for i, name := range op.Columns {
+		item, _ := q.item(name)
+		next.items[i] = sqlItem{expr: item.expr, column: columns[i]}
+	}
+	return &next, "", nil
+}
+
+func (c *compiler) pushFilter(q *sqlQuery, op *models.Operation) (*sqlQuery, string, error) {
+	var reason string
+	if q.grouped || q.distinct || q.windowed() {
+		if q, reason = c.wrap(q); reason != "" {
+			return nil, reason, nil
+		}
+	}
+	conditions := make([]string, len(op.Conditions))
+	for i, cond := range op.Conditions {
+		item, _ := q.item(cond.Column)
+		if conditions[i], reason = c.condition(item, cond); reason != "" {
+			return nil, fmt.Sprintf("conditions[%d]: %s", i, reason), nil
+		}
+	}
+	next := *q
+	next.where = append([]string(nil), q.where...)
+	if op.Match == "any" && len(conditions) > 1 {
+		next.where = append(next.where, "("+strings.Join(conditions, " OR ")+")")
+	} else {
+		next.where = append(next.where, conditions...)
+	}
+	return &next, "", nil
+}
+
+func (c *compiler) pushCalculate(q *sqlQuery, op *models.Operation, columns []models.ResultColumn) (*sqlQuery, string, error) {
+	var reason string
+	if q.distinct {
+		if q, reason = c.wrap(q); reason != "" {
+			return nil, reason, nil
+		}
+	}
+	next := *q
+	next.star = false
+	next.items = append([]sqlItem(nil), q.items...)
+	for i, field := range op.Fields {
+		e, err := parseExpr(field.Expression)
+		if err != nil {
+			return nil, "", err
+		}
+		// 后面的表达式引用前面的计算列时直接代入其表达式
+		sql, reason := c.expr(e, next.items)
+		if reason != "" {
+			return nil, fmt.Sprintf("fields[%d]: %s", i, reason), nil
+		}
+		next.items = append(next.items, sqlItem{expr: sql, column: columns[len(q.items)+i]})
+	}
+	return &next, "", nil
+}
+
+func (c *compiler) pushGroupBy(q *sqlQuery, op *models.Operation, columns []models.ResultColumn) (*sqlQuery, string, error) {
+	if len(q.orderBy) > 0 {
+		return nil, "groups would not follow the order of a previous sort", nil
+	}
+	var reason string
+	if q.grouped || q.distinct || q.windowed() {
+		if q, reason = c.wrap(q); reason != "" {
+			return nil, reason, nil
+		}
+	}
+	next := *q
+	next.star = false
+	next.grouped = true
+	next.groupBy = nil
+	next.items = nil
+	for i, name := range op.GroupBy {
+		item, _ := q.item(name)
+		next.groupBy = append(next.groupBy, item.expr)
+		next.items = append(next.items, sqlItem{expr: item.expr, column: columns[i]})
+	}
+	for i, agg := range op.Aggregates {
+		arg := "*"
+		if agg.Column != "" {
+			item, _ := q.item(agg.Column)
+			arg = item.expr
+		}
+		next.items = append(next.items, sqlItem{expr: c.aggregate(agg.Function, arg), column: columns[len(op.GroupBy)+i]})
+	}
+	return &next, "", nil
+}
+
+func (c *compiler) pushSort(q *sqlQuery, op *models.Operation) (*sqlQuery, string, error) {
+	var reason string
+	if q.windowed() {
+		if q, reason = c.wrap(q); reason != "" {
+			return nil, reason, nil
+		}
+	}
+	next := *q
+	next.orderBy = nil
+	for _, key := range op.Keys {
+		item, _ := q.item(key.Column)
+		next.orderBy = append(next.orderBy, orderKey{expr: item.expr, desc: key.Direction == "desc"})
+	}
+	// 排序是稳定的，原有的排序作为次要的排序列
+	next.orderBy = append(next.orderBy, q.orderBy...)
+	if q.distinct && !next.orderedBySelected() {
+		return nil, "sort keys of distinct rows must be selected", nil
+	}
+	return &next, "", nil
+}
+
+// orderedBySelected 排序列都在输出列中，SELECT DISTINCT 要求如此
+func (q *sqlQuery) orderedBySelected() bool {
+	for _, key := range q.orderBy {
+		found := false
+		for _, item := range q.items {
+			found = found || item.expr == key.expr
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *compiler) pushDistinct(q *sqlQuery) (*sqlQuery, string, error) {
+	var reason string
+	if q.windowed() {
+		if q, reason = c.wrap(q); reason != "" {
+			return nil, reason, nil
+		}
+	}
+	next := *q
+	next.distinct = true
+	if !next.orderedBySelected() {
+		return nil, "sort keys of distinct rows must be selected", nil
+	}
+	return &next, "", nil
+}
+
+// nested 编译 join、union 另一侧的实体及其操作，所有操作都能下推时才能使用
+func (c *compiler) nested(index int, op *models.Operation) (*sqlQuery, string, error) {
+	q, err := c.base(op.Entity)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range op.Operations {
+		next, reason, err := c.push(i, q, &op.Operations[i])
+		if err != nil {
+			return nil, "", nestedError(index, err)
+		}
+		if reason != "" {
+			return nil, fmt.Sprintf("operations[%d]: %s", i, reason), nil
+		}
+		q = next
+	}
+	return q, "", nil
+}
+
+func (c *compiler) pushJoin(index int, q *sqlQuery, op *models.Operation, columns []models.ResultColumn) (*sqlQuery, string, error) {
+	kind := op.JoinType
+	if kind == "" {
+		kind = joinInner
+	}
+	switch {
+	case kind == joinFull && c.dialect == sqlguard.MySQL:
+		return nil, "mysql does not support full joins", nil
+	case kind != joinInner && c.dialect == sqlguard.ClickHouse:
+		// ClickHouse 默认用列的默认值而不是空值填充未匹配的一侧
+		return nil, "outer joins fill unmatched rows with defaults in clickhouse", nil
+	case len(q.orderBy) > 0:
+		return nil, "would lose the order of a previous sort", nil
+	}
+	right, reason, err := c.nested(index, op)
+	if err != nil || reason != "" {
+		return nil, reason, err
+	}
+	for i, on := range op.On {
+		l, _ := q.item(on.Left)
+		r, _ := right.item(on.Right)
+		if l.column.Type != r.column.Type && (keyCoercion(l.column.Type, r.column.Type) != "" || keyCoercion(r.column.Type, l.column.Type) != "") {
+			return nil, fmt.Sprintf("on[%d]: join keys have different types (%s and %s)", i, l.column.Type, r.column.Type), nil
+		}
+	}
+
+	leftFrom, leftAlias := c.source(q)
+	rightFrom, rightAlias := c.source(right)
+	keys := make([]string, len(op.On))
+	for i, on := range op.On {
+		keys[i] = leftAlias + "." + c.quote(on.Left) + " = " + rightAlias + "." + c.quote(on.Right)
+	}
+	next := &sqlQuery{from: leftFrom + " " + strings.ToUpper(kind) + " JOIN " + rightFrom + " ON " + strings.Join(keys, " AND ")}
+	for i, item := range q.items {
+		next.items = append(next.items, sqlItem{expr: leftAlias + "." + c.quote(item.column.Name), column: columns[i]})
+	}
+	for i, item := range right.items {
+		next.items = append(next.items, sqlItem{expr: rightAlias + "." + c.quote(item.column.Name), column: columns[len(q.items)+i]})
+	}
+	return next, "", nil
+}
+
+func (c *compiler) pushUnion(index int, q *sqlQuery, op *models.Operation, columns []models.ResultColumn) (*sqlQuery, string, error) {
+	if len(q.orderBy) > 0 {
+		return nil, "would lose the order of a previous sort", nil
+	}
+	right, reason, err := c.nested(index, op)
+	if err != nil || reason != "" {
+		return nil, reason, err
+	}
+	// 右侧按列名对应左侧的列
+	names := make([]string, len(q.items))
+	for i, item := range q.items {
+		names[i] = c.quote(item.column.Name)
+	}
+	keyword := " UNION "
+	switch {
+	case op.All:
+		keyword = " UNION ALL "
+	case c.dialect == sqlguard.ClickHouse:
+		keyword = " UNION DISTINCT "
+	}
+	sql := "SELECT * FROM (" + c.render(q) + ") AS " + c.alias() + keyword +
+		"SELECT " + strings.Join(names, ", ") + " FROM (" + c.render(right) + ") AS " + c.alias()
+	next := &sqlQuery{from: "(" + sql + ") AS " + c.alias(), star: true}
+	for i, item := range q.items {
+		next.items = append(next.items, sqlItem{expr: c.quote(item.column.Name), column: columns[i]})
+	}
+	return next, "", nil
+}
+
+// aggregate 聚合函数的 SQL。ClickHouse 对空输入的 sum、avg、min、max 返回默认值，使用 OrNull 版本返回空值
+func (c *compiler) aggregate(function, arg string) string {
+	switch function {
+	case aggCount:
+		return "COUNT(" + arg + ")"
+	case aggCountDistinct:
+		return "COUNT(DISTINCT " + arg + ")"
+	}
+	if c.dialect == sqlguard.ClickHouse {
+		return function + "OrNull(" + arg + ")"
+	}
+	return strings.ToUpper(function) + "(" + arg + ")"
+}
+
+// render 生成 q 的 SQL
+func (c *compiler) render(q *sqlQuery) string {
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	if q.distinct {
+		b.WriteString("DISTINCT ")
+	}
+	if q.star {
+		b.WriteString("*")
+	} else {
+		for i, item := range q.items {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(item.expr)
+			if name := c.quote(item.column.Name); item.expr != name {
+				b.WriteString(" AS " + name)
+			}
+		}
+	}
+	b.WriteString(" FROM " + q.from)
+	if len(q.where) > 0 {
+		b.WriteString(" WHERE " + strings.Join(q.where, " AND "))
+	}
+	if len(q.groupBy) > 0 {
+		b.WriteString(" GROUP BY " + strings.Join(q.groupBy, ", "))
+	}
+	if len(q.orderBy) > 0 {
+		keys := make([]string, len(q.orderBy))
+		for i, key := range q.orderBy {
+			keys[i] = c.orderKey(key)
+		}
+		b.WriteString(" ORDER BY " + strings.Join(keys, ", "))
+	}
+	b.WriteString(c.limitClause(q.limit, q.offset))
+	return b.String()
+}
+
+// orderKey 排序列，升序时空值在前、降序时在后。MySQL、SQLite 默认如此，PostgreSQL、ClickHouse 需要指明
+func (c *compiler) orderKey(key orderKey) string {
+	direction := " ASC"
+	nulls := " NULLS FIRST"
+	if key.desc {
+		direction, nulls = " DESC", " NULLS LAST"
+	}
+	if c.dialect == sqlguard.MySQL || c.dialect == sqlguard.SQLite {
+		nulls = ""
+	}
+	return key.expr + direction + nulls
+}
+
+// limitClause LIMIT、OFFSET 子句。limit 为 -1 时不返回任何行；只有 offset 时，
+// MySQL、ClickHouse、SQLite 需要给出不限制的 LIMIT
+func (c *compiler) limitClause(limit, offset int) string {
+	var clause string
+	switch {
+	case limit < 0:
+		clause = " LIMIT 0"
+	case limit > 0:
+		clause = " LIMIT " + strconv.Itoa(limit)
+	case offset > 0 && c.dialect == sqlguard.SQLite:
+		clause = " LIMIT -1"
+	case offset > 0 && c.dialect != sqlguard.PostgreSQL:
+		clause = " LIMIT 18446744073709551615"
+	}
+	if offset > 0 {
+		clause += " OFFSET " + strconv.Itoa(offset)
+	}
+	return clause
+}
+
+// literal 内联的字面量。字符串按方言转义，MySQL、ClickHouse 中反斜杠同样需要转义
+func (c *compiler) literal(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if x {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	s := strings.ReplaceAll(toString(v), "'", "''")
+	if c.dialect == sqlguard.MySQL || c.dialect == sqlguard.ClickHouse {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
+	return "'" + s + "'"
+}
+
+// conditionValue 过滤条件中与列比较的字面量。数值列只能与数字比较，日期列只能与可解析的日期比较，
+// 否则数据库与内存中的比较结果不同
+func (c *compiler) conditionValue(v interface{}, columnType string) (string, string) {
+	s, ok := v.(string)
+	if !ok {
+		return c.literal(v), ""
+	}
+	switch columnType {
+	case database.TypeInteger, database.TypeFloat, database.TypeDecimal:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return "", fmt.Sprintf("%q is not a number", s)
+		}
+		return c.literal(f), ""
+	case database.TypeDate, database.TypeDateTime:
+		if _, ok := parseTime(s); !ok {
+			return "", fmt.Sprintf("%q is not a date", s)
+		}
+	case database.TypeBoolean:
+		return "", "boolean columns can only be compared with true or false"
+	}
+	return c.literal(s), ""
+}
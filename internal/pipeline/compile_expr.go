@@ -0,0 +1,311 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/sqlguard"
+)
+
+// condition 过滤条件的 SQL，item 为条件中的列
+func (c *compiler) condition(item sqlItem, cond models.FilterCondition) (string, string) {
+	col := item.expr
+	switch cond.Operator {
+	case opIsNull:
+		return col + " IS NULL", ""
+	case opNotNull:
+		return col + " IS NOT NULL", ""
+	case opContains, opStartsWith, opEndsWith:
+		return c.match(col, cond.Operator, cond.Value.(string)), ""
+	case opIn, opNotIn:
+		values := cond.Value.([]interface{})
+		list := make([]string, len(values))
+		for i, v := range values {
+			var reason string
+			if list[i], reason = c.conditionValue(v, item.column.Type); reason != "" {
+				return "", reason
+			}
+		}
+		keyword := " IN ("
+		if cond.Operator == opNotIn {
+			keyword = " NOT IN ("
+		}
+		return col + keyword + strings.Join(list, ", ") + ")", ""
+	case opBetween:
+		bounds := cond.Value.([]interface{})
+		low, reason := c.conditionValue(bounds[0], item.column.Type)
+		if reason != "" {
+			return "", reason
+		}
+		high, reason := c.conditionValue(bounds[1], item.column.Type)
+		if reason != "" {
+			return "", reason
+		}
+		return col + " BETWEEN " + low + " AND " + high, ""
+	}
+
+	value, reason := c.conditionValue(cond.Value, item.column.Type)
+	if reason != "" {
+		return "", reason
+	}
+	operators := map[string]string{opEq: " = ", opNe: " <> ", opGt: " > ", opGte: " >= ", opLt: " < ", opLte: " <= "}
+	return col + operators[cond.Operator] + value, ""
+}
+
+// match contains、startsWith、endsWith 的 SQL，与内存中相同区分大小写。
+// SQLite、MySQL 的 LIKE 默认不区分大小写，SQLite 使用 instr，MySQL 按二进制比较
+func (c *compiler) match(col, operator, pattern string) string {
+	if pattern == "" {
+		return col + " IS NOT NULL"
+	}
+	switch c.dialect {
+	case sqlguard.SQLite:
+		p := c.literal(pattern)
+		switch operator {
+		case opContains:
+			return "instr(" + col + ", " + p + ") > 0"
+		case opStartsWith:
+			return "instr(" + col + ", " + p + ") = 1"
+		}
+		return "substr(" + col + ", -length(" + p + ")) = " + p
+	case sqlguard.ClickHouse:
+		fn := map[string]string{opContains: "position", opStartsWith: "startsWith", opEndsWith: "endsWith"}[operator]
+		expr := fn + "(toString(" + col + "), " + c.literal(pattern) + ")"
+		if operator == opContains {
+			expr += " > 0"
+		}
+		return expr
+	}
+
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(pattern)
+	switch operator {
+	case opContains:
+		escaped = "%" + escaped + "%"
+	case opStartsWith:
+		escaped += "%"
+	default:
+		escaped = "%" + escaped
+	}
+	if c.dialect == sqlguard.MySQL {
+		return "CAST(" + col + " AS BINARY) LIKE CAST(" + c.literal(escaped) + " AS BINARY) ESCAPE '!'"
+	}
+	return "CAST(" + col + " AS TEXT) LIKE " + c.literal(escaped) + " ESCAPE '!'"
+}
+
+// expr 计算列表达式的 SQL，列引用替换为 items 中对应的表达式。
+// 方言无法与内存中得到相同结果时返回原因
+func (c *compiler) expr(e expr, items []sqlItem) (string, string) {
+	columns := make([]models.ResultColumn, len(items))
+	for i, item := range items {
+		columns[i] = item.column
+	}
+	typeOf := func(e expr) string {
+		_, t, _ := compileExpr(e, columns)
+		return t
+	}
+	var sql func(e expr) (string, string)
+	// condition 作为条件使用的表达式，PostgreSQL 中整数不能直接作为布尔值
+	condition := func(e expr) (string, string) {
+		s, reason := sql(e)
+		if reason != "" || c.dialect != sqlguard.PostgreSQL {
+			return s, reason
+		}
+		switch typeOf(e) {
+		case database.TypeBoolean:
+			return s, ""
+		case database.TypeInteger:
+			return "(" + s + " <> 0)", ""
+		}
+		return "", "operand of unknown type used as a condition"
+	}
+	sql = func(e expr) (string, string) {
+		switch e := e.(type) {
+		case *literalExpr:
+			return c.literal(e.value), ""
+		case *columnExpr:
+			for _, item := range items {
+				if item.column.Name == e.name {
+					return item.expr, ""
+				}
+			}
+			return "", fmt.Sprintf("unknown column %q", e.name)
+		case *unaryExpr:
+			if e.op == "not" {
+				x, reason := condition(e.x)
+				return "(NOT " + x + ")", reason
+			}
+			x, reason := sql(e.x)
+			return "(-" + x + ")", reason
+		case *isNullExpr:
+			x, reason := sql(e.x)
+			if e.not {
+				return "(" + x + " IS NOT NULL)", reason
+			}
+			return "(" + x + " IS NULL)", reason
+		case *binaryExpr:
+			return c.binary(e, sql, condition, typeOf)
+		case *callExpr:
+			return c.call(e, sql, condition, typeOf)
+		}
+		return "", fmt.Sprintf("unsupported expression %T", e)
+	}
+	return sql(e)
+}
+
+type sqlFunc func(e expr) (string, string)
+
+func (c *compiler) binary(e *binaryExpr, sql, condition sqlFunc, typeOf func(expr) string) (string, string) {
+	operand := sql
+	if e.op == "and" || e.op == "or" {
+		operand = condition
+	}
+	left, reason := operand(e.left)
+	if reason != "" {
+		return "", reason
+	}
+	right, reason := operand(e.right)
+	if reason != "" {
+		return "", reason
+	}
+	switch e.op {
+	case "and", "or":
+		return "(" + left + " " + strings.ToUpper(e.op) + " " + right + ")", ""
+	case "!=":
+		return "(" + left + " <> " + right + ")", ""
+	case "/":
+		// 与内存中相同，除法的结果总是浮点数，除数为 0 时为空值
+		switch c.dialect {
+		case sqlguard.PostgreSQL:
+			left = "CAST(" + left + " AS DOUBLE PRECISION)"
+		case sqlguard.SQLite:
+			left = "CAST(" + left + " AS REAL)"
+		}
+		return "(" + left + " / NULLIF(" + right + ", 0))", ""
+	case "%":
+		if typeOf(e.left) != database.TypeInteger || typeOf(e.right) != database.TypeInteger {
+			return "", "% is only pushed down for integer operands"
+		}
+		return "(" + left + " % NULLIF(" + right + ", 0))", ""
+	}
+	return "(" + left + " " + e.op + " " + right + ")", ""
+}
+
+func (c *compiler) call(e *callExpr, sql, condition sqlFunc, typeOf func(expr) string) (string, string) {
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		operand := sql
+		if e.name == "if" && i == 0 {
+			operand = condition
+		}
+		var reason string
+		if args[i], reason = operand(arg); reason != "" {
+			return "", reason
+		}
+	}
+	textOnly := func() string {
+		if typeOf(e.args[0]) != database.TypeString {
+			return fmt.Sprintf("%s is only pushed down for text arguments", e.name)
+		}
+		return ""
+	}
+	// sameTypes coalesce、if 的各分支类型不同时，PostgreSQL、ClickHouse 会报错
+	sameTypes := func(branches []expr) string {
+		if c.dialect != sqlguard.PostgreSQL && c.dialect != sqlguard.ClickHouse {
+			return ""
+		}
+		types := make([]string, len(branches))
+		known := false
+		for i, b := range branches {
+			types[i] = typeOf(b)
+			known = known || types[i] != database.TypeUnknown
+		}
+		if known && commonType(types...) == database.TypeUnknown {
+			return fmt.Sprintf("%s arguments have different types", e.name)
+		}
+		return ""
+	}
+
+	switch e.name {
+	case "abs":
+		return "ABS(" + args[0] + ")", ""
+	case "round":
+		digits := "0"
+		if len(args) == 2 {
+			digits = args[1]
+			if _, ok := e.args[1].(*literalExpr); !ok && c.dialect == sqlguard.ClickHouse {
+				return "", "round digits must be a constant in clickhouse"
+			}
+		}
+		if c.dialect == sqlguard.PostgreSQL {
+			return "CAST(ROUND(CAST(" + args[0] + " AS NUMERIC), " + digits + ") AS DOUBLE PRECISION)", ""
+		}
+		return "ROUND(" + args[0] + ", " + digits + ")", ""
+	case "floor", "ceil":
+		if c.dialect == sqlguard.SQLite {
+			return "", e.name + " is not available in sqlite"
+		}
+		return strings.ToUpper(e.name) + "(" + args[0] + ")", ""
+	case "lower", "upper", "trim", "length":
+		if reason := textOnly(); reason != "" {
+			return "", reason
+		}
+		fn := strings.ToUpper(e.name)
+		switch {
+		case c.dialect == sqlguard.ClickHouse:
+			fn = map[string]string{"lower": "lowerUTF8", "upper": "upperUTF8", "trim": "trimBoth", "length": "lengthUTF8"}[e.name]
+		case e.name == "length" && c.dialect != sqlguard.SQLite:
+			fn = "CHAR_LENGTH"
+		}
+		return fn + "(" + args[0] + ")", ""
+	case "concat":
+		// 与内存中相同，跳过空值
+		switch c.dialect {
+		case sqlguard.MySQL:
+			return "CONCAT_WS(''," + " " + strings.Join(args, ", ") + ")", ""
+		case sqlguard.PostgreSQL:
+			return "CONCAT(" + strings.Join(args, ", ") + ")", ""
+		}
+		parts := make([]string, len(args))
+		for i, arg := range args {
+			if c.dialect == sqlguard.ClickHouse {
+				parts[i] = "ifNull(toString(" + arg + "), '')"
+			} else {
+				parts[i] = "COALESCE(CAST(" + arg + " AS TEXT), '')"
+			}
+		}
+		if c.dialect == sqlguard.ClickHouse {
+			return "concat(" + strings.Join(parts, ", ") + ", '')", ""
+		}
+		return "(" + strings.Join(parts, " || ") + ")", ""
+	case "coalesce":
+		if reason := sameTypes(e.args); reason != "" {
+			return "", reason
+		}
+		return "COALESCE(" + strings.Join(args, ", ") + ")", ""
+	case "if":
+		if reason := sameTypes(e.args[1:]); reason != "" {
+			return "", reason
+		}
+		return "(CASE WHEN " + args[0] + " THEN " + args[1] + " ELSE " + args[2] + " END)", ""
+	case "year", "month", "day":
+		t := typeOf(e.args[0])
+		if c.dialect == sqlguard.SQLite {
+			format := map[string]string{"year": "%Y", "month": "%m", "day": "%d"}[e.name]
+			return "CAST(strftime('" + format + "', " + args[0] + ") AS INTEGER)", ""
+		}
+		if t != database.TypeDate && t != database.TypeDateTime {
+			return "", fmt.Sprintf("%s is only pushed down for date columns", e.name)
+		}
+		switch c.dialect {
+		case sqlguard.PostgreSQL:
+			return "CAST(EXTRACT(" + strings.ToUpper(e.name) + " FROM " + args[0] + ") AS INTEGER)", ""
+		case sqlguard.ClickHouse:
+			fn := map[string]string{"year": "toYear", "month": "toMonth", "day": "toDayOfMonth"}[e.name]
+			return fn + "(" + args[0] + ")", ""
+		}
+		return strings.ToUpper(e.name) + "(" + args[0] + ")", ""
+	}
+	return "", fmt.Sprintf("%s is not supported", e.name)
+}
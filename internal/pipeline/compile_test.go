@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/sqlguard"
+)
+
+func TestCompile(t *testing.T) {
+	schema := schemas(testTables(0))
+	aggregate := []models.Operation{
+		{Type: models.OpFilter, Conditions: []models.FilterCondition{
+			{Column: "amount", Operator: "gt", Value: 20.0},
+			{Column: "region", Operator: "in", Value: []interface{}{"east1", "west2"}},
+		}},
+		{Type: models.OpGroupBy, GroupBy: []string{"region"}, Aggregates: []models.Aggregate{{Function: "sum", Column: "amount"}, {Function: "count"}}},
+		{Type: models.OpSort, Keys: []models.SortKey{{Column: "sum_amount", Direction: "desc"}}},
+		{Type: models.OpLimit, Limit: 10},
+	}
+	fullJoin := []models.Operation{
+		{Type: models.OpLimit, Limit: 10},
+		{Type: models.OpJoin, Entity: "regions", JoinType: "full", On: []models.JoinKey{{Left: "region", Right: "region"}}},
+		{Type: models.OpCalculate, Fields: []models.CalculatedField{{Name: "double", Expression: "amount * 2"}}},
+	}
+	orders := []models.ResultColumn{
+		{Name: "id", Type: database.TypeInteger},
+		{Name: "region", Type: database.TypeString},
+		{Name: "amount", Type: database.TypeFloat},
+	}
+
+	tests := []struct {
+		name    string
+		ops     []models.Operation
+		dialect sqlguard.Dialect
+		sql     string
+		reasons []string // 各操作未下推的原因，空字符串表示已下推
+		columns []models.ResultColumn
+	}{
+		{"aggregate", aggregate, sqlguard.PostgreSQL,
+			`SELECT "region", SUM("amount") AS "sum_amount", COUNT(*) AS "count" FROM "orders" WHERE "amount" > 20 AND "region" IN ('east1', 'west2') ` +
+				`GROUP BY "region" ORDER BY SUM("amount") DESC NULLS LAST LIMIT 10`,
+			[]string{"", "", "", ""},
+			[]models.ResultColumn{
+				{Name: "region", Type: database.TypeString},
+				{Name: "sum_amount", Type: database.TypeFloat},
+				{Name: "count", Type: database.TypeInteger},
+			}},
+		{"aggregate mysql", aggregate, sqlguard.MySQL,
+			"SELECT `region`, SUM(`amount`) AS `sum_amount`, COUNT(*) AS `count` FROM `orders` WHERE `amount` > 20 AND `region` IN ('east1', 'west2') " +
+				"GROUP BY `region` ORDER BY SUM(`amount`) DESC LIMIT 10",
+			[]string{"", "", "", ""},
+			[]models.ResultColumn{
+				{Name: "region", Type: database.TypeString},
+				{Name: "sum_amount", Type: database.TypeFloat},
+				{Name: "count", Type: database.TypeInteger},
+			}},
+		{"full join", fullJoin, sqlguard.PostgreSQL,
+			`SELECT t1."id" AS "id", t1."region" AS "region", t1."amount" AS "amount", t2."region" AS "regions.region", t2."manager" AS "manager", ` +
+				`(t1."amount" * 2) AS "double" FROM (SELECT * FROM "orders" LIMIT 10) AS t1 FULL JOIN "regions" AS t2 ON t1."region" = t2."region" LIMIT 1001`,
+			[]string{"", "", ""},
+			append(append([]models.ResultColumn(nil), orders...),
+				models.ResultColumn{Name: "regions.region", Type: database.TypeString},
+				models.ResultColumn{Name: "manager", Type: database.TypeString},
+				models.ResultColumn{Name: "double", Type: database.TypeFloat})},
+		{"full join mysql", fullJoin, sqlguard.MySQL,
+			"SELECT * FROM `orders` LIMIT 10",
+			[]string{"", "mysql does not support full joins", "follows a step executed in memory"},
+			orders},
+		{"join after sort", []models.Operation{
+			{Type: models.OpSort, Keys: []models.SortKey{{Column: "id"}}},
+			{Type: models.OpJoin, Entity: "regions", On: []models.JoinKey{{Left: "region", Right: "region"}}},
+			{Type: models.OpSelect, Columns: []string{"id"}},
+		}, sqlguard.PostgreSQL,
+			`SELECT * FROM "orders" ORDER BY "id" ASC NULLS FIRST LIMIT 1001`,
+			[]string{"", "would lose the order of a previous sort", "follows a step executed in memory"},
+			orders},
+	}
+	for _, tt := range tests {
+		plan, err := Compile(&models.Pipeline{Entity: "orders", Operations: tt.ops}, tt.dialect, 1000, schema)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if plan.SQL != tt.sql {
+			t.Errorf("%s: got SQL\n%s\nwant\n%s", tt.name, plan.SQL, tt.sql)
+		}
+		pushed := 0
+		for i, step := range plan.Steps {
+			if step.Reason != tt.reasons[i] || step.PushedDown != (tt.reasons[i] == "") {
+				t.Errorf("%s: step %d: got %+v, want reason %q", tt.name, i, step, tt.reasons[i])
+			}
+			if step.PushedDown {
+				pushed++
+			}
+		}
+		if plan.Engine != EngineSQL || plan.Pushed != pushed {
+			t.Errorf("%s: got engine %s, %d pushed", tt.name, plan.Engine, plan.Pushed)
+		}
+		if !reflect.DeepEqual(plan.Columns, tt.columns) {
+			t.Errorf("%s: got columns %+v", tt.name, plan.Columns)
+		}
+	}
+}
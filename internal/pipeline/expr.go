@@ -0,0 +1,402 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 计算列表达式的语法树。表达式的语法与 SQL 相近：
+//
+//	字面量    123、1.5、'text'（'' 表示单引号）、true、false、null
+//	列        name 或 "列 名"（"" 表示双引号）
+//	运算      + - * / %，= != <> < <= > >=，and or not，x is [not] null
+//	函数      abs round floor ceil lower upper trim length concat coalesce if year month day
+//
+// 关键字和函数名不区分大小写
+type expr interface{}
+
+type literalExpr struct{ value interface{} }
+
+type columnExpr struct{ name string }
+
+type unaryExpr struct {
+	op string // - 或 not
+	x  expr
+}
+
+type binaryExpr struct {
+	op          string // 算术、比较运算符或 and、or；== 归一化为 =，<> 归一化为 !=
+	left, right expr
+}
+
+type isNullExpr struct {
+	x   expr
+	not bool
+}
+
+type callExpr struct {
+	name string // 小写的函数名
+	args []expr
+}
+
+// functionArity 各函数的参数个数范围，max 为 -1 时不限
+var functionArity = map[string][2]int{
+	"abs":      {1, 1},
+	"round":    {1, 2},
+	"floor":    {1, 1},
+	"ceil":     {1, 1},
+	"lower":    {1, 1},
+	"upper":    {1, 1},
+	"trim":     {1, 1},
+	"length":   {1, 1},
+	"concat":   {1, -1},
+	"coalesce": {1, -1},
+	"if":       {3, 3},
+	"year":     {1, 1},
+	"month":    {1, 1},
+	"day":      {1, 1},
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent       // 未加引号的标识符或关键字
+	tokQuotedIdent // 双引号中的列名
+	tokOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // 从 1 开始的字符位置
+}
+
+// tokenize 将表达式切分为词法单元
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				i++
+				if i < len(runes) && (runes[i] == '+' || runes[i] == '-') {
+					i++
+				}
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i]), pos: start + 1})
+			continue
+		case r == '_' || unicode.IsLetter(r):
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: start + 1})
+			continue
+		case r == '\'' || r == '"':
+			text, next, ok := scanQuoted(runes, i)
+			if !ok {
+				return nil, fmt.Errorf("unterminated quote at %d", start+1)
+			}
+			kind := tokString
+			if r == '"' {
+				kind = tokQuotedIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: text, pos: start + 1})
+			i = next
+			continue
+		}
+
+		op := string(r)
+		if i+1 < len(runes) {
+			switch two := string(runes[i : i+2]); two {
+			case "==", "!=", "<>", "<=", ">=":
+				op = two
+			}
+		}
+		if !strings.Contains("+-*/%(),=<>", op) && len(op) == 1 {
+			return nil, fmt.Errorf("unexpected character %q at %d", r, start+1)
+		}
+		tokens = append(tokens, token{kind: tokOperator, text: op, pos: start + 1})
+		i += len([]rune(op))
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(runes) + 1}), nil
+}
+
+// scanQuoted 读取从 runes[i] 开始的引号内容，连续两个引号表示引号本身
+func scanQuoted(runes []rune, i int) (string, int, bool) {
+	quote := runes[i]
+	var b strings.Builder
+	for i++; i < len(runes); i++ {
+		if runes[i] != quote {
+			b.WriteRune(runes[i])
+			continue
+		}
+		if i+1 < len(runes) && runes[i+1] == quote {
+			b.WriteRune(quote)
+			i++
+			continue
+		}
+		return b.String(), i + 1, true
+	}
+	return "", i, false
+}
+
+// parseExpr 解析计算列表达式
+func parseExpr(src string) (expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.unexpected(t)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword 下一个单元是指定的关键字时读取并返回 true
+func (p *exprParser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// operator 下一个单元是给出的运算符之一时读取并返回它
+func (p *exprParser) operator(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) unexpected(t token) error {
+	if t.kind == tokEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.keyword("or") {
+		var right expr
+		if right, err = p.parseAnd(); err == nil {
+			left = &binaryExpr{op: "or", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.keyword("and") {
+		var right expr
+		if right, err = p.parseNot(); err == nil {
+			left = &binaryExpr{op: "and", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseNot() (expr, error) {
+	if p.keyword("not") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "not", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if p.keyword("is") {
+		not := p.keyword("not")
+		if !p.keyword("null") {
+			return nil, p.unexpected(p.peek())
+		}
+		return &isNullExpr{x: left, not: not}, nil
+	}
+	op, ok := p.operator("=", "==", "!=", "<>", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "==":
+		op = "="
+	case "<>":
+		op = "!="
+	}
+	return &binaryExpr{op: op, left: left, right: right}, nil
+}
+
+func (p *exprParser) parseAdditive() (expr, error) {
+	left, err := p.parseMultiplicative()
+	for err == nil {
+		op, ok := p.operator("+", "-")
+		if !ok {
+			break
+		}
+		var right expr
+		if right, err = p.parseMultiplicative(); err == nil {
+			left = &binaryExpr{op: op, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseMultiplicative() (expr, error) {
+	left, err := p.parseUnary()
+	for err == nil {
+		op, ok := p.operator("*", "/", "%")
+		if !ok {
+			break
+		}
+		var right expr
+		if right, err = p.parseUnary(); err == nil {
+			left = &binaryExpr{op: op, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if _, ok := p.operator("-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "-", x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literalExpr{value: n}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return &literalExpr{value: f}, nil
+	case tokString:
+		return &literalExpr{value: t.text}, nil
+	case tokQuotedIdent:
+		return &columnExpr{name: t.text}, nil
+	case tokIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return &literalExpr{value: true}, nil
+		case "false":
+			return &literalExpr{value: false}, nil
+		case "null":
+			return &literalExpr{value: nil}, nil
+		case "and", "or", "not", "is":
+			return nil, p.unexpected(t)
+		}
+		if _, ok := p.operator("("); ok {
+			return p.parseCall(t)
+		}
+		return &columnExpr{name: t.text}, nil
+	case tokOperator:
+		if t.text == "(" {
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.operator(")"); !ok {
+				return nil, p.unexpected(p.peek())
+			}
+			return e, nil
+		}
+	}
+	return nil, p.unexpected(t)
+}
+
+// parseCall 读取函数参数，左括号已读取
+func (p *exprParser) parseCall(name token) (expr, error) {
+	fn := strings.ToLower(name.text)
+	arity, ok := functionArity[fn]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	call := &callExpr{name: fn}
+	if _, ok := p.operator(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if _, ok := p.operator(","); ok {
+				continue
+			}
+			if _, ok := p.operator(")"); ok {
+				break
+			}
+			return nil, p.unexpected(p.peek())
+		}
+	}
+	if len(call.args) < arity[0] || (arity[1] >= 0 && len(call.args) > arity[1]) {
+		return nil, fmt.Errorf("wrong number of arguments to %s at %d", fn, name.pos)
+	}
+	return call, nil
+}
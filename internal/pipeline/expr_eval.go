@@ -0,0 +1,386 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// evaluator 在一行上计算表达式的值。与 SQL 相同，运算数为空值时结果为空值，
+// 除数为 0 或值无法运算时结果同样为空值
+type evaluator func(row []interface{}) interface{}
+
+// compileExpr 按输入列检查表达式的列引用和运算数类型，返回求值函数和结果的归一化类型
+func compileExpr(e expr, columns []models.ResultColumn) (evaluator, string, error) {
+	switch e := e.(type) {
+	case *literalExpr:
+		v := e.value
+		return func([]interface{}) interface{} { return v }, literalType(v), nil
+	case *columnExpr:
+		j, ok := columnIndex(columns, e.name)
+		if !ok {
+			return nil, "", fmt.Errorf("unknown column %q", e.name)
+		}
+		return func(row []interface{}) interface{} { return row[j] }, columns[j].Type, nil
+	case *unaryExpr:
+		x, t, err := compileExpr(e.x, columns)
+		if err != nil {
+			return nil, "", err
+		}
+		if e.op == "not" {
+			if !booleanType(t) {
+				return nil, "", fmt.Errorf("not requires a boolean operand, got %s", t)
+			}
+			return func(row []interface{}) interface{} {
+				b, ok := toBool(x(row))
+				if !ok {
+					return nil
+				}
+				return !b
+			}, database.TypeBoolean, nil
+		}
+		if !numericType(t) {
+			return nil, "", fmt.Errorf("unary - requires a numeric operand, got %s", t)
+		}
+		return func(row []interface{}) interface{} { return arithmetic("-", int64(0), x(row)) }, t, nil
+	case *isNullExpr:
+		x, _, err := compileExpr(e.x, columns)
+		if err != nil {
+			return nil, "", err
+		}
+		not := e.not
+		return func(row []interface{}) interface{} { return (x(row) == nil) != not }, database.TypeBoolean, nil
+	case *binaryExpr:
+		return compileBinary(e, columns)
+	case *callExpr:
+		return compileCall(e, columns)
+	}
+	return nil, "", fmt.Errorf("unsupported expression %T", e)
+}
+
+func compileBinary(e *binaryExpr, columns []models.ResultColumn) (evaluator, string, error) {
+	left, lt, err := compileExpr(e.left, columns)
+	if err != nil {
+		return nil, "", err
+	}
+	right, rt, err := compileExpr(e.right, columns)
+	if err != nil {
+		return nil, "", err
+	}
+	op := e.op
+	switch op {
+	case "and", "or":
+		if !booleanType(lt) || !booleanType(rt) {
+			return nil, "", fmt.Errorf("%s requires boolean operands, got %s and %s", op, lt, rt)
+		}
+		return func(row []interface{}) interface{} { return logical(op, left(row), right(row)) }, database.TypeBoolean, nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		return func(row []interface{}) interface{} {
+			a, b := left(row), right(row)
+			if a == nil || b == nil {
+				return nil
+			}
+			c, ok := compareValues(a, b)
+			if !ok {
+				return nil
+			}
+			return compareResult(op, c)
+		}, database.TypeBoolean, nil
+	}
+
+	if !numericType(lt) || !numericType(rt) {
+		return nil, "", fmt.Errorf("operator %s requires numeric operands, got %s and %s", op, lt, rt)
+	}
+	t := database.TypeFloat
+	switch {
+	case op == "/":
+	case lt == database.TypeInteger && rt == database.TypeInteger:
+		t = database.TypeInteger
+	case lt == database.TypeUnknown || rt == database.TypeUnknown:
+		t = database.TypeUnknown
+	}
+	return func(row []interface{}) interface{} { return arithmetic(op, left(row), right(row)) }, t, nil
+}
+
+func compileCall(e *callExpr, columns []models.ResultColumn) (evaluator, string, error) {
+	args := make([]evaluator, len(e.args))
+	types := make([]string, len(e.args))
+	for i, arg := range e.args {
+		var err error
+		if args[i], types[i], err = compileExpr(arg, columns); err != nil {
+			return nil, "", err
+		}
+	}
+	requireType := func(check func(string) bool, what string, indexes ...int) error {
+		for _, i := range indexes {
+			if !check(types[i]) {
+				return fmt.Errorf("%s requires %s arguments, got %s", e.name, what, types[i])
+			}
+		}
+		return nil
+	}
+
+	switch e.name {
+	case "abs", "round", "floor", "ceil":
+		indexes := []int{0}
+		if len(args) == 2 {
+			indexes = append(indexes, 1)
+		}
+		if err := requireType(numericType, "numeric", indexes...); err != nil {
+			return nil, "", err
+		}
+		name := e.name
+		t := database.TypeFloat
+		if name == "abs" {
+			t = types[0]
+		}
+		return func(row []interface{}) interface{} {
+			v := args[0](row)
+			if v == nil {
+				return nil
+			}
+			digits := interface{}(int64(0))
+			if len(args) == 2 {
+				digits = args[1](row)
+			}
+			return mathFunction(name, v, digits)
+		}, t, nil
+	case "lower", "upper", "trim", "length":
+		name := e.name
+		t := database.TypeString
+		if name == "length" {
+			t = database.TypeInteger
+		}
+		return func(row []interface{}) interface{} {
+			v := args[0](row)
+			if v == nil {
+				return nil
+			}
+			s := toString(v)
+			switch name {
+			case "lower":
+				return strings.ToLower(s)
+			case "upper":
+				return strings.ToUpper(s)
+			case "trim":
+				return strings.TrimSpace(s)
+			}
+			return int64(utf8.RuneCountInString(s))
+		}, t, nil
+	case "concat":
+		return func(row []interface{}) interface{} {
+			var b strings.Builder
+			for _, arg := range args {
+				if v := arg(row); v != nil {
+					b.WriteString(toString(v))
+				}
+			}
+			return b.String()
+		}, database.TypeString, nil
+	case "coalesce":
+		return func(row []interface{}) interface{} {
+			for _, arg := range args {
+				if v := arg(row); v != nil {
+					return v
+				}
+			}
+			return nil
+		}, commonType(types...), nil
+	case "if":
+		if err := requireType(booleanType, "a boolean condition as", 0); err != nil {
+			return nil, "", err
+		}
+		return func(row []interface{}) interface{} {
+			if b, ok := toBool(args[0](row)); ok && b {
+				return args[1](row)
+			}
+			return args[2](row)
+		}, commonType(types[1:]...), nil
+	case "year", "month", "day":
+		if err := requireType(temporalType, "date", 0); err != nil {
+			return nil, "", err
+		}
+		name := e.name
+		return func(row []interface{}) interface{} {
+			t, ok := asTime(args[0](row))
+			if !ok {
+				return nil
+			}
+			switch name {
+			case "year":
+				return int64(t.Year())
+			case "month":
+				return int64(t.Month())
+			}
+			return int64(t.Day())
+		}, database.TypeInteger, nil
+	}
+	return nil, "", fmt.Errorf("unknown function %q", e.name)
+}
+
+func literalType(v interface{}) string {
+	switch v.(type) {
+	case int64:
+		return database.TypeInteger
+	case float64:
+		return database.TypeFloat
+	case string:
+		return database.TypeString
+	case bool:
+		return database.TypeBoolean
+	}
+	return database.TypeUnknown
+}
+
+// booleanType 可以作为条件的类型，整数按非 0 为真处理（MySQL、SQLite 的布尔值即整数）
+func booleanType(t string) bool {
+	return t == database.TypeBoolean || t == database.TypeInteger || t == database.TypeUnknown
+}
+
+// temporalType 可以取年月日的类型，字符串在执行时按日期时间解析
+func temporalType(t string) bool {
+	switch t {
+	case database.TypeDate, database.TypeDateTime, database.TypeString, database.TypeUnknown:
+		return true
+	}
+	return false
+}
+
+// commonType 多个分支的结果类型，忽略 null 字面量，类型不一致时为 unknown
+func commonType(types ...string) string {
+	result := ""
+	for _, t := range types {
+		switch {
+		case t == database.TypeUnknown:
+			continue
+		case result == "":
+			result = t
+		case result != t:
+			return database.TypeUnknown
+		}
+	}
+	if result == "" {
+		return database.TypeUnknown
+	}
+	return result
+}
+
+// toBool 条件的真假，空值或无法判断时 ok 为 false
+func toBool(v interface{}) (bool, bool) {
+	if b, ok := v.(bool); ok {
+		return b, true
+	}
+	if f, ok := toFloat64(v); ok && isNumber(v) {
+		return f != 0, true
+	}
+	return false, false
+}
+
+// logical and、or 的三值逻辑：结果可以由一侧确定时忽略另一侧的空值
+func logical(op string, a, b interface{}) interface{} {
+	x, xok := toBool(a)
+	y, yok := toBool(b)
+	decisive := op == "or" // or 中任一侧为真、and 中任一侧为假即可确定结果
+	if (xok && x == decisive) || (yok && y == decisive) {
+		return decisive
+	}
+	if !xok || !yok {
+		return nil
+	}
+	return !decisive
+}
+
+func compareResult(op string, c int) bool {
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// arithmetic 整数之间的 + - * % 保持整数，其他情况按浮点数计算，/ 的结果总是浮点数
+func arithmetic(op string, a, b interface{}) interface{} {
+	if a == nil || b == nil {
+		return nil
+	}
+	if x, ok := toInt64(a); ok && op != "/" {
+		if y, ok := toInt64(b); ok {
+			switch op {
+			case "+":
+				return x + y
+			case "-":
+				return x - y
+			case "*":
+				return x * y
+			}
+			if y == 0 {
+				return nil
+			}
+			return x % y
+		}
+	}
+	x, ok1 := toFloat64(a)
+	y, ok2 := toFloat64(b)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	switch op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	}
+	if y == 0 {
+		return nil
+	}
+	if op == "%" {
+		return math.Mod(x, y)
+	}
+	return x / y
+}
+
+// mathFunction abs 保持整数，round、floor、ceil 的结果为浮点数；round 的 digits 为保留的小数位数
+func mathFunction(name string, v, digits interface{}) interface{} {
+	if n, ok := toInt64(v); ok && name == "abs" {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	f, ok := toFloat64(v)
+	if !ok {
+		return nil
+	}
+	switch name {
+	case "abs":
+		return math.Abs(f)
+	case "floor":
+		return math.Floor(f)
+	case "ceil":
+		return math.Ceil(f)
+	}
+	d, ok := toInt64(digits)
+	if !ok {
+		if df, fok := toFloat64(digits); fok {
+			d = int64(df)
+		} else {
+			return nil
+		}
+	}
+	scale := math.Pow(10, float64(d))
+	return math.Round(f*scale) / scale
+}
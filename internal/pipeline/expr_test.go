@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// exprColumns 表达式测试中一行的列和值
+var exprColumns = []models.ResultColumn{
+	{Name: "i", Type: database.TypeInteger},
+	{Name: "f", Type: database.TypeFloat},
+	{Name: "s", Type: database.TypeString},
+	{Name: "n", Type: database.TypeFloat},
+	{Name: "d", Type: database.TypeDate},
+	{Name: "ds", Type: database.TypeString},
+	{Name: "zero", Type: database.TypeInteger},
+	{Name: "col name", Type: database.TypeInteger},
+}
+
+var exprRow = []interface{}{int64(7), 2.5, " Ab ", nil, time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), "2024-03-05", int64(0), int64(3)}
+
+func TestEvaluateExpr(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+		typ  string
+	}{
+		{"i + 1", int64(8), database.TypeInteger},
+		{"i * f", 17.5, database.TypeFloat},
+		{"i / 2", 3.5, database.TypeFloat},
+		{"i % 4", int64(3), database.TypeInteger},
+		{"i / zero", nil, database.TypeFloat},
+		{"i % zero", nil, database.TypeInteger},
+		{"-i + 2 * 3", int64(-1), database.TypeInteger},
+		{"(i + 1) * 2", int64(16), database.TypeInteger},
+		{"n + 1", nil, database.TypeFloat},
+		{`"col name" * 2`, int64(6), database.TypeInteger},
+		{"'it''s'", "it's", database.TypeString},
+		{"i >= f AND s IS NOT NULL", true, database.TypeBoolean},
+		{"i <> 7", false, database.TypeBoolean},
+		{"i == 7.0", true, database.TypeBoolean},
+		{"n > 1", nil, database.TypeBoolean},
+		{"n > 1 or i = 7", true, database.TypeBoolean},
+		{"n > 1 and i = 7", nil, database.TypeBoolean},
+		{"n > 1 and i = 8", false, database.TypeBoolean},
+		{"not (i = 7)", false, database.TypeBoolean},
+		{"not zero", true, database.TypeBoolean},
+		{"n is null", true, database.TypeBoolean},
+		{"null is not null", false, database.TypeBoolean},
+		{"upper(trim(s))", "AB", database.TypeString},
+		{"LOWER(s)", " ab ", database.TypeString},
+		{"length(s)", int64(4), database.TypeInteger},
+		{"length(n)", nil, database.TypeInteger},
+		{"concat(s, n, i)", " Ab 7", database.TypeString},
+		{"coalesce(n, f)", 2.5, database.TypeFloat},
+		{"coalesce(n, null)", nil, database.TypeFloat},
+		{"coalesce(i, s)", int64(7), database.TypeUnknown},
+		{"if(i > 5, 'big', 'small')", "big", database.TypeString},
+		{"if(n > 5, 'big', 'small')", "small", database.TypeString},
+		{"abs(-i)", int64(7), database.TypeInteger},
+		{"abs(-f)", 2.5, database.TypeFloat},
+		{"round(f)", 3.0, database.TypeFloat},
+		{"round(1234.5678, -2)", 1200.0, database.TypeFloat},
+		{"round(1.25, 1)", 1.3, database.TypeFloat},
+		{"floor(-f)", -3.0, database.TypeFloat},
+		{"ceil(f)", 3.0, database.TypeFloat},
+		{"year(d)", int64(2024), database.TypeInteger},
+		{"month(ds)", int64(3), database.TypeInteger},
+		{"day(s)", nil, database.TypeInteger},
+	}
+	for _, tt := range tests {
+		e, err := parseExpr(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		eval, typ, err := compileExpr(e, exprColumns)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got := eval(exprRow); !reflect.DeepEqual(got, tt.want) || typ != tt.typ {
+			t.Errorf("%s: got %#v (%s), want %#v (%s)", tt.src, got, typ, tt.want, tt.typ)
+		}
+	}
+}
+
+func TestInvalidExpr(t *testing.T) {
+	for _, src := range []string{
+		"", "i +", "(i + 1", "i 1", "'unterminated", `"unterminated`, "i # 2", "f(1)", "abs()", "if(i > 1, 2)",
+		"missing + 1", "s + 1", "-s", "not s", "i and s", "abs(s)", "round(f, s)", "year(i)",
+	} {
+		e, err := parseExpr(src)
+		if err == nil {
+			_, _, err = compileExpr(e, exprColumns)
+		}
+		if err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/sqlguard"
+)
+
+// Federation 跨数据源执行处理操作时读取各数据源的方式
+type Federation struct {
+	// Run 在 p.DataSourceID 上执行处理操作并返回结果，p 的操作中仍可能有跨数据源的 join、union
+	Run func(p *models.Pipeline) (Rows, error)
+	// Columns 检查在 p.DataSourceID 上执行的处理操作并返回结果的列，不读取数据
+	Columns func(p *models.Pipeline) ([]models.ResultColumn, error)
+	// MaxRows 从每个输入最多读取的行数，0 表示不限制。join、union 的 MaxRows 可以进一步限制其另一侧
+	MaxRows int
+}
+
+// Federated p 的操作中是否有 join、union 的另一侧位于 p.DataSourceID 以外的数据源，包括嵌套的操作
+func Federated(p *models.Pipeline) bool {
+	return firstRemote(p.DataSourceID, p.Operations) >= 0
+}
+
+// DataSources p 用到的全部数据源，第一个为 p.DataSourceID
+func DataSources(p *models.Pipeline) []uint {
+	ids := []uint{p.DataSourceID}
+	var walk func(dataSourceID uint, ops []models.Operation)
+	walk = func(dataSourceID uint, ops []models.Operation) {
+		for i := range ops {
+			if ops[i].Type != models.OpJoin && ops[i].Type != models.OpUnion {
+				continue
+			}
+			side := sideSource(dataSourceID, &ops[i])
+			if !containsID(ids, side) {
+				ids = append(ids, side)
+			}
+			walk(side, ops[i].Operations)
+		}
+	}
+	walk(p.DataSourceID, p.Operations)
+	return ids
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// firstRemote 第一个涉及 dataSourceID 以外数据源的操作的下标，没有时为 -1
+func firstRemote(dataSourceID uint, ops []models.Operation) int {
+	for i := range ops {
+		op := &ops[i]
+		if op.Type != models.OpJoin && op.Type != models.OpUnion {
+			continue
+		}
+		side := sideSource(dataSourceID, op)
+		if side != dataSourceID || firstRemote(side, op.Operations) >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// sideSource join、union 的另一侧所在的数据源
+func sideSource(dataSourceID uint, op *models.Operation) uint {
+	if op.DataSourceID != 0 {
+		return op.DataSourceID
+	}
+	return dataSourceID
+}
+
+// federation 跨数据源执行的计划。第一个跨数据源的操作之前的操作作为主输入在 p.DataSourceID 上执行，
+// 之后每个 join、union 的另一侧作为独立的输入在各自的数据源上执行，都可以下推到数据源；其余操作由列式引擎执行。
+// 第一个跨数据源的操作为 join 时，紧随其后的 filter 中只涉及一侧的条件移到该侧的输入，
+// 之后的 select 没有用到的列不再从输入读取
+type federation struct {
+	first  int
+	main   *models.Pipeline
+	inputs map[int]*models.Pipeline // join、union 操作的下标到其另一侧的输入
+	// filters 有条件移到输入中的 filter 操作剩余的条件，全部移走时为空
+	filters map[int][]models.FilterCondition
+}
+
+func planFederation(p *models.Pipeline, columns func(*models.Pipeline) ([]models.ResultColumn, error)) (*federation, error) {
+	first := firstRemote(p.DataSourceID, p.Operations)
+	if first < 0 {
+		return nil, fmt.Errorf("%w: pipeline does not read other datasources", ErrInvalidPipeline)
+	}
+	f := &federation{
+		first:   first,
+		main:    &models.Pipeline{DataSourceID: p.DataSourceID, Entity: p.Entity, Operations: append([]models.Operation{}, p.Operations[:first]...)},
+		inputs:  make(map[int]*models.Pipeline),
+		filters: make(map[int][]models.FilterCondition),
+	}
+	for i := first; i < len(p.Operations); i++ {
+		op := &p.Operations[i]
+		if op.Type == models.OpJoin || op.Type == models.OpUnion {
+			f.inputs[i] = &models.Pipeline{DataSourceID: sideSource(p.DataSourceID, op), Entity: op.Entity,
+				Operations: append([]models.Operation{}, op.Operations...)}
+		}
+	}
+	if columns != nil && p.Operations[first].Type == models.OpJoin {
+		if err := f.pushDown(p.Operations, columns); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// 连接结果中的列来自哪一侧
+const (
+	sideNone = iota
+	sideLeft
+	sideRight
+)
+
+// pushDown 将第一个跨数据源的 join 之后只涉及一侧的过滤条件和不需要的列下推到两侧的输入。
+// 左侧的条件在 full 连接中、右侧的条件在外连接中会改变未匹配的行，不下推
+func (f *federation) pushDown(ops []models.Operation, columns func(*models.Pipeline) ([]models.ResultColumn, error)) error {
+	op := &ops[f.first]
+	right := f.inputs[f.first]
+	lc, err := columns(f.main)
+	if err != nil {
+		return err
+	}
+	rc, err := columns(right)
+	if err != nil {
+		return nestedError(f.first, err)
+	}
+	layout, err := newJoinLayout(f.first, lc, rc, op)
+	if err != nil {
+		return err
+	}
+	resolve := func(name string) (int, string) {
+		c, ok := columnIndex(layout.columns, name)
+		switch {
+		case !ok:
+			return sideNone, ""
+		case c < len(lc):
+			return sideLeft, lc[c].Name
+		}
+		return sideRight, rc[c-len(lc)].Name
+	}
+
+	var leftConds, rightConds []models.FilterCondition
+	next := f.first + 1
+	for ; next < len(ops) && ops[next].Type == models.OpFilter; next++ {
+		filter := &ops[next]
+		if filter.Match == "any" && len(filter.Conditions) > 1 {
+			break
+		}
+		var rest []models.FilterCondition
+		for _, cond := range filter.Conditions {
+			side, name := resolve(cond.Column)
+			switch {
+			case side == sideLeft && layout.kind != joinFull:
+				cond.Column = name
+				leftConds = append(leftConds, cond)
+			case side == sideRight && layout.kind == joinInner:
+				cond.Column = name
+				rightConds = append(rightConds, cond)
+			default:
+				rest = append(rest, cond)
+			}
+		}
+		if len(rest) < len(filter.Conditions) {
+			f.filters[next] = rest
+		}
+	}
+	if len(leftConds) > 0 {
+		f.main.Operations = append(f.main.Operations, models.Operation{Type: models.OpFilter, Conditions: leftConds})
+	}
+	if len(rightConds) > 0 {
+		right.Operations = append(right.Operations, models.Operation{Type: models.OpFilter, Conditions: rightConds})
+	}
+
+	needed, ok := f.neededColumns(ops, next)
+	if !ok {
+		return nil
+	}
+	// 左侧与右侧同名的列决定右侧列的输出名，始终保留
+	var leftKeep []string
+	for i, c := range lc {
+		_, clash := columnIndex(rc, c.Name)
+		if needed[c.Name] || clash || isJoinKey(layout.leftKeys, i) {
+			leftKeep = append(leftKeep, c.Name)
+		}
+	}
+	if len(leftKeep) < len(lc) {
+		f.main.Operations = append(f.main.Operations, models.Operation{Type: models.OpSelect, Columns: leftKeep})
+	}
+	var rightKeep []string
+	for i, c := range rc {
+		if strings.Contains(c.Name, ".") {
+			// 输出名可能与其他列的 别名.列名 冲突，不裁剪右侧
+			return nil
+		}
+		if needed[layout.columns[len(lc)+i].Name] || isJoinKey(layout.rightKeys, i) {
+			rightKeep = append(rightKeep, c.Name)
+		}
+	}
+	if len(rightKeep) < len(rc) {
+		right.Operations = append(right.Operations, models.Operation{Type: models.OpSelect, Columns: rightKeep})
+	}
+	return nil
+}
+
+// neededColumns 从第 from 个操作起到下一个 select 为止用到的列。
+// 途中只有 filter、sort、limit 时 ok 为 true，否则无法确定用到的列
+func (f *federation) neededColumns(ops []models.Operation, from int) (map[string]bool, bool) {
+	needed := make(map[string]bool)
+	for i := from; i < len(ops); i++ {
+		op := &ops[i]
+		switch op.Type {
+		case models.OpFilter:
+			conditions := op.Conditions
+			if rest, ok := f.filters[i]; ok {
+				conditions = rest
+			}
+			for _, cond := range conditions {
+				needed[cond.Column] = true
+			}
+		case models.OpSort:
+			for _, key := range op.Keys {
+				needed[key.Column] = true
+			}
+		case models.OpLimit:
+		case models.OpSelect:
+			for _, name := range op.Columns {
+				needed[name] = true
+			}
+			return needed, true
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func isJoinKey(keys []joinKeyColumn, col int) bool {
+	for _, k := range keys {
+		if k.index == col {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecuteFederated 执行涉及多个数据源的处理操作，返回结果的行迭代器，需由调用方关闭。
+// 主输入和各 join、union 的另一侧由 fed.Run 在各自的数据源上执行，其余操作与 ExecuteColumnar 相同由列式引擎执行。
+// 操作应已通过 CheckFederated。与 ExecuteColumnar 相同检查 ctx
+func ExecuteFederated(ctx context.Context, p *models.Pipeline, fed Federation, opts ColumnarOptions) (Rows, error) {
+	if err := Validate(p); err != nil {
+		return nil, err
+	}
+	f, err := planFederation(p, fed.Columns)
+	if err != nil {
+		return nil, err
+	}
+	x := &columnarExec{ctx: ctx, mem: newMemory(opts), fed: &fed, federation: f}
+	src, err := x.runInput(f.main, 0)
+	if err != nil {
+		return nil, err
+	}
+	out, err := x.apply(&rowBatches{rows: src}, p.Operations, f.first)
+	if err != nil {
+		x.mem.cleanup()
+		return nil, err
+	}
+	return newBatchRows(out, x.mem.cleanup), nil
+}
+
+// CheckFederated 与 Check 相同，检查涉及多个数据源的处理操作并返回结果的列。columns 检查在单个数据源上执行的操作
+func CheckFederated(p *models.Pipeline, columns func(*models.Pipeline) ([]models.ResultColumn, error)) ([]models.ResultColumn, error) {
+	// 只读取结果的列，不执行操作
+	rows, err := ExecuteFederated(context.Background(), p, Federation{Run: func(sub *models.Pipeline) (Rows, error) {
+		c, err := columns(sub)
+		if err != nil {
+			return nil, err
+		}
+		return &emptyRows{columns: c}, nil
+	}}, ColumnarOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns(), nil
+}
+
+// ExplainFederated 涉及多个数据源的处理操作的执行计划。Inputs 中为主输入和各 join、union 的另一侧在各自数据源上的计划，
+// explain 返回在单个数据源上执行的操作的计划
+func ExplainFederated(p *models.Pipeline, fed Federation, explain func(*models.Pipeline) (*Plan, error)) (*Plan, error) {
+	if err := Validate(p); err != nil {
+		return nil, err
+	}
+	f, err := planFederation(p, fed.Columns)
+	if err != nil {
+		return nil, err
+	}
+	mainPlan, err := explain(f.main)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Engine: EngineFederated, Steps: make([]PlanStep, len(p.Operations)),
+		Inputs: []PlanInput{{Index: -1, Pipeline: f.main, Plan: mainPlan}}}
+	copy(plan.Steps, mainPlan.Steps[:f.first])
+	for i := f.first; i < len(p.Operations); i++ {
+		step := PlanStep{Index: i, Type: p.Operations[i].Type}
+		rest, filtered := f.filters[i]
+		switch {
+		case f.inputs[i] != nil:
+			step.Reason = "reads its entity as a separate input, combined by the columnar engine"
+		case filtered && len(rest) == 0:
+			step.PushedDown = true
+		case filtered:
+			step.Reason = "some conditions pushed to the join inputs, the rest executed by the columnar engine"
+		default:
+			step.Reason = "follows a cross-datasource step, executed by the columnar engine"
+		}
+		plan.Steps[i] = step
+	}
+
+	indexes := make([]int, 0, len(f.inputs))
+	for i := range f.inputs {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		sub, err := explain(f.inputs[i])
+		if err != nil {
+			return nil, nestedError(i, err)
+		}
+		plan.Inputs = append(plan.Inputs, PlanInput{Index: i, Pipeline: f.inputs[i], Plan: sub})
+	}
+	return plan, nil
+}
+
+// runInput 执行一个输入，读取的行超过 fed.MaxRows 或 maxRows 时报错
+func (x *columnarExec) runInput(sub *models.Pipeline, maxRows int) (Rows, error) {
+	if x.fed.MaxRows > 0 && (maxRows <= 0 || maxRows > x.fed.MaxRows) {
+		maxRows = x.fed.MaxRows
+	}
+	rows, err := x.fed.Run(sub)
+	if err != nil || maxRows <= 0 {
+		return rows, err
+	}
+	return &limitedRows{Rows: rows, limit: maxRows, entity: sub.Entity, dataSourceID: sub.DataSourceID}, nil
+}
+
+// remoteInput 执行第 index 个 join、union 操作的另一侧
+func (x *columnarExec) remoteInput(index int, op *models.Operation) (batchReader, error) {
+	rows, err := x.runInput(x.federation.inputs[index], op.MaxRows)
+	if errors.Is(err, database.ErrEntityNotFound) {
+		return nil, opError(index, "unknown entity %q", op.Entity)
+	}
+	if err != nil {
+		return nil, nestedError(index, err)
+	}
+	return &rowBatches{rows: rows}, nil
+}
+
+// limitedRows 跨数据源执行时从一个输入读取的行超过 limit 时报错，而不是截断
+type limitedRows struct {
+	Rows
+	limit        int
+	entity       string
+	dataSourceID uint
+	count        int
+	err          error
+}
+
+func (r *limitedRows) Next() bool {
+	if r.err != nil || !r.Rows.Next() {
+		return false
+	}
+	r.count++
+	if r.count > r.limit {
+		r.err = fmt.Errorf("%w: cross-datasource processing reads at most %d rows from %s of datasource %d",
+			sqlguard.ErrUnsafeQuery, r.limit, r.entity, r.dataSourceID)
+		return false
+	}
+	return true
+}
+
+func (r *limitedRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.Rows.Err()
+}
@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/sqlguard"
+)
+
+// testFederation 订单表位于数据源 1，地区表位于数据源 2，记录各数据源上执行的操作
+type testFederation struct {
+	sources map[uint]map[string]testTable
+
+	mu  sync.Mutex
+	ran []*models.Pipeline
+}
+
+func newTestFederation(orders int) *testFederation {
+	all := testTables(orders)
+	return &testFederation{sources: map[uint]map[string]testTable{
+		1: {"orders": all["orders"]},
+		2: {"regions": all["regions"]},
+	}}
+}
+
+func (f *testFederation) federation(maxRows int) Federation {
+	return Federation{
+		Run: func(p *models.Pipeline) (Rows, error) {
+			f.mu.Lock()
+			f.ran = append(f.ran, p)
+			f.mu.Unlock()
+			return Execute(p, tables(f.sources[p.DataSourceID]))
+		},
+		Columns: func(p *models.Pipeline) ([]models.ResultColumn, error) {
+			return Check(p, schemas(f.sources[p.DataSourceID]))
+		},
+		MaxRows: maxRows,
+	}
+}
+
+// federatedPipeline 连接另一数据源的地区表，之后的过滤条件和列可以下推到两侧
+func federatedPipeline() *models.Pipeline {
+	return &models.Pipeline{DataSourceID: 1, Entity: "orders", Operations: []models.Operation{
+		{Type: models.OpJoin, Entity: "regions", DataSourceID: 2, On: []models.JoinKey{{Left: "region", Right: "region"}}},
+		{Type: models.OpFilter, Conditions: []models.FilterCondition{
+			{Column: "amount", Operator: "gt", Value: 10.0},
+			{Column: "manager", Operator: "in", Value: []interface{}{"m0", "m9"}},
+		}},
+		{Type: models.OpSelect, Columns: []string{"id", "manager"}},
+		{Type: models.OpSort, Keys: []models.SortKey{{Column: "id", Direction: "desc"}}},
+	}}
+}
+
+func TestExecuteFederated(t *testing.T) {
+	f := newTestFederation(10000)
+	p := federatedPipeline()
+	rows, err := Execute(p, tables(testTables(10000)))
+	want := collect(t, rows, err)
+
+	rows, err = ExecuteFederated(context.Background(), p, f.federation(0), ColumnarOptions{})
+	if got := collect(t, rows, err); len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %d rows, want %d rows", len(got), len(want))
+	}
+
+	// 过滤条件下推到各自的一侧，左侧只读取需要的列
+	wantRan := []*models.Pipeline{
+		{DataSourceID: 1, Entity: "orders", Operations: []models.Operation{
+			{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "amount", Operator: "gt", Value: 10.0}}},
+			{Type: models.OpSelect, Columns: []string{"id", "region"}},
+		}},
+		{DataSourceID: 2, Entity: "regions", Operations: []models.Operation{
+			{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "manager", Operator: "in", Value: []interface{}{"m0", "m9"}}}},
+		}},
+	}
+	if !reflect.DeepEqual(f.ran, wantRan) {
+		t.Errorf("got inputs %+v", f.ran)
+	}
+
+	columns, err := CheckFederated(p, f.federation(0).Columns)
+	if err != nil || !reflect.DeepEqual(columns, rows.Columns()) {
+		t.Errorf("got columns %+v, %v", columns, err)
+	}
+}
+
+func TestExecuteFederatedMaxRows(t *testing.T) {
+	f := newTestFederation(1000)
+	p := federatedPipeline()
+	tests := []struct {
+		name    string
+		maxRows int // Federation.MaxRows
+		sideMax int // join 操作的 MaxRows
+		ok      bool
+	}{
+		{"within limits", 1000, 16, true},
+		// 下推的过滤条件之后左侧约 900 行，右侧 2 行
+		{"main input", 500, 0, false},
+		{"join side", 0, 1, false},
+		{"join side within limit", 2000, 2, true},
+	}
+	for _, tt := range tests {
+		p.Operations[0].MaxRows = tt.sideMax
+		rows, err := ExecuteFederated(context.Background(), p, f.federation(tt.maxRows), ColumnarOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, sqlguard.ErrUnsafeQuery) {
+			t.Errorf("%s: got %v, want ErrUnsafeQuery", tt.name, err)
+		}
+	}
+}
+
+func TestExecuteFederatedCancelled(t *testing.T) {
+	f := newTestFederation(10000)
+	ctx, cancel := context.WithCancel(context.Background())
+	rows, err := ExecuteFederated(ctx, federatedPipeline(), f.federation(0), ColumnarOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	cancel()
+	for rows.Next() {
+	}
+	if err := rows.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestExplainFederated(t *testing.T) {
+	f := newTestFederation(0)
+	fed := f.federation(0)
+	plan, err := ExplainFederated(federatedPipeline(), fed, func(p *models.Pipeline) (*Plan, error) {
+		return Compile(p, sqlguard.PostgreSQL, 0, schemas(f.sources[p.DataSourceID]))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Engine != EngineFederated || len(plan.Inputs) != 2 {
+		t.Fatalf("got %+v", plan)
+	}
+	wantSteps := []PlanStep{
+		{Index: 0, Type: models.OpJoin, Reason: "reads its entity as a separate input, combined by the columnar engine"},
+		{Index: 1, Type: models.OpFilter, PushedDown: true},
+		{Index: 2, Type: models.OpSelect, Reason: "follows a cross-datasource step, executed by the columnar engine"},
+		{Index: 3, Type: models.OpSort, Reason: "follows a cross-datasource step, executed by the columnar engine"},
+	}
+	if !reflect.DeepEqual(plan.Steps, wantSteps) {
+		t.Errorf("got steps %+v", plan.Steps)
+	}
+	wantSQL := []string{
+		`SELECT "id", "region" FROM "orders" WHERE "amount" > 10`,
+		`SELECT * FROM "regions" WHERE "manager" IN ('m0', 'm9')`,
+	}
+	for i, input := range plan.Inputs {
+		if input.Index != i-1 || input.Plan.SQL != wantSQL[i] {
+			t.Errorf("input %d: got index %d, SQL %s", i, input.Index, input.Plan.SQL)
+		}
+	}
+}
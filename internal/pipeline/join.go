@@ -0,0 +1,365 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// 连接类型
+const (
+	joinInner = "inner"
+	joinLeft  = "left"
+	joinFull  = "full"
+)
+
+// 在内存中连接的算法
+const (
+	strategyHash  = "hash"
+	strategyMerge = "merge"
+)
+
+// openInput 打开 join、union 的另一个实体并执行其上的操作，错误归到第 index 个操作
+func openInput(index int, op *models.Operation, open Source) (Rows, error) {
+	rows, err := open(op.Entity)
+	if errors.Is(err, database.ErrEntityNotFound) {
+		return nil, opError(index, "unknown entity %q", op.Entity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err = apply(rows, op.Operations, 0, open)
+	if err != nil {
+		return nil, nestedError(index, err)
+	}
+	return rows, nil
+}
+
+// hashJoin join 操作：读入右侧的全部行建立哈希表，再逐行读取左侧查找匹配的行。
+// 输出按左侧的顺序排列，full 连接最后输出右侧没有匹配的行
+type hashJoin struct {
+	*joinLayout
+	left, right Rows
+	leftWidth   int
+
+	built     bool
+	table     map[string][]int
+	rightRows [][]interface{}
+	matched   []bool // full 连接中右侧各行是否已匹配
+
+	leftRow   []interface{}
+	matches   []int
+	pos       int
+	leftDone  bool
+	unmatched int // full 连接输出右侧未匹配行时的位置
+	row       []interface{}
+	key       []byte
+	err       error
+}
+
+// joinKeyColumn 连接列。两侧类型不同时，字符串一侧的值按另一侧的类型转换后再比较，
+// 例如整数列可以与内容为数字的文本列连接
+type joinKeyColumn struct {
+	index  int
+	coerce string
+}
+
+func newHashJoin(index int, left Rows, op *models.Operation, open Source) (Rows, error) {
+	right, err := openInput(index, op, open)
+	if err != nil {
+		return nil, err
+	}
+	j, err := buildHashJoin(index, left, right, op)
+	if err != nil {
+		right.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func buildHashJoin(index int, left, right Rows, op *models.Operation) (*hashJoin, error) {
+	layout, err := newJoinLayout(index, left.Columns(), right.Columns(), op)
+	if err != nil {
+		return nil, err
+	}
+	return &hashJoin{joinLayout: layout, left: left, right: right, leftWidth: len(left.Columns()), pos: -1,
+		row: make([]interface{}, len(layout.columns))}, nil
+}
+
+// joinLayout join 操作的类型、两侧的连接列和输出列
+type joinLayout struct {
+	kind      string
+	leftKeys  []joinKeyColumn
+	rightKeys []joinKeyColumn
+	columns   []models.ResultColumn
+}
+
+// newJoinLayout 检查 join 操作，lc、rc 为左右两侧的列
+func newJoinLayout(index int, lc, rc []models.ResultColumn, op *models.Operation) (*joinLayout, error) {
+	j := &joinLayout{kind: op.JoinType}
+	if j.kind == "" {
+		j.kind = joinInner
+	}
+	for i, on := range op.On {
+		l, ok := columnIndex(lc, on.Left)
+		if !ok {
+			return nil, opError(index, "on[%d]: unknown left column %q", i, on.Left)
+		}
+		r, ok := columnIndex(rc, on.Right)
+		if !ok {
+			return nil, opError(index, "on[%d]: entity %q has no column %q", i, op.Entity, on.Right)
+		}
+		j.leftKeys = append(j.leftKeys, joinKeyColumn{index: l, coerce: keyCoercion(lc[l].Type, rc[r].Type)})
+		j.rightKeys = append(j.rightKeys, joinKeyColumn{index: r, coerce: keyCoercion(rc[r].Type, lc[l].Type)})
+	}
+
+	alias := op.Alias
+	if alias == "" {
+		alias = op.Entity[strings.LastIndex(op.Entity, ".")+1:]
+	}
+	j.columns = append([]models.ResultColumn(nil), lc...)
+	for _, c := range rc {
+		if _, clash := columnIndex(j.columns, c.Name); clash {
+			c.Name = alias + "." + c.Name
+			if _, clash := columnIndex(j.columns, c.Name); clash {
+				return nil, opError(index, "duplicate output column %q, use a different alias", c.Name)
+			}
+		}
+		j.columns = append(j.columns, c)
+	}
+	return j, nil
+}
+
+// keyCoercion 连接列的值需要转换成的类型，不需要转换时为空
+func keyCoercion(own, other string) string {
+	if own == database.TypeString && other != database.TypeString && other != database.TypeUnknown {
+		return other
+	}
+	return ""
+}
+
+// joinKey 一行的连接键，任一连接列为空值时 ok 为 false
+func (j *hashJoin) joinKey(row []interface{}, keys []joinKeyColumn) (string, bool) {
+	var ok bool
+	j.key, ok = appendJoinKey(j.key[:0], keys, func(col int) interface{} { return row[col] })
+	return string(j.key), ok
+}
+
+// appendJoinKey 将连接列的值编码后追加到 key 中，value 返回一列的值。任一连接列为空值时 ok 为 false
+func appendJoinKey(key []byte, keys []joinKeyColumn, value func(col int) interface{}) ([]byte, bool) {
+	for _, k := range keys {
+		v := value(k.index)
+		if v == nil {
+			return key, false
+		}
+		if k.coerce != "" {
+			v = coerceLiteral(toString(v), k.coerce)
+		}
+		key = appendKey(key, v)
+	}
+	return key, true
+}
+
+func (j *hashJoin) Columns() []models.ResultColumn {
+	return j.columns
+}
+
+// build 读入右侧的全部行
+func (j *hashJoin) build() error {
+	j.built = true
+	j.table = make(map[string][]int)
+	for j.right.Next() {
+		row := append([]interface{}(nil), j.right.Row()...)
+		if key, ok := j.joinKey(row, j.rightKeys); ok {
+			j.table[key] = append(j.table[key], len(j.rightRows))
+		}
+		j.rightRows = append(j.rightRows, row)
+	}
+	if j.kind == joinFull {
+		j.matched = make([]bool, len(j.rightRows))
+	}
+	return j.right.Err()
+}
+
+func (j *hashJoin) Next() bool {
+	if j.err != nil {
+		return false
+	}
+	if !j.built {
+		if j.err = j.build(); j.err != nil {
+			return false
+		}
+	}
+	for {
+		if j.pos+1 < len(j.matches) {
+			j.pos++
+			j.fill(j.leftRow, j.rightRows[j.matches[j.pos]])
+			return true
+		}
+		if j.leftDone {
+			return j.nextUnmatched()
+		}
+		if !j.left.Next() {
+			j.leftDone = true
+			if j.err = j.left.Err(); j.err != nil {
+				return false
+			}
+			continue
+		}
+		j.leftRow = j.left.Row()
+		j.matches, j.pos = nil, -1
+		if key, ok := j.joinKey(j.leftRow, j.leftKeys); ok {
+			j.matches = j.table[key]
+		}
+		for _, r := range j.matches {
+			if j.matched != nil {
+				j.matched[r] = true
+			}
+		}
+		if len(j.matches) == 0 && j.kind != joinInner {
+			j.fill(j.leftRow, nil)
+			return true
+		}
+	}
+}
+
+// nextUnmatched full 连接中输出右侧没有匹配的下一行
+func (j *hashJoin) nextUnmatched() bool {
+	for ; j.unmatched < len(j.matched); j.unmatched++ {
+		if !j.matched[j.unmatched] {
+			j.fill(nil, j.rightRows[j.unmatched])
+			j.unmatched++
+			return true
+		}
+	}
+	return false
+}
+
+// fill 拼接左右两侧的行，nil 表示该侧全部为空值
+func (j *hashJoin) fill(left, right []interface{}) {
+	for i := range j.row {
+		j.row[i] = nil
+	}
+	copy(j.row[:j.leftWidth], left)
+	copy(j.row[j.leftWidth:], right)
+}
+
+func (j *hashJoin) Row() []interface{} {
+	return j.row
+}
+
+func (j *hashJoin) Err() error {
+	return j.err
+}
+
+func (j *hashJoin) Close() error {
+	err := j.left.Close()
+	if rerr := j.right.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// union union 操作：先输出左侧的行，再输出右侧的行。右侧按列名对应左侧的列，
+// 两侧的列名必须相同；All 为 false 时去除重复行
+type union struct {
+	inputs  [2]Rows
+	mapping []int // 左侧各列在右侧中的下标
+	columns []models.ResultColumn
+	dedup   *deduplicator
+	current int
+	row     []interface{}
+	err     error
+}
+
+func newUnion(index int, left Rows, op *models.Operation, open Source) (Rows, error) {
+	right, err := openInput(index, op, open)
+	if err != nil {
+		return nil, err
+	}
+	mapping, columns, err := unionLayout(index, left.Columns(), right.Columns(), op)
+	if err != nil {
+		right.Close()
+		return nil, err
+	}
+	u := &union{inputs: [2]Rows{left, right}, mapping: mapping, columns: columns, row: make([]interface{}, len(columns))}
+	if !op.All {
+		u.dedup = &deduplicator{seen: make(map[string]struct{})}
+	}
+	return u, nil
+}
+
+// unionLayout 检查 union 操作，返回左侧各列在右侧中的下标和输出列
+func unionLayout(index int, lc, rc []models.ResultColumn, op *models.Operation) ([]int, []models.ResultColumn, error) {
+	if len(rc) != len(lc) {
+		return nil, nil, opError(index, "entity %q has %d columns, expected %d", op.Entity, len(rc), len(lc))
+	}
+	mapping := make([]int, len(lc))
+	columns := make([]models.ResultColumn, len(lc))
+	for i, c := range lc {
+		r, ok := columnIndex(rc, c.Name)
+		if !ok {
+			return nil, nil, opError(index, "entity %q has no column %q", op.Entity, c.Name)
+		}
+		mapping[i] = r
+		c.Type = unionType(c.Type, rc[r].Type)
+		columns[i] = c
+	}
+	return mapping, columns, nil
+}
+
+// unionType 两侧列类型不同时的结果类型：数值合并为 float，其他为 unknown
+func unionType(a, b string) string {
+	switch {
+	case a == b:
+		return a
+	case numericType(a) && numericType(b) && a != database.TypeUnknown && b != database.TypeUnknown:
+		return database.TypeFloat
+	}
+	return database.TypeUnknown
+}
+
+func (u *union) Columns() []models.ResultColumn {
+	return u.columns
+}
+
+func (u *union) Next() bool {
+	for u.err == nil && u.current < len(u.inputs) {
+		input := u.inputs[u.current]
+		if !input.Next() {
+			u.err = input.Err()
+			u.current++
+			continue
+		}
+		values := input.Row()
+		if u.current == 0 {
+			copy(u.row, values)
+		} else {
+			for i, r := range u.mapping {
+				u.row[i] = values[r]
+			}
+		}
+		if u.dedup == nil || u.dedup.firstSeen(u.row) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *union) Row() []interface{} {
+	return u.row
+}
+
+func (u *union) Err() error {
+	return u.err
+}
+
+func (u *union) Close() error {
+	err := u.inputs[0].Close()
+	if rerr := u.inputs[1].Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// joinTables 左侧的订单表和右侧的几张表，连接列含空值和类型不同的列
+func joinTables() map[string]testTable {
+	return map[string]testTable{
+		"orders": {columns: []models.ResultColumn{
+			{Name: "id", Type: database.TypeInteger},
+			{Name: "cust", Type: database.TypeInteger},
+			{Name: "amount", Type: database.TypeFloat},
+		}, rows: [][]interface{}{
+			{int64(1), int64(10), 5.0},
+			{int64(2), int64(20), 6.0},
+			{int64(3), nil, 7.0},
+			{int64(4), int64(40), 8.5},
+		}},
+		"customers": {columns: []models.ResultColumn{
+			{Name: "cust", Type: database.TypeInteger},
+			{Name: "name", Type: database.TypeString},
+		}, rows: [][]interface{}{
+			{int64(10), "a"},
+			{int64(20), "b"},
+			{int64(20), "b2"},
+			{int64(30), "c"},
+			{nil, "z"},
+		}},
+		// 连接列为文本，内容是数字的行可以与整数列连接
+		"legacy": {columns: []models.ResultColumn{
+			{Name: "code", Type: database.TypeString},
+			{Name: "name", Type: database.TypeString},
+		}, rows: [][]interface{}{
+			{"10", "a"},
+			{"20.0", "b"},
+			{"x", "c"},
+			{nil, "z"},
+		}},
+		"scores": {columns: []models.ResultColumn{
+			{Name: "value", Type: database.TypeInteger},
+			{Name: "label", Type: database.TypeString},
+		}, rows: [][]interface{}{
+			{int64(5), "five"},
+			{int64(7), "seven"},
+			{int64(8), "eight"},
+		}},
+		"extra": {columns: []models.ResultColumn{
+			{Name: "amount", Type: database.TypeFloat},
+			{Name: "id", Type: database.TypeFloat},
+		}, rows: [][]interface{}{
+			{5.0, 1.0},
+			{9.0, 9.0},
+			{9.0, 9.0},
+			{nil, nil},
+		}},
+	}
+}
+
+func TestJoin(t *testing.T) {
+	open := tables(joinTables())
+	join := func(entity, kind, left, right string) models.Operation {
+		return models.Operation{Type: models.OpJoin, Entity: entity, JoinType: kind, On: []models.JoinKey{{Left: left, Right: right}}}
+	}
+	tests := []struct {
+		name    string
+		op      models.Operation
+		columns []string
+		rows    [][]interface{}
+	}{
+		{"inner", join("customers", "", "cust", "cust"),
+			[]string{"id", "cust", "amount", "customers.cust", "name"},
+			[][]interface{}{
+				{int64(1), int64(10), 5.0, int64(10), "a"},
+				{int64(2), int64(20), 6.0, int64(20), "b"},
+				{int64(2), int64(20), 6.0, int64(20), "b2"},
+			}},
+		{"left keeps unmatched and null keys", join("customers", "left", "cust", "cust"),
+			[]string{"id", "cust", "amount", "customers.cust", "name"},
+			[][]interface{}{
+				{int64(1), int64(10), 5.0, int64(10), "a"},
+				{int64(2), int64(20), 6.0, int64(20), "b"},
+				{int64(2), int64(20), 6.0, int64(20), "b2"},
+				{int64(3), nil, 7.0, nil, nil},
+				{int64(4), int64(40), 8.5, nil, nil},
+			}},
+		{"full keeps both sides and null keys never match", join("customers", "full", "cust", "cust"),
+			[]string{"id", "cust", "amount", "customers.cust", "name"},
+			[][]interface{}{
+				{int64(1), int64(10), 5.0, int64(10), "a"},
+				{int64(2), int64(20), 6.0, int64(20), "b"},
+				{int64(2), int64(20), 6.0, int64(20), "b2"},
+				{int64(3), nil, 7.0, nil, nil},
+				{int64(4), int64(40), 8.5, nil, nil},
+				{nil, nil, nil, int64(30), "c"},
+				{nil, nil, nil, nil, "z"},
+			}},
+		{"integer with numeric text", join("legacy", "full", "cust", "code"),
+			[]string{"id", "cust", "amount", "code", "name"},
+			[][]interface{}{
+				{int64(1), int64(10), 5.0, "10", "a"},
+				{int64(2), int64(20), 6.0, "20.0", "b"},
+				{int64(3), nil, 7.0, nil, nil},
+				{int64(4), int64(40), 8.5, nil, nil},
+				{nil, nil, nil, "x", "c"},
+				{nil, nil, nil, nil, "z"},
+			}},
+		{"float with integer", join("scores", "", "amount", "value"),
+			[]string{"id", "cust", "amount", "value", "label"},
+			[][]interface{}{
+				{int64(1), int64(10), 5.0, int64(5), "five"},
+				{int64(3), nil, 7.0, int64(7), "seven"},
+			}},
+		{"alias", models.Operation{Type: models.OpJoin, Entity: "customers", Alias: "c", On: []models.JoinKey{{Left: "id", Right: "cust"}}},
+			[]string{"id", "cust", "amount", "c.cust", "name"}, nil},
+	}
+	for _, tt := range tests {
+		for _, strategy := range []string{strategyHash, strategyMerge} {
+			op := tt.op
+			op.Strategy = strategy
+			p := &models.Pipeline{Entity: "orders", Operations: []models.Operation{op}}
+			rows, err := Execute(p, open)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			var columns []string
+			for _, c := range rows.Columns() {
+				columns = append(columns, c.Name)
+			}
+			// 行引擎按左侧的顺序输出，full 连接最后输出右侧未匹配的行
+			if got := collect(t, rows, nil); !reflect.DeepEqual(columns, tt.columns) || !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("%s: got %v %v, want %v %v", tt.name, columns, got, tt.columns, tt.rows)
+			}
+
+			rows, err = ExecuteColumnar(context.Background(), p, open, ColumnarOptions{})
+			if got := collect(t, rows, err); !reflect.DeepEqual(sorted(got), sorted(tt.rows)) {
+				t.Errorf("%s: columnar %s join got %v", tt.name, strategy, got)
+			}
+		}
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	schema := schemas(joinTables())
+	selectAll := models.Operation{Type: models.OpSelect, Columns: []string{"id", "cust", "amount"}}
+	selfJoin := models.Operation{Type: models.OpJoin, Entity: "orders", On: []models.JoinKey{{Left: "id", Right: "id"}}}
+	tests := []struct {
+		name string
+		ops  []models.Operation
+	}{
+		{"unknown left column", []models.Operation{selectAll,
+			{Type: models.OpJoin, Entity: "customers", On: []models.JoinKey{{Left: "customer", Right: "cust"}}}}},
+		{"unknown right column", []models.Operation{selectAll,
+			{Type: models.OpJoin, Entity: "customers", On: []models.JoinKey{{Left: "cust", Right: "id"}}}}},
+		{"output column clash", []models.Operation{selfJoin, selfJoin}},
+		{"union column count", []models.Operation{selectAll, {Type: models.OpUnion, Entity: "customers"}}},
+		{"union column names", []models.Operation{{Type: models.OpSelect, Columns: []string{"id", "cust"}},
+			{Type: models.OpUnion, Entity: "extra"}}},
+	}
+	for _, tt := range tests {
+		_, err := Check(&models.Pipeline{Entity: "orders", Operations: tt.ops}, schema)
+		var opErr *OperationError
+		if !errors.As(err, &opErr) || opErr.Index != 1 {
+			t.Errorf("%s: got %v, want an error for operations[1]", tt.name, err)
+		}
+	}
+}
+
+func TestUnionAndDistinct(t *testing.T) {
+	open := tables(joinTables())
+	// 右侧的列顺序与左侧不同，按列名对应
+	selectLeft := models.Operation{Type: models.OpSelect, Columns: []string{"id", "amount"}}
+	tests := []struct {
+		name  string
+		ops   []models.Operation
+		types []string
+		rows  [][]interface{}
+	}{
+		{"union all", []models.Operation{selectLeft, {Type: models.OpUnion, Entity: "extra", All: true}},
+			[]string{database.TypeFloat, database.TypeFloat},
+			[][]interface{}{
+				{int64(1), 5.0}, {int64(2), 6.0}, {int64(3), 7.0}, {int64(4), 8.5},
+				{1.0, 5.0}, {9.0, 9.0}, {9.0, 9.0}, {nil, nil},
+			}},
+		{"union", []models.Operation{selectLeft, {Type: models.OpUnion, Entity: "extra"}},
+			[]string{database.TypeFloat, database.TypeFloat},
+			[][]interface{}{
+				{int64(1), 5.0}, {int64(2), 6.0}, {int64(3), 7.0}, {int64(4), 8.5},
+				{9.0, 9.0}, {nil, nil},
+			}},
+		{"union with nested operations", []models.Operation{
+			{Type: models.OpSelect, Columns: []string{"cust"}},
+			{Type: models.OpUnion, Entity: "customers", Operations: []models.Operation{{Type: models.OpSelect, Columns: []string{"cust"}}}},
+		},
+			[]string{database.TypeInteger},
+			[][]interface{}{{int64(10)}, {int64(20)}, {nil}, {int64(40)}, {int64(30)}}},
+		{"distinct treats equal numbers as duplicates", []models.Operation{
+			selectLeft,
+			{Type: models.OpUnion, Entity: "extra", All: true},
+			{Type: models.OpSelect, Columns: []string{"id"}},
+			{Type: models.OpDistinct},
+		},
+			[]string{database.TypeFloat},
+			[][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}, {int64(4)}, {9.0}, {nil}}},
+		{"distinct keeps one null", []models.Operation{
+			selectLeft,
+			{Type: models.OpUnion, Entity: "extra", All: true},
+			{Type: models.OpCalculate, Fields: []models.CalculatedField{{Name: "big", Expression: "amount > 6"}}},
+			{Type: models.OpSelect, Columns: []string{"big"}},
+			{Type: models.OpDistinct},
+		},
+			[]string{database.TypeBoolean},
+			[][]interface{}{{false}, {true}, {nil}}},
+	}
+	for _, tt := range tests {
+		p := &models.Pipeline{Entity: "orders", Operations: tt.ops}
+		rows, err := Execute(p, open)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var types []string
+		for _, c := range rows.Columns() {
+			types = append(types, c.Type)
+		}
+		if got := collect(t, rows, nil); !reflect.DeepEqual(types, tt.types) || !reflect.DeepEqual(got, tt.rows) {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, types, got, tt.types, tt.rows)
+		}
+
+		rows, err = ExecuteColumnar(context.Background(), p, open, ColumnarOptions{})
+		if got := collect(t, rows, err); !reflect.DeepEqual(sorted(got), sorted(tt.rows)) {
+			t.Errorf("%s: columnar got %v", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package pipeline
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// projection select 操作：按给出的顺序输出部分列
+type projection struct {
+	Rows
+	columns []models.ResultColumn
+	indexes []int
+	row     []interface{}
+}
+
+func newProjection(index int, input Rows, op *models.Operation) (Rows, error) {
+	in := input.Columns()
+	p := &projection{
+		Rows:    input,
+		columns: make([]models.ResultColumn, len(op.Columns)),
+		indexes: make([]int, len(op.Columns)),
+		row:     make([]interface{}, len(op.Columns)),
+	}
+	for i, name := range op.Columns {
+		j, ok := columnIndex(in, name)
+		if !ok {
+			return nil, opError(index, "unknown column %q", name)
+		}
+		p.columns[i] = in[j]
+		p.indexes[i] = j
+	}
+	return p, nil
+}
+
+func (p *projection) Columns() []models.ResultColumn {
+	return p.columns
+}
+
+func (p *projection) Row() []interface{} {
+	in := p.Rows.Row()
+	for i, j := range p.indexes {
+		p.row[i] = in[j]
+	}
+	return p.row
+}
+
+// predicate 判断一行是否满足条件
+type predicate func(row []interface{}) bool
+
+// filter filter 操作：只输出满足条件的行
+type filter struct {
+	Rows
+	match predicate
+}
+
+func newFilter(index int, input Rows, op *models.Operation) (Rows, error) {
+	columns := input.Columns()
+	predicates := make([]predicate, len(op.Conditions))
+	for i, cond := range op.Conditions {
+		j, ok := columnIndex(columns, cond.Column)
+		if !ok {
+			return nil, opError(index, "conditions[%d]: unknown column %q", i, cond.Column)
+		}
+		predicates[i] = newPredicate(j, columns[j].Type, cond)
+	}
+
+	matchAny := op.Match == "any"
+	return &filter{Rows: input, match: func(row []interface{}) bool {
+		for _, p := range predicates {
+			if p(row) == matchAny {
+				return matchAny
+			}
+		}
+		return !matchAny
+	}}, nil
+}
+
+func (f *filter) Next() bool {
+	for f.Rows.Next() {
+		if f.match(f.Rows.Row()) {
+			return true
+		}
+	}
+	return false
+}
+
+// newPredicate 构造列 col 上的条件。与 SQL 相同，空值只满足 isNull
+func newPredicate(col int, columnType string, cond models.FilterCondition) predicate {
+	switch cond.Operator {
+	case opIsNull:
+		return func(row []interface{}) bool { return row[col] == nil }
+	case opNotNull:
+		return func(row []interface{}) bool { return row[col] != nil }
+	case opContains, opStartsWith, opEndsWith:
+		pattern := cond.Value.(string)
+		match := textMatcher(cond.Operator)
+		return func(row []interface{}) bool {
+			return row[col] != nil && match(toString(row[col]), pattern)
+		}
+	case opIn, opNotIn:
+		raw := cond.Value.([]interface{})
+		values := make([]interface{}, len(raw))
+		for i, v := range raw {
+			values[i] = coerceLiteral(v, columnType)
+		}
+		want := cond.Operator == opIn
+		return func(row []interface{}) bool {
+			if row[col] == nil {
+				return false
+			}
+			for _, v := range values {
+				if c, ok := compareValues(row[col], v); ok && c == 0 {
+					return want
+				}
+			}
+			return !want
+		}
+	case opBetween:
+		bounds := cond.Value.([]interface{})
+		low, high := coerceLiteral(bounds[0], columnType), coerceLiteral(bounds[1], columnType)
+		return func(row []interface{}) bool {
+			if row[col] == nil {
+				return false
+			}
+			c1, ok1 := compareValues(row[col], low)
+			c2, ok2 := compareValues(row[col], high)
+			return ok1 && ok2 && c1 >= 0 && c2 <= 0
+		}
+	}
+
+	value := coerceLiteral(cond.Value, columnType)
+	test := comparisonTest(cond.Operator)
+	return func(row []interface{}) bool {
+		if row[col] == nil {
+			return false
+		}
+		c, ok := compareValues(row[col], value)
+		return ok && test(c)
+	}
+}
+
+// textMatcher contains、startsWith、endsWith 对应的匹配函数
+func textMatcher(operator string) func(s, pattern string) bool {
+	switch operator {
+	case opStartsWith:
+		return strings.HasPrefix
+	case opEndsWith:
+		return strings.HasSuffix
+	}
+	return strings.Contains
+}
+
+// comparisonTest 比较运算符对应的判断，参数为 compareValues 的结果
+func comparisonTest(operator string) func(c int) bool {
+	switch operator {
+	case opEq:
+		return func(c int) bool { return c == 0 }
+	case opNe:
+		return func(c int) bool { return c != 0 }
+	case opGt:
+		return func(c int) bool { return c > 0 }
+	case opGte:
+		return func(c int) bool { return c >= 0 }
+	case opLt:
+		return func(c int) bool { return c < 0 }
+	}
+	return func(c int) bool { return c <= 0 } // opLte
+}
+
+// calculation calculate 操作：在输入的列之后追加计算列
+type calculation struct {
+	Rows
+	columns []models.ResultColumn
+	exprs   []evaluator
+	width   int
+	row     []interface{}
+}
+
+func newCalculation(index int, input Rows, op *models.Operation) (Rows, error) {
+	in := input.Columns()
+	columns, exprs, err := calculationExprs(index, in, op)
+	if err != nil {
+		return nil, err
+	}
+	return &calculation{Rows: input, width: len(in), columns: columns, exprs: exprs, row: make([]interface{}, len(columns))}, nil
+}
+
+// calculationExprs 检查 calculate 操作，返回输出列和各计算列的求值函数
+func calculationExprs(index int, in []models.ResultColumn, op *models.Operation) ([]models.ResultColumn, []evaluator, error) {
+	columns := append([]models.ResultColumn(nil), in...)
+	var exprs []evaluator
+	for i, field := range op.Fields {
+		if _, exists := columnIndex(columns, field.Name); exists {
+			return nil, nil, opError(index, "fields[%d]: column %q already exists", i, field.Name)
+		}
+		e, err := parseExpr(field.Expression)
+		if err != nil {
+			return nil, nil, opError(index, "fields[%d]: %s", i, err)
+		}
+		// 后面的表达式可以引用前面的计算列
+		eval, t, err := compileExpr(e, columns)
+		if err != nil {
+			return nil, nil, opError(index, "fields[%d]: %s", i, err)
+		}
+		exprs = append(exprs, eval)
+		columns = append(columns, models.ResultColumn{Name: field.Name, Type: t})
+	}
+	return columns, exprs, nil
+}
+
+func (c *calculation) Columns() []models.ResultColumn {
+	return c.columns
+}
+
+func (c *calculation) Row() []interface{} {
+	copy(c.row, c.Rows.Row())
+	for i, eval := range c.exprs {
+		c.row[c.width+i] = eval(c.row)
+	}
+	return c.row
+}
+
+// sorter sort 操作：读完输入后稳定排序。升序时空值排在最前，降序时排在最后
+type sorter struct {
+	input Rows
+	keys  []int
+	desc  []bool
+
+	rows [][]interface{}
+	pos  int
+	done bool
+	err  error
+}
+
+func newSorter(index int, input Rows, op *models.Operation) (Rows, error) {
+	keys, desc, err := sortKeys(index, input.Columns(), op)
+	if err != nil {
+		return nil, err
+	}
+	return &sorter{input: input, pos: -1, keys: keys, desc: desc}, nil
+}
+
+// sortKeys 检查 sort 操作，返回排序列的下标及是否降序
+func sortKeys(index int, columns []models.ResultColumn, op *models.Operation) ([]int, []bool, error) {
+	var keys []int
+	var desc []bool
+	for i, key := range op.Keys {
+		j, ok := columnIndex(columns, key.Column)
+		if !ok {
+			return nil, nil, opError(index, "keys[%d]: unknown column %q", i, key.Column)
+		}
+		keys = append(keys, j)
+		desc = append(desc, key.Direction == "desc")
+	}
+	return keys, desc, nil
+}
+
+func (s *sorter) Columns() []models.ResultColumn {
+	return s.input.Columns()
+}
+
+func (s *sorter) Next() bool {
+	if !s.done {
+		s.done = true
+		s.rows, s.err = readAll(s.input)
+		if s.err == nil {
+			sort.SliceStable(s.rows, func(a, b int) bool { return s.less(s.rows[a], s.rows[b]) })
+		}
+	}
+	if s.err != nil || s.pos+1 >= len(s.rows) {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+func (s *sorter) less(a, b []interface{}) bool {
+	for i, j := range s.keys {
+		c := orderValues(a[j], b[j])
+		if s.desc[i] {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+// orderValues 排序时比较两个值：空值最小，无法比较的值按文本形式比较
+func orderValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	if c, ok := compareValues(a, b); ok {
+		return c
+	}
+	return strings.Compare(toString(a), toString(b))
+}
+
+func (s *sorter) Row() []interface{} {
+	return s.rows[s.pos]
+}
+
+func (s *sorter) Err() error {
+	return s.err
+}
+
+func (s *sorter) Close() error {
+	return s.input.Close()
+}
+
+// readAll 读完 rows 的全部行，每行复制一份
+func readAll(rows Rows) ([][]interface{}, error) {
+	var all [][]interface{}
+	for rows.Next() {
+		all = append(all, append([]interface{}(nil), rows.Row()...))
+	}
+	return all, rows.Err()
+}
+
+// limiter limit 操作：跳过前 offset 行后最多输出 limit 行，达到行数后不再读取输入
+type limiter struct {
+	Rows
+	limit, offset int
+	count         int
+}
+
+func newLimiter(_ int, input Rows, op *models.Operation) (Rows, error) {
+	return &limiter{Rows: input, limit: op.Limit, offset: op.Offset}, nil
+}
+
+func (l *limiter) Next() bool {
+	for ; l.offset > 0; l.offset-- {
+		if !l.Rows.Next() {
+			return false
+		}
+	}
+	if l.limit > 0 && l.count >= l.limit {
+		return false
+	}
+	if !l.Rows.Next() {
+		return false
+	}
+	l.count++
+	return true
+}
+
+// deduplicator distinct 操作：只输出每组相同的行中的第一行
+type deduplicator struct {
+	Rows
+	seen map[string]struct{}
+	key  []byte
+}
+
+func newDeduplicator(_ int, input Rows, _ *models.Operation) (Rows, error) {
+	return &deduplicator{Rows: input, seen: make(map[string]struct{})}, nil
+}
+
+func (d *deduplicator) Next() bool {
+	for d.Rows.Next() {
+		if d.firstSeen(d.Rows.Row()) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstSeen 记录一行，该行第一次出现时返回 true
+func (d *deduplicator) firstSeen(row []interface{}) bool {
+	d.key = d.key[:0]
+	for _, v := range row {
+		d.key = appendKey(d.key, v)
+	}
+	if _, ok := d.seen[string(d.key)]; ok {
+		return false
+	}
+	d.seen[string(d.key)] = struct{}{}
+	return true
+}
@@ -0,0 +1,389 @@
+// Package pipeline 校验并执行数据处理操作（models.Operation）。
+// 操作在内存中依次执行：选择、过滤、计算列等逐行处理；分组聚合、排序需要读完输入后才输出，
+// 连接读入右侧的全部行后逐行处理左侧。
+// 实体位于 SQL 数据源时，Compile 将能用该方言表达的前若干个操作编译为一条查询，由 Resume 在内存中执行其余操作；
+// 没有 SQL 的实体由 ExecuteColumnar 列式执行。join、union 的实体位于其他数据源时，
+// ExecuteFederated 在各数据源上分别执行各侧的输入，再由列式引擎在本地连接
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// ErrInvalidPipeline 操作定义不合法，或引用了输入中不存在的列
+var ErrInvalidPipeline = errors.New("invalid pipeline")
+
+// OperationError 某个操作不合法，Index 为该操作在操作列表中的下标
+type OperationError struct {
+	Index   int
+	Message string
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operations[%d]: %s", e.Index, e.Message)
+}
+
+func (e *OperationError) Unwrap() error {
+	return ErrInvalidPipeline
+}
+
+func opError(index int, format string, args ...interface{}) error {
+	return &OperationError{Index: index, Message: fmt.Sprintf(format, args...)}
+}
+
+// Rows 逐行读取的数据。用法与 sql.Rows 相同：循环调用 Next，读取 Row，结束后检查 Err 并 Close
+type Rows interface {
+	// Columns 各列的名称和归一化类型
+	Columns() []models.ResultColumn
+	Next() bool
+	// Row 当前行，值与 Columns 一一对应；返回的切片在下一次 Next 后可能被复用
+	Row() []interface{}
+	Err() error
+	Close() error
+}
+
+// Source 按名称打开数据源中的实体，实体不存在时返回 database.ErrEntityNotFound。
+// join、union 通过它读取其他实体
+type Source func(entity string) (Rows, error)
+
+// 过滤条件的运算符
+const (
+	opEq         = "eq"
+	opNe         = "ne"
+	opGt         = "gt"
+	opGte        = "gte"
+	opLt         = "lt"
+	opLte        = "lte"
+	opIn         = "in"
+	opNotIn      = "notIn"
+	opBetween    = "between"
+	opContains   = "contains"
+	opStartsWith = "startsWith"
+	opEndsWith   = "endsWith"
+	opIsNull     = "isNull"
+	opNotNull    = "notNull"
+)
+
+// 聚合函数
+const (
+	aggCount         = "count"
+	aggCountDistinct = "countDistinct"
+	aggSum           = "sum"
+	aggAvg           = "avg"
+	aggMin           = "min"
+	aggMax           = "max"
+)
+
+// Validate 检查操作定义的结构。列是否存在取决于实体的列，由 Check 或 Execute 检查
+func Validate(p *models.Pipeline) error {
+	if strings.TrimSpace(p.Entity) == "" {
+		return fmt.Errorf("%w: entity is required", ErrInvalidPipeline)
+	}
+	return validateOperations(p.Operations)
+}
+
+func validateOperations(ops []models.Operation) error {
+	for i := range ops {
+		if err := validateOperation(i, &ops[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nestedError 将 join、union 中嵌套操作的错误归到外层第 index 个操作
+func nestedError(index int, err error) error {
+	var opErr *OperationError
+	if errors.As(err, &opErr) {
+		return opError(index, "operations[%d]: %s", opErr.Index, opErr.Message)
+	}
+	return err
+}
+
+func validateOperation(index int, op *models.Operation) error {
+	switch op.Type {
+	case models.OpSelect:
+		if len(op.Columns) == 0 {
+			return opError(index, "select requires at least one column")
+		}
+		seen := make(map[string]bool, len(op.Columns))
+		for _, col := range op.Columns {
+			if col == "" {
+				return opError(index, "column name must not be empty")
+			}
+			if seen[col] {
+				return opError(index, "column %q is selected more than once", col)
+			}
+			seen[col] = true
+		}
+	case models.OpFilter:
+		if len(op.Conditions) == 0 {
+			return opError(index, "filter requires at least one condition")
+		}
+		if op.Match != "" && op.Match != "all" && op.Match != "any" {
+			return opError(index, "match must be all or any")
+		}
+		for j := range op.Conditions {
+			if err := validateCondition(&op.Conditions[j]); err != nil {
+				return opError(index, "conditions[%d]: %s", j, err)
+			}
+		}
+	case models.OpGroupBy:
+		if len(op.GroupBy) == 0 && len(op.Aggregates) == 0 {
+			return opError(index, "groupBy requires group columns or aggregates")
+		}
+		seen := make(map[string]bool, len(op.GroupBy)+len(op.Aggregates))
+		for _, col := range op.GroupBy {
+			if col == "" {
+				return opError(index, "group column name must not be empty")
+			}
+			if seen[col] {
+				return opError(index, "duplicate output column %q", col)
+			}
+			seen[col] = true
+		}
+		for j, agg := range op.Aggregates {
+			switch agg.Function {
+			case aggCount:
+			case aggCountDistinct, aggSum, aggAvg, aggMin, aggMax:
+				if agg.Column == "" {
+					return opError(index, "aggregates[%d]: %s requires a column", j, agg.Function)
+				}
+			default:
+				return opError(index, "aggregates[%d]: unsupported function %q", j, agg.Function)
+			}
+			name := aggregateName(agg)
+			if seen[name] {
+				return opError(index, "duplicate output column %q", name)
+			}
+			seen[name] = true
+		}
+	case models.OpJoin:
+		if strings.TrimSpace(op.Entity) == "" {
+			return opError(index, "join requires an entity")
+		}
+		switch op.JoinType {
+		case "", joinInner, joinLeft, joinFull:
+		default:
+			return opError(index, "joinType must be inner, left or full")
+		}
+		if op.Strategy != "" && op.Strategy != strategyHash && op.Strategy != strategyMerge {
+			return opError(index, "strategy must be hash or merge")
+		}
+		if op.MaxRows < 0 {
+			return opError(index, "maxRows must not be negative")
+		}
+		if len(op.On) == 0 {
+			return opError(index, "join requires at least one key in on")
+		}
+		for j, on := range op.On {
+			if on.Left == "" || on.Right == "" {
+				return opError(index, "on[%d]: left and right are required", j)
+			}
+		}
+		return nestedError(index, validateOperations(op.Operations))
+	case models.OpUnion:
+		if strings.TrimSpace(op.Entity) == "" {
+			return opError(index, "union requires an entity")
+		}
+		if op.MaxRows < 0 {
+			return opError(index, "maxRows must not be negative")
+		}
+		return nestedError(index, validateOperations(op.Operations))
+	case models.OpCalculate:
+		if len(op.Fields) == 0 {
+			return opError(index, "calculate requires at least one field")
+		}
+		seen := make(map[string]bool, len(op.Fields))
+		for j, field := range op.Fields {
+			if field.Name == "" {
+				return opError(index, "fields[%d]: name is required", j)
+			}
+			if seen[field.Name] {
+				return opError(index, "fields[%d]: duplicate field %q", j, field.Name)
+			}
+			seen[field.Name] = true
+			if _, err := parseExpr(field.Expression); err != nil {
+				return opError(index, "fields[%d]: %s", j, err)
+			}
+		}
+	case models.OpSort:
+		if len(op.Keys) == 0 {
+			return opError(index, "sort requires at least one key")
+		}
+		for j, key := range op.Keys {
+			if key.Column == "" {
+				return opError(index, "keys[%d]: column is required", j)
+			}
+			if key.Direction != "" && key.Direction != "asc" && key.Direction != "desc" {
+				return opError(index, "keys[%d]: direction must be asc or desc", j)
+			}
+		}
+	case models.OpLimit:
+		if op.Limit < 0 || op.Offset < 0 {
+			return opError(index, "limit and offset must not be negative")
+		}
+		if op.Limit == 0 && op.Offset == 0 {
+			return opError(index, "limit requires limit or offset")
+		}
+	case models.OpDistinct:
+	default:
+		return opError(index, "unsupported operation type %q", op.Type)
+	}
+	return nil
+}
+
+func validateCondition(cond *models.FilterCondition) error {
+	if cond.Column == "" {
+		return errors.New("column is required")
+	}
+	switch cond.Operator {
+	case opEq, opNe, opGt, opGte, opLt, opLte:
+		if !isScalar(cond.Value) {
+			return fmt.Errorf("%s requires a single value", cond.Operator)
+		}
+	case opContains, opStartsWith, opEndsWith:
+		if _, ok := cond.Value.(string); !ok {
+			return fmt.Errorf("%s requires a string value", cond.Operator)
+		}
+	case opIn, opNotIn:
+		values, ok := cond.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return fmt.Errorf("%s requires a non-empty array", cond.Operator)
+		}
+		for _, v := range values {
+			if !isScalar(v) {
+				return fmt.Errorf("%s values must be strings, numbers or booleans", cond.Operator)
+			}
+		}
+	case opBetween:
+		values, ok := cond.Value.([]interface{})
+		if !ok || len(values) != 2 || !isScalar(values[0]) || !isScalar(values[1]) {
+			return errors.New("between requires an array of two values")
+		}
+	case opIsNull, opNotNull:
+		if cond.Value != nil {
+			return fmt.Errorf("%s does not take a value", cond.Operator)
+		}
+	default:
+		return fmt.Errorf("unsupported operator %q", cond.Operator)
+	}
+	return nil
+}
+
+// isScalar JSON 解码后的单个非空值
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, float64, bool:
+		return true
+	}
+	return false
+}
+
+// aggregateName 聚合的输出列名，未指定 As 时为 函数_列名，统计行数时为 count
+func aggregateName(agg models.Aggregate) string {
+	if agg.As != "" {
+		return agg.As
+	}
+	if agg.Column == "" {
+		return agg.Function
+	}
+	return agg.Function + "_" + agg.Column
+}
+
+// Check 按 schema 给出的实体列检查操作，返回结果的列，不读取数据。
+// 操作不合法或引用了不存在的列、实体时返回 *OperationError
+func Check(p *models.Pipeline, schema func(entity string) ([]models.ResultColumn, error)) ([]models.ResultColumn, error) {
+	rows, err := Execute(p, func(entity string) (Rows, error) {
+		columns, err := schema(entity)
+		if err != nil {
+			return nil, err
+		}
+		return &emptyRows{columns: columns}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns(), nil
+}
+
+// Execute 通过 open 打开 p.Entity 并依次应用操作，返回结果的行迭代器，需由调用方关闭。
+// 各操作在读取数据前完成列的检查，出错时已打开的实体都会关闭
+func Execute(p *models.Pipeline, open Source) (Rows, error) {
+	if err := Validate(p); err != nil {
+		return nil, err
+	}
+	src, err := open(p.Entity)
+	if err != nil {
+		return nil, err
+	}
+	return apply(src, p.Operations, 0, open)
+}
+
+// Resume 在已执行了前 plan.Pushed 个操作的结果 rows 上执行其余操作，出错时关闭 rows
+func Resume(p *models.Pipeline, plan *Plan, rows Rows, open Source) (Rows, error) {
+	return apply(rows, p.Operations, plan.Pushed, open)
+}
+
+// apply 在 rows 上依次应用从第 first 个起的操作，出错时关闭 rows
+func apply(rows Rows, ops []models.Operation, first int, open Source) (Rows, error) {
+	for i := first; i < len(ops); i++ {
+		next, err := newOperator(i, rows, &ops[i], open)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rows = next
+	}
+	return rows, nil
+}
+
+func newOperator(index int, input Rows, op *models.Operation, open Source) (Rows, error) {
+	switch op.Type {
+	case models.OpSelect:
+		return newProjection(index, input, op)
+	case models.OpFilter:
+		return newFilter(index, input, op)
+	case models.OpGroupBy:
+		return newAggregation(index, input, op)
+	case models.OpJoin:
+		return newHashJoin(index, input, op, open)
+	case models.OpUnion:
+		return newUnion(index, input, op, open)
+	case models.OpCalculate:
+		return newCalculation(index, input, op)
+	case models.OpSort:
+		return newSorter(index, input, op)
+	case models.OpLimit:
+		return newLimiter(index, input, op)
+	case models.OpDistinct:
+		return newDeduplicator(index, input, op)
+	}
+	return nil, opError(index, "unsupported operation type %q", op.Type)
+}
+
+// emptyRows 只有列没有行的数据，Check 用它代替实体
+type emptyRows struct {
+	columns []models.ResultColumn
+}
+
+func (r *emptyRows) Columns() []models.ResultColumn { return r.columns }
+func (r *emptyRows) Next() bool                     { return false }
+func (r *emptyRows) Row() []interface{}             { return nil }
+func (r *emptyRows) Err() error                     { return nil }
+func (r *emptyRows) Close() error                   { return nil }
+
+// columnIndex 返回列在 columns 中的下标
+func columnIndex(columns []models.ResultColumn, name string) (int, bool) {
+	for i, c := range columns {
+		if c.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
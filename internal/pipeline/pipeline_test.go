@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+func TestValidate(t *testing.T) {
+	valid := models.Operation{Type: models.OpLimit, Limit: 10}
+	tests := []struct {
+		name  string
+		op    models.Operation
+		index int
+	}{
+		{"unknown type", models.Operation{Type: "pivot"}, 1},
+		{"empty select", models.Operation{Type: models.OpSelect}, 1},
+		{"duplicate select", models.Operation{Type: models.OpSelect, Columns: []string{"a", "a"}}, 1},
+		{"filter without conditions", models.Operation{Type: models.OpFilter}, 1},
+		{"unknown operator", models.Operation{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "a", Operator: "like", Value: "x"}}}, 1},
+		{"in without list", models.Operation{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "a", Operator: "in", Value: "x"}}}, 1},
+		{"invalid match", models.Operation{Type: models.OpFilter, Match: "some", Conditions: []models.FilterCondition{{Column: "a", Operator: "isNull"}}}, 1},
+		{"unknown aggregate", models.Operation{Type: models.OpGroupBy, Aggregates: []models.Aggregate{{Function: "median", Column: "a"}}}, 1},
+		{"sum without column", models.Operation{Type: models.OpGroupBy, Aggregates: []models.Aggregate{{Function: "sum"}}}, 1},
+		{"duplicate group output", models.Operation{Type: models.OpGroupBy, GroupBy: []string{"count"}, Aggregates: []models.Aggregate{{Function: "count"}}}, 1},
+		{"join without keys", models.Operation{Type: models.OpJoin, Entity: "b"}, 1},
+		{"invalid join type", models.Operation{Type: models.OpJoin, Entity: "b", JoinType: "right", On: []models.JoinKey{{Left: "a", Right: "a"}}}, 1},
+		{"invalid strategy", models.Operation{Type: models.OpJoin, Entity: "b", Strategy: "nested", On: []models.JoinKey{{Left: "a", Right: "a"}}}, 1},
+		{"invalid expression", models.Operation{Type: models.OpCalculate, Fields: []models.CalculatedField{{Name: "x", Expression: "a +"}}}, 1},
+		{"invalid sort direction", models.Operation{Type: models.OpSort, Keys: []models.SortKey{{Column: "a", Direction: "up"}}}, 1},
+		{"empty limit", models.Operation{Type: models.OpLimit}, 1},
+		{"negative offset", models.Operation{Type: models.OpLimit, Limit: 1, Offset: -1}, 1},
+		{"nested operation", models.Operation{Type: models.OpUnion, Entity: "b", Operations: []models.Operation{valid, {Type: models.OpSort}}}, 1},
+	}
+	for _, tt := range tests {
+		err := Validate(&models.Pipeline{Entity: "a", Operations: []models.Operation{valid, tt.op}})
+		var opErr *OperationError
+		if !errors.Is(err, ErrInvalidPipeline) || !errors.As(err, &opErr) || opErr.Index != tt.index {
+			t.Errorf("%s: got %v, want an error for operations[%d]", tt.name, err, tt.index)
+		}
+	}
+	// 嵌套操作的错误同时给出外层和内层的位置
+	err := Validate(&models.Pipeline{Entity: "a", Operations: []models.Operation{
+		{Type: models.OpJoin, Entity: "b", On: []models.JoinKey{{Left: "a", Right: "a"}}, Operations: []models.Operation{valid, {Type: models.OpLimit}}},
+	}})
+	if err == nil || !strings.HasPrefix(err.Error(), "operations[0]: operations[1]: ") {
+		t.Errorf("nested: got %v", err)
+	}
+	if err := Validate(&models.Pipeline{Entity: "a", Operations: []models.Operation{valid}}); err != nil {
+		t.Errorf("valid pipeline: %v", err)
+	}
+}
+
+func TestCheckUnknownColumns(t *testing.T) {
+	known := schemas(testTables(0))
+	schema := func(entity string) ([]models.ResultColumn, error) {
+		if entity == "customers" {
+			return nil, database.ErrEntityNotFound
+		}
+		return known(entity)
+	}
+	tests := []struct {
+		name  string
+		ops   []models.Operation
+		index int
+	}{
+		{"select", []models.Operation{{Type: models.OpSelect, Columns: []string{"id", "price"}}}, 0},
+		{"filter after select", []models.Operation{
+			{Type: models.OpSelect, Columns: []string{"id"}},
+			{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "region", Operator: "eq", Value: "east0"}}},
+		}, 1},
+		{"aggregate", []models.Operation{{Type: models.OpGroupBy, GroupBy: []string{"region"}, Aggregates: []models.Aggregate{{Function: "sum", Column: "price"}}}}, 0},
+		{"sort after group", []models.Operation{
+			{Type: models.OpGroupBy, GroupBy: []string{"region"}},
+			{Type: models.OpSort, Keys: []models.SortKey{{Column: "amount"}}},
+		}, 1},
+		{"join key", []models.Operation{{Type: models.OpJoin, Entity: "regions", On: []models.JoinKey{{Left: "region", Right: "name"}}}}, 0},
+		{"unknown entity", []models.Operation{{Type: models.OpLimit, Limit: 1}, {Type: models.OpUnion, Entity: "customers"}}, 1},
+		{"nested", []models.Operation{{Type: models.OpJoin, Entity: "regions", On: []models.JoinKey{{Left: "region", Right: "region"}},
+			Operations: []models.Operation{{Type: models.OpSelect, Columns: []string{"region", "amount"}}}}}, 0},
+	}
+	for _, tt := range tests {
+		_, err := Check(&models.Pipeline{Entity: "orders", Operations: tt.ops}, schema)
+		var opErr *OperationError
+		if !errors.As(err, &opErr) || opErr.Index != tt.index {
+			t.Errorf("%s: got %v, want an error for operations[%d]", tt.name, err, tt.index)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	open := tables(map[string]testTable{"orders": {
+		columns: []models.ResultColumn{
+			{Name: "id", Type: database.TypeInteger},
+			{Name: "region", Type: database.TypeString},
+			{Name: "amount", Type: database.TypeFloat},
+		},
+		rows: [][]interface{}{
+			{int64(1), "east", 10.0},
+			{int64(2), "west", 20.5},
+			{int64(3), "east", nil},
+			{int64(4), nil, 7.5},
+			{int64(5), "east", 2.5},
+		},
+	}})
+	tests := []struct {
+		name    string
+		ops     []models.Operation
+		columns []string
+		rows    [][]interface{}
+	}{
+		{"select", []models.Operation{{Type: models.OpSelect, Columns: []string{"amount", "id"}}, {Type: models.OpLimit, Limit: 2, Offset: 1}},
+			[]string{"amount", "id"},
+			[][]interface{}{{20.5, int64(2)}, {nil, int64(3)}}},
+		{"filter all", []models.Operation{{Type: models.OpFilter, Conditions: []models.FilterCondition{
+			{Column: "region", Operator: "eq", Value: "east"},
+			{Column: "amount", Operator: "gte", Value: 5.0},
+		}}},
+			[]string{"id", "region", "amount"},
+			[][]interface{}{{int64(1), "east", 10.0}}},
+		{"filter any", []models.Operation{{Type: models.OpFilter, Match: "any", Conditions: []models.FilterCondition{
+			{Column: "region", Operator: "isNull"},
+			{Column: "id", Operator: "in", Value: []interface{}{2.0, 5.0}},
+		}}, {Type: models.OpSelect, Columns: []string{"id"}}},
+			[]string{"id"},
+			[][]interface{}{{int64(2)}, {int64(4)}, {int64(5)}}},
+		{"filter ne skips nulls", []models.Operation{{Type: models.OpFilter, Conditions: []models.FilterCondition{
+			{Column: "region", Operator: "ne", Value: "east"},
+		}}, {Type: models.OpSelect, Columns: []string{"id"}}},
+			[]string{"id"},
+			[][]interface{}{{int64(2)}}},
+		{"group by", []models.Operation{
+			{Type: models.OpGroupBy, GroupBy: []string{"region"}, Aggregates: []models.Aggregate{
+				{Function: "count"}, {Function: "count", Column: "amount"}, {Function: "sum", Column: "amount", As: "total"},
+				{Function: "avg", Column: "amount"}, {Function: "max", Column: "id"},
+			}},
+			{Type: models.OpSort, Keys: []models.SortKey{{Column: "region"}}},
+		},
+			[]string{"region", "count", "count_amount", "total", "avg_amount", "max_id"},
+			[][]interface{}{
+				{nil, int64(1), int64(1), 7.5, 7.5, int64(4)},
+				{"east", int64(3), int64(2), 12.5, 6.25, int64(5)},
+				{"west", int64(1), int64(1), 20.5, 20.5, int64(2)},
+			}},
+		{"aggregate without groups", []models.Operation{
+			{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "id", Operator: "gt", Value: 100.0}}},
+			{Type: models.OpGroupBy, Aggregates: []models.Aggregate{{Function: "count"}, {Function: "sum", Column: "amount"}}},
+		},
+			[]string{"count", "sum_amount"},
+			[][]interface{}{{int64(0), nil}}},
+	}
+	for _, tt := range tests {
+		rows, err := Execute(&models.Pipeline{Entity: "orders", Operations: tt.ops}, open)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var columns []string
+		for _, c := range rows.Columns() {
+			columns = append(columns, c.Name)
+		}
+		if got := collect(t, rows, nil); !reflect.DeepEqual(columns, tt.columns) || !reflect.DeepEqual(got, tt.rows) {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, columns, got, tt.columns, tt.rows)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/foldn/bi-go/internal/sqlguard"
+)
+
+// SourceQuery 读取实体全部行的查询。entity 可带以 . 分隔的 schema 前缀，
+// limit 大于 0 时最多读取 limit 行
+func SourceQuery(dialect sqlguard.Dialect, entity string, limit int) string {
+	parts := strings.Split(entity, ".")
+	for i, part := range parts {
+		parts[i] = QuoteIdent(dialect, part)
+	}
+	query := "SELECT * FROM " + strings.Join(parts, ".")
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return query
+}
+
+// QuoteIdent 按方言引用标识符：MySQL、ClickHouse 使用反引号，其他使用双引号
+func QuoteIdent(dialect sqlguard.Dialect, name string) string {
+	switch dialect {
+	case sqlguard.MySQL, sqlguard.ClickHouse:
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// SchemaQuery 不返回任何行的实体查询，用于读取实体的列
+func SchemaQuery(dialect sqlguard.Dialect, entity string) string {
+	return SourceQuery(dialect, entity, 0) + " WHERE 1 = 0"
+}
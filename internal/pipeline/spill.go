@@ -0,0 +1,225 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/foldn/bi-go/internal/models"
+)
+
+func init() {
+	// kindAny 向量中的日期时间值
+	gob.Register(time.Time{})
+}
+
+// ColumnarOptions 列式执行的内存预算
+type ColumnarOptions struct {
+	// MemoryBudget 排序、分组、去重和连接保存的数据共用的内存上限（字节），0 表示不限制
+	MemoryBudget int64
+	// SpillDir 超出内存预算时写入临时文件的目录，为空时使用系统临时目录
+	SpillDir string
+}
+
+// memory 一次列式执行的内存预算，并记录创建的临时文件，执行结束时删除
+type memory struct {
+	limit int64
+	used  int64
+	dir   string
+	files []*spillFile
+}
+
+func newMemory(opts ColumnarOptions) *memory {
+	return &memory{limit: opts.MemoryBudget, dir: opts.SpillDir}
+}
+
+// reserve 占用 n 字节的预算，超出预算时不占用并返回 false
+func (m *memory) reserve(n int64) bool {
+	if m.limit > 0 && m.used+n > m.limit {
+		return false
+	}
+	m.used += n
+	return true
+}
+
+// take 占用 n 字节的预算，允许超出预算
+func (m *memory) take(n int64) {
+	m.used += n
+}
+
+func (m *memory) release(n int64) {
+	m.used -= n
+}
+
+// spill 创建临时文件
+func (m *memory) spill(columns []models.ResultColumn) (*spillFile, error) {
+	f, err := os.CreateTemp(m.dir, "bi-go-spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	s := &spillFile{file: f, columns: columns, w: bufio.NewWriter(f)}
+	s.enc = gob.NewEncoder(s.w)
+	m.files = append(m.files, s)
+	return s, nil
+}
+
+// cleanup 关闭并删除全部临时文件
+func (m *memory) cleanup() {
+	for _, s := range m.files {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+	m.files = nil
+}
+
+// spillFile 溢写到临时文件的批次，写完后可以读取多次
+type spillFile struct {
+	file    *os.File
+	columns []models.ResultColumn
+	w       *bufio.Writer
+	enc     *gob.Encoder
+	rows    int
+}
+
+func (s *spillFile) write(b *batch) error {
+	if err := s.enc.Encode(b); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	s.rows += b.Len
+	return nil
+}
+
+// reader 从头读取已写入的批次
+func (s *spillFile) reader() (batchReader, error) {
+	if err := s.w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write spill file: %w", err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &spillReader{columns: s.columns, dec: gob.NewDecoder(bufio.NewReader(s.file))}, nil
+}
+
+type spillReader struct {
+	columns []models.ResultColumn
+	dec     *gob.Decoder
+}
+
+func (r *spillReader) Columns() []models.ResultColumn {
+	return r.columns
+}
+
+func (r *spillReader) next() (*batch, error) {
+	b := &batch{}
+	if err := r.dec.Decode(b); errors.Is(err, io.EOF) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	// gob 不保存空切片，补全没有值的向量
+	for _, v := range b.Vectors {
+		if v.Nulls == nil {
+			*v = *newVector(v.Kind, 0)
+		}
+	}
+	return b, nil
+}
+
+func (r *spillReader) Close() error {
+	return nil
+}
+
+// table 一个操作保存的全部行：预算内的批次保存在内存中，超出后的批次写入临时文件
+type table struct {
+	mem      *memory
+	columns  []models.ResultColumn
+	batches  []*batch
+	reserved int64
+	spilled  *spillFile
+	rows     int
+}
+
+func newTable(mem *memory, columns []models.ResultColumn) *table {
+	return &table{mem: mem, columns: columns}
+}
+
+func (t *table) add(b *batch) error {
+	if b.Len == 0 {
+		return nil
+	}
+	t.rows += b.Len
+	if t.spilled == nil {
+		if n := b.size(); t.mem.reserve(n) {
+			t.reserved += n
+			t.batches = append(t.batches, b)
+			return nil
+		}
+		var err error
+		if t.spilled, err = t.mem.spill(t.columns); err != nil {
+			return err
+		}
+	}
+	return t.spilled.write(b)
+}
+
+// inMemory 全部行都保存在内存中
+func (t *table) inMemory() bool {
+	return t.spilled == nil
+}
+
+// reader 按添加的顺序读取全部行
+func (t *table) reader() (batchReader, error) {
+	mem := &sliceBatches{columns: t.columns, batches: append([]*batch(nil), t.batches...)}
+	if t.spilled == nil {
+		return mem, nil
+	}
+	disk, err := t.spilled.reader()
+	if err != nil {
+		return nil, err
+	}
+	return &chainBatches{columns: t.columns, inputs: []batchReader{mem, disk}}, nil
+}
+
+// release 释放内存中的批次占用的预算
+func (t *table) release() {
+	t.mem.release(t.reserved)
+	t.reserved = 0
+	t.batches = nil
+}
+
+// chainBatches 依次读取多个输入
+type chainBatches struct {
+	columns []models.ResultColumn
+	inputs  []batchReader
+}
+
+func (c *chainBatches) Columns() []models.ResultColumn {
+	return c.columns
+}
+
+func (c *chainBatches) next() (*batch, error) {
+	for len(c.inputs) > 0 {
+		b, err := c.inputs[0].next()
+		if err != nil || b != nil {
+			return b, err
+		}
+		c.inputs[0].Close()
+		c.inputs = c.inputs[1:]
+	}
+	return nil, nil
+}
+
+func (c *chainBatches) Close() error {
+	var err error
+	for _, input := range c.inputs {
+		if cerr := input.Close(); err == nil {
+			err = cerr
+		}
+	}
+	c.inputs = nil
+	return err
+}
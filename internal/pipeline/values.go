@@ -0,0 +1,230 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foldn/bi-go/internal/database"
+)
+
+// 日期时间字面量可用的格式
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// coerceLiteral 将 JSON 中的字面量转换为与列类型可比较的值：
+// 日期时间列的字符串解析为 time.Time，数值列的数字字符串解析为数值
+func coerceLiteral(v interface{}, columnType string) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	switch columnType {
+	case database.TypeDate, database.TypeDateTime:
+		if t, ok := parseTime(s); ok {
+			return t
+		}
+	case database.TypeInteger, database.TypeFloat, database.TypeDecimal:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+// toInt64 整数类型的值转换为 int64，超出范围的 uint64 不转换
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint8:
+		return int64(n), true
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n), true
+		}
+	case uint:
+		if uint64(n) <= math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// toFloat64 数值转换为 float64，数字字符串同样可以转换
+func toFloat64(v interface{}) (float64, bool) {
+	if n, ok := toInt64(v); ok {
+		return float64(n), true
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(n)), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int64, int, int32, int16, int8, uint64, uint, uint32, uint16, uint8, float64, float32:
+		return true
+	}
+	return false
+}
+
+// toString 字符串匹配时使用的文本形式
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case time.Time:
+		return s.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// compareValues 比较两个非空值，返回 -1、0 或 1；类型不可比较时 ok 为 false。
+// 整数之间精确比较，数值与数字字符串按数值比较，时间与日期时间字符串按时间比较
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := toInt64(a); ok {
+		if y, ok := toInt64(b); ok {
+			return compareOrdered(x, y), true
+		}
+	}
+	if isNumber(a) || isNumber(b) {
+		x, ok1 := toFloat64(a)
+		y, ok2 := toFloat64(b)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		return compareOrdered(x, y), true
+	}
+	switch x := a.(type) {
+	case time.Time:
+		if y, ok := asTime(b); ok {
+			return x.Compare(y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case !x:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	case string, []byte:
+		if y, ok := b.(time.Time); ok {
+			if x, ok := asTime(x); ok {
+				return x.Compare(y), true
+			}
+			return 0, false
+		}
+		if _, ok := b.(bool); ok {
+			return 0, false
+		}
+		return strings.Compare(toString(x), toString(b)), true
+	}
+	return 0, false
+}
+
+func asTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		return parseTime(t)
+	case []byte:
+		return parseTime(string(t))
+	}
+	return time.Time{}, false
+}
+
+func compareOrdered[T int64 | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// appendKey 将值编码后追加到 key 中，用于分组和去重。
+// 相等的整数和浮点数编码相同，编码带有类型和长度，不同的值序列不会得到相同的 key
+func appendKey(key []byte, v interface{}) []byte {
+	if n, ok := toInt64(v); ok {
+		key = append(key, 'i')
+		return strconv.AppendInt(append(key, ':'), n, 10)
+	}
+	switch x := v.(type) {
+	case nil:
+		return append(key, 'n', ':')
+	case float64, float32:
+		f, _ := toFloat64(x)
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			key = append(key, 'i')
+			return strconv.AppendInt(append(key, ':'), int64(f), 10)
+		}
+		key = append(key, 'f')
+		return strconv.AppendFloat(append(key, ':'), f, 'g', -1, 64)
+	case uint64:
+		key = append(key, 'u')
+		return strconv.AppendUint(append(key, ':'), x, 10)
+	case bool:
+		if x {
+			return append(key, 'b', '1')
+		}
+		return append(key, 'b', '0')
+	case time.Time:
+		key = append(key, 't')
+		return strconv.AppendInt(append(key, ':'), x.UnixNano(), 10)
+	}
+	s := toString(v)
+	key = append(key, 's')
+	key = strconv.AppendInt(key, int64(len(s)), 10)
+	return append(append(key, ':'), s...)
+}
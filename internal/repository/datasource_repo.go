@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"fmt"
 	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/secrets"
 	"gorm.io/gorm"
 )
 
@@ -12,18 +14,68 @@ type DataSourceRepository interface {
 	Update(ds *models.DataSource) error
 	Delete(id uint) error
 	GetByName(name string) (*models.DataSource, error)
+	// RotateCredentials 使用当前主密钥重新加密所有数据源（含已删除）的密码，返回处理的行数
+	RotateCredentials() (int, error)
 }
 
 type dataSourceRepository struct {
-	db *gorm.DB
+	db      *gorm.DB
+	keyring *secrets.Keyring
 }
 
-func NewDataSourceRepository(db *gorm.DB) DataSourceRepository {
-	return &dataSourceRepository{db: db}
+// NewDataSourceRepository 创建数据源仓储，密码在写入前加密、读取后解密
+func NewDataSourceRepository(db *gorm.DB, keyring *secrets.Keyring) DataSourceRepository {
+	return &dataSourceRepository{db: db, keyring: keyring}
+}
+
+// seal 返回用于落库的副本：明文密码被加密，Password 列置空
+func (r *dataSourceRepository) seal(ds *models.DataSource) (*models.DataSource, error) {
+	row := *ds
+	row.Password = ""
+	row.EncryptedPassword, row.EncryptedDataKey, row.KeyID = "", "", ""
+	if ds.Password == "" {
+		return &row, nil
+	}
+
+	env, err := r.keyring.Encrypt(ds.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt datasource password: %w", err)
+	}
+	row.EncryptedPassword = env.Ciphertext
+	row.EncryptedDataKey = env.DataKey
+	row.KeyID = env.KeyID
+	return &row, nil
+}
+
+// open 解密读出的密码，未加密的旧数据保持原样
+func (r *dataSourceRepository) open(ds *models.DataSource) error {
+	if ds.EncryptedPassword == "" {
+		return nil
+	}
+	password, err := r.keyring.Decrypt(secrets.Envelope{
+		KeyID:      ds.KeyID,
+		DataKey:    ds.EncryptedDataKey,
+		Ciphertext: ds.EncryptedPassword,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decrypt password of datasource %d: %w", ds.ID, err)
+	}
+	ds.Password = password
+	return nil
 }
 
 func (r *dataSourceRepository) Create(ds *models.DataSource) error {
-	return r.db.Create(ds).Error
+	row, err := r.seal(ds)
+	if err != nil {
+		return err
+	}
+	if err := r.db.Create(row).Error; err != nil {
+		return err
+	}
+	password := ds.Password
+	*ds = *row
+	ds.Password = password
+	return nil
 }
 
 func (r *dataSourceRepository) GetAll(offset, limit int) ([]models.DataSource, int64, error) {
@@ -35,6 +87,11 @@ func (r *dataSourceRepository) GetAll(offset, limit int) ([]models.DataSource, i
 	if err := r.db.Where("is_delete = ?", models.NOT_DELETE).Offset(offset).Limit(limit).Find(&dataSources).Error; err != nil {
 		return nil, total, err
 	}
+	for i := range dataSources {
+		if err := r.open(&dataSources[i]); err != nil {
+			return nil, total, err
+		}
+	}
 	return dataSources, total, nil
 }
 
@@ -43,11 +100,24 @@ func (r *dataSourceRepository) GetByID(id uint) (*models.DataSource, error) {
 	if err := r.db.Where("is_delete = ?", models.NOT_DELETE).First(&ds, id).Error; err != nil {
 		return nil, err
 	}
+	if err := r.open(&ds); err != nil {
+		return nil, err
+	}
 	return &ds, nil
 }
 
 func (r *dataSourceRepository) Update(ds *models.DataSource) error {
-	return r.db.Save(ds).Error
+	row, err := r.seal(ds)
+	if err != nil {
+		return err
+	}
+	if err := r.db.Save(row).Error; err != nil {
+		return err
+	}
+	password := ds.Password
+	*ds = *row
+	ds.Password = password
+	return nil
 }
 
 func (r *dataSourceRepository) Delete(id uint) error {
@@ -59,5 +129,44 @@ func (r *dataSourceRepository) GetByName(name string) (*models.DataSource, error
 	if err := r.db.Where("name = ? and is_delete = ?", name, models.NOT_DELETE).First(&ds).Error; err != nil {
 		return nil, err
 	}
+	if err := r.open(&ds); err != nil {
+		return nil, err
+	}
 	return &ds, nil
 }
+
+func (r *dataSourceRepository) RotateCredentials() (int, error) {
+	rotated := 0
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var dataSources []models.DataSource
+		if err := tx.Unscoped().Find(&dataSources).Error; err != nil {
+			return err
+		}
+		for i := range dataSources {
+			ds := &dataSources[i]
+			if ds.Password == "" && ds.EncryptedPassword == "" {
+				continue
+			}
+			if err := r.open(ds); err != nil {
+				return err
+			}
+			row, err := r.seal(ds)
+			if err != nil {
+				return err
+			}
+			// 只更新凭据列，不修改 updated_at，避免连接池误判配置变更
+			err = tx.Unscoped().Model(&models.DataSource{}).Where("id = ?", ds.ID).UpdateColumns(map[string]interface{}{
+				"password":           "",
+				"encrypted_password": row.EncryptedPassword,
+				"encrypted_data_key": row.EncryptedDataKey,
+				"key_id":             row.KeyID,
+			}).Error
+			if err != nil {
+				return err
+			}
+			rotated++
+		}
+		return nil
+	})
+	return rotated, err
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/secrets"
+	"gorm.io/gorm"
+)
+
+type DeliveryTargetRepository interface {
+	Create(target *models.DeliveryTarget) error
+	GetByID(id uint) (*models.DeliveryTarget, error)
+	// List 列出投递目标，reportID 为 0 时不按报表筛选
+	List(reportID uint, offset, limit int) ([]models.DeliveryTarget, int64, error)
+	// ListEnabled 列出报表启用的投递目标
+	ListEnabled(reportID uint) ([]models.DeliveryTarget, error)
+	Update(target *models.DeliveryTarget) error
+	Delete(id uint) error
+	// RotateSecrets 使用当前主密钥重新加密所有投递目标（含已删除）的签名密钥，返回处理的行数
+	RotateSecrets() (int, error)
+}
+
+type deliveryTargetRepository struct {
+	db      *gorm.DB
+	keyring *secrets.Keyring
+}
+
+// NewDeliveryTargetRepository 创建投递目标仓储，webhook 签名密钥在写入前加密、读取后解密
+func NewDeliveryTargetRepository(db *gorm.DB, keyring *secrets.Keyring) DeliveryTargetRepository {
+	return &deliveryTargetRepository{db: db, keyring: keyring}
+}
+
+// seal 加密 Config.Secret 并写入信封字段，Config 序列化时不包含明文
+func (r *deliveryTargetRepository) seal(target *models.DeliveryTarget) error {
+	target.EncryptedSecret, target.EncryptedDataKey, target.KeyID = "", "", ""
+	if target.Config.Secret == "" {
+		return nil
+	}
+	env, err := r.keyring.Encrypt(target.Config.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt delivery target secret: %w", err)
+	}
+	target.EncryptedSecret = env.Ciphertext
+	target.EncryptedDataKey = env.DataKey
+	target.KeyID = env.KeyID
+	return nil
+}
+
+func (r *deliveryTargetRepository) open(target *models.DeliveryTarget) error {
+	if target.EncryptedSecret == "" {
+		return nil
+	}
+	secret, err := r.keyring.Decrypt(secrets.Envelope{
+		KeyID:      target.KeyID,
+		DataKey:    target.EncryptedDataKey,
+		Ciphertext: target.EncryptedSecret,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decrypt secret of delivery target %d: %w", target.ID, err)
+	}
+	target.Config.Secret = secret
+	return nil
+}
+
+func (r *deliveryTargetRepository) Create(target *models.DeliveryTarget) error {
+	if err := r.seal(target); err != nil {
+		return err
+	}
+	return r.db.Create(target).Error
+}
+
+func (r *deliveryTargetRepository) GetByID(id uint) (*models.DeliveryTarget, error) {
+	var target models.DeliveryTarget
+	if err := r.db.First(&target, id).Error; err != nil {
+		return nil, err
+	}
+	if err := r.open(&target); err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+func (r *deliveryTargetRepository) List(reportID uint, offset, limit int) ([]models.DeliveryTarget, int64, error) {
+	var targets []models.DeliveryTarget
+	var total int64
+	query := r.db.Model(&models.DeliveryTarget{})
+	if reportID != 0 {
+		query = query.Where("report_id = ?", reportID)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("id").Offset(offset).Limit(limit).Find(&targets).Error; err != nil {
+		return nil, total, err
+	}
+	for i := range targets {
+		if err := r.open(&targets[i]); err != nil {
+			return nil, total, err
+		}
+	}
+	return targets, total, nil
+}
+
+func (r *deliveryTargetRepository) ListEnabled(reportID uint) ([]models.DeliveryTarget, error) {
+	var targets []models.DeliveryTarget
+	if err := r.db.Where("report_id = ? AND enabled = ?", reportID, true).Order("id").Find(&targets).Error; err != nil {
+		return nil, err
+	}
+	for i := range targets {
+		if err := r.open(&targets[i]); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+func (r *deliveryTargetRepository) Update(target *models.DeliveryTarget) error {
+	if err := r.seal(target); err != nil {
+		return err
+	}
+	return r.db.Save(target).Error
+}
+
+func (r *deliveryTargetRepository) Delete(id uint) error {
+	return r.db.Delete(&models.DeliveryTarget{}, id).Error
+}
+
+func (r *deliveryTargetRepository) RotateSecrets() (int, error) {
+	rotated := 0
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var targets []models.DeliveryTarget
+		if err := tx.Unscoped().Where("encrypted_secret <> ''").Find(&targets).Error; err != nil {
+			return err
+		}
+		for i := range targets {
+			target := &targets[i]
+			if err := r.open(target); err != nil {
+				return err
+			}
+			if err := r.seal(target); err != nil {
+				return err
+			}
+			err := tx.Unscoped().Model(&models.DeliveryTarget{}).Where("id = ?", target.ID).UpdateColumns(map[string]interface{}{
+				"encrypted_secret":   target.EncryptedSecret,
+				"encrypted_data_key": target.EncryptedDataKey,
+				"key_id":             target.KeyID,
+			}).Error
+			if err != nil {
+				return err
+			}
+			rotated++
+		}
+		return nil
+	})
+	return rotated, err
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"errors"
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ErrDeliveryStateConflict 投递状态已被其他流程修改，本次状态变更未生效
+var ErrDeliveryStateConflict = errors.New("report delivery state changed concurrently")
+
+type ReportDeliveryRepository interface {
+	// Create 批量创建投递并记录初始事件
+	Create(deliveries []models.ReportDelivery) error
+	// ListByJob 列出任务的投递及每次尝试的记录
+	ListByJob(jobID uint) ([]models.ReportDelivery, error)
+	// Transition 仅当投递当前处于 from 状态且仍由 delivery.ClaimedBy 领取时保存投递并记录事件，
+	// 否则返回 ErrDeliveryStateConflict
+	Transition(delivery *models.ReportDelivery, from models.DeliveryStatus, message string) error
+	// ClaimNext 将一个已到执行时间的 pending 投递原子地置为 sending，由 owner 持有租约至 leaseUntil，
+	// 没有可执行的投递时返回 nil
+	ClaimNext(now time.Time, owner string, leaseUntil time.Time) (*models.ReportDelivery, error)
+	// RenewLease 将 owner 持有的 sending 投递的租约延长至 leaseUntil。
+	// 投递已不是 sending 或已被其他实例接管时返回 false
+	RenewLease(id uint, owner string, leaseUntil time.Time) (bool, error)
+	// ClaimExpired 接管租约在 now 之前到期的 sending 投递（其发送实例已退出），由 owner 持有租约至 leaseUntil
+	ClaimExpired(now time.Time, owner string, leaseUntil time.Time) ([]models.ReportDelivery, error)
+	// ActiveJobIDs 列出仍有待投递或投递中记录的任务
+	ActiveJobIDs() ([]uint, error)
+}
+
+type reportDeliveryRepository struct {
+	db *gorm.DB
+}
+
+func NewReportDeliveryRepository(db *gorm.DB) ReportDeliveryRepository {
+	return &reportDeliveryRepository{db: db}
+}
+
+func (r *reportDeliveryRepository) Create(deliveries []models.ReportDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Events").Create(&deliveries).Error; err != nil {
+			return err
+		}
+		for i := range deliveries {
+			if err := recordDeliveryEvent(tx, &deliveries[i], ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *reportDeliveryRepository) ListByJob(jobID uint) ([]models.ReportDelivery, error) {
+	var deliveries []models.ReportDelivery
+	err := r.db.Where("job_id = ?", jobID).Order("id").
+		Preload("Events", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (r *reportDeliveryRepository) Transition(delivery *models.ReportDelivery, from models.DeliveryStatus, message string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// 升级前的投递 claimed_by 为 NULL
+		result := tx.Model(delivery).Where("status = ? AND COALESCE(claimed_by, '') = ?", from, delivery.ClaimedBy).
+			Select("*").Omit("created_at", "Events").Updates(delivery)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrDeliveryStateConflict
+		}
+		return recordDeliveryEvent(tx, delivery, message)
+	})
+}
+
+func (r *reportDeliveryRepository) ClaimNext(now time.Time, owner string, leaseUntil time.Time) (*models.ReportDelivery, error) {
+	var claimed *models.ReportDelivery
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var candidates []models.ReportDelivery
+		err := tx.Where("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", models.DeliveryPending, now).
+			Order("next_attempt_at, id").Limit(5).Find(&candidates).Error
+		if err != nil {
+			return err
+		}
+		for i := range candidates {
+			delivery := &candidates[i]
+			// 以状态为条件更新，其他 worker 已领取时影响行数为 0
+			result := tx.Model(&models.ReportDelivery{}).
+				Where("id = ? AND status = ?", delivery.ID, models.DeliveryPending).
+				Updates(map[string]interface{}{
+					"status":      models.DeliverySending,
+					"attempts":    gorm.Expr("attempts + 1"),
+					"updated_at":  now,
+					"claimed_by":  owner,
+					"lease_until": leaseUntil,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			delivery.Status = models.DeliverySending
+			delivery.Attempts++
+			delivery.UpdatedAt = now
+			delivery.ClaimedBy = owner
+			delivery.LeaseUntil = &leaseUntil
+			claimed = delivery
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func (r *reportDeliveryRepository) RenewLease(id uint, owner string, leaseUntil time.Time) (bool, error) {
+	result := r.db.Model(&models.ReportDelivery{}).
+		Where("id = ? AND status = ? AND claimed_by = ?", id, models.DeliverySending, owner).
+		UpdateColumn("lease_until", leaseUntil)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *reportDeliveryRepository) ClaimExpired(now time.Time, owner string, leaseUntil time.Time) ([]models.ReportDelivery, error) {
+	var candidates []models.ReportDelivery
+	// 升级前领取的投递没有租约，同样视为已到期
+	err := r.db.Where("status = ? AND (lease_until IS NULL OR lease_until < ?)", models.DeliverySending, now).
+		Order("id").Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	var claimed []models.ReportDelivery
+	for _, delivery := range candidates {
+		// 以读取时的租约为条件更新，其他实例已续约或接管时影响行数为 0
+		query := r.db.Model(&models.ReportDelivery{}).Where("id = ? AND status = ?", delivery.ID, models.DeliverySending)
+		if delivery.LeaseUntil == nil {
+			query = query.Where("lease_until IS NULL")
+		} else {
+			query = query.Where("lease_until = ?", *delivery.LeaseUntil)
+		}
+		result := query.UpdateColumns(map[string]interface{}{"claimed_by": owner, "lease_until": leaseUntil})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		delivery.ClaimedBy = owner
+		delivery.LeaseUntil = &leaseUntil
+		claimed = append(claimed, delivery)
+	}
+	return claimed, nil
+}
+
+func recordDeliveryEvent(tx *gorm.DB, delivery *models.ReportDelivery, message string) error {
+	return tx.Create(&models.ReportDeliveryEvent{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts,
+		Status:     delivery.Status,
+		Message:    message,
+	}).Error
+}
+
+func (r *reportDeliveryRepository) ActiveJobIDs() ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.ReportDelivery{}).
+		Where("status IN ?", []models.DeliveryStatus{models.DeliveryPending, models.DeliverySending}).
+		Distinct("job_id").Pluck("job_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"errors"
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ErrJobStateConflict 任务状态已被其他流程修改，本次状态变更未生效
+var ErrJobStateConflict = errors.New("report job state changed concurrently")
+
+// ReportJobFilter 任务列表的筛选条件，零值字段不参与筛选
+type ReportJobFilter struct {
+	Kind       models.JobKind
+	ReportID   uint
+	ScheduleID uint
+	Status     models.ReportJobStatus
+}
+
+type ReportJobRepository interface {
+	// Create 创建任务并记录初始状态事件
+	Create(job *models.ReportJob) error
+	GetByID(id uint) (*models.ReportJob, error)
+	Update(job *models.ReportJob) error
+	List(filter ReportJobFilter, offset, limit int) ([]models.ReportJob, int64, error)
+
+	// Transition 仅当任务当前处于 from 状态且仍由 job.ClaimedBy 领取时保存任务并记录状态事件，
+	// 否则返回 ErrJobStateConflict
+	Transition(job *models.ReportJob, from models.ReportJobStatus, message string) error
+	// ClaimNext 将一个已到执行时间的 pending 任务原子地置为 running，由 owner 持有租约至 leaseUntil，
+	// 没有可执行任务时返回 nil
+	ClaimNext(now time.Time, owner string, leaseUntil time.Time) (*models.ReportJob, error)
+	// RenewLease 将 owner 持有的 running 任务的租约延长至 leaseUntil。
+	// 任务已不是 running 或已被其他实例接管时返回 false
+	RenewLease(id uint, owner string, leaseUntil time.Time) (bool, error)
+	// ClaimExpired 接管租约在 now 之前到期的 running 任务（其执行实例已退出），由 owner 持有租约至 leaseUntil
+	ClaimExpired(now time.Time, owner string, leaseUntil time.Time) ([]models.ReportJob, error)
+	// UpdateProgress 更新执行中任务的已写出行数，不修改状态和 updated_at
+	UpdateProgress(id uint, rows int64) error
+	ListEvents(jobID uint) ([]models.ReportJobEvent, error)
+
+	// ReportIDs 列出有任务的报表（含已删除的报表）
+	ReportIDs() ([]uint, error)
+	// ListFinished 按结束先后倒序列出报表已结束（completed、failed、cancelled）的任务
+	ListFinished(reportID uint) ([]models.ReportJob, error)
+	// Purge 彻底删除已结束的任务及其状态事件和投递记录，返回删除的任务数
+	Purge(ids []uint) (int64, error)
+}
+
+type reportJobRepository struct {
+	db *gorm.DB
+}
+
+func NewReportJobRepository(db *gorm.DB) ReportJobRepository {
+	return &reportJobRepository{db: db}
+}
+
+func (r *reportJobRepository) Create(job *models.ReportJob) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		return recordEvent(tx, job, "", "")
+	})
+}
+
+func (r *reportJobRepository) GetByID(id uint) (*models.ReportJob, error) {
+	var job models.ReportJob
+	if err := r.db.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *reportJobRepository) Update(job *models.ReportJob) error {
+	return r.db.Save(job).Error
+}
+
+// List 按创建时间倒序列出生成任务
+func (r *reportJobRepository) List(filter ReportJobFilter, offset, limit int) ([]models.ReportJob, int64, error) {
+	var jobs []models.ReportJob
+	var total int64
+	query := r.db.Model(&models.ReportJob{})
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.ReportID != 0 {
+		query = query.Where("report_id = ?", filter.ReportID)
+	}
+	if filter.ScheduleID != 0 {
+		query = query.Where("schedule_id = ?", filter.ScheduleID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("id desc").Offset(offset).Limit(limit).Find(&jobs).Error; err != nil {
+		return nil, total, err
+	}
+	return jobs, total, nil
+}
+
+func (r *reportJobRepository) Transition(job *models.ReportJob, from models.ReportJobStatus, message string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// 升级前的任务 claimed_by 为 NULL
+		result := tx.Model(job).Where("status = ? AND COALESCE(claimed_by, '') = ?", from, job.ClaimedBy).
+			Select("*").Omit("created_at").Updates(job)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrJobStateConflict
+		}
+		return recordEvent(tx, job, from, message)
+	})
+}
+
+func (r *reportJobRepository) ClaimNext(now time.Time, owner string, leaseUntil time.Time) (*models.ReportJob, error) {
+	var claimed *models.ReportJob
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var candidates []models.ReportJob
+		err := tx.Where("status = ? AND (next_run_at IS NULL OR next_run_at <= ?)", models.JobStatusPending, now).
+			Order("next_run_at, id").Limit(5).Find(&candidates).Error
+		if err != nil {
+			return err
+		}
+		for i := range candidates {
+			job := &candidates[i]
+			// 以状态为条件更新，其他 worker 已领取时影响行数为 0
+			result := tx.Model(&models.ReportJob{}).
+				Where("id = ? AND status = ?", job.ID, models.JobStatusPending).
+				Updates(map[string]interface{}{
+					"status":       models.JobStatusRunning,
+					"attempts":     gorm.Expr("attempts + 1"),
+					"rows_written": 0,
+					"started_at":   now,
+					"updated_at":   now,
+					"claimed_by":   owner,
+					"lease_until":  leaseUntil,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			job.Status = models.JobStatusRunning
+			job.Attempts++
+			job.RowsWritten = 0
+			job.StartedAt = &now
+			job.UpdatedAt = now
+			job.ClaimedBy = owner
+			job.LeaseUntil = &leaseUntil
+			claimed = job
+			return recordEvent(tx, job, models.JobStatusPending, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func (r *reportJobRepository) RenewLease(id uint, owner string, leaseUntil time.Time) (bool, error) {
+	result := r.db.Model(&models.ReportJob{}).
+		Where("id = ? AND status = ? AND claimed_by = ?", id, models.JobStatusRunning, owner).
+		UpdateColumn("lease_until", leaseUntil)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *reportJobRepository) ClaimExpired(now time.Time, owner string, leaseUntil time.Time) ([]models.ReportJob, error) {
+	var candidates []models.ReportJob
+	// 升级前领取的任务没有租约，同样视为已到期
+	err := r.db.Where("status = ? AND (lease_until IS NULL OR lease_until < ?)", models.JobStatusRunning, now).
+		Order("id").Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	var claimed []models.ReportJob
+	for _, job := range candidates {
+		// 以读取时的租约为条件更新，其他实例已续约或接管时影响行数为 0
+		query := r.db.Model(&models.ReportJob{}).Where("id = ? AND status = ?", job.ID, models.JobStatusRunning)
+		if job.LeaseUntil == nil {
+			query = query.Where("lease_until IS NULL")
+		} else {
+			query = query.Where("lease_until = ?", *job.LeaseUntil)
+		}
+		result := query.UpdateColumns(map[string]interface{}{"claimed_by": owner, "lease_until": leaseUntil})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		job.ClaimedBy = owner
+		job.LeaseUntil = &leaseUntil
+		claimed = append(claimed, job)
+	}
+	return claimed, nil
+}
+
+func (r *reportJobRepository) UpdateProgress(id uint, rows int64) error {
+	return r.db.Model(&models.ReportJob{}).
+		Where("id = ? AND status = ?", id, models.JobStatusRunning).
+		UpdateColumn("rows_written", rows).Error
+}
+
+// ListEvents 按发生顺序列出任务的状态变更记录
+func (r *reportJobRepository) ListEvents(jobID uint) ([]models.ReportJobEvent, error) {
+	var events []models.ReportJobEvent
+	if err := r.db.Where("job_id = ?", jobID).Order("id").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func recordEvent(tx *gorm.DB, job *models.ReportJob, from models.ReportJobStatus, message string) error {
+	return tx.Create(&models.ReportJobEvent{
+		JobID:      job.ID,
+		FromStatus: from,
+		ToStatus:   job.Status,
+		Attempt:    job.Attempts,
+		Message:    message,
+	}).Error
+}
+
+// finishedStatuses 已结束、不会再被队列修改的任务状态
+var finishedStatuses = []models.ReportJobStatus{models.JobStatusCompleted, models.JobStatusFailed, models.JobStatusCancelled}
+
+func (r *reportJobRepository) ReportIDs() ([]uint, error) {
+	var ids []uint
+	if err := r.db.Model(&models.ReportJob{}).Distinct("report_id").Order("report_id").Pluck("report_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *reportJobRepository) ListFinished(reportID uint) ([]models.ReportJob, error) {
+	var jobs []models.ReportJob
+	// 重试的任务可能比后创建的任务结束得晚，按结束时间而不是 id 排序；旧版本的任务没有结束时间，以更新时间代替
+	err := r.db.Where("report_id = ? AND status IN ?", reportID, finishedStatuses).
+		Order("COALESCE(finished_at, updated_at) DESC, id DESC").Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *reportJobRepository) Purge(ids []uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	var purged int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// 只删除仍处于结束状态的任务，其余的不动
+		var finished []uint
+		if err := tx.Model(&models.ReportJob{}).Where("id IN ? AND status IN ?", ids, finishedStatuses).Pluck("id", &finished).Error; err != nil {
+			return err
+		}
+		if len(finished) == 0 {
+			return nil
+		}
+		ids = finished
+		deliveries := tx.Model(&models.ReportDelivery{}).Unscoped().Select("id").Where("job_id IN ?", ids)
+		if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&models.ReportDeliveryEvent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("job_id IN ?", ids).Delete(&models.ReportDelivery{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("job_id IN ?", ids).Delete(&models.ReportJobEvent{}).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("id IN ?", ids).Delete(&models.ReportJob{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	return purged, err
+}
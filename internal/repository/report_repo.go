@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/gorm"
+)
+
+type ReportRepository interface {
+	Create(report *models.Report) error
+	GetAll(offset, limit int) ([]models.Report, int64, error)
+	GetByID(id uint) (*models.Report, error)
+	Update(report *models.Report) error
+	Delete(id uint) error
+	GetByName(name string) (*models.Report, error)
+}
+
+type reportRepository struct {
+	db *gorm.DB
+}
+
+func NewReportRepository(db *gorm.DB) ReportRepository {
+	return &reportRepository{db: db}
+}
+
+func (r *reportRepository) Create(report *models.Report) error {
+	return r.db.Create(report).Error
+}
+
+func (r *reportRepository) GetAll(offset, limit int) ([]models.Report, int64, error) {
+	var reports []models.Report
+	var total int64
+	if err := r.db.Model(&models.Report{}).Where("is_delete = ?", models.NOT_DELETE).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := r.db.Where("is_delete = ?", models.NOT_DELETE).Order("id").Offset(offset).Limit(limit).Find(&reports).Error; err != nil {
+		return nil, total, err
+	}
+	return reports, total, nil
+}
+
+func (r *reportRepository) GetByID(id uint) (*models.Report, error) {
+	var report models.Report
+	if err := r.db.Where("is_delete = ?", models.NOT_DELETE).First(&report, id).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (r *reportRepository) Update(report *models.Report) error {
+	return r.db.Save(report).Error
+}
+
+func (r *reportRepository) Delete(id uint) error {
+	return r.db.Model(&models.Report{}).Where("id = ?", id).Update("is_delete", models.IS_DELETE).Error
+}
+
+func (r *reportRepository) GetByName(name string) (*models.Report, error) {
+	var report models.Report
+	if err := r.db.Where("name = ? and is_delete = ?", name, models.NOT_DELETE).First(&report).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
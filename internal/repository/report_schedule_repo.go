@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/foldn/bi-go/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ErrScheduleConflict 定时计划已被其他流程触发或修改，本次更新未生效
+var ErrScheduleConflict = errors.New("report schedule changed concurrently")
+
+type ReportScheduleRepository interface {
+	Create(schedule *models.ReportSchedule) error
+	GetByID(id uint) (*models.ReportSchedule, error)
+	// List 列出定时计划，reportID 为 0 时不按报表筛选
+	List(reportID uint, offset, limit int) ([]models.ReportSchedule, int64, error)
+	// Update 仅当计划的 Revision 未变化时保存修改，否则返回 ErrScheduleConflict
+	Update(schedule *models.ReportSchedule) error
+	Delete(id uint) error
+
+	// FindDue 列出已到触发时间的启用计划
+	FindDue(now time.Time, limit int) ([]models.ReportSchedule, error)
+	// Fire 在同一事务中创建任务并保存计划的触发结果，job 为 nil 时只保存计划。
+	// 计划已被其他实例触发或修改时返回 ErrScheduleConflict，任务不会被创建
+	Fire(schedule *models.ReportSchedule, job *models.ReportJob) error
+}
+
+type reportScheduleRepository struct {
+	db *gorm.DB
+}
+
+func NewReportScheduleRepository(db *gorm.DB) ReportScheduleRepository {
+	return &reportScheduleRepository{db: db}
+}
+
+func (r *reportScheduleRepository) Create(schedule *models.ReportSchedule) error {
+	return r.db.Create(schedule).Error
+}
+
+func (r *reportScheduleRepository) GetByID(id uint) (*models.ReportSchedule, error) {
+	var schedule models.ReportSchedule
+	if err := r.db.First(&schedule, id).Error; err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (r *reportScheduleRepository) List(reportID uint, offset, limit int) ([]models.ReportSchedule, int64, error) {
+	var schedules []models.ReportSchedule
+	var total int64
+	query := r.db.Model(&models.ReportSchedule{})
+	if reportID != 0 {
+		query = query.Where("report_id = ?", reportID)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("id").Offset(offset).Limit(limit).Find(&schedules).Error; err != nil {
+		return nil, total, err
+	}
+	return schedules, total, nil
+}
+
+func (r *reportScheduleRepository) Update(schedule *models.ReportSchedule) error {
+	return saveSchedule(r.db, schedule)
+}
+
+func (r *reportScheduleRepository) Delete(id uint) error {
+	return r.db.Delete(&models.ReportSchedule{}, id).Error
+}
+
+func (r *reportScheduleRepository) FindDue(now time.Time, limit int) ([]models.ReportSchedule, error) {
+	var schedules []models.ReportSchedule
+	err := r.db.Where("enabled = ? AND next_run_at <= ?", true, now).
+		Order("next_run_at, id").Limit(limit).Find(&schedules).Error
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (r *reportScheduleRepository) Fire(schedule *models.ReportSchedule, job *models.ReportJob) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if job != nil {
+			if err := tx.Create(job).Error; err != nil {
+				return err
+			}
+			if err := recordEvent(tx, job, "", fmt.Sprintf("由定时计划 %d 创建", schedule.ID)); err != nil {
+				return err
+			}
+			schedule.LastJobID = &job.ID
+		}
+		return saveSchedule(tx, schedule)
+	})
+}
+
+// saveSchedule 以 Revision 为条件保存计划并将 Revision 加一
+func saveSchedule(db *gorm.DB, schedule *models.ReportSchedule) error {
+	revision := schedule.Revision
+	schedule.Revision++
+	result := db.Model(schedule).Where("revision = ?", revision).Select("*").Omit("created_at").Updates(schedule)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = ErrScheduleConflict
+	}
+	if result.Error != nil {
+		schedule.Revision = revision
+		return result.Error
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/foldn/bi-go/internal/config"
+)
+
+const keySize = 32
+
+// ErrUnknownKey 密文使用的主密钥未在配置中提供
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Envelope 信封加密的结果：数据使用随机数据密钥加密，数据密钥再由主密钥加密
+type Envelope struct {
+	KeyID      string
+	DataKey    string
+	Ciphertext string
+}
+
+// Keyring 持有当前主密钥及用于解密旧数据的历史主密钥
+type Keyring struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// publishedKeys 曾随示例配置公开发布的主密钥，不能再作为当前主密钥，只能放在 PreviousKeys 中用于轮换
+var publishedKeys = map[string]bool{
+	"ZGV2LW9ubHktbWFzdGVyLWtleS1jaGFuZ2UtbWUhISE=": true,
+}
+
+// NewKeyring 根据安全配置创建密钥环，主密钥为 base64 编码的 32 字节 AES-256 密钥
+func NewKeyring(cfg config.SecurityConfig) (*Keyring, error) {
+	if cfg.MasterKey == "" {
+		return nil, errors.New("security.masterkey is not configured")
+	}
+	if publishedKeys[cfg.MasterKey] {
+		return nil, errors.New("security.masterkey is the publicly known example key; generate a new key, " +
+			"move the old one to security.previouskeys and run rotate-keys")
+	}
+	if cfg.MasterKeyID == "" {
+		return nil, errors.New("security.masterkeyid is not configured")
+	}
+
+	k := &Keyring{currentID: cfg.MasterKeyID, keys: make(map[string][]byte)}
+	for id, encoded := range cfg.PreviousKeys {
+		key, err := decodeKey(id, encoded)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = key
+	}
+	key, err := decodeKey(cfg.MasterKeyID, cfg.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[cfg.MasterKeyID] = key
+	return k, nil
+}
+
+func decodeKey(id, encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("master key %q is not valid base64: %w", id, err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key %q must be %d bytes, got %d", id, keySize, len(key))
+	}
+	return key, nil
+}
+
+// CurrentKeyID 当前用于加密的主密钥ID
+func (k *Keyring) CurrentKeyID() string {
+	return k.currentID
+}
+
+// Encrypt 使用新生成的数据密钥加密明文，并用当前主密钥包装数据密钥
+func (k *Keyring) Encrypt(plaintext string) (Envelope, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return Envelope{}, err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return Envelope{}, err
+	}
+	wrappedKey, err := seal(k.keys[k.currentID], dataKey)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		KeyID:      k.currentID,
+		DataKey:    base64.StdEncoding.EncodeToString(wrappedKey),
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+// Decrypt 使用信封中记录的主密钥解开数据密钥，再解密密文
+func (k *Keyring) Decrypt(env Envelope) (string, error) {
+	masterKey, ok := k.keys[env.KeyID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, env.KeyID)
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(env.DataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(masterKey, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// seal 使用 AES-GCM 加密，输出为 nonce || ciphertext
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,51 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/foldn/bi-go/internal/config"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestNewKeyringRequiresKey(t *testing.T) {
+	if _, err := NewKeyring(config.SecurityConfig{MasterKeyID: "v1"}); err == nil {
+		t.Fatal("keyring created without a master key")
+	}
+	if _, err := NewKeyring(config.SecurityConfig{MasterKeyID: "v1", MasterKey: "c2hvcnQ="}); err == nil {
+		t.Fatal("keyring created with a short master key")
+	}
+}
+
+func TestNewKeyringRejectsPublishedKey(t *testing.T) {
+	const published = "ZGV2LW9ubHktbWFzdGVyLWtleS1jaGFuZ2UtbWUhISE="
+	if _, err := NewKeyring(config.SecurityConfig{MasterKeyID: "dev", MasterKey: published}); err == nil {
+		t.Fatal("keyring created with the published example key")
+	}
+	// 旧密钥仍可作为历史密钥，用于轮换前解密已有的凭据
+	old, err := NewKeyring(config.SecurityConfig{MasterKeyID: "dev", MasterKey: newKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.keys["dev"], _ = base64.StdEncoding.DecodeString(published)
+	env, err := old.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewKeyring(config.SecurityConfig{MasterKeyID: "v1", MasterKey: newKey(t), PreviousKeys: map[string]string{"dev": published}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := k.Decrypt(env); err != nil || plain != "secret" {
+		t.Fatalf("got %q, %v", plain, err)
+	}
+}
@@ -3,18 +3,21 @@ package service
 import (
 	"errors"
 	"fmt"
+	"github.com/foldn/bi-go/internal/database"
 	"github.com/foldn/bi-go/internal/models"
 	"github.com/foldn/bi-go/internal/repository"
-	"gorm.io/driver/clickhouse"
-	"gorm.io/driver/postgres"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 	"log"
-	"os"
 	"time"
 )
 
+var (
+	// ErrDataSourceExists 数据源名称已被占用
+	ErrDataSourceExists = errors.New("datasource with this name already exists")
+	// ErrInvalidDataSourceParams 数据源的参数不合法，如 CSV 数据源的 otherParams 无法解析
+	ErrInvalidDataSourceParams = errors.New("invalid datasource parameters")
+)
+
 type DataSourceService interface {
 	CreateDataSource(input CreateDataSourceInput) (*models.DataSource, error)
 	GetDataSources(page, pageSize int) ([]models.DataSource, int64, error)
@@ -22,22 +25,31 @@ type DataSourceService interface {
 	UpdateDataSource(id uint, input UpdateDataSourceInput) (*models.DataSource, error)
 	DeleteDataSource(id uint) error
 
-	// Schema discovery methods - to be detailed in schema_service.go or here
-	GetDataSourceSchema(dataSourceID uint) (interface{}, error)
-	GetDataSourceEntitySchema(dataSourceID uint, entityName string) (interface{}, error)
+	// Schema discovery methods
+	GetDataSourceSchema(dataSourceID uint) (*DataSourceSchema, error)
+	GetDataSourceEntitySchema(dataSourceID uint, entityName string) (*EntitySchema, error)
+
+	// Connection tests
+	TestConnection(input CreateDataSourceInput) *database.ConnectionTestResult
+	TestDataSourceConnection(dataSourceID uint) (*database.ConnectionTestResult, error)
+
+	// Connection pool statistics
+	GetPoolStats() []database.PoolStats
+	GetDataSourcePoolStats(dataSourceID uint) (*database.PoolStats, error)
 }
 
 type dataSourceService struct {
-	repo repository.DataSourceRepository
+	repo        repository.DataSourceRepository
+	connections *database.ConnectionManager
 }
 
-func NewDataSourceService(repo repository.DataSourceRepository) DataSourceService {
-	return &dataSourceService{repo: repo}
+func NewDataSourceService(repo repository.DataSourceRepository, connections *database.ConnectionManager) DataSourceService {
+	return &dataSourceService{repo: repo, connections: connections}
 }
 
 type CreateDataSourceInput struct {
 	Name        string                `json:"name" binding:"required"`
-	Type        models.DataSourceType `json:"type" binding:"required,oneof=postgresql mysql csv"`
+	Type        models.DataSourceType `json:"type" binding:"required,oneof=postgresql mysql csv clickhouse sqlite"`
 	Host        string                `json:"host"`
 	Port        string                `json:"port"`
 	Username    string                `json:"username"`
@@ -46,19 +58,108 @@ type CreateDataSourceInput struct {
 	FilePath    string                `json:"filePath"`
 	OtherParams string                `json:"otherParams"`
 	Description string                `json:"description"`
+	// MaxRows 大于 0 时，报表查询必须带有不超过该值的 LIMIT
+	MaxRows         int      `json:"maxRows" binding:"min=0"`
+	ForbiddenTables []string `json:"forbiddenTables"`
 }
 
 type UpdateDataSourceInput struct {
-	Name        *string                `json:"name"` // Use pointers for optional updates
-	Type        *models.DataSourceType `json:"type" binding:"omitempty,oneof=postgresql mysql csv clickhouse sqlite"`
-	Host        *string                `json:"host"`
-	Port        *string                `json:"port"`
-	Username    *string                `json:"username"`
-	Password    *string                `json:"password"`
-	DBName      *string                `json:"dbName"`
-	FilePath    *string                `json:"filePath"`
-	OtherParams *string                `json:"otherParams"`
-	Description *string                `json:"description"`
+	Name            *string                `json:"name"` // Use pointers for optional updates
+	Type            *models.DataSourceType `json:"type" binding:"omitempty,oneof=postgresql mysql csv clickhouse sqlite"`
+	Host            *string                `json:"host"`
+	Port            *string                `json:"port"`
+	Username        *string                `json:"username"`
+	Password        *string                `json:"password"`
+	DBName          *string                `json:"dbName"`
+	FilePath        *string                `json:"filePath"`
+	OtherParams     *string                `json:"otherParams"`
+	Description     *string                `json:"description"`
+	MaxRows         *int                   `json:"maxRows" binding:"omitempty,min=0"`
+	ForbiddenTables *[]string              `json:"forbiddenTables"`
+}
+
+// DataSourceResponse 数据源的 API 响应，不包含密码，只通过 PasswordSet 表明是否已设置
+type DataSourceResponse struct {
+	ID              uint                  `json:"id"`
+	Name            string                `json:"name"`
+	Type            models.DataSourceType `json:"type"`
+	Host            string                `json:"host"`
+	Port            string                `json:"port"`
+	Username        string                `json:"username"`
+	PasswordSet     bool                  `json:"password_set"`
+	DBName          string                `json:"dbName"`
+	FilePath        string                `json:"filePath"`
+	OtherParams     string                `json:"otherParams"`
+	Description     string                `json:"description"`
+	MaxRows         int                   `json:"maxRows"`
+	ForbiddenTables []string              `json:"forbiddenTables"`
+	CreatedAt       time.Time             `json:"createdAt"`
+	UpdatedAt       time.Time             `json:"updatedAt"`
+}
+
+// NewDataSourceResponse 将数据源模型转换为响应结构
+func NewDataSourceResponse(ds *models.DataSource) DataSourceResponse {
+	forbiddenTables := ds.ForbiddenTables
+	if forbiddenTables == nil {
+		forbiddenTables = []string{}
+	}
+	return DataSourceResponse{
+		ID:              ds.ID,
+		Name:            ds.Name,
+		Type:            ds.Type,
+		Host:            ds.Host,
+		Port:            ds.Port,
+		Username:        ds.Username,
+		PasswordSet:     ds.Password != "" || ds.EncryptedPassword != "",
+		DBName:          ds.DBName,
+		FilePath:        ds.FilePath,
+		OtherParams:     ds.OtherParams,
+		Description:     ds.Description,
+		MaxRows:         ds.MaxRows,
+		ForbiddenTables: forbiddenTables,
+		CreatedAt:       ds.CreatedAt,
+		UpdatedAt:       ds.UpdatedAt,
+	}
+}
+
+// NewDataSourceResponses 批量转换数据源模型
+func NewDataSourceResponses(dataSources []models.DataSource) []DataSourceResponse {
+	responses := make([]DataSourceResponse, len(dataSources))
+	for i := range dataSources {
+		responses[i] = NewDataSourceResponse(&dataSources[i])
+	}
+	return responses
+}
+
+// DataSourceSchema 数据源的顶层结构
+type DataSourceSchema struct {
+	DataSourceID uint                  `json:"dataSourceId"`
+	Type         models.DataSourceType `json:"type"`
+	Entities     []database.Entity     `json:"entities"`
+}
+
+// EntitySchema 单个实体的列结构
+type EntitySchema struct {
+	DataSourceID uint `json:"dataSourceId"`
+	database.Entity
+	Columns []database.Column `json:"columns"`
+}
+
+func (input CreateDataSourceInput) toModel() *models.DataSource {
+	return &models.DataSource{
+		Name:            input.Name,
+		Type:            input.Type,
+		Host:            input.Host,
+		Port:            input.Port,
+		Username:        input.Username,
+		Password:        input.Password, // Remember security!
+		DBName:          input.DBName,
+		FilePath:        input.FilePath,
+		OtherParams:     input.OtherParams,
+		Description:     input.Description,
+		MaxRows:         input.MaxRows,
+		ForbiddenTables: input.ForbiddenTables,
+	}
 }
 
 func (s *dataSourceService) CreateDataSource(input CreateDataSourceInput) (*models.DataSource, error) {
@@ -68,20 +169,12 @@ func (s *dataSourceService) CreateDataSource(input CreateDataSourceInput) (*mode
 		return nil, fmt.Errorf("error checking existing datasource: %w", err)
 	}
 	if existing != nil {
-		return nil, errors.New("datasource with this name already exists")
+		return nil, ErrDataSourceExists
 	}
 
-	ds := &models.DataSource{
-		Name:        input.Name,
-		Type:        input.Type,
-		Host:        input.Host,
-		Port:        input.Port,
-		Username:    input.Username,
-		Password:    input.Password, // Remember security!
-		DBName:      input.DBName,
-		FilePath:    input.FilePath,
-		OtherParams: input.OtherParams,
-		Description: input.Description,
+	ds := input.toModel()
+	if err := checkParams(ds); err != nil {
+		return nil, err
 	}
 	if err := s.repo.Create(ds); err != nil {
 		return nil, err
@@ -89,6 +182,21 @@ func (s *dataSourceService) CreateDataSource(input CreateDataSourceInput) (*mode
 	return ds, nil
 }
 
+// checkParams 保存前检查不需要连接就能检查的参数
+func checkParams(ds *models.DataSource) error {
+	switch ds.Type {
+	case models.CSV:
+		if _, err := database.ParseCSVOptions(ds.OtherParams); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidDataSourceParams, err)
+		}
+	case models.PostgreSQL:
+		if _, err := database.ParsePostgresParams(ds.OtherParams); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidDataSourceParams, err)
+		}
+	}
+	return nil
+}
+
 func (s *dataSourceService) GetDataSources(page, pageSize int) ([]models.DataSource, int64, error) {
 	if page <= 0 {
 		page = 1
@@ -119,7 +227,7 @@ func (s *dataSourceService) UpdateDataSource(id uint, input UpdateDataSourceInpu
 				return nil, fmt.Errorf("error checking existing datasource: %w", err)
 			}
 			if existing != nil && existing.ID != id { // if another DS has this new name
-				return nil, errors.New("datasource with this name already exists")
+				return nil, ErrDataSourceExists
 			}
 		}
 		ds.Name = *input.Name
@@ -139,9 +247,10 @@ func (s *dataSourceService) UpdateDataSource(id uint, input UpdateDataSourceInpu
 	if input.Username != nil {
 		ds.Username = *input.Username
 	}
+	// 未提供 password 字段时保留原有密码
 	if input.Password != nil {
 		ds.Password = *input.Password
-	} // Security!
+	}
 	if input.Description != nil {
 		ds.Description = *input.Description
 	}
@@ -158,9 +267,23 @@ func (s *dataSourceService) UpdateDataSource(id uint, input UpdateDataSourceInpu
 		ds.OtherParams = *input.OtherParams
 	}
 
+	if input.MaxRows != nil {
+		ds.MaxRows = *input.MaxRows
+	}
+	if input.ForbiddenTables != nil {
+		ds.ForbiddenTables = *input.ForbiddenTables
+	}
+	if err := checkParams(ds); err != nil {
+		return nil, err
+	}
+
 	if err := s.repo.Update(ds); err != nil {
 		return nil, err
 	}
+	// 连接参数可能已变化，关闭旧连接池
+	if err := s.connections.Evict(id); err != nil {
+		log.Printf("failed to close connection pool of datasource %d: %v", id, err)
+	}
 	return ds, nil
 }
 
@@ -170,88 +293,89 @@ func (s *dataSourceService) DeleteDataSource(id uint) error {
 	if err != nil {
 		return err // handles gorm.ErrRecordNotFound appropriately
 	}
-	return s.repo.Delete(id)
+	if err := s.repo.Delete(id); err != nil {
+		return err
+	}
+	if err := s.connections.Evict(id); err != nil {
+		log.Printf("failed to close connection pool of datasource %d: %v", id, err)
+	}
+	return nil
 }
 
-// Placeholder for schema service methods - actual implementation is complex
-func (s *dataSourceService) GetDataSourceSchema(dataSourceID uint) (interface{}, error) {
-	// 1. Get DataSource config by dataSourceID using s.repo
-	// 2. Based on ds.Type, connect to the actual data source (NOT the metadata DB)
-	// 3. Fetch schema (tables for DBs, columns for CSVs)
-	// 4. Return formatted schema
+// GetDataSourceSchema 列出数据源中的表、视图或文件
+func (s *dataSourceService) GetDataSourceSchema(dataSourceID uint) (*DataSourceSchema, error) {
 	ds, err := s.repo.GetByID(dataSourceID)
 	if err != nil {
 		return nil, err
 	}
 
-	dataSourceType := ds.Type
-	switch dataSourceType {
-	case models.PostgreSQL:
-		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=Asia/Shanghai",
-			ds.Host, ds.Username, ds.Password, ds.DBName, ds.Port)
-
-		newLogger := logger.New(
-			log.New(os.Stdout, "\r\n", log.LstdFlags),
-			logger.Config{
-				SlowThreshold: time.Second,
-				LogLevel:      logger.Info, // Or logger.Silent for less noise
-				Colorful:      true,
-			},
-		)
-		postgresDb, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-			Logger: newLogger,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to connect to postgresDb: %w", err)
-		}
-		return postgresDb.Exec("SHOW DATABASES"), err
-	case models.ClickHouse:
-		dsn := fmt.Sprintf("tcp://%s:%s?username=%s&password=%s&database=%s",
-			ds.Host, ds.Port, ds.Username, ds.Password, ds.DBName)
-
-		newLogger := logger.New(
-			log.New(os.Stdout, "\r\n", log.LstdFlags),
-			logger.Config{
-				SlowThreshold: time.Second,
-				LogLevel:      logger.Info, // Or logger.Silent for less noise
-				Colorful:      true,
-			},
-		)
-		clickhouseDb, err := gorm.Open(clickhouse.Open(dsn), &gorm.Config{
-			Logger: newLogger,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to connect to clickhouse:%w ,%w", dsn, err)
+	driver, db, err := s.connections.Get(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := driver.ListEntities(db, ds)
+	if err != nil {
+		return nil, err
+	}
+	return &DataSourceSchema{DataSourceID: ds.ID, Type: ds.Type, Entities: entities}, nil
+}
+
+// GetDataSourceEntitySchema 返回指定实体的列结构，实体不存在时返回 database.ErrEntityNotFound
+func (s *dataSourceService) GetDataSourceEntitySchema(dataSourceID uint, entityName string) (*EntitySchema, error) {
+	ds, err := s.repo.GetByID(dataSourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, db, err := s.connections.Get(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := driver.ListEntities(db, ds)
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range entities {
+		if entity.Name != entityName {
+			continue
 		}
-		return clickhouseDb.Exec("show databases;"), err
-	case models.Sqlite:
-		dsn := fmt.Sprintf("tcp://%s:%s?username=%s&password=%s&database=%s",
-			ds.Host, ds.Port, ds.Username, ds.Password, ds.DBName)
-
-		newLogger := logger.New(
-			log.New(os.Stdout, "\r\n", log.LstdFlags),
-			logger.Config{
-				SlowThreshold: time.Second,
-				LogLevel:      logger.Info, // Or logger.Silent for less noise
-				Colorful:      true,
-			},
-		)
-		sqlLiteDb, err := gorm.Open(sqlite.Open("gorm.db"), &gorm.Config{
-			Logger: newLogger,
-		})
+		columns, err := driver.DescribeColumns(db, ds, entityName)
 		if err != nil {
-			return nil, fmt.Errorf("failed to connect to clickhouse:%w ,%w", dsn, err)
+			return nil, err
 		}
-		return sqlLiteDb.Exec("show databases;"), err
-	default:
+		return &EntitySchema{DataSourceID: ds.ID, Entity: entity, Columns: columns}, nil
+	}
+	return nil, database.ErrEntityNotFound
+}
+
+// TestConnection 测试尚未保存的数据源配置能否连接
+func (s *dataSourceService) TestConnection(input CreateDataSourceInput) *database.ConnectionTestResult {
+	return database.TestConnection(input.toModel(), s.connections.ConnectTimeout())
+}
+
+// TestDataSourceConnection 测试已保存的数据源能否连接，使用独立连接而非连接池
+func (s *dataSourceService) TestDataSourceConnection(dataSourceID uint) (*database.ConnectionTestResult, error) {
+	ds, err := s.repo.GetByID(dataSourceID)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("GetDataSourceSchema not implemented yet")
+	return database.TestConnection(ds, s.connections.ConnectTimeout()), nil
+}
+
+// GetPoolStats 返回所有已建立连接池的统计信息
+func (s *dataSourceService) GetPoolStats() []database.PoolStats {
+	return s.connections.Stats()
 }
 
-func (s *dataSourceService) GetDataSourceEntitySchema(dataSourceID uint, entityName string) (interface{}, error) {
-	// 1. Get DataSource config
-	// 2. Connect to actual data source
-	// 3. Fetch specific entity (table/CSV) schema (columns with types)
-	// 4. Return formatted schema
-	return nil, errors.New("GetDataSourceEntitySchema not implemented yet")
+// GetDataSourcePoolStats 返回指定数据源的连接池统计信息
+func (s *dataSourceService) GetDataSourcePoolStats(dataSourceID uint) (*database.PoolStats, error) {
+	ds, err := s.repo.GetByID(dataSourceID)
+	if err != nil {
+		return nil, err
+	}
+	stats := s.connections.PoolStats(ds.ID)
+	stats.Type = ds.Type
+	return &stats, nil
 }
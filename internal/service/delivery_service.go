@@ -0,0 +1,252 @@
+package service
+
+import (
+	"errors"
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/services"
+	"gorm.io/gorm"
+	"time"
+)
+
+var ErrInvalidDeliveryTarget = services.ErrInvalidDeliveryTarget
+
+type DeliveryService interface {
+	CreateTarget(input CreateDeliveryTargetInput) (*models.DeliveryTarget, error)
+	GetTargets(filter DeliveryTargetFilter, page, pageSize int) ([]models.DeliveryTarget, int64, error)
+	GetTargetByID(id uint) (*models.DeliveryTarget, error)
+	UpdateTarget(id uint, input UpdateDeliveryTargetInput) (*models.DeliveryTarget, error)
+	DeleteTarget(id uint) error
+	// GetJobDeliveries 列出报表任务的投递及每次尝试的记录
+	GetJobDeliveries(jobID uint) ([]models.ReportDelivery, error)
+}
+
+type deliveryService struct {
+	targets    repository.DeliveryTargetRepository
+	deliveries repository.ReportDeliveryRepository
+	reportRepo repository.ReportRepository
+	jobRepo    repository.ReportJobRepository
+	cfg        config.DeliveryConfig
+}
+
+func NewDeliveryService(targets repository.DeliveryTargetRepository, deliveries repository.ReportDeliveryRepository,
+	reportRepo repository.ReportRepository, jobRepo repository.ReportJobRepository, cfg config.DeliveryConfig) DeliveryService {
+	return &deliveryService{
+		targets:    targets,
+		deliveries: deliveries,
+		reportRepo: reportRepo,
+		jobRepo:    jobRepo,
+		cfg:        cfg,
+	}
+}
+
+// DeliveryTargetConfigInput 投递目标配置，Secret 只写不读
+type DeliveryTargetConfigInput struct {
+	models.DeliveryTargetConfig
+	// Secret webhook 签名密钥，修改时为空表示保留原密钥
+	Secret string `json:"secret"`
+}
+
+func (c DeliveryTargetConfigInput) toModel() models.DeliveryTargetConfig {
+	cfg := c.DeliveryTargetConfig
+	cfg.Secret = c.Secret
+	return cfg
+}
+
+type CreateDeliveryTargetInput struct {
+	ReportID uint   `json:"reportId" binding:"required"`
+	Name     string `json:"name"`
+	// Type email、webhook 或 directory
+	Type   models.DeliveryType       `json:"type" binding:"required"`
+	Config DeliveryTargetConfigInput `json:"config"`
+	// Enabled 为空时默认启用
+	Enabled *bool `json:"enabled"`
+}
+
+type UpdateDeliveryTargetInput struct {
+	Name *string `json:"name"`
+	// Config 整体替换原配置，Secret 为空时保留原密钥
+	Config  *DeliveryTargetConfigInput `json:"config"`
+	Enabled *bool                      `json:"enabled"`
+}
+
+// DeliveryTargetFilter 投递目标列表的查询条件
+type DeliveryTargetFilter struct {
+	ReportID uint `form:"reportId"`
+}
+
+// DeliveryTargetResponse 投递目标的 API 响应，不包含签名密钥
+type DeliveryTargetResponse struct {
+	ID        uint                        `json:"id"`
+	ReportID  uint                        `json:"reportId"`
+	Name      string                      `json:"name"`
+	Type      models.DeliveryType         `json:"type"`
+	Enabled   bool                        `json:"enabled"`
+	Config    models.DeliveryTargetConfig `json:"config"`
+	SecretSet bool                        `json:"secretSet"`
+	CreatedAt time.Time                   `json:"createdAt"`
+	UpdatedAt time.Time                   `json:"updatedAt"`
+}
+
+// NewDeliveryTargetResponse 将投递目标模型转换为响应结构
+func NewDeliveryTargetResponse(target *models.DeliveryTarget) DeliveryTargetResponse {
+	return DeliveryTargetResponse{
+		ID:        target.ID,
+		ReportID:  target.ReportID,
+		Name:      target.Name,
+		Type:      target.Type,
+		Enabled:   target.Enabled,
+		Config:    target.Config,
+		SecretSet: target.Config.Secret != "",
+		CreatedAt: target.CreatedAt,
+		UpdatedAt: target.UpdatedAt,
+	}
+}
+
+// NewDeliveryTargetResponses 批量转换投递目标模型
+func NewDeliveryTargetResponses(targets []models.DeliveryTarget) []DeliveryTargetResponse {
+	responses := make([]DeliveryTargetResponse, len(targets))
+	for i := range targets {
+		responses[i] = NewDeliveryTargetResponse(&targets[i])
+	}
+	return responses
+}
+
+// DeliveryEventResponse 一次投递尝试的记录
+type DeliveryEventResponse struct {
+	Attempt   int                   `json:"attempt"`
+	Status    models.DeliveryStatus `json:"status"`
+	Message   string                `json:"message,omitempty"`
+	CreatedAt time.Time             `json:"createdAt"`
+}
+
+// DeliveryResponse 报表任务投递的 API 响应
+type DeliveryResponse struct {
+	ID            uint                    `json:"id"`
+	JobID         uint                    `json:"jobId"`
+	TargetID      uint                    `json:"targetId"`
+	Type          models.DeliveryType     `json:"type"`
+	Destination   string                  `json:"destination"`
+	Status        models.DeliveryStatus   `json:"status"`
+	Error         string                  `json:"error,omitempty"`
+	Attempts      int                     `json:"attempts"`
+	MaxAttempts   int                     `json:"maxAttempts"`
+	NextAttemptAt *time.Time              `json:"nextAttemptAt,omitempty"`
+	DeliveredAt   *time.Time              `json:"deliveredAt,omitempty"`
+	Events        []DeliveryEventResponse `json:"events"`
+	CreatedAt     time.Time               `json:"createdAt"`
+	UpdatedAt     time.Time               `json:"updatedAt"`
+}
+
+// NewDeliveryResponses 批量转换投递模型
+func NewDeliveryResponses(deliveries []models.ReportDelivery) []DeliveryResponse {
+	responses := make([]DeliveryResponse, len(deliveries))
+	for i, d := range deliveries {
+		events := make([]DeliveryEventResponse, len(d.Events))
+		for j, e := range d.Events {
+			events[j] = DeliveryEventResponse{
+				Attempt:   e.Attempt,
+				Status:    e.Status,
+				Message:   e.Message,
+				CreatedAt: e.CreatedAt,
+			}
+		}
+		responses[i] = DeliveryResponse{
+			ID:            d.ID,
+			JobID:         d.JobID,
+			TargetID:      d.TargetID,
+			Type:          d.Type,
+			Destination:   d.Destination,
+			Status:        d.Status,
+			Error:         d.Error,
+			Attempts:      d.Attempts,
+			MaxAttempts:   d.MaxAttempts,
+			NextAttemptAt: d.NextAttemptAt,
+			DeliveredAt:   d.DeliveredAt,
+			Events:        events,
+			CreatedAt:     d.CreatedAt,
+			UpdatedAt:     d.UpdatedAt,
+		}
+	}
+	return responses
+}
+
+func (s *deliveryService) CreateTarget(input CreateDeliveryTargetInput) (*models.DeliveryTarget, error) {
+	if _, err := s.reportRepo.GetByID(input.ReportID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidReport
+		}
+		return nil, err
+	}
+	target := &models.DeliveryTarget{
+		ReportID: input.ReportID,
+		Name:     input.Name,
+		Type:     input.Type,
+		Enabled:  input.Enabled == nil || *input.Enabled,
+		Config:   input.Config.toModel(),
+	}
+	if err := services.ValidateDeliveryTarget(target, s.cfg); err != nil {
+		return nil, err
+	}
+	if err := s.targets.Create(target); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+func (s *deliveryService) GetTargets(filter DeliveryTargetFilter, page, pageSize int) ([]models.DeliveryTarget, int64, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+	offset := (page - 1) * pageSize
+	return s.targets.List(filter.ReportID, offset, pageSize)
+}
+
+func (s *deliveryService) GetTargetByID(id uint) (*models.DeliveryTarget, error) {
+	return s.targets.GetByID(id)
+}
+
+func (s *deliveryService) UpdateTarget(id uint, input UpdateDeliveryTargetInput) (*models.DeliveryTarget, error) {
+	target, err := s.targets.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if input.Name != nil {
+		target.Name = *input.Name
+	}
+	if input.Config != nil {
+		secret := target.Config.Secret
+		target.Config = input.Config.toModel()
+		if target.Config.Secret == "" {
+			target.Config.Secret = secret
+		}
+	}
+	if input.Enabled != nil {
+		target.Enabled = *input.Enabled
+	}
+	if err := services.ValidateDeliveryTarget(target, s.cfg); err != nil {
+		return nil, err
+	}
+	if err := s.targets.Update(target); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+func (s *deliveryService) DeleteTarget(id uint) error {
+	if _, err := s.targets.GetByID(id); err != nil {
+		return err
+	}
+	return s.targets.Delete(id)
+}
+
+func (s *deliveryService) GetJobDeliveries(jobID uint) ([]models.ReportDelivery, error) {
+	if _, err := s.jobRepo.GetByID(jobID); err != nil {
+		return nil, err
+	}
+	return s.deliveries.ListByJob(jobID)
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/pipeline"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/services"
+	"github.com/foldn/bi-go/internal/storage"
+	"gorm.io/gorm"
+)
+
+// 同步处理的默认限制
+const (
+	defaultSyncMaxRows = 1000
+	defaultSyncTimeout = 30 * time.Second
+)
+
+// 处理模式
+const (
+	ProcessModeAuto  = "auto"
+	ProcessModeSync  = "sync"
+	ProcessModeAsync = "async"
+)
+
+var (
+	ErrInvalidPipeline    = services.ErrInvalidPipeline
+	ErrSyncResultTooLarge = errors.New("result exceeds the synchronous row limit, submit with mode async")
+	ErrSyncTimeout        = errors.New("synchronous processing timed out, submit with mode async")
+	ErrNotProcessJob      = errors.New("job is not a data processing job")
+	ErrJobNotCompleted    = errors.New("job is not completed")
+)
+
+type ProcessService interface {
+	// Process 同步执行处理操作或创建异步任务，返回的结果和任务只有一个不为空
+	Process(ctx context.Context, input ProcessInput) (*ProcessOutcome, error)
+	// GetJobResult 分页读取已完成处理任务的结果
+	GetJobResult(ctx context.Context, jobID uint, page, pageSize int) (*ProcessResultPage, error)
+	// Explain 返回处理操作的执行计划，不执行查询
+	Explain(ctx context.Context, input ProcessInput) (*pipeline.Plan, error)
+}
+
+type processService struct {
+	jobRepo   repository.ReportJobRepository
+	dsRepo    repository.DataSourceRepository
+	processor *services.Processor
+	queue     *services.JobQueue
+	files     storage.Storage
+	cfg       config.ProcessConfig
+}
+
+func NewProcessService(jobRepo repository.ReportJobRepository, dsRepo repository.DataSourceRepository,
+	processor *services.Processor, queue *services.JobQueue, files storage.Storage, cfg config.ProcessConfig) ProcessService {
+	if cfg.SyncMaxRows <= 0 {
+		cfg.SyncMaxRows = defaultSyncMaxRows
+	}
+	if cfg.SyncTimeout <= 0 {
+		cfg.SyncTimeout = defaultSyncTimeout
+	}
+	return &processService{jobRepo: jobRepo, dsRepo: dsRepo, processor: processor, queue: queue, files: files, cfg: cfg}
+}
+
+type ProcessInput struct {
+	DataSourceID uint `json:"dataSourceId" binding:"required"`
+	// Entity 表、视图或文件名，可带 schema 前缀
+	Entity     string             `json:"entity" binding:"required"`
+	Operations []models.Operation `json:"operations"`
+	// Mode auto（默认）结果不超过 process.syncmaxrows 行且在 process.synctimeout 内完成时同步返回，
+	// 否则转为异步任务；sync 只同步执行；async 直接创建异步任务
+	Mode string `json:"mode" binding:"omitempty,oneof=auto sync async"`
+}
+
+// ProcessResult 同步处理的结果，Data 中每行的键按 Columns 的顺序排列
+type ProcessResult struct {
+	Columns []models.ResultColumn `json:"columns"`
+	Data    []json.RawMessage     `json:"data"`
+	Total   int                   `json:"total"`
+}
+
+// ProcessOutcome 处理请求的结果：同步完成时为 Result，转为异步任务时为 Job
+type ProcessOutcome struct {
+	Result *ProcessResult
+	Job    *models.ReportJob
+}
+
+// ProcessResultPage 处理任务结果的一页，Data 中的每行与结果文件中的 JSON 对象相同
+type ProcessResultPage struct {
+	Columns  []models.ResultColumn `json:"columns"`
+	Data     []json.RawMessage     `json:"data"`
+	Total    int64                 `json:"total"`
+	Page     int                   `json:"page"`
+	PageSize int                   `json:"pageSize"`
+}
+
+func (s *processService) Process(ctx context.Context, input ProcessInput) (*ProcessOutcome, error) {
+	pl, err := s.pipeline(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Mode == ProcessModeAsync {
+		// 异步任务提交前按实体的列检查操作，错误直接返回给调用方而不是留到任务失败
+		if _, err := s.processor.Check(ctx, pl); err != nil {
+			return nil, err
+		}
+		return s.enqueue(pl)
+	}
+	result, err := s.processSync(ctx, pl)
+	switch {
+	case err == nil:
+		return &ProcessOutcome{Result: result}, nil
+	case input.Mode == ProcessModeSync:
+		return nil, err
+	case errors.Is(err, ErrSyncResultTooLarge), errors.Is(err, ErrSyncTimeout):
+		// 结果较大或执行较慢，重新作为异步任务执行
+		return s.enqueue(pl)
+	default:
+		return nil, err
+	}
+}
+
+func (s *processService) Explain(ctx context.Context, input ProcessInput) (*pipeline.Plan, error) {
+	pl, err := s.pipeline(input)
+	if err != nil {
+		return nil, err
+	}
+	return s.processor.Explain(ctx, pl)
+}
+
+// pipeline 由请求构造处理操作并检查其结构和数据源
+func (s *processService) pipeline(input ProcessInput) (*models.Pipeline, error) {
+	operations := input.Operations
+	if operations == nil {
+		operations = []models.Operation{}
+	}
+	pl := &models.Pipeline{DataSourceID: input.DataSourceID, Entity: input.Entity, Operations: operations}
+	if err := pipeline.Validate(pl); err != nil {
+		return nil, err
+	}
+	// join、union 可以读取其他数据源的实体，用到的数据源都需存在
+	for _, id := range pipeline.DataSources(pl) {
+		if _, err := s.dsRepo.GetByID(id); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrInvalidDataSource
+			}
+			return nil, err
+		}
+	}
+	return pl, nil
+}
+
+func (s *processService) enqueue(pl *models.Pipeline) (*ProcessOutcome, error) {
+	job, err := s.queue.EnqueueProcess(pl)
+	if err != nil {
+		return nil, err
+	}
+	return &ProcessOutcome{Job: job}, nil
+}
+
+// processSync 在 process.synctimeout 内执行并读取不超过 process.syncmaxrows 行的结果
+func (s *processService) processSync(ctx context.Context, pl *models.Pipeline) (*ProcessResult, error) {
+	syncCtx, cancel := context.WithTimeout(ctx, s.cfg.SyncTimeout)
+	defer cancel()
+	// 请求本身被取消时保留原错误，只有同步执行超时才转换为 ErrSyncTimeout
+	timedOut := func(err error) error {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return ErrSyncTimeout
+		}
+		return err
+	}
+
+	rows, err := s.processor.Open(syncCtx, pl)
+	if err != nil {
+		return nil, timedOut(err)
+	}
+	it := services.NewResultIterator(rows)
+	defer it.Close()
+
+	result := &ProcessResult{Columns: services.ResultColumns(it), Data: []json.RawMessage{}}
+	for it.Next() {
+		if len(result.Data) == s.cfg.SyncMaxRows {
+			return nil, ErrSyncResultTooLarge
+		}
+		row, err := services.MarshalRow(it.Columns(), it.Row())
+		if err != nil {
+			return nil, err
+		}
+		result.Data = append(result.Data, row)
+	}
+	if err := it.Err(); err != nil {
+		if syncCtx.Err() != nil {
+			err = syncCtx.Err()
+		}
+		return nil, timedOut(err)
+	}
+	result.Total = len(result.Data)
+	return result, nil
+}
+
+func (s *processService) GetJobResult(ctx context.Context, jobID uint, page, pageSize int) (*ProcessResultPage, error) {
+	job, err := s.jobRepo.GetByID(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Kind != models.JobKindProcess {
+		return nil, ErrNotProcessJob
+	}
+	if job.Status != models.JobStatusCompleted {
+		return nil, fmt.Errorf("%w: job is %s", ErrJobNotCompleted, job.Status)
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+
+	body, err := services.OpenReportFile(ctx, s.files, job)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrJobFileMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := readLines(bufio.NewReader(body), (page-1)*pageSize, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	columns := job.ResultColumns
+	if columns == nil {
+		columns = []models.ResultColumn{}
+	}
+	return &ProcessResultPage{Columns: columns, Data: data, Total: job.RowsWritten, Page: page, PageSize: pageSize}, nil
+}
+
+// readLines 跳过 ndjson 的前 offset 行，读取之后的最多 limit 行
+func readLines(r *bufio.Reader, offset, limit int) ([]json.RawMessage, error) {
+	lines := []json.RawMessage{}
+	for n := 0; len(lines) < limit; n++ {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 && n >= offset {
+			lines = append(lines, json.RawMessage(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/pipeline"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/secrets"
+	"github.com/foldn/bi-go/internal/services"
+	"github.com/foldn/bi-go/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testProcess 测试用的处理服务和它使用的数据源、任务队列
+type testProcess struct {
+	ProcessService
+	ds    *models.DataSource
+	jobs  repository.ReportJobRepository
+	queue *services.JobQueue
+}
+
+// newTestProcessService 创建使用 SQLite 元数据库的处理服务，数据源的 orders 表有两行。
+// 任务队列不启动 worker
+func newTestProcessService(t *testing.T, cfg config.ProcessConfig) *testProcess {
+	t.Helper()
+	open := func(path string) *gorm.DB {
+		db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if sqlDB, err := db.DB(); err == nil {
+				sqlDB.Close()
+			}
+		})
+		return db
+	}
+	sourcePath := filepath.Join(t.TempDir(), "source.db")
+	source := open(sourcePath)
+	if err := source.Exec("CREATE TABLE orders (id INTEGER PRIMARY KEY, region TEXT, amount REAL)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Exec("INSERT INTO orders (region, amount) VALUES ('east', 10), ('west', 20.5)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	db := open(filepath.Join(t.TempDir(), "meta.db"))
+	if err := db.AutoMigrate(&models.DataSource{}, &models.ReportJob{}, &models.ReportJobEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	keyring, err := secrets.NewKeyring(config.SecurityConfig{MasterKeyID: "test", MasterKey: base64.StdEncoding.EncodeToString(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataSources := repository.NewDataSourceRepository(db, keyring)
+	ds := &models.DataSource{Name: "source", Type: models.Sqlite, FilePath: sourcePath}
+	if err := dataSources.Create(ds); err != nil {
+		t.Fatal(err)
+	}
+
+	connections := database.NewConnectionManager(config.PoolConfig{})
+	t.Cleanup(func() { connections.Close() })
+	files := storage.NewLocal(t.TempDir())
+	jobs := repository.NewReportJobRepository(db)
+	processor := services.NewProcessor(dataSources, connections, files, config.ProcessConfig{})
+	queue := services.NewJobQueue(jobs, nil, processor, nil, config.QueueConfig{PollInterval: 10 * time.Millisecond})
+	return &testProcess{NewProcessService(jobs, dataSources, processor, queue, files, cfg), ds, jobs, queue}
+}
+
+func TestProcessSync(t *testing.T) {
+	s := newTestProcessService(t, config.ProcessConfig{})
+	out, err := s.Process(context.Background(), ProcessInput{DataSourceID: s.ds.ID, Entity: "orders", Operations: []models.Operation{
+		{Type: models.OpFilter, Conditions: []models.FilterCondition{{Column: "amount", Operator: "gt", Value: 15.0}}},
+		{Type: models.OpSelect, Columns: []string{"region", "amount"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Job != nil || out.Result == nil || out.Result.Total != 1 {
+		t.Fatalf("got %+v, want a synchronous result with one row", out)
+	}
+	if row := string(out.Result.Data[0]); row != `{"region":"west","amount":20.5}` {
+		t.Errorf("got row %s", row)
+	}
+}
+
+func TestProcessFallsBackToAsync(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.ProcessConfig
+		err  error
+	}{
+		{"too many rows", config.ProcessConfig{SyncMaxRows: 1}, ErrSyncResultTooLarge},
+		{"timeout", config.ProcessConfig{SyncTimeout: time.Nanosecond}, ErrSyncTimeout},
+	}
+	for _, tt := range tests {
+		s := newTestProcessService(t, tt.cfg)
+		input := ProcessInput{DataSourceID: s.ds.ID, Entity: "orders"}
+
+		input.Mode = ProcessModeSync
+		if _, err := s.Process(context.Background(), input); !errors.Is(err, tt.err) {
+			t.Errorf("%s: sync mode got %v, want %v", tt.name, err, tt.err)
+		}
+
+		for _, mode := range []string{"", ProcessModeAuto} {
+			input.Mode = mode
+			out, err := s.Process(context.Background(), input)
+			if err != nil {
+				t.Fatalf("%s: mode %q: %v", tt.name, mode, err)
+			}
+			if out.Result != nil || out.Job == nil {
+				t.Fatalf("%s: mode %q: got %+v, want a job", tt.name, mode, out)
+			}
+			job, err := s.jobs.GetByID(out.Job.ID)
+			if err != nil || job.Kind != models.JobKindProcess || job.Status != models.JobStatusPending {
+				t.Errorf("%s: mode %q: got job %+v, %v", tt.name, mode, job, err)
+			}
+		}
+	}
+}
+
+func TestProcessAsync(t *testing.T) {
+	s := newTestProcessService(t, config.ProcessConfig{})
+	out, err := s.Process(context.Background(), ProcessInput{DataSourceID: s.ds.ID, Entity: "orders", Mode: ProcessModeAsync})
+	if err != nil || out.Job == nil || out.Result != nil {
+		t.Fatalf("got %+v, %v, want a job", out, err)
+	}
+}
+
+func TestProcessInvalidInput(t *testing.T) {
+	s := newTestProcessService(t, config.ProcessConfig{})
+	limit := models.Operation{Type: models.OpLimit, Limit: 1}
+	tests := []struct {
+		name  string
+		input ProcessInput
+		index int // 出错操作的位置，-1 表示不是操作的错误
+		err   error
+	}{
+		{"invalid operation", ProcessInput{DataSourceID: s.ds.ID, Entity: "orders",
+			Operations: []models.Operation{limit, {Type: models.OpSort}}}, 1, ErrInvalidPipeline},
+		{"unknown column", ProcessInput{DataSourceID: s.ds.ID, Entity: "orders",
+			Operations: []models.Operation{limit, {Type: models.OpSelect, Columns: []string{"price"}}}}, 1, ErrInvalidPipeline},
+		{"unknown column async", ProcessInput{DataSourceID: s.ds.ID, Entity: "orders", Mode: ProcessModeAsync,
+			Operations: []models.Operation{{Type: models.OpSelect, Columns: []string{"price"}}}}, 0, ErrInvalidPipeline},
+		{"missing data source", ProcessInput{DataSourceID: s.ds.ID + 1, Entity: "orders"}, -1, ErrInvalidDataSource},
+		{"missing joined data source", ProcessInput{DataSourceID: s.ds.ID, Entity: "orders", Operations: []models.Operation{
+			{Type: models.OpUnion, DataSourceID: s.ds.ID + 1, Entity: "orders"},
+		}}, -1, ErrInvalidDataSource},
+	}
+	for _, tt := range tests {
+		_, err := s.Process(context.Background(), tt.input)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		var opErr *pipeline.OperationError
+		if tt.index >= 0 && (!errors.As(err, &opErr) || opErr.Index != tt.index) {
+			t.Errorf("%s: got %v, want an error for operations[%d]", tt.name, err, tt.index)
+		}
+	}
+}
+
+func TestGetJobResult(t *testing.T) {
+	s := newTestProcessService(t, config.ProcessConfig{})
+	if err := s.queue.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.queue.Stop()
+	out, err := s.Process(context.Background(), ProcessInput{DataSourceID: s.ds.ID, Entity: "orders", Mode: ProcessModeAsync,
+		Operations: []models.Operation{{Type: models.OpSelect, Columns: []string{"region", "id", "amount"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := s.jobs.GetByID(out.Job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == models.JobStatusCompleted {
+			break
+		}
+		if job.Status == models.JobStatusFailed || time.Now().After(deadline) {
+			t.Fatalf("job is %s: %s", job.Status, job.Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	page, err := s.GetJobResult(context.Background(), out.Job.ID, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var columns []string
+	for _, c := range page.Columns {
+		columns = append(columns, c.Name)
+	}
+	// 每行的键按结果列的顺序排列
+	if len(page.Data) != 1 || string(page.Data[0]) != `{"region":"west","id":2,"amount":20.5}` || page.Total != 2 ||
+		!reflect.DeepEqual(columns, []string{"region", "id", "amount"}) {
+		t.Errorf("got columns %v, rows %s, total %d", columns, page.Data, page.Total)
+	}
+}
@@ -0,0 +1,538 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/services"
+	"github.com/foldn/bi-go/internal/sqlguard"
+	"github.com/foldn/bi-go/internal/storage"
+	"github.com/foldn/bi-go/pkg/utils"
+	"gorm.io/gorm"
+	"io"
+	"time"
+)
+
+// 预签名下载地址的默认有效期
+const defaultPresignExpiry = 15 * time.Minute
+
+var (
+	ErrReportExists      = errors.New("report with this name already exists")
+	ErrInvalidDataSource = errors.New("invalid datasource id")
+	ErrUnsupportedFormat = errors.New("unsupported report format")
+	ErrJobNotInReport    = errors.New("job does not belong to this report")
+	ErrJobNotCancellable = services.ErrJobNotCancellable
+	ErrInvalidLayout     = services.ErrInvalidLayout
+	ErrInvalidParameter  = services.ErrInvalidParameter
+	ErrUnsafeQuery       = sqlguard.ErrUnsafeQuery
+	ErrJobFileMissing    = errors.New("job output file is missing")
+	ErrInvalidRetention  = services.ErrInvalidRetention
+)
+
+type ReportService interface {
+	CreateReport(input CreateReportInput) (*models.Report, error)
+	GetReports(page, pageSize int) ([]models.Report, int64, error)
+	GetReportByID(id uint) (*models.Report, error)
+	UpdateReport(id uint, input UpdateReportInput) (*models.Report, error)
+	DeleteReport(id uint) error
+
+	// Report generation
+	GenerateReport(reportID uint, input GenerateReportInput) (*models.ReportJob, error)
+	GetReportJobs(reportID uint, page, pageSize int) ([]models.ReportJob, int64, error)
+	GetReportJob(reportID, jobID uint) (*models.ReportJob, error)
+
+	// Jobs across all reports
+	GetJobs(filter JobFilter, page, pageSize int) ([]models.ReportJob, int64, error)
+	GetJobByID(id uint) (*models.ReportJob, error)
+	GetJobEvents(id uint) ([]models.ReportJobEvent, error)
+	CancelJob(id uint) (*models.ReportJob, error)
+	// OpenJobFile 返回已完成任务文件的下载方式，文件已不存在时返回 ErrJobFileMissing
+	OpenJobFile(ctx context.Context, job *models.ReportJob) (*JobFile, error)
+}
+
+type reportService struct {
+	repo    repository.ReportRepository
+	jobRepo repository.ReportJobRepository
+	dsRepo  repository.DataSourceRepository
+	queue   *services.JobQueue
+	files   storage.Storage
+	cfg     config.StorageConfig
+}
+
+func NewReportService(repo repository.ReportRepository, jobRepo repository.ReportJobRepository,
+	dsRepo repository.DataSourceRepository, queue *services.JobQueue, files storage.Storage, cfg config.StorageConfig) ReportService {
+	if cfg.PresignExpiry <= 0 {
+		cfg.PresignExpiry = defaultPresignExpiry
+	}
+	return &reportService{repo: repo, jobRepo: jobRepo, dsRepo: dsRepo, queue: queue, files: files, cfg: cfg}
+}
+
+// JobFile 任务文件的下载方式：URL 不为空时重定向到对象存储的预签名地址，否则由服务转发 Body 的内容
+type JobFile struct {
+	URL  string
+	Body io.ReadCloser
+}
+
+type CreateReportInput struct {
+	Name         string   `json:"name" binding:"required"`
+	Description  string   `json:"description"`
+	DataSourceID uint     `json:"dataSourceId" binding:"required"`
+	Query        string   `json:"query" binding:"required"`
+	Columns      []string `json:"columns"`
+	Timeout      int      `json:"timeout" binding:"min=0"` // 执行超时（秒），0 表示使用全局超时
+	// Layout html、pdf 格式的版式，其中的模板在保存时校验
+	Layout models.ReportLayout `json:"layout"`
+	// Parameters 查询参数定义，查询中以 :name 引用
+	Parameters []models.ReportParameter `json:"parameters"`
+	// Retention 任务和文件的保留策略，未设置的项使用全局配置
+	Retention models.ReportRetention `json:"retention"`
+}
+
+type UpdateReportInput struct {
+	Name         *string                   `json:"name"`
+	Description  *string                   `json:"description"`
+	DataSourceID *uint                     `json:"dataSourceId"`
+	Query        *string                   `json:"query"`
+	Columns      *[]string                 `json:"columns"`
+	Timeout      *int                      `json:"timeout" binding:"omitempty,min=0"`
+	Layout       *models.ReportLayout      `json:"layout"`
+	Parameters   *[]models.ReportParameter `json:"parameters"`
+	Retention    *models.ReportRetention   `json:"retention"`
+}
+
+// JobFilter 任务列表的查询条件
+type JobFilter struct {
+	Kind       models.JobKind         `form:"kind" binding:"omitempty,oneof=report process"`
+	ReportID   uint                   `form:"reportId"`
+	ScheduleID uint                   `form:"scheduleId"`
+	Status     models.ReportJobStatus `form:"status" binding:"omitempty,oneof=pending running completed failed cancelled"`
+}
+
+type GenerateReportInput struct {
+	Format string `json:"format" binding:"required"`
+	// Parameters 参数值，未传入的参数使用默认值
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// ReportResponse 报表定义的 API 响应
+type ReportResponse struct {
+	ID           uint                     `json:"id"`
+	Name         string                   `json:"name"`
+	Description  string                   `json:"description"`
+	DataSourceID uint                     `json:"dataSourceId"`
+	Query        string                   `json:"query"`
+	Columns      []string                 `json:"columns"`
+	Timeout      int                      `json:"timeout"`
+	Layout       models.ReportLayout      `json:"layout"`
+	Parameters   []models.ReportParameter `json:"parameters"`
+	Retention    models.ReportRetention   `json:"retention"`
+	CreatedAt    time.Time                `json:"createdAt"`
+	UpdatedAt    time.Time                `json:"updatedAt"`
+}
+
+// NewReportResponse 将报表模型转换为响应结构
+func NewReportResponse(report *models.Report) ReportResponse {
+	columns := report.Columns
+	if columns == nil {
+		columns = []string{}
+	}
+	parameters := report.Parameters
+	if parameters == nil {
+		parameters = []models.ReportParameter{}
+	}
+	return ReportResponse{
+		ID:           report.ID,
+		Name:         report.Name,
+		Description:  report.Description,
+		DataSourceID: report.DataSourceID,
+		Query:        report.Query,
+		Columns:      columns,
+		Timeout:      report.Timeout,
+		Layout:       report.Layout,
+		Parameters:   parameters,
+		Retention:    report.Retention,
+		CreatedAt:    report.CreatedAt,
+		UpdatedAt:    report.UpdatedAt,
+	}
+}
+
+// NewReportResponses 批量转换报表模型
+func NewReportResponses(reports []models.Report) []ReportResponse {
+	responses := make([]ReportResponse, len(reports))
+	for i := range reports {
+		responses[i] = NewReportResponse(&reports[i])
+	}
+	return responses
+}
+
+// ReportJobResponse 报表生成任务或数据处理任务的 API 响应，不暴露服务器上的文件路径
+type ReportJobResponse struct {
+	ID          uint                   `json:"id"`
+	Kind        models.JobKind         `json:"kind"`
+	ReportID    uint                   `json:"reportId,omitempty"`
+	Status      models.ReportJobStatus `json:"status"`
+	Format      string                 `json:"format"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	ScheduleID  *uint                  `json:"scheduleId,omitempty"`
+	Pipeline    *models.Pipeline       `json:"pipeline,omitempty"`
+	Columns     []models.ResultColumn  `json:"columns,omitempty"` // 处理任务结果的列
+	Error       string                 `json:"error,omitempty"`
+	RowsWritten int64                  `json:"rowsWritten"`
+	FileSize    int64                  `json:"fileSize,omitempty"`
+	Checksum    string                 `json:"checksum,omitempty"` // 文件内容的 SHA-256
+	Attempts    int                    `json:"attempts"`
+	MaxAttempts int                    `json:"maxAttempts"`
+	NextRunAt   *time.Time             `json:"nextRunAt,omitempty"`
+	StartedAt   *time.Time             `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time             `json:"finishedAt,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt"`
+	UpdatedAt   time.Time              `json:"updatedAt"`
+}
+
+// NewReportJobResponse 将任务模型转换为响应结构
+func NewReportJobResponse(job *models.ReportJob) ReportJobResponse {
+	resp := ReportJobResponse{
+		ID:          job.ID,
+		Kind:        job.Kind,
+		ReportID:    job.ReportID,
+		Status:      job.Status,
+		Format:      job.Format,
+		Parameters:  job.Parameters,
+		ScheduleID:  job.ScheduleID,
+		Pipeline:    job.Pipeline,
+		Columns:     job.ResultColumns,
+		Error:       job.Error,
+		RowsWritten: job.RowsWritten,
+		FileSize:    job.FileSize,
+		Checksum:    job.Checksum,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		StartedAt:   job.StartedAt,
+		FinishedAt:  job.FinishedAt,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   job.UpdatedAt,
+	}
+	// 只有等待重试的任务才有意义
+	if job.Status == models.JobStatusPending {
+		resp.NextRunAt = job.NextRunAt
+	}
+	return resp
+}
+
+// NewReportJobResponses 批量转换任务模型
+func NewReportJobResponses(jobs []models.ReportJob) []ReportJobResponse {
+	responses := make([]ReportJobResponse, len(jobs))
+	for i := range jobs {
+		responses[i] = NewReportJobResponse(&jobs[i])
+	}
+	return responses
+}
+
+// JobFileName 下载任务文件时使用的文件名
+func JobFileName(job *models.ReportJob) string {
+	if job.Kind == models.JobKindProcess {
+		return fmt.Sprintf("process_%d.%s", job.ID, job.Format)
+	}
+	return utils.DownloadFileName(job.ReportID, job.ID, job.Format)
+}
+
+// JobStatusResponse 任务状态的简要响应，任务完成后给出读取结果的地址
+type JobStatusResponse struct {
+	ID          uint                   `json:"id"`
+	Kind        models.JobKind         `json:"kind"`
+	Status      models.ReportJobStatus `json:"status"`
+	RowsWritten int64                  `json:"rowsWritten"`
+	Error       string                 `json:"error,omitempty"`
+	FinishedAt  *time.Time             `json:"finishedAt,omitempty"`
+	// ResultURL 分页读取处理结果的地址，只有已完成的处理任务才有
+	ResultURL string `json:"resultUrl,omitempty"`
+	// DownloadURL 下载任务文件的地址，只有已完成的任务才有
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
+
+// NewJobStatusResponse 将任务模型转换为状态响应
+func NewJobStatusResponse(job *models.ReportJob) JobStatusResponse {
+	resp := JobStatusResponse{
+		ID:          job.ID,
+		Kind:        job.Kind,
+		Status:      job.Status,
+		RowsWritten: job.RowsWritten,
+		Error:       job.Error,
+		FinishedAt:  job.FinishedAt,
+	}
+	if job.Status == models.JobStatusCompleted {
+		resp.DownloadURL = fmt.Sprintf("/api/v1/jobs/%d/download", job.ID)
+		if job.Kind == models.JobKindProcess {
+			resp.ResultURL = fmt.Sprintf("/api/v1/jobs/%d/result", job.ID)
+		}
+	}
+	return resp
+}
+
+// ReportJobEventResponse 任务状态变更记录的 API 响应
+type ReportJobEventResponse struct {
+	FromStatus models.ReportJobStatus `json:"fromStatus,omitempty"`
+	ToStatus   models.ReportJobStatus `json:"toStatus"`
+	Attempt    int                    `json:"attempt"`
+	Message    string                 `json:"message,omitempty"`
+	CreatedAt  time.Time              `json:"createdAt"`
+}
+
+// NewReportJobEventResponses 批量转换任务状态变更记录
+func NewReportJobEventResponses(events []models.ReportJobEvent) []ReportJobEventResponse {
+	responses := make([]ReportJobEventResponse, len(events))
+	for i, e := range events {
+		responses[i] = ReportJobEventResponse{
+			FromStatus: e.FromStatus,
+			ToStatus:   e.ToStatus,
+			Attempt:    e.Attempt,
+			Message:    e.Message,
+			CreatedAt:  e.CreatedAt,
+		}
+	}
+	return responses
+}
+
+// checkDataSource 确认报表引用的数据源存在并返回该数据源
+func (s *reportService) checkDataSource(id uint) (*models.DataSource, error) {
+	ds, err := s.dsRepo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidDataSource
+		}
+		return nil, fmt.Errorf("error checking datasource: %w", err)
+	}
+	return ds, nil
+}
+
+// checkName 确认报表名称未被其他报表使用
+func (s *reportService) checkName(name string, selfID uint) error {
+	existing, err := s.repo.GetByName(name)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("error checking existing report: %w", err)
+	}
+	if existing != nil && existing.ID != selfID {
+		return ErrReportExists
+	}
+	return nil
+}
+
+func (s *reportService) CreateReport(input CreateReportInput) (*models.Report, error) {
+	if err := s.checkName(input.Name, 0); err != nil {
+		return nil, err
+	}
+	ds, err := s.checkDataSource(input.DataSourceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := sqlguard.CheckDataSourceQuery(ds, input.Query); err != nil {
+		return nil, err
+	}
+	if err := services.ValidateReportLayout(input.Layout); err != nil {
+		return nil, err
+	}
+	if err := services.ValidateReportParameters(input.Query, input.Parameters); err != nil {
+		return nil, err
+	}
+	if err := services.ValidateReportRetention(input.Retention); err != nil {
+		return nil, err
+	}
+
+	report := &models.Report{
+		Name:         input.Name,
+		Description:  input.Description,
+		DataSourceID: input.DataSourceID,
+		Query:        input.Query,
+		Columns:      input.Columns,
+		Timeout:      input.Timeout,
+		Layout:       input.Layout,
+		Parameters:   input.Parameters,
+		Retention:    input.Retention,
+	}
+	if err := s.repo.Create(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (s *reportService) GetReports(page, pageSize int) ([]models.Report, int64, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+	offset := (page - 1) * pageSize
+	return s.repo.GetAll(offset, pageSize)
+}
+
+func (s *reportService) GetReportByID(id uint) (*models.Report, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *reportService) UpdateReport(id uint, input UpdateReportInput) (*models.Report, error) {
+	report, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err // handles gorm.ErrRecordNotFound appropriately
+	}
+
+	if input.Name != nil && *input.Name != report.Name {
+		if err := s.checkName(*input.Name, id); err != nil {
+			return nil, err
+		}
+		report.Name = *input.Name
+	}
+	if input.Description != nil {
+		report.Description = *input.Description
+	}
+	if input.DataSourceID != nil {
+		report.DataSourceID = *input.DataSourceID
+	}
+	if input.Query != nil {
+		report.Query = *input.Query
+	}
+	// 查询或数据源变化后按数据源的方言和限制重新校验
+	if input.DataSourceID != nil || input.Query != nil {
+		ds, err := s.checkDataSource(report.DataSourceID)
+		if err != nil {
+			return nil, err
+		}
+		if err := sqlguard.CheckDataSourceQuery(ds, report.Query); err != nil {
+			return nil, err
+		}
+	}
+	if input.Columns != nil {
+		report.Columns = *input.Columns
+	}
+	if input.Timeout != nil {
+		report.Timeout = *input.Timeout
+	}
+	if input.Layout != nil {
+		if err := services.ValidateReportLayout(*input.Layout); err != nil {
+			return nil, err
+		}
+		report.Layout = *input.Layout
+	}
+	if input.Parameters != nil {
+		report.Parameters = *input.Parameters
+	}
+	// 查询和参数定义可能只修改了其中之一，按修改后的结果整体校验
+	if input.Query != nil || input.Parameters != nil {
+		if err := services.ValidateReportParameters(report.Query, report.Parameters); err != nil {
+			return nil, err
+		}
+	}
+	if input.Retention != nil {
+		if err := services.ValidateReportRetention(*input.Retention); err != nil {
+			return nil, err
+		}
+		report.Retention = *input.Retention
+	}
+
+	if err := s.repo.Update(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (s *reportService) DeleteReport(id uint) error {
+	_, err := s.repo.GetByID(id)
+	if err != nil {
+		return err // handles gorm.ErrRecordNotFound appropriately
+	}
+	return s.repo.Delete(id)
+}
+
+// GenerateReport 创建报表生成任务并加入队列
+func (s *reportService) GenerateReport(reportID uint, input GenerateReportInput) (*models.ReportJob, error) {
+	report, err := s.repo.GetByID(reportID)
+	if err != nil {
+		return nil, err
+	}
+	if !utils.ValidateFormat(input.Format) {
+		return nil, ErrUnsupportedFormat
+	}
+	parameters, err := services.ResolveParameters(report.Parameters, input.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	// 任务落库后由队列中的 worker 异步执行
+	return s.queue.Enqueue(report.ID, input.Format, parameters)
+}
+
+func (s *reportService) GetReportJobs(reportID uint, page, pageSize int) ([]models.ReportJob, int64, error) {
+	if _, err := s.repo.GetByID(reportID); err != nil {
+		return nil, 0, err
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+	offset := (page - 1) * pageSize
+	return s.jobRepo.List(repository.ReportJobFilter{ReportID: reportID}, offset, pageSize)
+}
+
+// GetReportJob 获取报表的某个生成任务，任务不属于该报表时返回 ErrJobNotInReport
+func (s *reportService) GetReportJob(reportID, jobID uint) (*models.ReportJob, error) {
+	job, err := s.jobRepo.GetByID(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.ReportID != reportID {
+		return nil, ErrJobNotInReport
+	}
+	return job, nil
+}
+
+func (s *reportService) GetJobs(filter JobFilter, page, pageSize int) ([]models.ReportJob, int64, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+	offset := (page - 1) * pageSize
+	return s.jobRepo.List(repository.ReportJobFilter{Kind: filter.Kind, ReportID: filter.ReportID, ScheduleID: filter.ScheduleID, Status: filter.Status}, offset, pageSize)
+}
+
+func (s *reportService) GetJobByID(id uint) (*models.ReportJob, error) {
+	return s.jobRepo.GetByID(id)
+}
+
+func (s *reportService) GetJobEvents(id uint) ([]models.ReportJobEvent, error) {
+	if _, err := s.jobRepo.GetByID(id); err != nil {
+		return nil, err
+	}
+	return s.jobRepo.ListEvents(id)
+}
+
+// CancelJob 取消尚未结束的任务，已结束的任务返回 ErrJobNotCancellable
+func (s *reportService) CancelJob(id uint) (*models.ReportJob, error) {
+	return s.queue.Cancel(id)
+}
+
+func (s *reportService) OpenJobFile(ctx context.Context, job *models.ReportJob) (*JobFile, error) {
+	if s.cfg.Presign && job.StorageKey != "" {
+		url, err := s.files.PresignGet(ctx, job.StorageKey, JobFileName(job), s.cfg.PresignExpiry)
+		if err == nil {
+			return &JobFile{URL: url}, nil
+		}
+		if !errors.Is(err, storage.ErrPresignUnsupported) {
+			return nil, err
+		}
+	}
+	body, err := services.OpenReportFile(ctx, s.files, job)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrJobFileMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &JobFile{Body: body}, nil
+}
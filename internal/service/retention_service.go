@@ -0,0 +1,71 @@
+package service
+
+import (
+	"time"
+
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/services"
+)
+
+type RetentionService interface {
+	// Preview 列出下一次清理将删除的任务，不做任何修改。reportID 为 0 时检查所有报表
+	Preview(reportID uint) (*RetentionPreview, error)
+}
+
+type retentionService struct {
+	janitor    *services.Janitor
+	reportRepo repository.ReportRepository
+}
+
+func NewRetentionService(janitor *services.Janitor, reportRepo repository.ReportRepository) RetentionService {
+	return &retentionService{janitor: janitor, reportRepo: reportRepo}
+}
+
+// RetentionPreviewFilter 清理预览的查询条件
+type RetentionPreviewFilter struct {
+	ReportID uint `form:"reportId"`
+}
+
+// ExpiredJobResponse 将被清理的报表任务
+type ExpiredJobResponse struct {
+	JobID      uint                   `json:"jobId"`
+	ReportID   uint                   `json:"reportId"`
+	Status     models.ReportJobStatus `json:"status"`
+	FileSize   int64                  `json:"fileSize"`
+	FinishedAt *time.Time             `json:"finishedAt,omitempty"`
+	// Reason 到期的原因：keepLast、maxAge 或 maxTotalSize
+	Reason string `json:"reason"`
+}
+
+// RetentionPreview 清理预览的结果
+type RetentionPreview struct {
+	Jobs       []ExpiredJobResponse `json:"jobs"`
+	TotalJobs  int                  `json:"totalJobs"`
+	TotalBytes int64                `json:"totalBytes"`
+}
+
+func (s *retentionService) Preview(reportID uint) (*RetentionPreview, error) {
+	if reportID != 0 {
+		if _, err := s.reportRepo.GetByID(reportID); err != nil {
+			return nil, err
+		}
+	}
+	expired, err := s.janitor.Plan(reportID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	preview := &RetentionPreview{Jobs: make([]ExpiredJobResponse, len(expired)), TotalJobs: len(expired)}
+	for i, e := range expired {
+		preview.Jobs[i] = ExpiredJobResponse{
+			JobID:      e.Job.ID,
+			ReportID:   e.Job.ReportID,
+			Status:     e.Job.Status,
+			FileSize:   e.Job.FileSize,
+			FinishedAt: e.Job.FinishedAt,
+			Reason:     e.Reason,
+		}
+		preview.TotalBytes += e.Job.FileSize
+	}
+	return preview, nil
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"errors"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/services"
+	"github.com/foldn/bi-go/pkg/utils"
+	"gorm.io/gorm"
+	"time"
+)
+
+var (
+	ErrInvalidReport   = errors.New("invalid report id")
+	ErrInvalidSchedule = services.ErrInvalidSchedule
+)
+
+type ScheduleService interface {
+	CreateSchedule(input CreateScheduleInput) (*models.ReportSchedule, error)
+	GetSchedules(filter ScheduleFilter, page, pageSize int) ([]models.ReportSchedule, int64, error)
+	GetScheduleByID(id uint) (*models.ReportSchedule, error)
+	UpdateSchedule(id uint, input UpdateScheduleInput) (*models.ReportSchedule, error)
+	DeleteSchedule(id uint) error
+}
+
+type scheduleService struct {
+	repo       repository.ReportScheduleRepository
+	reportRepo repository.ReportRepository
+}
+
+func NewScheduleService(repo repository.ReportScheduleRepository, reportRepo repository.ReportRepository) ScheduleService {
+	return &scheduleService{repo: repo, reportRepo: reportRepo}
+}
+
+type CreateScheduleInput struct {
+	ReportID uint `json:"reportId" binding:"required"`
+	// Cron 五段式 cron 表达式（分 时 日 月 周）或 @daily、@every 1h 等描述符
+	Cron string `json:"cron" binding:"required"`
+	// Timezone IANA 时区名，如 Asia/Shanghai，为空时使用 UTC
+	Timezone string `json:"timezone"`
+	Format   string `json:"format" binding:"required"`
+	// Parameters 参数值，触发时按报表当前的参数定义填充默认值
+	Parameters map[string]interface{} `json:"parameters"`
+	// Enabled 为空时默认启用
+	Enabled *bool `json:"enabled"`
+}
+
+type UpdateScheduleInput struct {
+	Cron       *string                 `json:"cron"`
+	Timezone   *string                 `json:"timezone"`
+	Format     *string                 `json:"format"`
+	Parameters *map[string]interface{} `json:"parameters"`
+	Enabled    *bool                   `json:"enabled"`
+}
+
+// ScheduleFilter 定时计划列表的查询条件
+type ScheduleFilter struct {
+	ReportID uint `form:"reportId"`
+}
+
+// ScheduleResponse 定时计划的 API 响应
+type ScheduleResponse struct {
+	ID         uint                   `json:"id"`
+	ReportID   uint                   `json:"reportId"`
+	Cron       string                 `json:"cron"`
+	Timezone   string                 `json:"timezone"`
+	Format     string                 `json:"format"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Enabled    bool                   `json:"enabled"`
+	NextRunAt  *time.Time             `json:"nextRunAt,omitempty"`
+	LastRunAt  *time.Time             `json:"lastRunAt,omitempty"`
+	LastJobID  *uint                  `json:"lastJobId,omitempty"`
+	LastError  string                 `json:"lastError,omitempty"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	UpdatedAt  time.Time              `json:"updatedAt"`
+}
+
+// NewScheduleResponse 将定时计划模型转换为响应结构
+func NewScheduleResponse(schedule *models.ReportSchedule) ScheduleResponse {
+	parameters := schedule.Parameters
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
+	return ScheduleResponse{
+		ID:         schedule.ID,
+		ReportID:   schedule.ReportID,
+		Cron:       schedule.Cron,
+		Timezone:   schedule.Timezone,
+		Format:     schedule.Format,
+		Parameters: parameters,
+		Enabled:    schedule.Enabled,
+		NextRunAt:  schedule.NextRunAt,
+		LastRunAt:  schedule.LastRunAt,
+		LastJobID:  schedule.LastJobID,
+		LastError:  schedule.LastError,
+		CreatedAt:  schedule.CreatedAt,
+		UpdatedAt:  schedule.UpdatedAt,
+	}
+}
+
+// NewScheduleResponses 批量转换定时计划模型
+func NewScheduleResponses(schedules []models.ReportSchedule) []ScheduleResponse {
+	responses := make([]ScheduleResponse, len(schedules))
+	for i := range schedules {
+		responses[i] = NewScheduleResponse(&schedules[i])
+	}
+	return responses
+}
+
+func (s *scheduleService) CreateSchedule(input CreateScheduleInput) (*models.ReportSchedule, error) {
+	schedule := &models.ReportSchedule{
+		ReportID:   input.ReportID,
+		Cron:       input.Cron,
+		Timezone:   input.Timezone,
+		Format:     input.Format,
+		Parameters: input.Parameters,
+		Enabled:    input.Enabled == nil || *input.Enabled,
+	}
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	if err := s.prepare(schedule); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *scheduleService) GetSchedules(filter ScheduleFilter, page, pageSize int) ([]models.ReportSchedule, int64, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+	offset := (page - 1) * pageSize
+	return s.repo.List(filter.ReportID, offset, pageSize)
+}
+
+func (s *scheduleService) GetScheduleByID(id uint) (*models.ReportSchedule, error) {
+	return s.repo.GetByID(id)
+}
+
+// UpdateSchedule 修改定时计划并重新计算下一次触发时间。
+// 读取后计划恰好被调度器触发时重新读取再修改
+func (s *scheduleService) UpdateSchedule(id uint, input UpdateScheduleInput) (*models.ReportSchedule, error) {
+	for {
+		schedule, err := s.repo.GetByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if input.Cron != nil {
+			schedule.Cron = *input.Cron
+		}
+		if input.Timezone != nil {
+			schedule.Timezone = *input.Timezone
+			if schedule.Timezone == "" {
+				schedule.Timezone = "UTC"
+			}
+		}
+		if input.Format != nil {
+			schedule.Format = *input.Format
+		}
+		if input.Parameters != nil {
+			schedule.Parameters = *input.Parameters
+		}
+		if input.Enabled != nil {
+			schedule.Enabled = *input.Enabled
+		}
+		if err := s.prepare(schedule); err != nil {
+			return nil, err
+		}
+
+		err = s.repo.Update(schedule)
+		if errors.Is(err, repository.ErrScheduleConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return schedule, nil
+	}
+}
+
+func (s *scheduleService) DeleteSchedule(id uint) error {
+	if _, err := s.repo.GetByID(id); err != nil {
+		return err
+	}
+	return s.repo.Delete(id)
+}
+
+// prepare 校验计划引用的报表、表达式、格式和参数值，并计算下一次触发时间
+func (s *scheduleService) prepare(schedule *models.ReportSchedule) error {
+	report, err := s.reportRepo.GetByID(schedule.ReportID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidReport
+		}
+		return err
+	}
+	if !utils.ValidateFormat(schedule.Format) {
+		return ErrUnsupportedFormat
+	}
+	if _, err := services.ResolveParameters(report.Parameters, schedule.Parameters); err != nil {
+		return err
+	}
+	next, err := services.NextScheduleRun(schedule.Cron, schedule.Timezone, time.Now())
+	if err != nil {
+		return err
+	}
+	schedule.NextRunAt = nil
+	if schedule.Enabled {
+		schedule.NextRunAt = &next
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+)
+
+// 列式格式的默认批大小
+const (
+	defaultParquetRowGroupRows = 100000
+	defaultArrowBatchRows      = 10000
+)
+
+// parquetCodecs 支持的 Parquet 压缩算法，空串使用 snappy
+var parquetCodecs = map[string]compress.Compression{
+	"":       compress.Codecs.Snappy,
+	"snappy": compress.Codecs.Snappy,
+	"zstd":   compress.Codecs.Zstd,
+	"gzip":   compress.Codecs.Gzip,
+	"none":   compress.Codecs.Uncompressed,
+}
+
+// writeParquet 写出 Parquet 文件。每累积 RowGroupRows 行构建一个记录批并写为一个行组，
+// 内存占用只与行组大小有关
+func writeParquet(w io.Writer, columns []string, rows RowIterator, cfg config.ParquetConfig) error {
+	codec, ok := parquetCodecs[strings.ToLower(cfg.Compression)]
+	if !ok {
+		return fmt.Errorf("不支持的 Parquet 压缩算法: %s", cfg.Compression)
+	}
+	rowGroupRows := cfg.RowGroupRows
+	if rowGroupRows <= 0 {
+		rowGroupRows = defaultParquetRowGroupRows
+	}
+
+	schema := arrowSchema(columns, outputColumnTypes(columns, rows))
+	props := parquet.NewWriterProperties(
+		parquet.WithCompression(codec),
+		parquet.WithMaxRowGroupLength(int64(rowGroupRows)),
+	)
+	// 保存 Arrow schema，读取时可还原时区等 Parquet 本身不记录的信息
+	writer, err := pqarrow.NewFileWriter(schema, w, props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	if err != nil {
+		return err
+	}
+	if err := writeRecordBatches(schema, rows, rowGroupRows, writer.Write); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// writeArrow 写出 Arrow IPC 文件，每 BatchRows 行一个记录批，可选 zstd 或 lz4 压缩
+func writeArrow(w io.Writer, columns []string, rows RowIterator, cfg config.ArrowConfig) error {
+	schema := arrowSchema(columns, outputColumnTypes(columns, rows))
+	opts := []ipc.Option{ipc.WithSchema(schema), ipc.WithAllocator(memory.DefaultAllocator)}
+	switch strings.ToLower(cfg.Compression) {
+	case "", "none":
+	case "zstd":
+		opts = append(opts, ipc.WithZstd())
+	case "lz4":
+		opts = append(opts, ipc.WithLZ4())
+	default:
+		return fmt.Errorf("不支持的 Arrow 压缩算法: %s", cfg.Compression)
+	}
+	batchRows := cfg.BatchRows
+	if batchRows <= 0 {
+		batchRows = defaultArrowBatchRows
+	}
+
+	writer, err := ipc.NewFileWriter(w, opts...)
+	if err != nil {
+		return err
+	}
+	if err := writeRecordBatches(schema, rows, batchRows, writer.Write); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// arrowSchema 根据归一化的列类型生成 Arrow schema，所有列均可为空。
+// decimal 按查询结果中的 float64 写出；time、json 和未知类型写为字符串
+func arrowSchema(columns []string, types []string) *arrow.Schema {
+	fields := make([]arrow.Field, len(columns))
+	for i, col := range columns {
+		var dataType arrow.DataType
+		switch types[i] {
+		case database.TypeInteger:
+			dataType = arrow.PrimitiveTypes.Int64
+		case database.TypeFloat, database.TypeDecimal:
+			dataType = arrow.PrimitiveTypes.Float64
+		case database.TypeBoolean:
+			dataType = arrow.FixedWidthTypes.Boolean
+		case database.TypeDate:
+			dataType = arrow.FixedWidthTypes.Date32
+		case database.TypeDateTime:
+			dataType = &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+		case database.TypeBinary:
+			dataType = arrow.BinaryTypes.Binary
+		default:
+			dataType = arrow.BinaryTypes.String
+		}
+		fields[i] = arrow.Field{Name: col, Type: dataType, Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// writeRecordBatches 将查询结果按 batchRows 行一批转换为记录批并交给 write
+func writeRecordBatches(schema *arrow.Schema, rows RowIterator, batchRows int, write func(arrow.Record) error) error {
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	flush := func() error {
+		record := builder.NewRecord()
+		defer record.Release()
+		return write(record)
+	}
+
+	pending := 0
+	for rows.Next() {
+		row := rows.Row()
+		for i, field := range schema.Fields() {
+			if err := appendArrowValue(builder.Field(i), row[field.Name]); err != nil {
+				return fmt.Errorf("列 %s: %w", field.Name, err)
+			}
+		}
+		pending++
+		if pending == batchRows {
+			if err := flush(); err != nil {
+				return err
+			}
+			pending = 0
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if pending > 0 {
+		return flush()
+	}
+	return nil
+}
+
+// appendArrowValue 将单元格值转换为列的 Arrow 类型后追加，无法转换时返回错误
+func appendArrowValue(b array.Builder, value interface{}) error {
+	if value == nil {
+		b.AppendNull()
+		return nil
+	}
+	switch b := b.(type) {
+	case *array.Int64Builder:
+		v, ok := toInt64(value)
+		if !ok {
+			return fmt.Errorf("值 %v 无法转换为整数", value)
+		}
+		b.Append(v)
+	case *array.Float64Builder:
+		v, ok := toFloat64(value)
+		if !ok {
+			return fmt.Errorf("值 %v 无法转换为浮点数", value)
+		}
+		b.Append(v)
+	case *array.BooleanBuilder:
+		v, ok := toBool(value)
+		if !ok {
+			return fmt.Errorf("值 %v 无法转换为布尔值", value)
+		}
+		b.Append(v)
+	case *array.Date32Builder:
+		t, ok := toTime(value)
+		if !ok {
+			return fmt.Errorf("值 %v 无法转换为日期", value)
+		}
+		b.Append(arrow.Date32FromTime(t))
+	case *array.TimestampBuilder:
+		t, ok := toTime(value)
+		if !ok {
+			return fmt.Errorf("值 %v 无法转换为时间", value)
+		}
+		b.Append(arrow.Timestamp(t.UnixMicro()))
+	case *array.BinaryBuilder:
+		switch v := value.(type) {
+		case []byte:
+			b.Append(v)
+		default:
+			b.Append([]byte(formatCSVValue(v)))
+		}
+	case *array.StringBuilder:
+		b.Append(formatCSVValue(value))
+	default:
+		return fmt.Errorf("不支持的列类型 %s", b.Type())
+	}
+	return nil
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case uint:
+		return int64(v), uint64(v) <= math.MaxInt64
+	case float64:
+		return int64(v), v == math.Trunc(v) && math.Abs(v) < 1<<63
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case []byte:
+		return toInt64(string(v))
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case []byte:
+		return toFloat64(string(v))
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	if n, ok := toInt64(value); ok {
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func toBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case []byte:
+		return toBool(string(v))
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		return b, err == nil
+	}
+	if n, ok := toInt64(value); ok {
+		return n != 0, true
+	}
+	return false, false
+}
+
+// columnarTimeLayouts 时间以字符串返回时尝试的格式
+var columnarTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case []byte:
+		return toTime(string(v))
+	case string:
+		for _, layout := range columnarTimeLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
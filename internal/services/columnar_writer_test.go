@@ -0,0 +1,168 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+)
+
+// columnarTestColumns 输出列，extra 不在查询结果中，写为全部为空值的字符串列
+var columnarTestColumns = []string{"id", "name", "amount", "day", "at", "flag", "extra"}
+
+// columnarTestSchema 与 columnarTestColumns 对应的 Arrow 类型
+var columnarTestSchema = []arrow.DataType{
+	arrow.PrimitiveTypes.Int64,
+	arrow.BinaryTypes.String,
+	arrow.PrimitiveTypes.Float64,
+	arrow.FixedWidthTypes.Date32,
+	&arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"},
+	arrow.FixedWidthTypes.Boolean,
+	arrow.BinaryTypes.String,
+}
+
+// wantColumnarRows newWriterTestRows(n) 读回后的值
+func wantColumnarRows(n int) [][]interface{} {
+	var rows [][]interface{}
+	for _, row := range newWriterTestRows(n).rows {
+		rows = append(rows, append(append([]interface{}(nil), row...), nil))
+	}
+	return rows
+}
+
+// recordRows 将记录批转换为行，时间按 UTC 读取
+func recordRows(t *testing.T, rec arrow.Record) [][]interface{} {
+	t.Helper()
+	rows := make([][]interface{}, rec.NumRows())
+	for i := range rows {
+		rows[i] = make([]interface{}, rec.NumCols())
+		for j, col := range rec.Columns() {
+			if col.IsNull(i) {
+				continue
+			}
+			switch c := col.(type) {
+			case *array.Int64:
+				rows[i][j] = c.Value(i)
+			case *array.Float64:
+				rows[i][j] = c.Value(i)
+			case *array.Boolean:
+				rows[i][j] = c.Value(i)
+			case *array.String:
+				rows[i][j] = c.Value(i)
+			case *array.Date32:
+				rows[i][j] = c.Value(i).ToTime()
+			case *array.Timestamp:
+				rows[i][j] = c.Value(i).ToTime(arrow.Microsecond)
+			default:
+				t.Fatalf("column %d: unexpected type %s", j, col.DataType())
+			}
+		}
+	}
+	return rows
+}
+
+func checkSchema(t *testing.T, name string, schema *arrow.Schema) {
+	t.Helper()
+	for i, f := range schema.Fields() {
+		if f.Name != columnarTestColumns[i] || !arrow.TypeEqual(f.Type, columnarTestSchema[i]) || !f.Nullable {
+			t.Errorf("%s: field %d: got %s", name, i, f)
+		}
+	}
+	if schema.NumFields() != len(columnarTestColumns) {
+		t.Errorf("%s: got %d fields", name, schema.NumFields())
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	for _, codec := range []string{"", "zstd", "gzip", "none"} {
+		var buf bytes.Buffer
+		err := writeParquet(&buf, columnarTestColumns, newWriterTestRows(5), config.ParquetConfig{Compression: codec, RowGroupRows: 2})
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		pf, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		if pf.NumRowGroups() != 3 {
+			t.Errorf("%q: got %d row groups, want 3", codec, pf.NumRowGroups())
+		}
+		reader, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		table, err := reader.ReadTable(context.Background())
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		checkSchema(t, codec, table.Schema())
+
+		var got [][]interface{}
+		tr := array.NewTableReader(table, -1)
+		for tr.Next() {
+			got = append(got, recordRows(t, tr.Record())...)
+		}
+		tr.Release()
+		table.Release()
+		pf.Close()
+		if want := wantColumnarRows(5); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got rows\n%v\nwant\n%v", codec, got, want)
+		}
+	}
+}
+
+func TestWriteArrow(t *testing.T) {
+	for _, codec := range []string{"", "zstd", "lz4"} {
+		var buf bytes.Buffer
+		err := writeArrow(&buf, columnarTestColumns, newWriterTestRows(5), config.ArrowConfig{Compression: codec, BatchRows: 2})
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		reader, err := ipc.NewFileReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		checkSchema(t, codec, reader.Schema())
+		if reader.NumRecords() != 3 {
+			t.Errorf("%q: got %d record batches, want 3", codec, reader.NumRecords())
+		}
+		var got [][]interface{}
+		for i := 0; i < reader.NumRecords(); i++ {
+			rec, err := reader.Record(i)
+			if err != nil {
+				t.Fatalf("%q: %v", codec, err)
+			}
+			got = append(got, recordRows(t, rec)...)
+		}
+		reader.Close()
+		if want := wantColumnarRows(5); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got rows\n%v\nwant\n%v", codec, got, want)
+		}
+	}
+}
+
+func TestWriteColumnarErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeParquet(&buf, columnarTestColumns, newWriterTestRows(1), config.ParquetConfig{Compression: "brotli2"}); err == nil {
+		t.Error("parquet: expected an error for an unknown compression")
+	}
+	if err := writeArrow(&buf, columnarTestColumns, newWriterTestRows(1), config.ArrowConfig{Compression: "snappy"}); err == nil {
+		t.Error("arrow: expected an error for an unknown compression")
+	}
+
+	// 值无法转换为列的类型时报告列名
+	rows := &testRows{columns: []string{"n"}, types: []string{database.TypeInteger}, rows: [][]interface{}{{int64(1)}, {"many"}}}
+	if err := writeArrow(&buf, []string{"n"}, rows, config.ArrowConfig{}); err == nil || !strings.HasPrefix(err.Error(), "列 n:") {
+		t.Errorf("got %v, want a conversion error for column n", err)
+	}
+}
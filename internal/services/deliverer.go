@@ -0,0 +1,365 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/storage"
+	"gorm.io/gorm"
+)
+
+// 投递默认参数，配置项为零值时使用
+const (
+	defaultDeliveryWorkers         = 2
+	defaultDeliveryPollInterval    = 5 * time.Second
+	defaultDeliveryMaxAttempts     = 5
+	defaultDeliveryRetryBackoff    = 30 * time.Second
+	defaultDeliveryMaxRetryBackoff = 30 * time.Minute
+	defaultDeliveryTimeout         = 30 * time.Second
+	defaultDeliveryLeaseDuration   = time.Minute
+)
+
+// permanentError 重试也不会成功的投递错误，例如目标已删除或配置无效
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Deliverer 将完成的报表任务投递到报表的投递目标。每个目标的投递以 pending 状态落库，
+// 由固定数量的 worker 执行，失败后按指数退避重试。领取投递的实例持有租约并在发送中续约，
+// 租约到期的投递视为其实例已退出，由任一实例恢复
+type Deliverer struct {
+	owner      string // 本实例的标识，记录在领取的投递中
+	targets    repository.DeliveryTargetRepository
+	deliveries repository.ReportDeliveryRepository
+	reports    repository.ReportRepository
+	jobs       repository.ReportJobRepository
+	files      storage.Storage
+	cfg        config.DeliveryConfig
+	client     *http.Client
+
+	ctx    context.Context // Stop 时取消，中止正在进行的发送
+	cancel context.CancelFunc
+	notify chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// NewDeliverer 创建投递器，需调用 Start 启动 worker
+func NewDeliverer(targets repository.DeliveryTargetRepository, deliveries repository.ReportDeliveryRepository,
+	reports repository.ReportRepository, jobs repository.ReportJobRepository, files storage.Storage, cfg config.DeliveryConfig) *Deliverer {
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultDeliveryWorkers
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultDeliveryPollInterval
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultDeliveryMaxAttempts
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultDeliveryRetryBackoff
+	}
+	if cfg.MaxRetryBackoff <= 0 {
+		cfg.MaxRetryBackoff = defaultDeliveryMaxRetryBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultDeliveryTimeout
+	}
+	if cfg.LeaseDuration <= 0 {
+		cfg.LeaseDuration = defaultDeliveryLeaseDuration
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Deliverer{
+		owner:      instanceID(),
+		targets:    targets,
+		deliveries: deliveries,
+		reports:    reports,
+		jobs:       jobs,
+		files:      files,
+		cfg:        cfg,
+		client:     &http.Client{Timeout: cfg.Timeout},
+		ctx:        ctx,
+		cancel:     cancel,
+		notify:     make(chan struct{}, 1),
+	}
+}
+
+// Start 恢复租约已到期的投递，启动 worker 和定期恢复到期投递的 reaper
+func (d *Deliverer) Start() error {
+	if err := d.recoverExpired(); err != nil {
+		return err
+	}
+	for i := 0; i < d.cfg.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+	d.wg.Add(1)
+	go d.reaper()
+	return nil
+}
+
+// Stop 停止领取新的投递，中止正在进行的发送并等待 worker 退出
+func (d *Deliverer) Stop() {
+	d.once.Do(d.cancel)
+	d.wg.Wait()
+}
+
+// Schedule 为完成的任务创建报表所有启用目标的投递
+func (d *Deliverer) Schedule(job *models.ReportJob) error {
+	targets, err := d.targets.ListEnabled(job.ReportID)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	deliveries := make([]models.ReportDelivery, len(targets))
+	for i, target := range targets {
+		deliveries[i] = models.ReportDelivery{
+			JobID:       job.ID,
+			TargetID:    target.ID,
+			Type:        target.Type,
+			Destination: deliveryDestination(&target),
+			Status:      models.DeliveryPending,
+			MaxAttempts: d.cfg.MaxAttempts,
+		}
+	}
+	if err := d.deliveries.Create(deliveries); err != nil {
+		return err
+	}
+	d.wake()
+	return nil
+}
+
+// recoverExpired 接管租约已到期的 sending 投递，视为发送被中断：
+// 还有剩余次数的重新排队，否则标记为失败。其他实例正在发送的投递持续续约，不会被接管
+func (d *Deliverer) recoverExpired() error {
+	now := time.Now()
+	deliveries, err := d.deliveries.ClaimExpired(now, d.owner, now.Add(d.cfg.LeaseDuration))
+	if err != nil {
+		return fmt.Errorf("failed to recover interrupted report deliveries: %w", err)
+	}
+	for i := range deliveries {
+		d.finish(&deliveries[i], errors.New("投递被中断"))
+	}
+	if len(deliveries) > 0 {
+		log.Printf("Recovered %d interrupted report deliveries", len(deliveries))
+	}
+	return nil
+}
+
+// reaper 每隔一个租约时长恢复一次其他实例退出后遗留的投递
+func (d *Deliverer) reaper() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.cfg.LeaseDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.recoverExpired(); err != nil {
+				log.Print(err)
+			} else {
+				d.wake()
+			}
+		}
+	}
+}
+
+func (d *Deliverer) wake() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+func deliveryDestination(target *models.DeliveryTarget) string {
+	switch target.Type {
+	case models.DeliveryEmail:
+		return strings.Join(append(append([]string{}, target.Config.To...), target.Config.Cc...), ", ")
+	case models.DeliveryWebhook:
+		return target.Config.URL
+	case models.DeliveryDirectory:
+		return target.Config.Path
+	}
+	return ""
+}
+
+func (d *Deliverer) worker() {
+	defer d.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		if d.ctx.Err() != nil {
+			return
+		}
+
+		now := time.Now()
+		delivery, err := d.deliveries.ClaimNext(now, d.owner, now.Add(d.cfg.LeaseDuration))
+		if err != nil {
+			log.Printf("failed to claim report delivery: %v", err)
+		}
+		if delivery != nil {
+			d.send(delivery)
+			continue
+		}
+
+		// 没有可执行的投递，等待新投递通知或下一次轮询
+		timer.Reset(d.cfg.PollInterval)
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-d.notify:
+		case <-timer.C:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// send 执行领取的投递并保存结果，发送期间持续续约
+func (d *Deliverer) send(delivery *models.ReportDelivery) {
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go d.heartbeat(delivery.ID, cancel, done)
+	err := d.deliver(ctx, delivery)
+	close(done)
+	if ctx.Err() != nil && d.ctx.Err() == nil {
+		// 投递已被其他实例接管，结果由接管的实例记录
+		return
+	}
+	d.finish(delivery, err)
+}
+
+// heartbeat 在发送期间续约，直到 done 关闭。投递已不再由本实例持有时（租约到期后被其他实例恢复）
+// 中止发送，避免同一投递在两个实例上同时发送
+func (d *Deliverer) heartbeat(id uint, cancel context.CancelFunc, done <-chan struct{}) {
+	ticker := time.NewTicker(d.cfg.LeaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		held, err := d.deliveries.RenewLease(id, d.owner, time.Now().Add(d.cfg.LeaseDuration))
+		switch {
+		case err != nil:
+			// 数据库暂时不可用时继续发送，租约到期前仍有机会续约
+			log.Printf("failed to renew lease of report delivery %d: %v", id, err)
+		case !held:
+			log.Printf("Report delivery %d is no longer held by this instance, stopping", id)
+			cancel()
+			return
+		}
+	}
+}
+
+// deliver 执行一次投递，panic 按失败处理
+func (d *Deliverer) deliver(ctx context.Context, delivery *models.ReportDelivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("投递异常: %v", r)
+		}
+	}()
+
+	target, err := d.targets.GetByID(delivery.TargetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return permanent(errors.New("投递目标已删除"))
+	}
+	if err != nil {
+		return err
+	}
+	job, err := d.jobs.GetByID(delivery.JobID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return permanent(errors.New("报表任务已删除"))
+	}
+	if err != nil {
+		return err
+	}
+	report, err := d.reports.GetByID(job.ReportID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return permanent(errors.New("报表已删除"))
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+	data := newDeliveryData(delivery, job, report, d.cfg.BaseURL)
+	switch target.Type {
+	case models.DeliveryEmail:
+		return d.sendEmail(ctx, target, job, data)
+	case models.DeliveryWebhook:
+		return d.postWebhook(ctx, target, data)
+	case models.DeliveryDirectory:
+		return d.copyToDirectory(ctx, target, job, data)
+	}
+	return permanent(fmt.Errorf("不支持的投递方式: %s", target.Type))
+}
+
+// finish 保存投递结果：成功时标记为 delivered，失败时还有剩余次数的重新排队，否则标记为 failed
+func (d *Deliverer) finish(delivery *models.ReportDelivery, cause error) {
+	now := time.Now()
+	message := ""
+	delivery.LeaseUntil = nil
+	var perm *permanentError
+	switch {
+	case cause == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.Error = ""
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+	case !errors.As(cause, &perm) && delivery.Attempts < delivery.MaxAttempts:
+		delay := d.backoff(delivery.Attempts)
+		next := now.Add(delay)
+		delivery.Status = models.DeliveryPending
+		delivery.Error = cause.Error()
+		delivery.NextAttemptAt = &next
+		message = fmt.Sprintf("%s，%s 后重试", delivery.Error, delay)
+	default:
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = cause.Error()
+		delivery.NextAttemptAt = nil
+		message = delivery.Error
+	}
+	if err := d.deliveries.Transition(delivery, models.DeliverySending, message); err != nil {
+		log.Printf("failed to save report delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// backoff 第 attempt 次失败后的等待时间
+func (d *Deliverer) backoff(attempt int) time.Duration {
+	delay := d.cfg.RetryBackoff
+	for i := 1; i < attempt && delay < d.cfg.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxRetryBackoff {
+		delay = d.cfg.MaxRetryBackoff
+	}
+	return delay
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/storage"
+)
+
+const testReportContent = "id,region\n1,east\n2,west\n"
+
+// deliveryFixture 投递测试使用的元数据库、投递器和一个已完成的任务
+type deliveryFixture struct {
+	deliverer  *Deliverer
+	targets    repository.DeliveryTargetRepository
+	deliveries repository.ReportDeliveryRepository
+	job        *models.ReportJob
+}
+
+// newTestDeliverer 创建不启动 worker 的投递器，以及一个报表文件保存在本地存储中的已完成任务
+func newTestDeliverer(t *testing.T, cfg config.DeliveryConfig) *deliveryFixture {
+	t.Helper()
+	db := newTestDB(t)
+	reports := repository.NewReportRepository(db)
+	jobs := repository.NewReportJobRepository(db)
+	report := &models.Report{Name: "orders", DataSourceID: 1, Query: "SELECT 1"}
+	if err := reports.Create(report); err != nil {
+		t.Fatal(err)
+	}
+
+	files := storage.NewLocal(t.TempDir())
+	path := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(path, []byte(testReportContent), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := files.PutFile(context.Background(), "reports/report.csv", path, "text/csv"); err != nil {
+		t.Fatal(err)
+	}
+	finished := time.Now()
+	job := &models.ReportJob{ReportID: report.ID, Status: models.JobStatusCompleted, Format: "csv",
+		RowsWritten: 2, StorageKey: "reports/report.csv", FinishedAt: &finished}
+	if err := jobs.Create(job); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &deliveryFixture{
+		targets:    repository.NewDeliveryTargetRepository(db, newTestKeyring(t)),
+		deliveries: repository.NewReportDeliveryRepository(db),
+		job:        job,
+	}
+	f.deliverer = NewDeliverer(f.targets, f.deliveries, reports, jobs, files, cfg)
+	return f
+}
+
+// addTarget 为任务的报表创建启用的投递目标
+func (f *deliveryFixture) addTarget(t *testing.T, typ models.DeliveryType, c models.DeliveryTargetConfig) *models.DeliveryTarget {
+	t.Helper()
+	target := &models.DeliveryTarget{ReportID: f.job.ReportID, Name: string(typ), Type: typ, Enabled: true, Config: c}
+	if err := f.targets.Create(target); err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+// deliver 为 target 创建投递并执行一次
+func (f *deliveryFixture) deliver(t *testing.T, target *models.DeliveryTarget) error {
+	t.Helper()
+	deliveries := []models.ReportDelivery{{JobID: f.job.ID, TargetID: target.ID, Type: target.Type,
+		Status: models.DeliveryPending, MaxAttempts: 1}}
+	if err := f.deliveries.Create(deliveries); err != nil {
+		t.Fatal(err)
+	}
+	return f.deliverer.deliver(context.Background(), &deliveries[0])
+}
+
+func isPermanent(err error) bool {
+	var perm *permanentError
+	return errors.As(err, &perm)
+}
+
+func TestDelivererRecoversOnlyExpiredLeases(t *testing.T) {
+	f := newTestDeliverer(t, config.DeliveryConfig{LeaseDuration: time.Minute, MaxAttempts: 3})
+	target := f.addTarget(t, models.DeliveryWebhook, models.DeliveryTargetConfig{URL: "http://127.0.0.1:1/hook", Secret: "s"})
+	if err := f.deliverer.Schedule(f.job); err != nil {
+		t.Fatal(err)
+	}
+	a, b := f.deliverer, NewDeliverer(f.targets, f.deliveries, nil, nil, nil, config.DeliveryConfig{LeaseDuration: time.Minute})
+	a.owner, b.owner = "instance-a", "instance-b"
+
+	now := time.Now()
+	claimed, err := f.deliveries.ClaimNext(now, a.owner, now.Add(time.Minute))
+	if err != nil || claimed == nil || claimed.TargetID != target.ID {
+		t.Fatalf("claim: %v, %v", claimed, err)
+	}
+
+	// 实例 b 启动时，a 的租约仍有效，投递不受影响
+	if err := b.recoverExpired(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := f.deliveries.ListByJob(f.job.ID)
+	if got[0].Status != models.DeliverySending || got[0].ClaimedBy != a.owner {
+		t.Fatalf("live delivery was recovered: %+v", got[0])
+	}
+	if held, _ := f.deliveries.RenewLease(claimed.ID, b.owner, now.Add(time.Minute)); held {
+		t.Fatal("another instance renewed the lease")
+	}
+
+	// a 的租约到期后由 b 恢复，a 之后的结果不再生效
+	if held, err := f.deliveries.RenewLease(claimed.ID, a.owner, now.Add(-time.Second)); err != nil || !held {
+		t.Fatalf("renew: %v, %v", held, err)
+	}
+	if err := b.recoverExpired(); err != nil {
+		t.Fatal(err)
+	}
+	a.finish(claimed, nil)
+	got, _ = f.deliveries.ListByJob(f.job.ID)
+	if got[0].Status != models.DeliveryPending || got[0].Error != "投递被中断" || got[0].LeaseUntil != nil {
+		t.Fatalf("expired delivery was not requeued: %+v", got[0])
+	}
+}
+
+func TestDelivererWebhook(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	var status atomic.Int32
+	status.Store(http.StatusNoContent)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{header: r.Header, body: body}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	f := newTestDeliverer(t, config.DeliveryConfig{BaseURL: "https://bi.example.com", PollInterval: 50 * time.Millisecond})
+	target := f.addTarget(t, models.DeliveryWebhook, models.DeliveryTargetConfig{
+		URL: server.URL, Secret: "webhook-secret", Headers: map[string]string{"X-Team": "finance"}})
+
+	// 由 worker 领取并完成投递
+	if err := f.deliverer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.deliverer.Schedule(f.job); err != nil {
+		t.Fatal(err)
+	}
+	var req request
+	select {
+	case req = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	waitFor(t, func() bool {
+		got, _ := f.deliveries.ListByJob(f.job.ID)
+		return len(got) == 1 && got[0].Status == models.DeliveryDelivered
+	})
+	f.deliverer.Stop()
+
+	timestamp := req.header.Get(webhookTimestampHeader)
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		t.Fatalf("timestamp header %q", timestamp)
+	}
+	if got, want := req.header.Get(webhookSignatureHeader), "sha256="+SignWebhook("webhook-secret", timestamp, req.body); got != want {
+		t.Errorf("signature: got %s, want %s", got, want)
+	}
+	if req.header.Get(webhookEventHeader) != webhookEvent || req.header.Get("X-Team") != "finance" {
+		t.Errorf("headers: %v", req.header)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.JobID != f.job.ID || payload.RowsWritten != 2 ||
+		payload.DownloadURL != "https://bi.example.com/api/v1/jobs/"+strconv.Itoa(int(f.job.ID))+"/download" {
+		t.Errorf("payload: %+v", payload)
+	}
+
+	// 4xx 响应（408、429 除外）不再重试，5xx 响应可以重试
+	for code, perm := range map[int]bool{
+		http.StatusBadRequest:          true,
+		http.StatusNotFound:            true,
+		http.StatusTooManyRequests:     false,
+		http.StatusInternalServerError: false,
+	} {
+		status.Store(int32(code))
+		err := f.deliver(t, target)
+		<-requests
+		if err == nil || isPermanent(err) != perm {
+			t.Errorf("status %d: got %v, permanent %v", code, err, isPermanent(err))
+		}
+	}
+}
+
+// serveSMTP 在 ln 上接受一个连接，按最简单的 SMTP 会话收取邮件，
+// 收件人包含 reject 时以 550 拒绝
+func serveSMTP(ln net.Listener, reject string, messages chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+		switch verb {
+		case "EHLO", "HELO":
+			c.PrintfLine("250-localhost")
+			c.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			if reject != "" && strings.Contains(line, reject) {
+				c.PrintfLine("550 no such user")
+			} else {
+				c.PrintfLine("250 OK")
+			}
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			messages <- string(data)
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func newTestSMTPServer(t *testing.T, reject string) (config.SMTPConfig, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages := make(chan string, 1)
+	go serveSMTP(ln, reject, messages)
+	addr := ln.Addr().(*net.TCPAddr)
+	return config.SMTPConfig{Host: "127.0.0.1", Port: addr.Port, From: "BI <bi@example.com>"}, messages
+}
+
+func TestDelivererEmail(t *testing.T) {
+	smtpCfg, messages := newTestSMTPServer(t, "")
+	f := newTestDeliverer(t, config.DeliveryConfig{SMTP: smtpCfg, Timeout: 5 * time.Second})
+	target := f.addTarget(t, models.DeliveryEmail, models.DeliveryTargetConfig{
+		To: []string{"alice@example.com"}, Cc: []string{"bob@example.com"}, Subject: "报表 {{.ReportName}}", Attach: true})
+	if err := f.deliver(t, target); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-messages))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "报表 orders" || msg.Header.Get("Cc") != "bob@example.com" {
+		t.Errorf("headers: %v", msg.Header)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type %q: %v", mediaType, err)
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	if _, err := parts.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	attachment, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "report_1_1.csv" {
+		t.Errorf("attachment name %q", attachment.FileName())
+	}
+	content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, attachment))
+	if err != nil || string(content) != testReportContent {
+		t.Errorf("attachment: %q, %v", content, err)
+	}
+}
+
+func TestDelivererEmailRejected(t *testing.T) {
+	smtpCfg, _ := newTestSMTPServer(t, "nobody@")
+	f := newTestDeliverer(t, config.DeliveryConfig{SMTP: smtpCfg, Timeout: 5 * time.Second})
+	target := f.addTarget(t, models.DeliveryEmail, models.DeliveryTargetConfig{To: []string{"nobody@example.com"}, Attach: true})
+	if err := f.deliver(t, target); !isPermanent(err) {
+		t.Fatalf("got %v, want a permanent error", err)
+	}
+}
+
+func TestDelivererDirectory(t *testing.T) {
+	allowed := t.TempDir()
+	f := newTestDeliverer(t, config.DeliveryConfig{AllowedDirs: []string{allowed}})
+	target := f.addTarget(t, models.DeliveryDirectory, models.DeliveryTargetConfig{
+		Path: filepath.Join(allowed, "daily"), FileName: "{{.ReportName}}-{{.JobID}}.csv"})
+	if err := f.deliver(t, target); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(allowed, "daily", "orders-1.csv"))
+	if err != nil || string(content) != testReportContent {
+		t.Fatalf("copied file: %q, %v", content, err)
+	}
+
+	outside := f.addTarget(t, models.DeliveryDirectory, models.DeliveryTargetConfig{Path: t.TempDir()})
+	if err := f.deliver(t, outside); !isPermanent(err) {
+		t.Fatalf("got %v, want a permanent error", err)
+	}
+}
@@ -0,0 +1,555 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/storage"
+	"github.com/foldn/bi-go/pkg/utils"
+)
+
+// 投递内容的默认模板
+const (
+	defaultEmailSubject = "报表 {{.ReportName}} 已生成"
+	defaultEmailBody    = `报表 {{.ReportName}} 已于 {{.FinishedAt.Format "2006-01-02 15:04:05"}} 生成，共 {{.RowsWritten}} 行。
+{{if .DownloadURL}}
+下载地址：{{.DownloadURL}}
+{{end}}`
+	defaultDeliveryFileName = "{{.FileName}}"
+)
+
+// webhook 请求头，签名为 HMAC-SHA256(secret, timestamp + "." + body) 的十六进制
+const (
+	webhookEventHeader     = "X-BI-Event"
+	webhookDeliveryHeader  = "X-BI-Delivery"
+	webhookTimestampHeader = "X-BI-Timestamp"
+	webhookSignatureHeader = "X-BI-Signature"
+	webhookEvent           = "report.completed"
+)
+
+// ErrInvalidDeliveryTarget 投递目标的配置无效
+var ErrInvalidDeliveryTarget = errors.New("invalid delivery target")
+
+// DeliveryData 邮件主题、正文和投递文件名模板可使用的数据
+type DeliveryData struct {
+	DeliveryID  uint
+	ReportID    uint
+	ReportName  string
+	JobID       uint
+	Format      string
+	RowsWritten int64
+	FileName    string // 默认的文件名 report_{报表ID}_{任务ID}.{格式}
+	DownloadURL string // 未配置 delivery.baseurl 时为空
+	FinishedAt  time.Time
+}
+
+func newDeliveryData(delivery *models.ReportDelivery, job *models.ReportJob, report *models.Report, baseURL string) DeliveryData {
+	data := DeliveryData{
+		DeliveryID:  delivery.ID,
+		ReportID:    report.ID,
+		ReportName:  report.Name,
+		JobID:       job.ID,
+		Format:      job.Format,
+		RowsWritten: job.RowsWritten,
+		FileName:    utils.DownloadFileName(report.ID, job.ID, job.Format),
+		FinishedAt:  job.UpdatedAt,
+	}
+	if job.FinishedAt != nil {
+		data.FinishedAt = *job.FinishedAt
+	}
+	if baseURL != "" {
+		data.DownloadURL = fmt.Sprintf("%s/api/v1/jobs/%d/download", strings.TrimRight(baseURL, "/"), job.ID)
+	}
+	return data
+}
+
+// ValidateDeliveryTarget 检查投递目标的配置：收件人、URL、目录和模板
+func ValidateDeliveryTarget(target *models.DeliveryTarget, cfg config.DeliveryConfig) error {
+	c := target.Config
+	switch target.Type {
+	case models.DeliveryEmail:
+		if len(c.To) == 0 {
+			return fmt.Errorf("%w: email needs at least one recipient in to", ErrInvalidDeliveryTarget)
+		}
+		for _, addr := range append(append([]string{}, c.To...), c.Cc...) {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				return fmt.Errorf("%w: address %q: %v", ErrInvalidDeliveryTarget, addr, err)
+			}
+		}
+		if !c.Attach && cfg.BaseURL == "" {
+			return fmt.Errorf("%w: download links need delivery.baseurl to be configured, set attach to send the file instead", ErrInvalidDeliveryTarget)
+		}
+		if _, err := parseDeliveryTemplate("subject", c.Subject, defaultEmailSubject); err != nil {
+			return err
+		}
+		if _, err := parseDeliveryTemplate("body", c.Body, defaultEmailBody); err != nil {
+			return err
+		}
+	case models.DeliveryWebhook:
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidDeliveryTarget)
+		}
+		if c.Secret == "" {
+			return fmt.Errorf("%w: webhook needs a secret to sign requests", ErrInvalidDeliveryTarget)
+		}
+		for name, value := range c.Headers {
+			if !isHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("%w: invalid header %q", ErrInvalidDeliveryTarget, name)
+			}
+		}
+	case models.DeliveryDirectory:
+		if _, err := deliveryDir(c.Path, cfg.AllowedDirs); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidDeliveryTarget, err)
+		}
+		if _, err := parseDeliveryTemplate("fileName", c.FileName, defaultDeliveryFileName); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unsupported type %q", ErrInvalidDeliveryTarget, target.Type)
+	}
+	return nil
+}
+
+func isHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseDeliveryTemplate(name, text, def string) (*template.Template, error) {
+	if text == "" {
+		text = def
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidDeliveryTarget, name, err)
+	}
+	return tmpl, nil
+}
+
+func renderDeliveryTemplate(name, text, def string, data DeliveryData) (string, error) {
+	tmpl, err := parseDeliveryTemplate(name, text, def)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("渲染 %s 模板失败: %w", name, err)
+	}
+	return sb.String(), nil
+}
+
+// sendEmail 通过 SMTP 发送报表邮件，附件以 base64 边读边写，不整体读入内存
+func (d *Deliverer) sendEmail(ctx context.Context, target *models.DeliveryTarget, job *models.ReportJob, data DeliveryData) error {
+	cfg := d.cfg.SMTP
+	if cfg.Host == "" {
+		return permanent(errors.New("未配置 SMTP 服务器"))
+	}
+	c := target.Config
+	if !c.Attach && data.DownloadURL == "" {
+		return permanent(errors.New("未配置 delivery.baseurl，无法发送下载链接"))
+	}
+	subject, err := renderDeliveryTemplate("subject", c.Subject, defaultEmailSubject, data)
+	if err != nil {
+		return permanent(err)
+	}
+	body, err := renderDeliveryTemplate("body", c.Body, defaultEmailBody, data)
+	if err != nil {
+		return permanent(err)
+	}
+	var attachment io.Reader
+	if c.Attach {
+		f, err := d.openReportFile(ctx, job)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		attachment = f
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if cfg.TLS {
+		conn = tls.Client(conn, &tls.Config{ServerName: cfg.Host})
+	}
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !cfg.TLS {
+		if err := client.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return smtpError(err)
+		}
+	}
+	sender := cfg.From
+	if addr, err := mail.ParseAddress(cfg.From); err == nil {
+		sender = addr.Address
+	}
+	if err := client.Mail(sender); err != nil {
+		return smtpError(err)
+	}
+	for _, rcpt := range append(append([]string{}, c.To...), c.Cc...) {
+		addr, err := mail.ParseAddress(rcpt)
+		if err != nil {
+			return permanent(err)
+		}
+		if err := client.Rcpt(addr.Address); err != nil {
+			return smtpError(err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if err := writeEmail(w, cfg.From, c, subject, body, data.FileName, attachment); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(err)
+	}
+	return client.Quit()
+}
+
+// openReportFile 打开任务的报表文件，文件已不存在时重试也不会成功
+func (d *Deliverer) openReportFile(ctx context.Context, job *models.ReportJob) (io.ReadCloser, error) {
+	file, err := OpenReportFile(ctx, d.files, job)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, permanent(fmt.Errorf("报表文件不存在: %w", err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("打开报表文件失败: %w", err)
+	}
+	return file, nil
+}
+
+// smtpError 服务器以 5xx 拒绝时重试也不会成功
+func smtpError(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return permanent(err)
+	}
+	return err
+}
+
+// writeEmail 写出邮件头和正文，有附件时使用 multipart/mixed
+func writeEmail(w io.Writer, from string, c models.DeliveryTargetConfig, subject, body, fileName string, attachment io.Reader) error {
+	headers := []string{
+		"From: " + from,
+		"To: " + strings.Join(c.To, ", "),
+	}
+	if len(c.Cc) > 0 {
+		headers = append(headers, "Cc: "+strings.Join(c.Cc, ", "))
+	}
+	headers = append(headers,
+		"Subject: "+mime.BEncoding.Encode("utf-8", subject),
+		"Date: "+time.Now().Format(time.RFC1123Z),
+		"Message-ID: "+messageID(from),
+		"MIME-Version: 1.0",
+	)
+
+	if attachment == nil {
+		headers = append(headers, "Content-Type: text/plain; charset=utf-8", "Content-Transfer-Encoding: quoted-printable")
+		if _, err := io.WriteString(w, strings.Join(headers, "\r\n")+"\r\n\r\n"); err != nil {
+			return err
+		}
+		return writeQuotedPrintable(w, body)
+	}
+
+	mw := multipart.NewWriter(w)
+	headers = append(headers, "Content-Type: multipart/mixed; boundary="+mw.Boundary())
+	if _, err := io.WriteString(w, strings.Join(headers, "\r\n")+"\r\n\r\n"); err != nil {
+		return err
+	}
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeQuotedPrintable(part, body); err != nil {
+		return err
+	}
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(utils.ContentType(filepath.Ext(fileName)[1:]), map[string]string{"name": fileName})},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": fileName})},
+	})
+	if err != nil {
+		return err
+	}
+	encoder := base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: part, width: 76})
+	if _, err := io.Copy(encoder, attachment); err != nil {
+		return fmt.Errorf("读取报表文件失败: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, strings.ReplaceAll(s, "\n", "\r\n")); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if i := strings.LastIndexByte(addr.Address, '@'); i >= 0 {
+			domain = addr.Address[i+1:]
+		}
+	}
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(buf), domain)
+}
+
+// lineWrapper 每写出 width 个字符插入一次换行，用于 base64 编码的附件
+type lineWrapper struct {
+	w      io.Writer
+	width  int
+	column int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := l.width - l.column
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.column += n
+		p = p[n:]
+		if l.column == l.width {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.column = 0
+		}
+	}
+	return written, nil
+}
+
+// webhookPayload webhook 请求体
+type webhookPayload struct {
+	Event       string    `json:"event"`
+	DeliveryID  uint      `json:"deliveryId"`
+	ReportID    uint      `json:"reportId"`
+	ReportName  string    `json:"reportName"`
+	JobID       uint      `json:"jobId"`
+	Format      string    `json:"format"`
+	RowsWritten int64     `json:"rowsWritten"`
+	FileName    string    `json:"fileName"`
+	ContentType string    `json:"contentType"`
+	DownloadURL string    `json:"downloadUrl,omitempty"`
+	FinishedAt  time.Time `json:"finishedAt"`
+}
+
+// postWebhook 发送签名的任务完成通知。4xx 响应（408、429 除外）不再重试
+func (d *Deliverer) postWebhook(ctx context.Context, target *models.DeliveryTarget, data DeliveryData) error {
+	body, err := json.Marshal(webhookPayload{
+		Event:       webhookEvent,
+		DeliveryID:  data.DeliveryID,
+		ReportID:    data.ReportID,
+		ReportName:  data.ReportName,
+		JobID:       data.JobID,
+		Format:      data.Format,
+		RowsWritten: data.RowsWritten,
+		FileName:    data.FileName,
+		ContentType: utils.ContentType(data.Format),
+		DownloadURL: data.DownloadURL,
+		FinishedAt:  data.FinishedAt,
+	})
+	if err != nil {
+		return permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.Config.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	for name, value := range target.Config.Headers {
+		req.Header.Set(name, value)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bi-go")
+	req.Header.Set(webhookEventHeader, webhookEvent)
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatUint(uint64(data.DeliveryID), 10))
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+SignWebhook(target.Config.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("webhook 返回 %s", resp.Status)
+	if snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512)); len(bytes.TrimSpace(snippet)) > 0 {
+		err = fmt.Errorf("webhook 返回 %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return permanent(err)
+	}
+	return err
+}
+
+// SignWebhook 计算 webhook 请求的签名，接收方以相同方式计算后比较
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryDir 检查目录为绝对路径且位于允许的目录之内
+func deliveryDir(path string, allowed []string) (string, error) {
+	if len(allowed) == 0 {
+		return "", errors.New("directory delivery is disabled, configure delivery.alloweddirs to enable it")
+	}
+	if !filepath.IsAbs(path) {
+		return "", errors.New("path must be absolute")
+	}
+	dir := filepath.Clean(path)
+	for _, root := range allowed {
+		if withinDir(dir, filepath.Clean(root)) {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("path %s is outside delivery.alloweddirs", dir)
+}
+
+func withinDir(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// copyToDirectory 将报表文件复制到目标目录。先写入同目录下的临时文件再重命名，
+// 读取该目录的程序不会看到写了一半的文件
+func (d *Deliverer) copyToDirectory(ctx context.Context, target *models.DeliveryTarget, job *models.ReportJob, data DeliveryData) error {
+	allowed := d.cfg.AllowedDirs
+	dir, err := deliveryDir(target.Config.Path, allowed)
+	if err != nil {
+		return permanent(err)
+	}
+	name, err := renderDeliveryTemplate("fileName", target.Config.FileName, defaultDeliveryFileName, data)
+	if err != nil {
+		return permanent(err)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return permanent(fmt.Errorf("文件名无效: %q", name))
+	}
+
+	src, err := d.openReportFile(ctx, job)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// 目录中的符号链接可能指向允许范围之外
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	inside := false
+	for _, root := range allowed {
+		if realRoot, err := filepath.EvalSymlinks(root); err == nil && withinDir(real, realRoot) {
+			inside = true
+			break
+		}
+	}
+	if !inside {
+		return permanent(fmt.Errorf("目录 %s 解析后位于 delivery.alloweddirs 之外", dir))
+	}
+
+	tmp, err := os.CreateTemp(real, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(real, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/storage"
+	"gorm.io/gorm"
+)
+
+// 清理默认参数
+const (
+	defaultRetentionInterval = time.Hour
+	janitorBatchSize         = 100
+)
+
+// 任务到期的原因，与保留策略的字段名一致
+const (
+	ExpiredByKeepLast     = "keepLast"
+	ExpiredByMaxAge       = "maxAge"
+	ExpiredByMaxTotalSize = "maxTotalSize"
+)
+
+// ErrInvalidRetention 报表的保留策略无效
+var ErrInvalidRetention = errors.New("invalid retention policy")
+
+// ValidateReportRetention 检查报表保留策略的各项不为负数
+func ValidateReportRetention(r models.ReportRetention) error {
+	switch {
+	case r.KeepLast != nil && *r.KeepLast < 0:
+		return fmt.Errorf("%w: keepLast must not be negative", ErrInvalidRetention)
+	case r.MaxAgeDays != nil && *r.MaxAgeDays < 0:
+		return fmt.Errorf("%w: maxAgeDays must not be negative", ErrInvalidRetention)
+	case r.MaxTotalSize != nil && *r.MaxTotalSize < 0:
+		return fmt.Errorf("%w: maxTotalSize must not be negative", ErrInvalidRetention)
+	}
+	return nil
+}
+
+// RetentionPolicy 一个报表生效的保留策略，各项为 0 表示不限制
+type RetentionPolicy struct {
+	KeepLast     int
+	MaxAge       time.Duration
+	MaxTotalSize int64
+}
+
+// EffectiveRetention 以报表的设置覆盖全局配置，report 为 nil 时使用全局配置
+func EffectiveRetention(report *models.Report, cfg config.RetentionConfig) RetentionPolicy {
+	policy := RetentionPolicy{KeepLast: cfg.KeepLast, MaxAge: cfg.MaxAge, MaxTotalSize: cfg.MaxTotalSize}
+	if report == nil {
+		return policy
+	}
+	if r := report.Retention; r.KeepLast != nil {
+		policy.KeepLast = *r.KeepLast
+	}
+	if r := report.Retention; r.MaxAgeDays != nil {
+		policy.MaxAge = time.Duration(*r.MaxAgeDays) * 24 * time.Hour
+	}
+	if r := report.Retention; r.MaxTotalSize != nil {
+		policy.MaxTotalSize = *r.MaxTotalSize
+	}
+	return policy
+}
+
+// ExpiredJob 按保留策略应删除的任务
+type ExpiredJob struct {
+	Job    models.ReportJob
+	Reason string // ExpiredByKeepLast、ExpiredByMaxAge 或 ExpiredByMaxTotalSize
+}
+
+// expireJobs 按策略挑选到期的任务。jobs 为一个报表已结束的任务，按结束先后倒序。
+// 保留的文件总大小超限时，超限的任务及更早的任务都到期，但始终保留最新的任务
+func expireJobs(jobs []models.ReportJob, policy RetentionPolicy, now time.Time) []ExpiredJob {
+	var expired []ExpiredJob
+	var kept int64
+	full := false
+	for i := range jobs {
+		job := &jobs[i]
+		finishedAt := job.UpdatedAt
+		if job.FinishedAt != nil {
+			finishedAt = *job.FinishedAt
+		}
+		reason := ""
+		switch {
+		case policy.KeepLast > 0 && i >= policy.KeepLast:
+			reason = ExpiredByKeepLast
+		case policy.MaxAge > 0 && now.Sub(finishedAt) > policy.MaxAge:
+			reason = ExpiredByMaxAge
+		case policy.MaxTotalSize > 0 && i > 0 && (full || kept+job.FileSize > policy.MaxTotalSize):
+			reason = ExpiredByMaxTotalSize
+			full = true
+		}
+		if reason == "" {
+			kept += job.FileSize
+			continue
+		}
+		expired = append(expired, ExpiredJob{Job: *job, Reason: reason})
+	}
+	return expired
+}
+
+// Janitor 按保留策略定期删除过期的报表任务及其文件。
+// 多个实例同时清理时删除是幂等的，不需要互相协调
+type Janitor struct {
+	jobs       repository.ReportJobRepository
+	reports    repository.ReportRepository
+	deliveries repository.ReportDeliveryRepository
+	files      storage.Storage
+	cfg        config.RetentionConfig
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewJanitor 创建清理器，需调用 Start 启动
+func NewJanitor(jobs repository.ReportJobRepository, reports repository.ReportRepository,
+	deliveries repository.ReportDeliveryRepository, files storage.Storage, cfg config.RetentionConfig) *Janitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultRetentionInterval
+	}
+	return &Janitor{
+		jobs:       jobs,
+		reports:    reports,
+		deliveries: deliveries,
+		files:      files,
+		cfg:        cfg,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start 启动清理循环，启动时立即清理一次
+func (j *Janitor) Start() {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(j.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			if _, err := j.Sweep(time.Now()); err != nil {
+				log.Printf("Failed to clean up expired report jobs: %v", err)
+			}
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止清理循环，等待进行中的清理结束
+func (j *Janitor) Stop() {
+	j.once.Do(func() { close(j.stop) })
+	j.wg.Wait()
+}
+
+// Plan 列出按当前保留策略应删除的任务，reportID 为 0 时检查所有报表。
+// 仍有待投递记录的任务暂不删除。报表已删除时和数据处理任务按全局配置处理
+func (j *Janitor) Plan(reportID uint, now time.Time) ([]ExpiredJob, error) {
+	reportIDs := []uint{reportID}
+	if reportID == 0 {
+		var err error
+		if reportIDs, err = j.jobs.ReportIDs(); err != nil {
+			return nil, err
+		}
+	}
+	activeIDs, err := j.deliveries.ActiveJobIDs()
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[uint]bool, len(activeIDs))
+	for _, id := range activeIDs {
+		active[id] = true
+	}
+
+	var expired []ExpiredJob
+	for _, id := range reportIDs {
+		// 数据处理任务的 ReportID 为 0，与已删除报表的任务一样按全局配置处理
+		var report *models.Report
+		if id != 0 {
+			report, err = j.reports.GetByID(id)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				report = nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		policy := EffectiveRetention(report, j.cfg)
+		if policy == (RetentionPolicy{}) {
+			continue
+		}
+		jobs, err := j.jobs.ListFinished(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range expireJobs(jobs, policy, now) {
+			if !active[e.Job.ID] {
+				expired = append(expired, e)
+			}
+		}
+	}
+	return expired, nil
+}
+
+// Sweep 删除到期任务的文件和记录，返回删除的任务数。
+// 文件删除失败的任务保留到下一次清理，不会留下没有记录的文件
+func (j *Janitor) Sweep(now time.Time) (int, error) {
+	expired, err := j.Plan(0, now)
+	if err != nil {
+		return 0, err
+	}
+	var purged int64
+	var bytes int64
+	for start := 0; start < len(expired); start += janitorBatchSize {
+		end := start + janitorBatchSize
+		if end > len(expired) {
+			end = len(expired)
+		}
+		ids := make([]uint, 0, end-start)
+		for _, e := range expired[start:end] {
+			if err := j.removeFile(&e.Job); err != nil {
+				log.Printf("Failed to remove file of report job %d: %v", e.Job.ID, err)
+				continue
+			}
+			ids = append(ids, e.Job.ID)
+			bytes += e.Job.FileSize
+		}
+		n, err := j.jobs.Purge(ids)
+		purged += n
+		if err != nil {
+			return int(purged), err
+		}
+	}
+	if purged > 0 {
+		log.Printf("Removed %d expired report jobs, %d bytes of files", purged, bytes)
+	}
+	return int(purged), nil
+}
+
+func (j *Janitor) removeFile(job *models.ReportJob) error {
+	if job.StorageKey != "" {
+		return j.files.Delete(context.Background(), job.StorageKey)
+	}
+	// 旧版本生成的任务只记录了本地路径
+	if job.FilePath != "" {
+		if err := os.Remove(job.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/storage"
+)
+
+func TestExpireJobs(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	// 按结束时间倒序：第 i 个任务在 i 天前结束，文件大小 sizes[i]
+	newJobs := func(sizes ...int64) []models.ReportJob {
+		jobs := make([]models.ReportJob, len(sizes))
+		for i, size := range sizes {
+			finishedAt := now.Add(-time.Duration(i) * 24 * time.Hour)
+			jobs[i].ID = uint(len(sizes) - i)
+			jobs[i].FinishedAt = &finishedAt
+			jobs[i].FileSize = size
+		}
+		return jobs
+	}
+	withoutFinishedAt := newJobs(1, 1, 1)
+	for i := range withoutFinishedAt {
+		withoutFinishedAt[i].UpdatedAt = *withoutFinishedAt[i].FinishedAt
+		withoutFinishedAt[i].FinishedAt = nil
+	}
+
+	tests := []struct {
+		name   string
+		jobs   []models.ReportJob
+		policy RetentionPolicy
+		want   map[uint]string // 到期任务的 ID 和原因
+	}{
+		{"no limits", newJobs(1, 1, 1), RetentionPolicy{}, map[uint]string{}},
+		{"keep last", newJobs(1, 1, 1, 1), RetentionPolicy{KeepLast: 2}, map[uint]string{2: ExpiredByKeepLast, 1: ExpiredByKeepLast}},
+		{"max age", newJobs(1, 1, 1, 1), RetentionPolicy{MaxAge: 36 * time.Hour}, map[uint]string{2: ExpiredByMaxAge, 1: ExpiredByMaxAge}},
+		{"max age of old jobs without finish time", withoutFinishedAt, RetentionPolicy{MaxAge: 36 * time.Hour},
+			map[uint]string{1: ExpiredByMaxAge}},
+		{"keep last before max age", newJobs(1, 1, 1), RetentionPolicy{KeepLast: 1, MaxAge: time.Hour},
+			map[uint]string{2: ExpiredByKeepLast, 1: ExpiredByKeepLast}},
+		{"max total size", newJobs(40, 40, 30, 10), RetentionPolicy{MaxTotalSize: 100},
+			map[uint]string{2: ExpiredByMaxTotalSize, 1: ExpiredByMaxTotalSize}},
+		{"newest job is always kept", newJobs(500, 10, 10), RetentionPolicy{MaxTotalSize: 100},
+			map[uint]string{2: ExpiredByMaxTotalSize, 1: ExpiredByMaxTotalSize}},
+		{"total size counts only kept jobs", newJobs(60, 1, 60, 1), RetentionPolicy{KeepLast: 3, MaxTotalSize: 100},
+			map[uint]string{2: ExpiredByMaxTotalSize, 1: ExpiredByKeepLast}},
+	}
+	for _, tt := range tests {
+		got := make(map[uint]string)
+		for _, e := range expireJobs(tt.jobs, tt.policy, now) {
+			got[e.Job.ID] = e.Reason
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJanitorSweep(t *testing.T) {
+	db := newTestDB(t)
+	jobs := repository.NewReportJobRepository(db)
+	deliveries := repository.NewReportDeliveryRepository(db)
+	files := storage.NewLocal(t.TempDir())
+	keepLast := 2
+	report := &models.Report{Name: "sales", Query: "SELECT 1", Retention: models.ReportRetention{KeepLast: &keepLast}}
+	if err := db.Create(report).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	addJob := func(status models.ReportJobStatus, finishedAgo time.Duration) *models.ReportJob {
+		t.Helper()
+		finishedAt := now.Add(-finishedAgo)
+		job := &models.ReportJob{ReportID: report.ID, Status: status, Format: "csv", FinishedAt: &finishedAt, FileSize: 3}
+		if err := db.Create(job).Error; err != nil {
+			t.Fatal(err)
+		}
+		job.StorageKey = fmt.Sprintf("jobs/%d.csv", job.ID)
+		path := filepath.Join(t.TempDir(), "report.csv")
+		if err := os.WriteFile(path, []byte("a\n1"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := files.PutFile(context.Background(), job.StorageKey, path, "text/csv"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Save(job).Error; err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	// retried 最先创建，重试后最后结束，按结束时间是最新的任务
+	retried := addJob(models.JobStatusCompleted, 0)
+	oldest := addJob(models.JobStatusCompleted, 4*time.Hour)
+	delivering := addJob(models.JobStatusFailed, 3*time.Hour)
+	middle := addJob(models.JobStatusCompleted, 2*time.Hour)
+	newer := addJob(models.JobStatusCompleted, time.Hour)
+	running := &models.ReportJob{ReportID: report.ID, Status: models.JobStatusRunning, Format: "csv"}
+	if err := db.Create(running).Error; err != nil {
+		t.Fatal(err)
+	}
+	err := deliveries.Create([]models.ReportDelivery{{JobID: delivering.ID, TargetID: 1, Type: models.DeliveryWebhook, Status: models.DeliveryPending}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j := NewJanitor(jobs, repository.NewReportRepository(db), deliveries, files, config.RetentionConfig{})
+	plan, err := j.Plan(report.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var planned []uint
+	for _, e := range plan {
+		planned = append(planned, e.Job.ID)
+	}
+	if want := []uint{middle.ID, oldest.ID}; !reflect.DeepEqual(planned, want) {
+		t.Fatalf("planned %v, want %v", planned, want)
+	}
+
+	purged, err := j.Sweep(now)
+	if err != nil || purged != 2 {
+		t.Fatalf("purged %d, %v", purged, err)
+	}
+	for _, job := range []*models.ReportJob{retried, newer, delivering, running} {
+		if _, err := jobs.GetByID(job.ID); err != nil {
+			t.Errorf("job %d: %v", job.ID, err)
+		}
+	}
+	for _, job := range []*models.ReportJob{oldest, middle} {
+		if _, err := jobs.GetByID(job.ID); err == nil {
+			t.Errorf("job %d was not purged", job.ID)
+		}
+		if _, err := files.Open(context.Background(), job.StorageKey); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("file of job %d: got %v, want ErrNotFound", job.ID, err)
+		}
+	}
+	r, err := files.Open(context.Background(), retried.StorageKey)
+	if err != nil {
+		t.Fatalf("file of the newest job: %v", err)
+	}
+	r.Close()
+
+	// 投递结束后，该任务在下一次清理时删除
+	if err := db.Model(&models.ReportDelivery{}).Where("job_id = ?", delivering.ID).Update("status", models.DeliveryDelivered).Error; err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := j.Sweep(now); err != nil || purged != 1 {
+		t.Fatalf("purged %d, %v", purged, err)
+	}
+	if _, err := jobs.GetByID(delivering.ID); err == nil {
+		t.Error("job with finished deliveries was not purged")
+	}
+}
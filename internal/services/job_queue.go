@@ -0,0 +1,436 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/sqlguard"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 任务队列默认参数，配置项为零值时使用
+const (
+	defaultQueueWorkers    = 4
+	defaultPollInterval    = 5 * time.Second
+	defaultMaxAttempts     = 3
+	defaultRetryBackoff    = 10 * time.Second
+	defaultMaxRetryBackoff = 5 * time.Minute
+	defaultJobTimeout      = 30 * time.Minute
+	defaultLeaseDuration   = time.Minute
+)
+
+// instanceID 本进程的标识：主机名、进程号和随机后缀，同一主机上重启后也不相同
+var instanceID = sync.OnceValue(func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	id := fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+	if len(id) > 64 {
+		id = id[len(id)-64:]
+	}
+	return id
+})
+
+// ErrJobNotCancellable 任务已结束，无法取消
+var ErrJobNotCancellable = errors.New("job has already finished and cannot be cancelled")
+
+// JobQueue 基于元数据库的任务队列，执行报表生成任务和数据处理任务。任务以 pending 状态落库，
+// 由固定数量的 worker 领取执行；失败的任务按指数退避重试。
+// 多个实例可以共用一个队列：领取任务的实例持有租约并在执行中续约，
+// 租约到期的任务视为其实例已退出，由任一实例恢复。
+type JobQueue struct {
+	owner     string // 本实例的标识，记录在领取的任务中
+	jobs      repository.ReportJobRepository
+	generator *ReportGenerator
+	processor *Processor // 为空时无法执行数据处理任务
+	deliverer *Deliverer // 为空时完成的任务不投递
+	cfg       config.QueueConfig
+
+	notify chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+
+	mu      sync.Mutex
+	running map[uint]context.CancelFunc // 本进程中正在执行的任务
+}
+
+// NewJobQueue 创建任务队列，需调用 Start 启动 worker。processor、deliverer 可以为 nil
+func NewJobQueue(jobs repository.ReportJobRepository, generator *ReportGenerator, processor *Processor,
+	deliverer *Deliverer, cfg config.QueueConfig) *JobQueue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultQueueWorkers
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if cfg.MaxRetryBackoff <= 0 {
+		cfg.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	if cfg.JobTimeout <= 0 {
+		cfg.JobTimeout = defaultJobTimeout
+	}
+	if cfg.LeaseDuration <= 0 {
+		cfg.LeaseDuration = defaultLeaseDuration
+	}
+	return &JobQueue{
+		owner:     instanceID(),
+		jobs:      jobs,
+		generator: generator,
+		processor: processor,
+		deliverer: deliverer,
+		cfg:       cfg,
+		notify:    make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		running:   make(map[uint]context.CancelFunc),
+	}
+}
+
+// Enqueue 创建 pending 任务并唤醒空闲的 worker，parameters 为已校验的参数值
+func (q *JobQueue) Enqueue(reportID uint, format string, parameters map[string]interface{}) (*models.ReportJob, error) {
+	job := q.newJob(reportID, format, parameters)
+	if err := q.jobs.Create(job); err != nil {
+		return nil, err
+	}
+	q.wake()
+	return job, nil
+}
+
+// EnqueueProcess 创建 pending 的数据处理任务，结果按 ndjson 保存
+func (q *JobQueue) EnqueueProcess(pl *models.Pipeline) (*models.ReportJob, error) {
+	job := &models.ReportJob{
+		Kind:        models.JobKindProcess,
+		Status:      models.JobStatusPending,
+		Format:      "ndjson",
+		Pipeline:    pl,
+		MaxAttempts: q.cfg.MaxAttempts,
+	}
+	if err := q.jobs.Create(job); err != nil {
+		return nil, err
+	}
+	q.wake()
+	return job, nil
+}
+
+// newJob 构造尚未落库的 pending 报表任务
+func (q *JobQueue) newJob(reportID uint, format string, parameters map[string]interface{}) *models.ReportJob {
+	return &models.ReportJob{
+		Kind:        models.JobKindReport,
+		ReportID:    reportID,
+		Status:      models.JobStatusPending,
+		Format:      format,
+		Parameters:  parameters,
+		MaxAttempts: q.cfg.MaxAttempts,
+	}
+}
+
+// Cancel 取消 pending 或 running 的任务。任务状态先在数据库中置为 cancelled，
+// 再中止本进程中正在执行的查询；在其他实例中执行的任务由该实例的 heartbeat 在 queue.pollinterval 内发现并中止
+func (q *JobQueue) Cancel(id uint) (*models.ReportJob, error) {
+	for {
+		job, err := q.jobs.GetByID(id)
+		if err != nil {
+			return nil, err
+		}
+		from := job.Status
+		if from != models.JobStatusPending && from != models.JobStatusRunning {
+			return nil, ErrJobNotCancellable
+		}
+
+		now := time.Now()
+		job.Status = models.JobStatusCancelled
+		job.NextRunAt = nil
+		job.FinishedAt = &now
+		job.LeaseUntil = nil
+		err = q.jobs.Transition(job, from, "任务已取消")
+		if errors.Is(err, repository.ErrJobStateConflict) {
+			// 读取后状态发生了变化（例如刚被 worker 领取），重新判断
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		q.mu.Lock()
+		cancel, ok := q.running[id]
+		q.mu.Unlock()
+		if ok {
+			cancel()
+		}
+		return job, nil
+	}
+}
+
+// Start 恢复租约已到期的任务并启动 worker，之后每隔一个租约时长检查一次
+func (q *JobQueue) Start() error {
+	if err := q.recoverExpired(); err != nil {
+		return err
+	}
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	q.wg.Add(1)
+	go q.reaper()
+	return nil
+}
+
+// Stop 停止领取新任务，并等待正在执行的任务结束
+func (q *JobQueue) Stop() {
+	q.once.Do(func() { close(q.stop) })
+	q.wg.Wait()
+}
+
+func (q *JobQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// recoverExpired 接管租约已到期的 running 任务，视为执行被中断：
+// 还有剩余次数的重新排队，否则标记为失败。其他实例正在执行的任务持续续约，不会被接管
+func (q *JobQueue) recoverExpired() error {
+	now := time.Now()
+	jobs, err := q.jobs.ClaimExpired(now, q.owner, now.Add(q.cfg.LeaseDuration))
+	if err != nil {
+		return fmt.Errorf("failed to recover interrupted report jobs: %w", err)
+	}
+	for i := range jobs {
+		q.finishAttempt(&jobs[i], errors.New("任务执行被中断"), true)
+	}
+	if len(jobs) > 0 {
+		log.Printf("Recovered %d interrupted report jobs", len(jobs))
+	}
+	return nil
+}
+
+// reaper 定期恢复其他实例退出后留下的任务
+func (q *JobQueue) reaper() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.cfg.LeaseDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+			if err := q.recoverExpired(); err != nil {
+				log.Print(err)
+			} else {
+				q.wake()
+			}
+		}
+	}
+}
+
+// heartbeat 在任务执行期间续约，直到 done 关闭。任务已不再由本实例持有时（在其他实例上被取消，
+// 或租约到期后被其他实例恢复）中止执行，使查询随之取消，也避免同一任务在两个实例上同时执行。
+// 续约间隔不超过 PollInterval，取消在该间隔内生效
+func (q *JobQueue) heartbeat(job *models.ReportJob, cancel context.CancelFunc, done <-chan struct{}) {
+	ticker := time.NewTicker(min(q.cfg.PollInterval, q.cfg.LeaseDuration/3))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		until := time.Now().Add(q.cfg.LeaseDuration)
+		held, err := q.jobs.RenewLease(job.ID, q.owner, until)
+		switch {
+		case err != nil:
+			// 数据库暂时不可用时继续执行，租约到期前仍有机会续约
+			log.Printf("failed to renew lease of report job %d: %v", job.ID, err)
+		case !held:
+			if current, err := q.jobs.GetByID(job.ID); err == nil && current.Status == models.JobStatusCancelled {
+				log.Printf("Report job %d was cancelled, stopping", job.ID)
+			} else {
+				log.Printf("Report job %d is no longer held by this instance, stopping", job.ID)
+			}
+			cancel()
+			return
+		default:
+			job.LeaseUntil = &until
+		}
+	}
+}
+
+func (q *JobQueue) worker() {
+	defer q.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		now := time.Now()
+		job, err := q.jobs.ClaimNext(now, q.owner, now.Add(q.cfg.LeaseDuration))
+		if err != nil {
+			log.Printf("failed to claim report job: %v", err)
+		}
+		if job != nil {
+			q.run(job)
+			continue
+		}
+
+		// 没有可执行的任务，等待新任务通知或下一次轮询
+		timer.Reset(q.cfg.PollInterval)
+		select {
+		case <-q.stop:
+			return
+		case <-q.notify:
+		case <-timer.C:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// run 执行已领取的任务，生成过程中的 panic 按失败处理
+func (q *JobQueue) run(job *models.ReportJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.cfg.JobTimeout)
+	defer cancel()
+	q.mu.Lock()
+	q.running[job.ID] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, job.ID)
+		q.mu.Unlock()
+	}()
+
+	var file *ReportFile
+	var rows int64
+	progress := func(n int64) {
+		if err := q.jobs.UpdateProgress(job.ID, n); err != nil {
+			log.Printf("failed to update progress of report job %d: %v", job.ID, err)
+		}
+	}
+	done := make(chan struct{})
+	go q.heartbeat(job, cancel, done)
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("报表生成异常: %v", r)
+			}
+		}()
+		file, rows, err = q.generate(ctx, job, progress)
+		return err
+	}()
+	close(done)
+
+	job.RowsWritten = rows
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		// 由 Cancel 中止，或已被其他实例接管，状态已经记录
+		if file != nil {
+			q.generator.removeReportFile(file)
+		}
+		log.Printf("Report job %d cancelled", job.ID)
+	case errors.Is(err, context.DeadlineExceeded):
+		// 超时的查询重试大概率仍会超时，直接失败
+		q.finishAttempt(job, fmt.Errorf("执行超时: %w", err), false)
+	case errors.Is(err, sqlguard.ErrUnsafeQuery), errors.Is(err, ErrInvalidParameter), errors.Is(err, ErrInvalidPipeline),
+		errors.Is(err, database.ErrEntityNotFound):
+		// 查询、参数或处理操作本身不合法，或处理的实体不存在，重试不会成功
+		q.finishAttempt(job, err, false)
+	case err != nil:
+		q.finishAttempt(job, err, true)
+	default:
+		now := time.Now()
+		job.Status = models.JobStatusCompleted
+		job.StorageKey = file.Key
+		job.FileSize = file.Size
+		job.Checksum = file.Checksum
+		job.Error = ""
+		job.FinishedAt = &now
+		job.LeaseUntil = nil
+		err := q.transition(job, models.JobStatusRunning, "")
+		if errors.Is(err, repository.ErrJobStateConflict) {
+			// 执行期间被其他实例取消或接管，丢弃生成的文件
+			q.generator.removeReportFile(file)
+			return
+		}
+		if err == nil && q.deliverer != nil && job.Kind != models.JobKindProcess {
+			if err := q.deliverer.Schedule(job); err != nil {
+				log.Printf("failed to schedule deliveries of report job %d: %v", job.ID, err)
+			}
+		}
+	}
+}
+
+// generate 按任务类型生成报表文件或处理结果
+func (q *JobQueue) generate(ctx context.Context, job *models.ReportJob, progress ProgressFunc) (*ReportFile, int64, error) {
+	if job.Kind != models.JobKindProcess {
+		return q.generator.Generate(ctx, job, progress)
+	}
+	if q.processor == nil {
+		return nil, 0, errors.New("未配置数据处理器")
+	}
+	return q.processor.Generate(ctx, job, progress)
+}
+
+// finishAttempt 记录一次失败的执行，retry 为 true 且仍有剩余次数时重新排队，否则标记失败
+func (q *JobQueue) finishAttempt(job *models.ReportJob, cause error, retry bool) {
+	now := time.Now()
+	job.Error = cause.Error()
+	job.LeaseUntil = nil
+	if retry && job.Attempts < job.MaxAttempts {
+		delay := q.backoff(job.Attempts)
+		next := now.Add(delay)
+		job.Status = models.JobStatusPending
+		job.NextRunAt = &next
+		q.transition(job, models.JobStatusRunning, fmt.Sprintf("%s，%s 后重试", job.Error, delay))
+		return
+	}
+	job.Status = models.JobStatusFailed
+	job.FinishedAt = &now
+	q.transition(job, models.JobStatusRunning, job.Error)
+}
+
+// backoff 第 attempt 次失败后的等待时间
+func (q *JobQueue) backoff(attempt int) time.Duration {
+	delay := q.cfg.RetryBackoff
+	for i := 1; i < attempt && delay < q.cfg.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.cfg.MaxRetryBackoff {
+		delay = q.cfg.MaxRetryBackoff
+	}
+	return delay
+}
+
+func (q *JobQueue) transition(job *models.ReportJob, from models.ReportJobStatus, message string) error {
+	err := q.jobs.Transition(job, from, message)
+	if err != nil {
+		log.Printf("failed to save report job %d: %v", job.ID, err)
+	}
+	return err
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/secrets"
+	"github.com/foldn/bi-go/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB 在临时目录中创建 SQLite 元数据库并建表
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "meta.db")+"?_busy_timeout=5000"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.DataSource{}, &models.Report{}, &models.ReportJob{}, &models.ReportJobEvent{},
+		&models.ReportSchedule{}, &models.DeliveryTarget{}, &models.ReportDelivery{}, &models.ReportDeliveryEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// newTestQueue 创建不启动 worker 的队列，owner 模拟不同的实例
+func newTestQueue(jobs repository.ReportJobRepository, owner string, lease time.Duration) *JobQueue {
+	q := NewJobQueue(jobs, nil, nil, nil, config.QueueConfig{LeaseDuration: lease, MaxAttempts: 3})
+	q.owner = owner
+	return q
+}
+
+func TestJobQueueRecoversOnlyExpiredLeases(t *testing.T) {
+	jobs := repository.NewReportJobRepository(newTestDB(t))
+	a := newTestQueue(jobs, "instance-a", time.Minute)
+	b := newTestQueue(jobs, "instance-b", time.Minute)
+
+	job, err := a.Enqueue(1, "csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claimed, err := jobs.ClaimNext(now, a.owner, now.Add(time.Minute))
+	if err != nil || claimed == nil || claimed.ID != job.ID {
+		t.Fatalf("claim: %v, %v", claimed, err)
+	}
+
+	// 实例 b 启动时，a 的租约仍有效，任务不受影响
+	if err := b.recoverExpired(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := jobs.GetByID(job.ID)
+	if got.Status != models.JobStatusRunning || got.ClaimedBy != a.owner {
+		t.Fatalf("live job was recovered: %+v", got)
+	}
+
+	// a 续约后租约延长
+	held, err := jobs.RenewLease(job.ID, a.owner, now.Add(2*time.Minute))
+	if err != nil || !held {
+		t.Fatalf("renew: %v, %v", held, err)
+	}
+	// 其他实例不能续约
+	if held, _ := jobs.RenewLease(job.ID, b.owner, now.Add(2*time.Minute)); held {
+		t.Fatal("lease renewed by another instance")
+	}
+
+	// 租约到期后由 b 恢复，重新排队
+	expired, err := jobs.ClaimExpired(now.Add(3*time.Minute), b.owner, now.Add(4*time.Minute))
+	if err != nil || len(expired) != 1 {
+		t.Fatalf("claim expired: %v, %v", expired, err)
+	}
+	b.finishAttempt(&expired[0], context.Canceled, true)
+	got, _ = jobs.GetByID(job.ID)
+	if got.Status != models.JobStatusPending || got.ClaimedBy != b.owner || got.LeaseUntil != nil {
+		t.Fatalf("expired job not requeued: %+v", got)
+	}
+
+	// a 执行结束时任务已被接管，不能再覆盖状态
+	claimed.Status = models.JobStatusCompleted
+	if err := jobs.Transition(claimed, models.JobStatusRunning, ""); err != repository.ErrJobStateConflict {
+		t.Fatalf("stale owner transition: %v", err)
+	}
+}
+
+func TestJobQueueRecoversJobsWithoutLease(t *testing.T) {
+	db := newTestDB(t)
+	jobs := repository.NewReportJobRepository(db)
+	q := newTestQueue(jobs, "instance-a", time.Minute)
+
+	// 升级前领取的任务没有租约和实例
+	job := &models.ReportJob{Kind: models.JobKindReport, ReportID: 1, Status: models.JobStatusRunning, Format: "csv", Attempts: 1, MaxAttempts: 1}
+	if err := db.Create(job).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(job).Update("claimed_by", gorm.Expr("NULL")).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := q.recoverExpired(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := jobs.GetByID(job.ID)
+	if got.Status != models.JobStatusFailed {
+		t.Fatalf("got %+v, want failed", got)
+	}
+}
+
+func TestJobQueueHeartbeat(t *testing.T) {
+	jobs := repository.NewReportJobRepository(newTestDB(t))
+	a := newTestQueue(jobs, "instance-a", 60*time.Millisecond)
+
+	if _, err := a.Enqueue(1, "csv", nil); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	job, err := jobs.ClaimNext(now, a.owner, now.Add(a.cfg.LeaseDuration))
+	if err != nil || job == nil {
+		t.Fatalf("claim: %v, %v", job, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go a.heartbeat(job, cancel, done)
+
+	// 续约使租约在执行期间不会到期
+	time.Sleep(5 * a.cfg.LeaseDuration)
+	if expired, err := jobs.ClaimExpired(time.Now(), "instance-b", time.Now().Add(time.Minute)); err != nil || len(expired) != 0 {
+		t.Fatalf("running job expired: %v, %v", expired, err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("job cancelled while holding the lease")
+	}
+
+	// 任务不再由本实例持有时（如被其他实例取消）中止执行
+	b := newTestQueue(jobs, "instance-b", time.Minute)
+	if _, err := b.Cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat did not stop the job after it was cancelled")
+	}
+	close(done)
+}
+
+func newTestKeyring(t *testing.T) *secrets.Keyring {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	keyring, err := secrets.NewKeyring(config.SecurityConfig{MasterKeyID: "test", MasterKey: base64.StdEncoding.EncodeToString(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// newTestDataSource 在元数据库中创建一个 SQLite 数据源，其中的 orders 表有两行
+func newTestDataSource(t *testing.T, db *gorm.DB) (*models.DataSource, repository.DataSourceRepository) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "source.db")
+	source, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Exec("CREATE TABLE orders (id INTEGER PRIMARY KEY, region TEXT, amount REAL)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Exec("INSERT INTO orders (region, amount) VALUES ('east', 10), ('west', 20.5)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if sqlDB, err := source.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	dataSources := repository.NewDataSourceRepository(db, newTestKeyring(t))
+	ds := &models.DataSource{Name: "source", Type: models.Sqlite, FilePath: path}
+	if err := dataSources.Create(ds); err != nil {
+		t.Fatal(err)
+	}
+	return ds, dataSources
+}
+
+// newTestConnections 创建测试结束时关闭的连接池
+func newTestConnections(t *testing.T) *database.ConnectionManager {
+	connections := database.NewConnectionManager(config.PoolConfig{})
+	t.Cleanup(func() { connections.Close() })
+	return connections
+}
+
+// newTestGenerator 在元数据库中创建一个 SQLite 数据源和以 query 为查询的报表，返回报表 ID 和报表生成器
+func newTestGenerator(t *testing.T, db *gorm.DB, query string) (uint, *ReportGenerator) {
+	t.Helper()
+	ds, dataSources := newTestDataSource(t, db)
+	reports := repository.NewReportRepository(db)
+	report := &models.Report{Name: "report", DataSourceID: ds.ID, Query: query}
+	if err := reports.Create(report); err != nil {
+		t.Fatal(err)
+	}
+	return report.ID, NewReportGenerator(reports, dataSources, newTestConnections(t), storage.NewLocal(t.TempDir()), config.ReportConfig{})
+}
+
+// TestJobQueueCancelOnOtherInstance 在其他实例上取消执行中的任务时，执行实例中止正在执行的查询
+func TestJobQueueCancelOnOtherInstance(t *testing.T) {
+	db := newTestDB(t)
+	jobs := repository.NewReportJobRepository(db)
+	reportID, generator := newTestGenerator(t, db,
+		"WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000000) SELECT COUNT(*) FROM c")
+
+	a := NewJobQueue(jobs, generator, nil, nil, config.QueueConfig{Workers: 1, PollInterval: 50 * time.Millisecond})
+	a.owner = "instance-a"
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+	b := newTestQueue(jobs, "instance-b", time.Minute)
+
+	job, err := a.Enqueue(reportID, "csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		got, _ := jobs.GetByID(job.ID)
+		return got.Status == models.JobStatusRunning
+	})
+	if _, err := b.Cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return len(a.running) == 0
+	})
+	got, _ := jobs.GetByID(job.ID)
+	if got.Status != models.JobStatusCancelled || got.StorageKey != "" {
+		t.Fatalf("got %+v, want cancelled without a file", got)
+	}
+}
+
+// waitFor 等待 cond 成立，最多 5 秒
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/go-pdf/fpdf"
+)
+
+// PDF 版面参数，单位为毫米
+const (
+	pdfMargin      = 15.0
+	pdfLineHeight  = 5.0
+	pdfCellPadding = 1.5
+	pdfFontSize    = 9.0
+	// pdfMinColumnWidth 列宽按内容估算，但不小于该值
+	pdfMinColumnWidth = 12.0
+)
+
+var pdfPageSizes = map[string]string{
+	"":       "A4",
+	"a4":     "A4",
+	"a3":     "A3",
+	"letter": "Letter",
+	"legal":  "Legal",
+}
+
+// pdfDocument 在 fpdf 之上绘制报表：首页标题区、可跨页的表格，以及每页的页眉页脚
+type pdfDocument struct {
+	pdf    *fpdf.Fpdf
+	data   *RenderData
+	family string
+	// text 将 UTF-8 文本转换为当前字体能输出的编码
+	text func(string) string
+	// utf8 为 false 时使用内置字体，只能显示 Latin-1 字符
+	utf8 bool
+
+	widths       []float64
+	tableStarted bool
+	err          error
+}
+
+// writePDF 渲染 PDF 报表。表格跨页时在每页顶部重复表头
+func writePDF(w io.Writer, job *models.ReportJob, report *models.Report, columns []string, rows RowIterator, cfg config.RenderConfig) error {
+	pageSize, ok := pdfPageSizes[strings.ToLower(cfg.PageSize)]
+	if !ok {
+		return fmt.Errorf("不支持的 PDF 纸张大小: %s", cfg.PageSize)
+	}
+	header, footer, err := parsePageTemplates(report.Layout)
+	if err != nil {
+		return err
+	}
+	data, err := collectRenderData(job, report, columns, rows, cfg.MaxRows)
+	if err != nil {
+		return err
+	}
+
+	orientation := "P"
+	if report.Layout.Landscape {
+		orientation = "L"
+	}
+	pdf := fpdf.New(orientation, "mm", pageSize, "")
+	doc := &pdfDocument{pdf: pdf, data: data, family: "Helvetica"}
+	if cfg.FontPath != "" {
+		font, err := os.ReadFile(cfg.FontPath)
+		if err != nil {
+			return fmt.Errorf("加载 PDF 字体失败: %w", err)
+		}
+		// 同一字体同时注册为常规和粗体，粗体表头与正文字形相同
+		doc.family = "report"
+		pdf.AddUTF8FontFromBytes(doc.family, "", font)
+		pdf.AddUTF8FontFromBytes(doc.family, "B", font)
+		doc.text = func(s string) string { return s }
+		doc.utf8 = true
+	} else {
+		doc.text = pdf.UnicodeTranslatorFromDescriptor("")
+	}
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("加载 PDF 字体失败: %w", err)
+	}
+
+	pdf.SetTitle(data.Title, doc.utf8)
+	pdf.SetCreator("bi-go", false)
+	pdf.SetMargins(pdfMargin, pdfMargin+6, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin+4)
+	pdf.AliasNbPages("{nb}")
+	pdf.SetHeaderFunc(func() { doc.pageHeader(header) })
+	pdf.SetFooterFunc(func() { doc.pageFooter(footer) })
+
+	pdf.AddPage()
+	doc.writeTitle()
+	doc.writeTable()
+
+	// 最后一页的页脚在 Close 时输出，之后才能确定页眉页脚是否渲染成功
+	pdf.Close()
+	if doc.err != nil {
+		return doc.err
+	}
+	return pdf.Output(w)
+}
+
+// sanitize 替换当前字体无法输出的字符
+func (d *pdfDocument) sanitize(s string) string {
+	limit := rune(0xFFFF)
+	if !d.utf8 {
+		limit = 0xFF
+	}
+	return strings.Map(func(r rune) rune {
+		if r > limit {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+// cell 输出单行文本
+func (d *pdfDocument) cell(w, h float64, s, border string, ln int, align string, fill bool) {
+	d.pdf.CellFormat(w, h, d.text(d.sanitize(s)), border, ln, align, fill, 0, "")
+}
+
+// multiCell 输出自动换行的文本
+func (d *pdfDocument) multiCell(h float64, s string) {
+	width, _ := d.pdf.GetPageSize()
+	for _, line := range d.pdf.SplitText(d.sanitize(s), width-2*pdfMargin) {
+		d.pdf.CellFormat(0, h, d.text(line), "", 1, "L", false, 0, "")
+	}
+}
+
+func (d *pdfDocument) pageHeader(tmpl *template.Template) {
+	text, err := executePageTemplate(tmpl, d.data, d.pdf.PageNo(), "{nb}")
+	if err != nil && d.err == nil {
+		d.err = fmt.Errorf("渲染页眉失败: %w", err)
+	}
+	width, _ := d.pdf.GetPageSize()
+	d.pdf.SetY(pdfMargin - 4)
+	d.pdf.SetFont(d.family, "", 8)
+	d.pdf.SetTextColor(120, 120, 120)
+	d.pdf.SetDrawColor(200, 200, 200)
+	d.cell(width-2*pdfMargin, pdfLineHeight, text, "B", 1, "L", false)
+	d.pdf.SetTextColor(0, 0, 0)
+	d.pdf.SetY(pdfMargin + 6)
+
+	if d.tableStarted {
+		d.tableHeader()
+	}
+}
+
+func (d *pdfDocument) pageFooter(tmpl *template.Template) {
+	text, err := executePageTemplate(tmpl, d.data, d.pdf.PageNo(), "{nb}")
+	if err != nil && d.err == nil {
+		d.err = fmt.Errorf("渲染页脚失败: %w", err)
+	}
+	d.pdf.SetY(-pdfMargin)
+	d.pdf.SetFont(d.family, "", 8)
+	d.pdf.SetTextColor(120, 120, 120)
+	d.cell(0, pdfLineHeight, text, "T", 0, "C", false)
+	d.pdf.SetTextColor(0, 0, 0)
+}
+
+// writeTitle 输出标题、描述、生成时间和参数
+func (d *pdfDocument) writeTitle() {
+	d.pdf.SetFont(d.family, "B", 16)
+	d.multiCell(8, d.data.Title)
+	d.pdf.Ln(1)
+
+	d.pdf.SetFont(d.family, "", 10)
+	if d.data.Description != "" {
+		d.multiCell(pdfLineHeight, d.data.Description)
+		d.pdf.Ln(1)
+	}
+	d.pdf.SetTextColor(90, 90, 90)
+	// 内置字体无法显示中文，使用英文标签
+	meta, separator := "生成时间：%s，共 %d 行", "："
+	if !d.utf8 {
+		meta, separator = "Generated: %s, %d rows", ": "
+	}
+	d.multiCell(pdfLineHeight, fmt.Sprintf(meta, d.data.GeneratedAt.Format("2006-01-02 15:04:05"), d.data.RowCount))
+	for _, p := range d.data.Parameters {
+		d.multiCell(pdfLineHeight, p.Name+separator+p.Value)
+	}
+	d.pdf.SetTextColor(0, 0, 0)
+	d.pdf.Ln(3)
+}
+
+// writeTable 输出数据表格。单元格内容过长时换行，行高取该行最多的行数；
+// 剩余空间放不下一行时换页，由页眉重新绘制表头
+func (d *pdfDocument) writeTable() {
+	d.pdf.SetFont(d.family, "", pdfFontSize)
+	d.widths = d.columnWidths()
+	d.tableHeader()
+	d.tableStarted = true
+
+	_, pageHeight := d.pdf.GetPageSize()
+	_, bottom := d.pdf.GetAutoPageBreak()
+	left, _, _, _ := d.pdf.GetMargins()
+	d.pdf.SetFillColor(247, 247, 247)
+	for n, row := range d.data.Rows {
+		lines := make([][]string, len(row))
+		height := pdfLineHeight
+		for i, value := range row {
+			lines[i] = d.pdf.SplitText(d.sanitize(value), d.widths[i])
+			if h := float64(len(lines[i])) * pdfLineHeight; h > height {
+				height = h
+			}
+		}
+		if d.pdf.GetY()+height > pageHeight-bottom {
+			d.pdf.AddPage()
+			d.pdf.SetFont(d.family, "", pdfFontSize)
+		}
+
+		x, y := left, d.pdf.GetY()
+		fill := n%2 == 1
+		for i, cellLines := range lines {
+			style := "D"
+			if fill {
+				style = "FD"
+			}
+			d.pdf.Rect(x, y, d.widths[i], height, style)
+			align := "L"
+			if d.data.Columns[i].Numeric {
+				align = "R"
+			}
+			for k, line := range cellLines {
+				d.pdf.SetXY(x, y+float64(k)*pdfLineHeight)
+				d.pdf.CellFormat(d.widths[i], pdfLineHeight, d.text(line), "", 0, align, false, 0, "")
+			}
+			x += d.widths[i]
+		}
+		d.pdf.SetXY(left, y+height)
+	}
+}
+
+// tableHeader 输出表头行
+func (d *pdfDocument) tableHeader() {
+	d.pdf.SetFont(d.family, "B", pdfFontSize)
+	d.pdf.SetFillColor(221, 235, 247)
+	d.pdf.SetDrawColor(180, 180, 180)
+	for i, col := range d.data.Columns {
+		d.cell(d.widths[i], pdfLineHeight+1, col.Label, "1", 0, "L", true)
+	}
+	d.pdf.Ln(-1)
+	d.pdf.SetFont(d.family, "", pdfFontSize)
+	d.pdf.SetFillColor(247, 247, 247)
+}
+
+// columnWidths 按表头和前若干行的内容估算列宽，总宽度不超过版心
+func (d *pdfDocument) columnWidths() []float64 {
+	pageWidth, _ := d.pdf.GetPageSize()
+	available := pageWidth - 2*pdfMargin
+	widths := make([]float64, len(d.data.Columns))
+	if len(widths) == 0 {
+		return widths
+	}
+	measure := func(s string) float64 {
+		return d.pdf.GetStringWidth(d.sanitize(s)) + 2*pdfCellPadding + 1
+	}
+	d.pdf.SetFont(d.family, "B", pdfFontSize)
+	for i, col := range d.data.Columns {
+		widths[i] = measure(col.Label)
+	}
+	d.pdf.SetFont(d.family, "", pdfFontSize)
+	for n, row := range d.data.Rows {
+		if n == xlsxWidthSample {
+			break
+		}
+		for i, value := range row {
+			if w := measure(value); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	total := 0.0
+	for i := range widths {
+		if widths[i] < pdfMinColumnWidth {
+			widths[i] = pdfMinColumnWidth
+		}
+		total += widths[i]
+	}
+	if total > available {
+		fitColumnWidths(widths, available)
+	}
+	return widths
+}
+
+// fitColumnWidths 将列宽压缩到 available 以内：不超过平均宽度的窄列保持原宽，
+// 剩余宽度按比例分给较宽的列，只有宽列中的内容会换行
+func fitColumnWidths(widths []float64, available float64) {
+	fixed := make([]bool, len(widths))
+	for {
+		remaining, wide, count := available, 0.0, 0
+		for i, w := range widths {
+			if fixed[i] {
+				remaining -= w
+			} else {
+				wide += w
+				count++
+			}
+		}
+		share := remaining / float64(count)
+		changed := false
+		for i, w := range widths {
+			if !fixed[i] && w <= share {
+				fixed[i] = true
+				changed = true
+			}
+		}
+		if !changed {
+			for i := range widths {
+				if !fixed[i] {
+					widths[i] *= remaining / wide
+				}
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/pipeline"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/foldn/bi-go/internal/sqlguard"
+	"github.com/foldn/bi-go/internal/storage"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidPipeline 处理操作不合法
+var ErrInvalidPipeline = pipeline.ErrInvalidPipeline
+
+// defaultMemoryBudget 列式执行的默认内存预算
+const defaultMemoryBudget = 256 << 20
+
+// defaultFederatedMaxRows 跨数据源执行时每个输入默认最多读取的行数
+const defaultFederatedMaxRows = 1000000
+
+// Processor 在数据源实体上执行数据处理操作。能用数据源方言表达的操作编译为一条查询在数据源中执行，
+// 其余操作由 pipeline 在内存中逐行处理；CSV 数据源直接读取文件，由 pipeline 的列式引擎执行全部操作。
+// join、union 的实体位于其他数据源时，各数据源上的部分分别执行，再由列式引擎在本地连接
+type Processor struct {
+	dataSources      repository.DataSourceRepository
+	connections      *database.ConnectionManager
+	files            storage.Storage
+	columnar         pipeline.ColumnarOptions
+	federatedMaxRows int
+}
+
+// NewProcessor 创建处理器，异步任务的结果保存到 files
+func NewProcessor(dataSources repository.DataSourceRepository, connections *database.ConnectionManager, files storage.Storage, cfg config.ProcessConfig) *Processor {
+	columnar := pipeline.ColumnarOptions{MemoryBudget: cfg.MemoryBudget, SpillDir: cfg.SpillDir}
+	switch {
+	case columnar.MemoryBudget == 0:
+		columnar.MemoryBudget = defaultMemoryBudget
+	case columnar.MemoryBudget < 0:
+		columnar.MemoryBudget = 0
+	}
+	federatedMaxRows := cfg.FederatedMaxRows
+	switch {
+	case federatedMaxRows == 0:
+		federatedMaxRows = defaultFederatedMaxRows
+	case federatedMaxRows < 0:
+		federatedMaxRows = 0
+	}
+	return &Processor{dataSources: dataSources, connections: connections, files: files, columnar: columnar,
+		federatedMaxRows: federatedMaxRows}
+}
+
+// Check 按实体的列检查处理操作并返回结果的列，只读取各实体的列定义，不读取数据
+func (p *Processor) Check(ctx context.Context, pl *models.Pipeline) ([]models.ResultColumn, error) {
+	if err := pipeline.Validate(pl); err != nil {
+		return nil, err
+	}
+	if pipeline.Federated(pl) {
+		return pipeline.CheckFederated(pl, func(sub *models.Pipeline) ([]models.ResultColumn, error) {
+			return p.Check(ctx, sub)
+		})
+	}
+	dataSource, err := p.dataSources.GetByID(pl.DataSourceID)
+	if err != nil {
+		return nil, fmt.Errorf("获取数据源失败: %w", err)
+	}
+	return pipeline.Check(pl, func(entity string) ([]models.ResultColumn, error) {
+		return p.entitySchema(ctx, dataSource, entity)
+	})
+}
+
+// Open 执行处理操作，返回结果的行迭代器，需由调用方关闭。ctx 取消或超时时中止读取。
+// 执行前先按实体的列检查操作，避免在操作不合法时已开始读取大表
+func (p *Processor) Open(ctx context.Context, pl *models.Pipeline) (pipeline.Rows, error) {
+	if pl == nil {
+		return nil, errors.New("任务没有处理操作")
+	}
+	dataSource, err := p.dataSources.GetByID(pl.DataSourceID)
+	if err != nil {
+		return nil, fmt.Errorf("获取数据源失败: %w", err)
+	}
+	// 列式执行在读取数据前检查操作，CSV 数据源不需要先推断一遍列类型
+	if dataSource.Type != models.CSV || pipeline.Federated(pl) {
+		if _, err := p.Check(ctx, pl); err != nil {
+			return nil, contextError(ctx, err)
+		}
+	}
+	rows, err := p.run(ctx, dataSource, pl)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return rows, nil
+}
+
+// run 在 dataSource 上执行处理操作。跨数据源的操作由 ExecuteFederated 执行，其各输入再由 run 在各自的数据源上执行
+func (p *Processor) run(ctx context.Context, dataSource *models.DataSource, pl *models.Pipeline) (pipeline.Rows, error) {
+	if pipeline.Federated(pl) {
+		return pipeline.ExecuteFederated(ctx, pl, p.federation(ctx), p.columnar)
+	}
+	if dataSource.Type == models.CSV {
+		return pipeline.ExecuteColumnar(ctx, pl, func(entity string) (pipeline.Rows, error) {
+			return p.openCSV(ctx, dataSource, entity)
+		}, p.columnar)
+	}
+	plan, err := p.plan(ctx, dataSource, pl)
+	if err != nil {
+		return nil, err
+	}
+	driver, db, err := p.connections.Get(dataSource)
+	if err != nil {
+		return nil, err
+	}
+	sqlRows, err := driver.Query(ctx, db, plan.SQL)
+	if err != nil {
+		return nil, err
+	}
+	it, err := newSQLRowIterator(sqlRows)
+	if err != nil {
+		return nil, err
+	}
+	it.preferTypes(plan.Columns)
+	return pipeline.Resume(pl, plan, newEntityRows(it, pl.Entity, dataSource.MaxRows), func(entity string) (pipeline.Rows, error) {
+		return p.openEntity(ctx, dataSource, entity)
+	})
+}
+
+// federation 跨数据源执行时读取各数据源的方式：各输入在所属的数据源上执行，检查时只读取列定义
+func (p *Processor) federation(ctx context.Context) pipeline.Federation {
+	return pipeline.Federation{
+		Run: func(sub *models.Pipeline) (pipeline.Rows, error) {
+			dataSource, err := p.dataSources.GetByID(sub.DataSourceID)
+			if err != nil {
+				return nil, fmt.Errorf("获取数据源失败: %w", err)
+			}
+			return p.run(ctx, dataSource, sub)
+		},
+		Columns: func(sub *models.Pipeline) ([]models.ResultColumn, error) {
+			return p.Check(ctx, sub)
+		},
+		MaxRows: p.federatedMaxRows,
+	}
+}
+
+// Explain 返回处理操作的执行计划：下推到数据源的查询，以及各操作是否下推、未下推的原因。
+// 跨数据源的操作另外给出各数据源上执行的部分的计划
+func (p *Processor) Explain(ctx context.Context, pl *models.Pipeline) (*pipeline.Plan, error) {
+	if _, err := p.Check(ctx, pl); err != nil {
+		return nil, err
+	}
+	if pipeline.Federated(pl) {
+		return pipeline.ExplainFederated(pl, p.federation(ctx), func(sub *models.Pipeline) (*pipeline.Plan, error) {
+			return p.Explain(ctx, sub)
+		})
+	}
+	dataSource, err := p.dataSources.GetByID(pl.DataSourceID)
+	if err != nil {
+		return nil, fmt.Errorf("获取数据源失败: %w", err)
+	}
+	if dataSource.Type == models.CSV {
+		return pipeline.ColumnarPlan(pl)
+	}
+	return p.plan(ctx, dataSource, pl)
+}
+
+// plan 按数据源的方言编译处理操作。数据源设置了 MaxRows 时查询最多返回 MaxRows+1 行，
+// 由 entityRows 在超出时报错
+func (p *Processor) plan(ctx context.Context, dataSource *models.DataSource, pl *models.Pipeline) (*pipeline.Plan, error) {
+	return pipeline.Compile(pl, sqlguard.DialectOf(dataSource.Type), dataSource.MaxRows, func(entity string) ([]models.ResultColumn, error) {
+		return p.entitySchema(ctx, dataSource, entity)
+	})
+}
+
+// entitySchema 通过不返回行的查询读取实体的列，列类型与 openEntity 读取的数据一致。
+// CSV 数据源读取文件的表头并推断列类型，与 openCSV 一致
+func (p *Processor) entitySchema(ctx context.Context, dataSource *models.DataSource, entity string) ([]models.ResultColumn, error) {
+	if err := sqlguard.CheckDataSourceEntity(dataSource, entity); err != nil {
+		return nil, err
+	}
+	if dataSource.Type == models.CSV {
+		file, opts, err := csvEntity(dataSource, entity)
+		if err != nil {
+			return nil, err
+		}
+		header, types, err := database.InferCSVTypes(file, opts)
+		if err != nil {
+			return nil, err
+		}
+		return csvColumns(header, types), nil
+	}
+	driver, db, err := p.connections.Get(dataSource)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := driver.Query(ctx, db, pipeline.SchemaQuery(sqlguard.DialectOf(dataSource.Type), entity))
+	if err != nil {
+		return nil, entityError(driver, db, dataSource, entity, err)
+	}
+	it, err := newSQLRowIterator(rows)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	return newEntityRows(it, entity, 0).columns, nil
+}
+
+// entityError 查询实体失败时检查实体是否存在，不存在时返回 database.ErrEntityNotFound，否则返回原错误
+func entityError(driver database.DataSourceDriver, db *gorm.DB, dataSource *models.DataSource, entity string, err error) error {
+	if _, derr := driver.DescribeColumns(db, dataSource, entity); errors.Is(derr, database.ErrEntityNotFound) {
+		return fmt.Errorf("%w: %s", database.ErrEntityNotFound, entity)
+	}
+	return err
+}
+
+// openEntity 读取实体的全部行。数据源设置了 MaxRows 时最多读取该行数，超出时报错而不是截断，
+// 避免聚合结果在不知情的情况下只覆盖部分数据
+func (p *Processor) openEntity(ctx context.Context, dataSource *models.DataSource, entity string) (pipeline.Rows, error) {
+	if err := sqlguard.CheckDataSourceEntity(dataSource, entity); err != nil {
+		return nil, err
+	}
+	limit := 0
+	if dataSource.MaxRows > 0 {
+		limit = dataSource.MaxRows + 1
+	}
+	query := pipeline.SourceQuery(sqlguard.DialectOf(dataSource.Type), entity, limit)
+
+	driver, db, err := p.connections.Get(dataSource)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := driver.Query(ctx, db, query)
+	if err != nil {
+		return nil, entityError(driver, db, dataSource, entity, err)
+	}
+	it, err := newSQLRowIterator(rows)
+	if err != nil {
+		return nil, err
+	}
+	return newEntityRows(it, entity, dataSource.MaxRows), nil
+}
+
+// openCSV 读取 CSV 实体的全部行，值按推断的列类型解析。与 openEntity 相同，
+// 数据源设置了 MaxRows 时超出该行数报错
+func (p *Processor) openCSV(ctx context.Context, dataSource *models.DataSource, entity string) (pipeline.Rows, error) {
+	if err := sqlguard.CheckDataSourceEntity(dataSource, entity); err != nil {
+		return nil, err
+	}
+	file, opts, err := csvEntity(dataSource, entity)
+	if err != nil {
+		return nil, err
+	}
+	header, types, err := database.InferCSVTypes(file, opts)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := database.OpenCSV(file, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := reader.Read(); err != nil { // 跳过表头
+		reader.Close()
+		return nil, fmt.Errorf("failed to read csv header of %s: %w", file, err)
+	}
+	return &csvRows{ctx: ctx, reader: reader, columns: csvColumns(header, types), entity: entity,
+		maxRows: dataSource.MaxRows, row: make([]interface{}, len(header))}, nil
+}
+
+// csvEntity 返回 CSV 实体对应的文件和数据源的解析选项
+func csvEntity(dataSource *models.DataSource, entity string) (string, database.CSVOptions, error) {
+	opts, err := database.ParseCSVOptions(dataSource.OtherParams)
+	if err != nil {
+		return "", opts, err
+	}
+	file, err := database.CSVEntityFile(dataSource, entity)
+	return file, opts, err
+}
+
+func csvColumns(header, types []string) []models.ResultColumn {
+	columns := make([]models.ResultColumn, len(header))
+	for i, name := range header {
+		columns[i] = models.ResultColumn{Name: name, Type: types[i]}
+	}
+	return columns
+}
+
+// Generate 执行处理任务，将结果按 ndjson 保存到报表存储，返回文件和行数。
+// 结果的列记录在 job.ResultColumns 中，随任务状态一起保存
+func (p *Processor) Generate(ctx context.Context, job *models.ReportJob, progress ProgressFunc) (*ReportFile, int64, error) {
+	rows, err := p.Open(ctx, job.Pipeline)
+	if err != nil {
+		return nil, 0, fmt.Errorf("执行处理操作失败: %w", contextError(ctx, err))
+	}
+	defer rows.Close()
+	it := NewResultIterator(rows)
+	job.ResultColumns = ResultColumns(it)
+
+	counter := &countingIterator{RowIterator: it, progress: progress, lastReport: time.Now()}
+	key := fmt.Sprintf("process_%d.%s", job.ID, job.Format)
+	file, err := saveReportFile(ctx, p.files, key, job.Format, func(w io.Writer) error { return writeNDJSON(w, counter) })
+	if err != nil {
+		return nil, counter.count, fmt.Errorf("生成处理结果失败: %w", contextError(ctx, err))
+	}
+	return file, counter.count, nil
+}
+
+// entityRows 将实体查询的结果适配为 pipeline.Rows，并检查数据源的读取行数上限
+type entityRows struct {
+	it      *sqlRowIterator
+	columns []models.ResultColumn
+	entity  string
+	maxRows int
+	count   int
+	err     error
+}
+
+func newEntityRows(it *sqlRowIterator, entity string, maxRows int) *entityRows {
+	columns := make([]models.ResultColumn, len(it.columns))
+	for i, name := range it.columns {
+		columns[i] = models.ResultColumn{Name: name, Type: it.types[i]}
+	}
+	return &entityRows{it: it, columns: columns, entity: entity, maxRows: maxRows}
+}
+
+func (r *entityRows) Columns() []models.ResultColumn {
+	return r.columns
+}
+
+func (r *entityRows) Next() bool {
+	if r.err != nil || !r.it.Next() {
+		return false
+	}
+	r.count++
+	if r.maxRows > 0 && r.count > r.maxRows {
+		r.err = fmt.Errorf("%w: datasource allows reading at most %d rows from %s", sqlguard.ErrUnsafeQuery, r.maxRows, r.entity)
+		return false
+	}
+	return true
+}
+
+func (r *entityRows) Row() []interface{} {
+	return r.it.current
+}
+
+func (r *entityRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.it.Err()
+}
+
+func (r *entityRows) Close() error {
+	return r.it.Close()
+}
+
+// csvCheckInterval 读取 CSV 时每读取该行数检查一次 ctx
+const csvCheckInterval = 1000
+
+// csvRows 逐行读取 CSV 实体并按列类型解析值，检查数据源的读取行数上限
+type csvRows struct {
+	ctx     context.Context
+	reader  *database.CSVReader
+	columns []models.ResultColumn
+	entity  string
+	maxRows int
+	count   int
+	row     []interface{}
+	err     error
+}
+
+func (r *csvRows) Columns() []models.ResultColumn {
+	return r.columns
+}
+
+func (r *csvRows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if r.count%csvCheckInterval == 0 {
+		if r.err = r.ctx.Err(); r.err != nil {
+			return false
+		}
+	}
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		r.err = fmt.Errorf("failed to read %s: %w", r.entity, err)
+		return false
+	}
+	r.count++
+	if r.maxRows > 0 && r.count > r.maxRows {
+		r.err = fmt.Errorf("%w: datasource allows reading at most %d rows from %s", sqlguard.ErrUnsafeQuery, r.maxRows, r.entity)
+		return false
+	}
+	for i, c := range r.columns {
+		raw := ""
+		if i < len(record) {
+			raw = record[i]
+		}
+		if r.row[i], err = database.ParseCSVValue(raw, c.Type); err != nil {
+			r.err = fmt.Errorf("%s row %d column %q: %w", r.entity, r.count, c.Name, err)
+			return false
+		}
+	}
+	return true
+}
+
+func (r *csvRows) Row() []interface{} {
+	return r.row
+}
+
+func (r *csvRows) Err() error {
+	return r.err
+}
+
+func (r *csvRows) Close() error {
+	return r.reader.Close()
+}
+
+// resultIterator 将处理结果适配为 RowIterator，供各输出格式写出
+type resultIterator struct {
+	rows    pipeline.Rows
+	columns []string
+	types   []string
+	row     DataRow
+}
+
+// NewResultIterator 将处理结果适配为 RowIterator，关闭时同时关闭 rows
+func NewResultIterator(rows pipeline.Rows) RowIterator {
+	columns := rows.Columns()
+	it := &resultIterator{rows: rows, columns: make([]string, len(columns)), types: make([]string, len(columns))}
+	for i, c := range columns {
+		it.columns[i] = c.Name
+		it.types[i] = c.Type
+	}
+	it.columns = uniqueColumnNames(it.columns)
+	return it
+}
+
+// ResultColumns 迭代器的列名和类型，重名的列已加上后缀
+func ResultColumns(rows RowIterator) []models.ResultColumn {
+	names, types := rows.Columns(), rows.ColumnTypes()
+	columns := make([]models.ResultColumn, len(names))
+	for i, name := range names {
+		columns[i] = models.ResultColumn{Name: name, Type: types[i]}
+	}
+	return columns
+}
+
+func (it *resultIterator) Columns() []string {
+	return it.columns
+}
+
+func (it *resultIterator) ColumnTypes() []string {
+	return it.types
+}
+
+func (it *resultIterator) Next() bool {
+	if !it.rows.Next() {
+		return false
+	}
+	values := it.rows.Row()
+	it.row = make(DataRow, len(it.columns))
+	for i, col := range it.columns {
+		it.row[col] = values[i]
+	}
+	return true
+}
+
+func (it *resultIterator) Row() DataRow {
+	return it.row
+}
+
+func (it *resultIterator) Err() error {
+	return it.rows.Err()
+}
+
+func (it *resultIterator) Close() error {
+	return it.rows.Close()
+}
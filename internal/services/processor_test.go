@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/pipeline"
+	"github.com/foldn/bi-go/internal/storage"
+)
+
+func TestProcessorPushedDownColumnTypes(t *testing.T) {
+	db := newTestDB(t)
+	ds, dataSources := newTestDataSource(t, db)
+	p := NewProcessor(dataSources, newTestConnections(t), storage.NewLocal(t.TempDir()), config.ProcessConfig{})
+
+	pl := &models.Pipeline{DataSourceID: ds.ID, Entity: "orders", Operations: []models.Operation{
+		{Type: models.OpGroupBy, GroupBy: []string{"region"}, Aggregates: []models.Aggregate{
+			{Function: "sum", Column: "amount", As: "total"},
+			{Function: "count", As: "orders"},
+		}},
+		{Type: models.OpCalculate, Fields: []models.CalculatedField{{Name: "average", Expression: "total / orders"}}},
+	}}
+	plan, err := p.Explain(context.Background(), pl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Engine != pipeline.EngineSQL || plan.Pushed != 2 {
+		t.Fatalf("got plan %+v, want both steps pushed down", plan)
+	}
+
+	rows, err := p.Open(context.Background(), pl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	want := []models.ResultColumn{
+		{Name: "region", Type: database.TypeString},
+		{Name: "total", Type: database.TypeFloat},
+		{Name: "orders", Type: database.TypeInteger},
+		{Name: "average", Type: database.TypeFloat},
+	}
+	columns := rows.Columns()
+	if len(columns) != len(want) {
+		t.Fatalf("got columns %+v", columns)
+	}
+	for i, c := range columns {
+		if c != want[i] {
+			t.Errorf("column %d: got %+v, want %+v", i, c, want[i])
+		}
+	}
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Err(); err != nil || n != 2 {
+		t.Fatalf("got %d rows, %v", n, err)
+	}
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/sqlguard"
+)
+
+// RowIterator 逐行读取查询结果，内存占用与结果集大小无关。
+// 用法与 sql.Rows 相同：循环调用 Next，读取 Row，结束后检查 Err 并 Close
+type RowIterator interface {
+	// Columns 查询返回的列，保持查询中的顺序
+	Columns() []string
+	// ColumnTypes 与 Columns 对应的归一化类型（database.Type*），无法识别时为 unknown
+	ColumnTypes() []string
+	Next() bool
+	// Row 当前行，值已转换为Go原生类型；返回的行在下一次 Next 后不再复用
+	Row() DataRow
+	Err() error
+	Close() error
+}
+
+// executeQuery 通过连接池在实际数据源上执行报表查询，任务的参数值作为绑定参数传给驱动。
+// 返回的迭代器需由调用方关闭
+func (g *ReportGenerator) executeQuery(ctx context.Context, dataSource *models.DataSource, report *models.Report, job *models.ReportJob) (RowIterator, error) {
+	if strings.TrimSpace(report.Query) == "" {
+		return nil, errors.New("报表查询语句为空")
+	}
+	// 数据源的查询限制可能在报表保存后才修改，执行前再次校验
+	if err := sqlguard.CheckDataSourceQuery(dataSource, report.Query); err != nil {
+		return nil, err
+	}
+
+	query, args, err := bindParameters(report.Query, report.Parameters, job.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, db, err := g.connections.Get(dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := driver.Query(ctx, db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLRowIterator(rows)
+}
+
+// sqlRowIterator 基于 sql.Rows 的行迭代器
+type sqlRowIterator struct {
+	rows        *sql.Rows
+	columns     []string
+	types       []string
+	columnTypes []*sql.ColumnType
+	values      []interface{}
+	pointers    []interface{}
+	current     []interface{} // 当前行转换后的值，与 columns 一一对应
+	row         DataRow       // 由 Row 按需构造
+	err         error
+}
+
+func newSQLRowIterator(rows *sql.Rows) (*sqlRowIterator, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	columns := make([]string, len(columnTypes))
+	types := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		columns[i] = ct.Name()
+		types[i] = database.NormalizeType(ct.DatabaseTypeName())
+	}
+	columns = uniqueColumnNames(columns)
+
+	it := &sqlRowIterator{
+		rows:        rows,
+		columns:     columns,
+		types:       types,
+		columnTypes: columnTypes,
+		values:      make([]interface{}, len(columns)),
+		pointers:    make([]interface{}, len(columns)),
+	}
+	for i := range it.values {
+		it.pointers[i] = &it.values[i]
+	}
+	return it, nil
+}
+
+// uniqueColumnNames 重名的列依次加上 _2、_3 等后缀，例如连接查询两侧的 id 列，
+// 避免按列名构造的行中后一列覆盖前一列
+func uniqueColumnNames(names []string) []string {
+	taken := make(map[string]bool, len(names))
+	for _, name := range names {
+		taken[name] = true
+	}
+	out := make([]string, len(names))
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		if seen[name] {
+			for n := 2; taken[name]; n++ {
+				name = names[i] + "_" + strconv.Itoa(n)
+			}
+			taken[name] = true
+		}
+		seen[name] = true
+		out[i] = name
+	}
+	return out
+}
+
+// preferTypes 以 columns 中已知的类型替换驱动报告的列类型。SQLite 等驱动对聚合、计算列
+// 不报告类型，下推查询的列类型以编译处理操作时推导的为准
+func (it *sqlRowIterator) preferTypes(columns []models.ResultColumn) {
+	if len(columns) != len(it.columns) {
+		return
+	}
+	for i, c := range columns {
+		if c.Name == it.columns[i] && c.Type != "" && c.Type != database.TypeUnknown {
+			it.types[i] = c.Type
+		}
+	}
+}
+
+func (it *sqlRowIterator) Columns() []string {
+	return it.columns
+}
+
+func (it *sqlRowIterator) ColumnTypes() []string {
+	return it.types
+}
+
+func (it *sqlRowIterator) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+	if err := it.rows.Scan(it.pointers...); err != nil {
+		it.err = err
+		return false
+	}
+
+	it.current = make([]interface{}, len(it.columns))
+	for i := range it.columns {
+		it.current[i] = normalizeValue(it.values[i], it.columnTypes[i])
+	}
+	it.row = nil
+	return true
+}
+
+func (it *sqlRowIterator) Row() DataRow {
+	if it.row == nil && it.current != nil {
+		it.row = make(DataRow, len(it.columns))
+		for i, col := range it.columns {
+			it.row[col] = it.current[i]
+		}
+	}
+	return it.row
+}
+
+func (it *sqlRowIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *sqlRowIterator) Close() error {
+	return it.rows.Close()
+}
+
+// normalizeValue 驱动返回的[]byte按列的数据库类型转换为数值或字符串
+func normalizeValue(value interface{}, columnType *sql.ColumnType) interface{} {
+	raw, ok := value.([]byte)
+	if !ok {
+		return value
+	}
+	s := string(raw)
+	switch strings.ToUpper(columnType.DatabaseTypeName()) {
+	case "INT", "INTEGER", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "INT2", "INT4", "INT8":
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return v
+		}
+	case "UNSIGNED INT", "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED BIGINT":
+		if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return v
+		}
+	case "DECIMAL", "NUMERIC", "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8":
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v
+		}
+	case "DATE":
+		if v, err := time.Parse("2006-01-02", s); err == nil {
+			return v
+		}
+	case "DATETIME", "TIMESTAMP":
+		if v, err := time.Parse("2006-01-02 15:04:05", s); err == nil {
+			return v
+		}
+	}
+	return s
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// ErrInvalidParameter 参数定义或参数值不合法
+var ErrInvalidParameter = errors.New("invalid report parameter")
+
+var parameterNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+const parameterDateLayout = "2006-01-02"
+
+// parameterRef 查询中对参数的一次引用，[start, end) 为 :name 或 :name.field 在查询中的位置
+type parameterRef struct {
+	start, end int
+	name       string
+	field      string
+}
+
+// scanParameterRefs 找出查询中的参数引用，跳过字符串、带引号的标识符、注释和 :: 类型转换。
+// hasPlaceholder 表示查询中出现了 ?，此时无法安全地追加绑定参数
+func scanParameterRefs(query string) (refs []parameterRef, hasPlaceholder bool) {
+	isIdentStart := func(c byte) bool { return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' }
+	isIdent := func(c byte) bool { return isIdentStart(c) || c >= '0' && c <= '9' }
+	skipTo := func(i int, end string) int {
+		if j := strings.Index(query[i:], end); j >= 0 {
+			return i + j + len(end)
+		}
+		return len(query)
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipTo(i+1, string(c))
+		case strings.HasPrefix(query[i:], "--"):
+			i = skipTo(i+2, "\n")
+		case strings.HasPrefix(query[i:], "/*"):
+			i = skipTo(i+2, "*/")
+		case strings.HasPrefix(query[i:], "::"):
+			i += 2
+		case c == '?':
+			hasPlaceholder = true
+			i++
+		case c == ':' && i+1 < len(query) && isIdentStart(query[i+1]):
+			ref := parameterRef{start: i}
+			j := i + 1
+			for j < len(query) && isIdent(query[j]) {
+				j++
+			}
+			ref.name = query[i+1 : j]
+			if j+1 < len(query) && query[j] == '.' && isIdentStart(query[j+1]) {
+				k := j + 1
+				for k < len(query) && isIdent(query[k]) {
+					k++
+				}
+				ref.field = query[j+1 : k]
+				j = k
+			}
+			ref.end = j
+			refs = append(refs, ref)
+			i = j
+		default:
+			i++
+		}
+	}
+	return refs, hasPlaceholder
+}
+
+// ValidateReportParameters 检查参数定义是否合法，以及查询引用的参数是否都已定义
+func ValidateReportParameters(query string, params []models.ReportParameter) error {
+	defs := make(map[string]*models.ReportParameter, len(params))
+	for i := range params {
+		p := &params[i]
+		if !parameterNamePattern.MatchString(p.Name) {
+			return fmt.Errorf("%w: invalid name %q", ErrInvalidParameter, p.Name)
+		}
+		if defs[p.Name] != nil {
+			return fmt.Errorf("%w: duplicate name %q", ErrInvalidParameter, p.Name)
+		}
+		defs[p.Name] = p
+
+		switch p.Type {
+		case models.ParamString, models.ParamInt, models.ParamFloat, models.ParamDate, models.ParamDateRange:
+			if len(p.Options) > 0 {
+				return fmt.Errorf("%w: %s: options are only allowed for enum and multiselect", ErrInvalidParameter, p.Name)
+			}
+		case models.ParamEnum, models.ParamMultiSelect:
+			if len(p.Options) == 0 {
+				return fmt.Errorf("%w: %s: options are required for %s", ErrInvalidParameter, p.Name, p.Type)
+			}
+		default:
+			return fmt.Errorf("%w: %s: unknown type %q", ErrInvalidParameter, p.Name, p.Type)
+		}
+		if (p.Min != nil || p.Max != nil) && p.Type != models.ParamInt && p.Type != models.ParamFloat {
+			return fmt.Errorf("%w: %s: min and max are only allowed for int and float", ErrInvalidParameter, p.Name)
+		}
+		if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+			return fmt.Errorf("%w: %s: min is greater than max", ErrInvalidParameter, p.Name)
+		}
+		if p.Default != nil {
+			if _, err := normalizeParameter(p, p.Default); err != nil {
+				return fmt.Errorf("%w: %s: default: %v", ErrInvalidParameter, p.Name, err)
+			}
+		}
+	}
+
+	refs, hasPlaceholder := scanParameterRefs(query)
+	if len(refs) > 0 && hasPlaceholder {
+		return fmt.Errorf("%w: query must not contain ? when parameters are used", ErrInvalidParameter)
+	}
+	for _, ref := range refs {
+		p := defs[ref.name]
+		if p == nil {
+			return fmt.Errorf("%w: query references undefined parameter %q", ErrInvalidParameter, ref.name)
+		}
+		if err := checkParameterField(p, ref.field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParameterField 日期范围参数必须以 .from 或 .to 引用，其他参数不能带字段
+func checkParameterField(p *models.ReportParameter, field string) error {
+	if p.Type == models.ParamDateRange {
+		if field != "from" && field != "to" {
+			return fmt.Errorf("%w: %s: daterange must be referenced as :%s.from or :%s.to", ErrInvalidParameter, p.Name, p.Name, p.Name)
+		}
+		return nil
+	}
+	if field != "" {
+		return fmt.Errorf("%w: %s: unexpected field .%s", ErrInvalidParameter, p.Name, field)
+	}
+	return nil
+}
+
+// ResolveParameters 校验传入的参数值并填充默认值，返回可保存到任务中的参数值。
+// 未定义的参数名返回错误；没有值也没有默认值的可选参数为 nil，查询中绑定为 NULL
+func ResolveParameters(params []models.ReportParameter, values map[string]interface{}) (map[string]interface{}, error) {
+	known := make(map[string]bool, len(params))
+	for _, p := range params {
+		known[p.Name] = true
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("%w: unknown parameter %q", ErrInvalidParameter, name)
+		}
+	}
+
+	resolved := make(map[string]interface{}, len(params))
+	for i := range params {
+		p := &params[i]
+		value, ok := values[p.Name]
+		if !ok || value == nil {
+			value = p.Default
+		}
+		if value == nil {
+			if p.Required {
+				return nil, fmt.Errorf("%w: %s is required", ErrInvalidParameter, p.Name)
+			}
+			resolved[p.Name] = nil
+			continue
+		}
+		normalized, err := normalizeParameter(p, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidParameter, p.Name, err)
+		}
+		resolved[p.Name] = normalized
+	}
+	return resolved, nil
+}
+
+// normalizeParameter 将 JSON 解码得到的值转换为参数类型对应的规范形式：
+// string、int64、float64、yyyy-mm-dd 字符串、{"from","to"} 或 []string
+func normalizeParameter(p *models.ReportParameter, value interface{}) (interface{}, error) {
+	switch p.Type {
+	case models.ParamString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string, got %v", value)
+		}
+		if p.Required && s == "" {
+			return nil, errors.New("must not be empty")
+		}
+		return s, nil
+	case models.ParamInt:
+		if _, isBool := value.(bool); isBool {
+			return nil, fmt.Errorf("expected an integer, got %v", value)
+		}
+		n, ok := toInt64(value)
+		if !ok {
+			return nil, fmt.Errorf("expected an integer, got %v", value)
+		}
+		if err := checkParameterRange(p, float64(n)); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case models.ParamFloat:
+		if _, isBool := value.(bool); isBool {
+			return nil, fmt.Errorf("expected a number, got %v", value)
+		}
+		f, ok := toFloat64(value)
+		if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("expected a number, got %v", value)
+		}
+		if err := checkParameterRange(p, f); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case models.ParamDate:
+		return normalizeDate(value)
+	case models.ParamDateRange:
+		var from, to interface{}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			from, to = v["from"], v["to"]
+		case map[string]string:
+			from, to = v["from"], v["to"]
+		default:
+			return nil, fmt.Errorf(`expected {"from": "yyyy-mm-dd", "to": "yyyy-mm-dd"}, got %v`, value)
+		}
+		fromDate, err := normalizeDate(from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %v", err)
+		}
+		toDate, err := normalizeDate(to)
+		if err != nil {
+			return nil, fmt.Errorf("to: %v", err)
+		}
+		if fromDate > toDate {
+			return nil, errors.New("from is after to")
+		}
+		return map[string]interface{}{"from": fromDate, "to": toDate}, nil
+	case models.ParamEnum:
+		s, ok := value.(string)
+		if !ok || !containsString(p.Options, s) {
+			return nil, fmt.Errorf("%v is not one of %v", value, p.Options)
+		}
+		return s, nil
+	case models.ParamMultiSelect:
+		var items []string
+		switch v := value.(type) {
+		case []string:
+			items = v
+		case []interface{}:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("expected a list of strings, got %v", value)
+				}
+				items = append(items, s)
+			}
+		default:
+			return nil, fmt.Errorf("expected a list of strings, got %v", value)
+		}
+		for _, s := range items {
+			if !containsString(p.Options, s) {
+				return nil, fmt.Errorf("%q is not one of %v", s, p.Options)
+			}
+		}
+		if p.Required && len(items) == 0 {
+			return nil, errors.New("at least one option must be selected")
+		}
+		if items == nil {
+			items = []string{}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", p.Type)
+}
+
+func normalizeDate(value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a date (yyyy-mm-dd), got %v", value)
+	}
+	t, err := time.Parse(parameterDateLayout, s)
+	if err != nil {
+		return "", fmt.Errorf("expected a date (yyyy-mm-dd), got %q", s)
+	}
+	return t.Format(parameterDateLayout), nil
+}
+
+func checkParameterRange(p *models.ReportParameter, v float64) error {
+	if p.Min != nil && v < *p.Min {
+		return fmt.Errorf("%v is less than %v", v, *p.Min)
+	}
+	if p.Max != nil && v > *p.Max {
+		return fmt.Errorf("%v is greater than %v", v, *p.Max)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// bindParameters 将查询中的 :name 替换为驱动占位符 ?，参数值按出现顺序作为绑定参数返回，
+// 不会拼接到 SQL 中。多选参数展开为多个占位符，选择为空时为 NULL
+func bindParameters(query string, params []models.ReportParameter, values map[string]interface{}) (string, []interface{}, error) {
+	refs, hasPlaceholder := scanParameterRefs(query)
+	if len(refs) == 0 {
+		return query, nil, nil
+	}
+	if hasPlaceholder {
+		return "", nil, fmt.Errorf("%w: query must not contain ? when parameters are used", ErrInvalidParameter)
+	}
+	// 任务中保存的是 JSON 解码后的值，重新校验并转换为规范形式
+	resolved, err := ResolveParameters(params, values)
+	if err != nil {
+		return "", nil, err
+	}
+	defs := make(map[string]*models.ReportParameter, len(params))
+	for i := range params {
+		defs[params[i].Name] = &params[i]
+	}
+
+	var sb strings.Builder
+	var args []interface{}
+	last := 0
+	for _, ref := range refs {
+		p := defs[ref.name]
+		if p == nil {
+			return "", nil, fmt.Errorf("%w: query references undefined parameter %q", ErrInvalidParameter, ref.name)
+		}
+		if err := checkParameterField(p, ref.field); err != nil {
+			return "", nil, err
+		}
+		sb.WriteString(query[last:ref.start])
+		last = ref.end
+
+		switch value := resolved[ref.name].(type) {
+		case map[string]interface{}:
+			sb.WriteString("?")
+			args = append(args, value[ref.field])
+		case []string:
+			if len(value) == 0 {
+				sb.WriteString("NULL")
+				continue
+			}
+			sb.WriteString(strings.TrimSuffix(strings.Repeat("?, ", len(value)), ", "))
+			for _, item := range value {
+				args = append(args, item)
+			}
+		default:
+			sb.WriteString("?")
+			args = append(args, value)
+		}
+	}
+	sb.WriteString(query[last:])
+	return sb.String(), args, nil
+}
+
+// formatParameterValue 参数值的显示文本
+func formatParameterValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case map[string]interface{}:
+		return fmt.Sprintf("%v ~ %v", v["from"], v["to"])
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ", ")
+	case []string:
+		return strings.Join(v, ", ")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/foldn/bi-go/internal/models"
+)
+
+func TestScanParameterRefs(t *testing.T) {
+	tests := []struct {
+		query       string
+		refs        []string // name 或 name.field
+		placeholder bool
+	}{
+		{"SELECT * FROM t WHERE a = :a AND b > :b_2", []string{"a", "b_2"}, false},
+		{"SELECT * FROM t WHERE d BETWEEN :month.from AND :month.to", []string{"month.from", "month.to"}, false},
+		{"SELECT ':a', \":b\", `:c` FROM t WHERE x = :x", []string{"x"}, false},
+		{"SELECT 'it''s :a' FROM t WHERE x = :x", []string{"x"}, false},
+		{"SELECT 1 -- :a\nFROM t /* :b\n:c */ WHERE x = :x", []string{"x"}, false},
+		{"SELECT a::text, b::numeric(10,2) FROM t WHERE x = :x::date", []string{"x"}, false},
+		{"SELECT * FROM t WHERE a = ? AND b = :b", []string{"b"}, true},
+		{"SELECT '?' FROM t -- ?", nil, false},
+		{"SELECT :1, : a, :a.1 FROM t", []string{"a"}, false},
+		{"SELECT 'unterminated :a", nil, false},
+	}
+	for _, tt := range tests {
+		refs, placeholder := scanParameterRefs(tt.query)
+		var got []string
+		for _, ref := range refs {
+			name := ref.name
+			if ref.field != "" {
+				name += "." + ref.field
+			}
+			if tt.query[ref.start:ref.end] != ":"+name {
+				t.Errorf("%q: ref %s at %q", tt.query, name, tt.query[ref.start:ref.end])
+			}
+			got = append(got, name)
+		}
+		if !reflect.DeepEqual(got, tt.refs) || placeholder != tt.placeholder {
+			t.Errorf("%q: got %v, placeholder %v", tt.query, got, placeholder)
+		}
+	}
+}
+
+func TestBindParameters(t *testing.T) {
+	min := 1.0
+	params := []models.ReportParameter{
+		{Name: "region", Type: models.ParamString},
+		{Name: "top", Type: models.ParamInt, Min: &min, Default: float64(10)},
+		{Name: "month", Type: models.ParamDateRange, Required: true},
+		{Name: "tags", Type: models.ParamMultiSelect, Options: []string{"a", "b", "c"}},
+	}
+	month := map[string]interface{}{"from": "2024-01-01", "to": "2024-01-31"}
+	tests := []struct {
+		name   string
+		query  string
+		values map[string]interface{}
+		sql    string
+		args   []interface{}
+	}{
+		{"scalars and range", "SELECT * FROM t WHERE r = :region AND d BETWEEN :month.from AND :month.to LIMIT :top",
+			map[string]interface{}{"region": "east", "month": month},
+			"SELECT * FROM t WHERE r = ? AND d BETWEEN ? AND ? LIMIT ?",
+			[]interface{}{"east", "2024-01-01", "2024-01-31", int64(10)}},
+		{"multiselect", "SELECT * FROM t WHERE tag IN (:tags) AND d >= :month.from",
+			map[string]interface{}{"month": month, "tags": []interface{}{"a", "c"}},
+			"SELECT * FROM t WHERE tag IN (?, ?) AND d >= ?",
+			[]interface{}{"a", "c", "2024-01-01"}},
+		{"empty multiselect and missing optional", "SELECT * FROM t WHERE tag IN (:tags) AND r = :region AND d <= :month.to",
+			map[string]interface{}{"month": month, "tags": []interface{}{}},
+			"SELECT * FROM t WHERE tag IN (NULL) AND r = ? AND d <= ?",
+			[]interface{}{nil, "2024-01-31"}},
+		{"quotes comments and casts", "SELECT ':region', x::text FROM t -- :top\nWHERE r = :region::text AND d = :month.from",
+			map[string]interface{}{"region": "o'neil; DROP TABLE t", "month": month},
+			"SELECT ':region', x::text FROM t -- :top\nWHERE r = ?::text AND d = ?",
+			[]interface{}{"o'neil; DROP TABLE t", "2024-01-01"}},
+		{"no parameters", "SELECT * FROM t WHERE a = ?", nil, "SELECT * FROM t WHERE a = ?", nil},
+	}
+	for _, tt := range tests {
+		sql, args, err := bindParameters(tt.query, params, tt.values)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if sql != tt.sql || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: got %q %v", tt.name, sql, args)
+		}
+	}
+
+	invalid := []struct {
+		name   string
+		query  string
+		values map[string]interface{}
+	}{
+		{"missing required", "SELECT * FROM t WHERE d >= :month.from", map[string]interface{}{}},
+		{"invalid range", "SELECT * FROM t WHERE d >= :month.from",
+			map[string]interface{}{"month": map[string]interface{}{"from": "2024-02-01", "to": "2024-01-01"}}},
+		{"invalid date", "SELECT * FROM t WHERE d >= :month.from",
+			map[string]interface{}{"month": map[string]interface{}{"from": "2024-13-01", "to": "2024-01-01"}}},
+		{"below min", "SELECT * FROM t LIMIT :top", map[string]interface{}{"month": month, "top": float64(0)}},
+		{"not an integer", "SELECT * FROM t LIMIT :top", map[string]interface{}{"month": month, "top": 2.5}},
+		{"not a number", "SELECT * FROM t LIMIT :top", map[string]interface{}{"month": month, "top": "ten"}},
+		{"unknown option", "SELECT * FROM t WHERE tag IN (:tags)", map[string]interface{}{"month": month, "tags": []interface{}{"x"}}},
+		{"unknown parameter", "SELECT * FROM t WHERE r = :region", map[string]interface{}{"month": month, "other": "x"}},
+		{"undefined reference", "SELECT * FROM t WHERE r = :missing", map[string]interface{}{"month": month}},
+		{"range without field", "SELECT * FROM t WHERE d = :month", map[string]interface{}{"month": month}},
+		{"field on scalar", "SELECT * FROM t WHERE r = :region.from", map[string]interface{}{"month": month}},
+		{"mixed placeholders", "SELECT * FROM t WHERE a = ? AND r = :region", map[string]interface{}{"month": month}},
+	}
+	for _, tt := range invalid {
+		if _, _, err := bindParameters(tt.query, params, tt.values); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("%s: got %v, want ErrInvalidParameter", tt.name, err)
+		}
+	}
+}
+
+func TestValidateReportParameters(t *testing.T) {
+	min, max := 10.0, 1.0
+	tests := []struct {
+		name   string
+		query  string
+		params []models.ReportParameter
+		ok     bool
+	}{
+		{"valid", "SELECT * FROM t WHERE r IN (:r) AND d BETWEEN :m.from AND :m.to",
+			[]models.ReportParameter{{Name: "r", Type: models.ParamMultiSelect, Options: []string{"a"}}, {Name: "m", Type: models.ParamDateRange}}, true},
+		{"invalid name", "SELECT 1", []models.ReportParameter{{Name: "1a", Type: models.ParamString}}, false},
+		{"duplicate name", "SELECT 1", []models.ReportParameter{{Name: "a", Type: models.ParamString}, {Name: "a", Type: models.ParamInt}}, false},
+		{"unknown type", "SELECT 1", []models.ReportParameter{{Name: "a", Type: "bool"}}, false},
+		{"enum without options", "SELECT 1", []models.ReportParameter{{Name: "a", Type: models.ParamEnum}}, false},
+		{"options on string", "SELECT 1", []models.ReportParameter{{Name: "a", Type: models.ParamString, Options: []string{"x"}}}, false},
+		{"min above max", "SELECT 1", []models.ReportParameter{{Name: "a", Type: models.ParamInt, Min: &min, Max: &max}}, false},
+		{"invalid default", "SELECT 1", []models.ReportParameter{{Name: "a", Type: models.ParamDate, Default: "yesterday"}}, false},
+		{"undefined reference", "SELECT :b", []models.ReportParameter{{Name: "a", Type: models.ParamString}}, false},
+		{"placeholder", "SELECT ?, :a", []models.ReportParameter{{Name: "a", Type: models.ParamString}}, false},
+	}
+	for _, tt := range tests {
+		err := ValidateReportParameters(tt.query, tt.params)
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("%s: got %v, want ErrInvalidParameter", tt.name, err)
+		}
+	}
+}
@@ -1,179 +1,386 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
 	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
 	"github.com/foldn/bi-go/internal/storage"
+	"github.com/foldn/bi-go/pkg/utils"
+	"hash"
+	"io"
+	"log"
 	"os"
-	"path/filepath"
 	"time"
 )
 
-// 模拟数据结果
+// DataRow 查询结果中的一行，值为Go原生类型
 type DataRow map[string]interface{}
 
-// GenerateReport 异步生成报表
-func GenerateReport(job *models.ReportJob) {
-	// 更新任务状态为运行中
-	job.Status = "running"
-	job.UpdatedAt = time.Now()
-	storage.SaveReportJob(job)
+// ReportGenerator 执行报表查询并生成报表文件，任务状态由 JobQueue 维护
+type ReportGenerator struct {
+	reports     repository.ReportRepository
+	dataSources repository.DataSourceRepository
+	connections *database.ConnectionManager
+	files       storage.Storage
+	cfg         config.ReportConfig
+}
+
+// NewReportGenerator 创建报表生成器，生成的文件保存到 files，cfg 提供各输出格式的参数
+func NewReportGenerator(reports repository.ReportRepository, dataSources repository.DataSourceRepository,
+	connections *database.ConnectionManager, files storage.Storage, cfg config.ReportConfig) *ReportGenerator {
+	return &ReportGenerator{
+		reports:     reports,
+		dataSources: dataSources,
+		connections: connections,
+		files:       files,
+		cfg:         cfg,
+	}
+}
+
+// ReportFile 保存到报表存储中的文件
+type ReportFile struct {
+	Key      string
+	Size     int64
+	Checksum string // SHA-256，十六进制
+}
+
+// ProgressFunc 接收已写出的行数
+type ProgressFunc func(rows int64)
 
+// 进度回调的触发间隔：每写出 progressRows 行且距上次回调不少于 progressInterval
+const (
+	progressRows     = 1000
+	progressInterval = time.Second
+)
+
+// Generate 执行任务对应的报表查询，将结果逐行写出到文件并保存到报表存储，返回文件和行数。
+// ctx 取消或超时时中止查询；报表设置了超时时在 ctx 的基础上进一步收紧。
+// progress 不为 nil 时在写出过程中定期回调
+func (g *ReportGenerator) Generate(ctx context.Context, job *models.ReportJob, progress ProgressFunc) (*ReportFile, int64, error) {
 	// 获取报表定义
-	report, err := storage.GetReport(job.ReportID)
+	report, err := g.reports.GetByID(job.ReportID)
 	if err != nil {
-		handleJobError(job, fmt.Sprintf("获取报表定义失败: %v", err))
-		return
+		return nil, 0, fmt.Errorf("获取报表定义失败: %w", err)
+	}
+	if report.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(report.Timeout)*time.Second)
+		defer cancel()
 	}
 
 	// 获取数据源
-	dataSource, err := storage.GetDataSource(report.DataSourceID)
+	dataSource, err := g.dataSources.GetByID(report.DataSourceID)
 	if err != nil {
-		handleJobError(job, fmt.Sprintf("获取数据源失败: %v", err))
-		return
+		return nil, 0, fmt.Errorf("获取数据源失败: %w", err)
 	}
 
-	// 执行查询获取数据
-	data, err := executeQuery(dataSource, report)
+	// 执行查询
+	rows, err := g.executeQuery(ctx, dataSource, report, job)
 	if err != nil {
-		handleJobError(job, fmt.Sprintf("执行查询失败: %v", err))
-		return
+		return nil, 0, fmt.Errorf("执行查询失败: %w", contextError(ctx, err))
 	}
+	defer rows.Close()
 
-	// 生成报表文件
-	filePath, err := generateReportFile(job, report, data)
+	// 边读取边生成报表文件
+	counter := &countingIterator{RowIterator: rows, progress: progress, lastReport: time.Now()}
+	file, err := g.generateReportFile(ctx, job, report, counter)
 	if err != nil {
-		handleJobError(job, fmt.Sprintf("生成报表文件失败: %v", err))
-		return
-	}
-
-	// 更新任务状态为完成
-	job.Status = "completed"
-	job.FilePath = filePath
-	job.UpdatedAt = time.Now()
-	storage.SaveReportJob(job)
-}
-
-// handleJobError 处理任务错误
-func handleJobError(job *models.ReportJob, errMsg string) {
-	job.Status = "failed"
-	job.Error = errMsg
-	job.UpdatedAt = time.Now()
-	storage.SaveReportJob(job)
-}
-
-// executeQuery 执行查询获取数据
-// 注意：这里是模拟实现，实际应用中需要根据数据源类型连接实际数据库
-func executeQuery(dataSource *models.DataSource, report *models.Report) ([]DataRow, error) {
-	// 这里仅作为演示，返回模拟数据
-	// 实际应用中，应该根据dataSource.Type和dataSource.Config连接到实际数据源
-	// 然后执行report.Query查询，并返回结果
-
-	// 模拟数据
-	result := []DataRow{
-		{
-			"id":    1,
-			"name":  "示例1",
-			"value": 100,
-			"date":  "2023-01-01",
-		},
-		{
-			"id":    2,
-			"name":  "示例2",
-			"value": 200,
-			"date":  "2023-01-02",
-		},
-		{
-			"id":    3,
-			"name":  "示例3",
-			"value": 300,
-			"date":  "2023-01-03",
-		},
-	}
-
-	return result, nil
-}
-
-// generateReportFile 生成报表文件
-func generateReportFile(job *models.ReportJob, report *models.Report, data []DataRow) (string, error) {
-	// 使用配置的输出目录
-	outputDir := config.OutputDir
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return "", err
+		return nil, counter.count, fmt.Errorf("生成报表文件失败: %w", contextError(ctx, err))
 	}
+	return file, counter.count, nil
+}
 
-	// 生成文件路径
-	fileName := fmt.Sprintf("%s_%s.%s", report.ID, job.ID, job.Format)
-	filePath := filepath.Join(outputDir, fileName)
+// OpenReportFile 打开已完成任务生成的报表文件，文件不存在时返回 storage.ErrNotFound
+func OpenReportFile(ctx context.Context, files storage.Storage, job *models.ReportJob) (io.ReadCloser, error) {
+	if job.StorageKey != "" {
+		return files.Open(ctx, job.StorageKey)
+	}
+	if job.FilePath == "" {
+		return nil, fmt.Errorf("%w: job %d has no output file", storage.ErrNotFound, job.ID)
+	}
+	// 旧版本生成的任务只记录了本地路径
+	file, err := os.Open(job.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, job.FilePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
 
-	// 根据格式生成文件
+// removeReportFile 删除已保存但不再需要的报表文件，例如任务在保存后被取消
+func (g *ReportGenerator) removeReportFile(file *ReportFile) {
+	if err := g.files.Delete(context.Background(), file.Key); err != nil {
+		log.Printf("failed to remove report file %s: %v", file.Key, err)
+	}
+}
+
+// contextError 查询被中止时各驱动返回的错误不一，统一为 ctx 的错误
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// countingIterator 统计已读取的行数并定期回调进度
+type countingIterator struct {
+	RowIterator
+	count      int64
+	progress   ProgressFunc
+	lastReport time.Time
+}
+
+func (it *countingIterator) Next() bool {
+	if !it.RowIterator.Next() {
+		return false
+	}
+	it.count++
+	if it.progress != nil && it.count%progressRows == 0 && time.Since(it.lastReport) >= progressInterval {
+		it.progress(it.count)
+		it.lastReport = time.Now()
+	}
+	return true
+}
+
+// formatWriter 将查询结果按 columns 写出为一种文件格式
+type formatWriter func(w io.Writer, columns []string, rows RowIterator) error
+
+// formatWriter 返回任务格式对应的写出函数
+func (g *ReportGenerator) formatWriter(job *models.ReportJob, report *models.Report) (formatWriter, bool) {
 	switch job.Format {
 	case "csv":
-		return generateCSV(filePath, report.Columns, data)
+		return writeCSV, true
 	case "json":
-		return generateJSON(filePath, data)
+		return func(w io.Writer, _ []string, rows RowIterator) error { return writeJSON(w, rows) }, true
+	case "ndjson":
+		return func(w io.Writer, _ []string, rows RowIterator) error { return writeNDJSON(w, rows) }, true
+	case "xlsx":
+		return func(w io.Writer, columns []string, rows RowIterator) error {
+			return writeXLSX(w, columns, rows, g.cfg.XLSX)
+		}, true
+	case "parquet":
+		return func(w io.Writer, columns []string, rows RowIterator) error {
+			return writeParquet(w, columns, rows, g.cfg.Parquet)
+		}, true
+	case "arrow":
+		return func(w io.Writer, columns []string, rows RowIterator) error {
+			return writeArrow(w, columns, rows, g.cfg.Arrow)
+		}, true
+	case "html":
+		return func(w io.Writer, columns []string, rows RowIterator) error {
+			return writeHTML(w, job, report, columns, rows, g.cfg.Render)
+		}, true
+	case "pdf":
+		return func(w io.Writer, columns []string, rows RowIterator) error {
+			return writePDF(w, job, report, columns, rows, g.cfg.Render)
+		}, true
 	default:
-		return "", errors.New("不支持的报表格式")
+		return nil, false
+	}
+}
+
+// generateReportFile 生成报表文件并保存到报表存储
+func (g *ReportGenerator) generateReportFile(ctx context.Context, job *models.ReportJob, report *models.Report, rows RowIterator) (*ReportFile, error) {
+	key := fmt.Sprintf("%d_%d.%s", report.ID, job.ID, job.Format)
+
+	// 未定义输出列时使用查询返回的列
+	columns := report.Columns
+	if len(columns) == 0 {
+		columns = rows.Columns()
+	}
+
+	// 根据格式生成文件
+	writeRows, ok := g.formatWriter(job, report)
+	if !ok {
+		return nil, errors.New("不支持的报表格式")
 	}
+	return saveReportFile(ctx, g.files, key, job.Format, func(w io.Writer) error { return writeRows(w, columns, rows) })
 }
 
-// generateCSV 生成CSV格式报表
-func generateCSV(filePath string, columns []string, data []DataRow) (string, error) {
-	// 创建文件
-	file, err := os.Create(filePath)
+// saveReportFile 通过 write 写出文件内容，以 key 保存到报表存储
+func saveReportFile(ctx context.Context, files storage.Storage, key, format string, write func(w io.Writer) error) (*ReportFile, error) {
+	// 先写入输出目录下的临时文件，使用本地存储时保存即为重命名
+	outputDir := config.OutputDir
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(outputDir, "."+key+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// 不保留写了一半的文件；保存到本地存储后临时文件已不存在
+	defer os.Remove(tmp.Name())
+	size, checksum, err := writeFile(tmp, write)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if err := files.PutFile(ctx, key, tmp.Name(), utils.ContentType(format)); err != nil {
+		return nil, fmt.Errorf("保存报表文件失败: %w", err)
+	}
+	return &ReportFile{Key: key, Size: size, Checksum: checksum}, nil
+}
+
+// writeFile 通过缓冲写入文件并计算大小和 SHA-256，写入或关闭失败都返回错误
+func writeFile(file *os.File, write func(w io.Writer) error) (int64, string, error) {
+	buf := bufio.NewWriter(file)
+	w := &checksumWriter{w: buf, hash: sha256.New()}
+	if err := write(w); err != nil {
+		file.Close()
+		return 0, "", err
+	}
+	if err := buf.Flush(); err != nil {
+		file.Close()
+		return 0, "", err
 	}
-	defer file.Close()
+	if err := file.Close(); err != nil {
+		return 0, "", err
+	}
+	return w.size, hex.EncodeToString(w.hash.Sum(nil)), nil
+}
+
+// checksumWriter 写入的同时统计字节数并计算摘要
+type checksumWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.hash.Write(p[:n])
+	c.size += int64(n)
+	return n, err
+}
 
+// writeCSV 按 columns 的顺序逐行写出CSV
+func writeCSV(w io.Writer, columns []string, rows RowIterator) error {
 	// 创建CSV写入器
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	writer := csv.NewWriter(w)
 
 	// 写入表头
 	if err := writer.Write(columns); err != nil {
-		return "", err
+		return err
 	}
 
 	// 写入数据行
-	for _, row := range data {
-		values := make([]string, len(columns))
+	values := make([]string, len(columns))
+	for rows.Next() {
+		row := rows.Row()
 		for i, col := range columns {
 			// 获取列值并转换为字符串
-			if val, ok := row[col]; ok {
-				values[i] = fmt.Sprintf("%v", val)
-			} else {
-				values[i] = ""
-			}
+			values[i] = formatCSVValue(row[col])
 		}
-
 		if err := writer.Write(values); err != nil {
-			return "", err
+			return err
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	return filePath, nil
+	writer.Flush()
+	return writer.Error()
 }
 
-// generateJSON 生成JSON格式报表
-func generateJSON(filePath string, data []DataRow) (string, error) {
-	// 创建文件
-	file, err := os.Create(filePath)
-	if err != nil {
-		return "", err
+// formatCSVValue 将单元格值转换为CSV字符串，空值输出为空串
+func formatCSVValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// writeJSON 以JSON数组的形式逐行写出，每行一个对象，键按 rows.Columns() 的顺序排列
+func writeJSON(w io.Writer, rows RowIterator) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	columns := rows.Columns()
+	first := true
+	var indented bytes.Buffer
+	for rows.Next() {
+		data, err := MarshalRow(columns, rows.Row())
+		if err != nil {
+			return err
+		}
+		indented.Reset()
+		if err := json.Indent(&indented, data, "  ", "  "); err != nil {
+			return err
+		}
+		sep := ",\n  "
+		if first {
+			sep = "\n  "
+			first = false
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		if _, err := indented.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
-	defer file.Close()
 
-	// 将数据编码为JSON并写入文件
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(data); err != nil {
-		return "", err
+	end := "\n]\n"
+	if first {
+		end = "]\n"
 	}
+	_, err := io.WriteString(w, end)
+	return err
+}
+
+// writeNDJSON 逐行写出，每行一个JSON对象（Newline Delimited JSON），键按 rows.Columns() 的顺序排列
+func writeNDJSON(w io.Writer, rows RowIterator) error {
+	columns := rows.Columns()
+	for rows.Next() {
+		data, err := MarshalRow(columns, rows.Row())
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
 
-	return filePath, nil
+// MarshalRow 将一行编码为JSON对象，键按 columns 的顺序排列。
+// DataRow 是 map，直接编码时键按字母排序
+func MarshalRow(columns []string, row DataRow) ([]byte, error) {
+	buf := []byte{'{'}
+	for i, col := range columns {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		key, err := json.Marshal(col)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(row[col])
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(append(buf, key...), ':'), value...)
+	}
+	return append(buf, '}'), nil
 }
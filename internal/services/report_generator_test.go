@@ -0,0 +1,115 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestUniqueColumnNames(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{[]string{"a", "b"}, []string{"a", "b"}},
+		{[]string{"id", "id", "id"}, []string{"id", "id_2", "id_3"}},
+		{[]string{"id", "id", "id_2"}, []string{"id", "id_3", "id_2"}},
+		{nil, []string{}},
+	}
+	for _, tt := range tests {
+		if got := uniqueColumnNames(tt.names); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestWriteJSONColumnOrder(t *testing.T) {
+	db := newTestDB(t)
+	ds, _ := newTestDataSource(t, db)
+	source := openTestSource(t, ds.FilePath)
+	query := func() RowIterator {
+		t.Helper()
+		// 两侧的 id 列重名，列的顺序与字母顺序不同
+		rows, err := source.Query("SELECT o.amount AS zeta, o.region AS alpha, o.id, p.id FROM orders o JOIN orders p ON p.id = o.id ORDER BY o.id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := newSQLRowIterator(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { it.Close() })
+		return it
+	}
+
+	var buf bytes.Buffer
+	if err := writeNDJSON(&buf, query()); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"zeta":10,"alpha":"east","id":1,"id_2":1}` + "\n" + `{"zeta":20.5,"alpha":"west","id":2,"id_2":2}` + "\n"
+	if buf.String() != want {
+		t.Errorf("ndjson: got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writeJSON(&buf, query()); err != nil {
+		t.Fatal(err)
+	}
+	want = "[\n" +
+		"  {\n    \"zeta\": 10,\n    \"alpha\": \"east\",\n    \"id\": 1,\n    \"id_2\": 1\n  },\n" +
+		"  {\n    \"zeta\": 20.5,\n    \"alpha\": \"west\",\n    \"id\": 2,\n    \"id_2\": 2\n  }\n" +
+		"]\n"
+	if buf.String() != want {
+		t.Errorf("json: got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writeJSON(&buf, &testRows{columns: []string{"a"}}); err != nil || buf.String() != "[]\n" {
+		t.Errorf("empty json: got %q, %v", buf.String(), err)
+	}
+}
+
+// openTestSource 直接打开 SQLite 数据源文件
+func openTestSource(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// testRows 保存在切片中的查询结果
+type testRows struct {
+	columns []string
+	types   []string
+	rows    [][]interface{}
+	pos     int
+}
+
+func (r *testRows) Columns() []string { return r.columns }
+
+func (r *testRows) ColumnTypes() []string {
+	if r.types == nil {
+		return make([]string, len(r.columns))
+	}
+	return r.types
+}
+
+func (r *testRows) Next() bool {
+	r.pos++
+	return r.pos <= len(r.rows)
+}
+
+func (r *testRows) Row() DataRow {
+	row := make(DataRow, len(r.columns))
+	for i, col := range r.columns {
+		row[col] = r.rows[r.pos-1][i]
+	}
+	return row
+}
+
+func (r *testRows) Err() error   { return nil }
+func (r *testRows) Close() error { return nil }
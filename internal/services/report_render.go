@@ -0,0 +1,298 @@
+package services
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// defaultRenderMaxRows 渲染格式默认的最大行数
+const defaultRenderMaxRows = 10000
+
+// 未设置页眉页脚时使用的默认模板
+const (
+	defaultPageHeader = "{{.Title}}"
+	defaultPageFooter = `{{.GeneratedAt.Format "2006-01-02 15:04:05"}}    {{.Page}} / {{.Pages}}`
+)
+
+//go:embed templates/report.html
+var defaultHTMLTemplate string
+
+// ErrInvalidLayout 报表版式中的模板无法解析
+var ErrInvalidLayout = errors.New("invalid report layout")
+
+// RenderParameter 报表执行时使用的参数值，Name 为参数的显示名称
+type RenderParameter struct {
+	Name  string
+	Value string
+}
+
+// RenderColumn 渲染表格中的一列
+type RenderColumn struct {
+	Name    string
+	Label   string
+	Numeric bool // 数值列右对齐
+}
+
+// RenderData html 模板和页眉页脚模板可使用的数据
+type RenderData struct {
+	ReportID    uint
+	JobID       uint
+	Title       string
+	Description string
+	GeneratedAt time.Time
+	Parameters  []RenderParameter
+	Columns     []RenderColumn
+	Rows        [][]string // 已按列格式转换的单元格文本
+	RowCount    int
+	// Header、Footer 为渲染后的页眉页脚，供 html 模板使用
+	Header string
+	Footer string
+}
+
+// RenderPage 页眉页脚模板的数据，Page 和 Pages 为当前页码和总页数
+type RenderPage struct {
+	*RenderData
+	Page  int
+	Pages string
+}
+
+// ValidateReportLayout 检查版式中的模板能否解析
+func ValidateReportLayout(layout models.ReportLayout) error {
+	if _, _, err := parsePageTemplates(layout); err != nil {
+		return err
+	}
+	if _, err := parseHTMLTemplate(layout); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parsePageTemplates 解析页眉页脚模板，未设置时使用默认模板
+func parsePageTemplates(layout models.ReportLayout) (*template.Template, *template.Template, error) {
+	headerText, footerText := layout.Header, layout.Footer
+	if headerText == "" {
+		headerText = defaultPageHeader
+	}
+	if footerText == "" {
+		footerText = defaultPageFooter
+	}
+	header, err := template.New("header").Parse(headerText)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: header: %v", ErrInvalidLayout, err)
+	}
+	footer, err := template.New("footer").Parse(footerText)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: footer: %v", ErrInvalidLayout, err)
+	}
+	return header, footer, nil
+}
+
+// parseHTMLTemplate 解析报表的 html 模板，未设置时使用默认模板
+func parseHTMLTemplate(layout models.ReportLayout) (*htmltemplate.Template, error) {
+	text := layout.Template
+	if text == "" {
+		text = defaultHTMLTemplate
+	}
+	tmpl, err := htmltemplate.New("report").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: template: %v", ErrInvalidLayout, err)
+	}
+	return tmpl, nil
+}
+
+// executePageTemplate 渲染页眉或页脚
+func executePageTemplate(tmpl *template.Template, data *RenderData, page int, pages string) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, RenderPage{RenderData: data, Page: page, Pages: pages}); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// collectRenderData 读取全部结果行并按版式格式化。渲染格式需要完整的数据，
+// 行数超过 maxRows 时返回错误
+func collectRenderData(job *models.ReportJob, report *models.Report, columns []string, rows RowIterator, maxRows int) (*RenderData, error) {
+	if maxRows <= 0 {
+		maxRows = defaultRenderMaxRows
+	}
+	layout := report.Layout
+	data := &RenderData{
+		ReportID:    report.ID,
+		JobID:       job.ID,
+		Title:       layout.Title,
+		Description: report.Description,
+		GeneratedAt: time.Now(),
+		Columns:     make([]RenderColumn, len(columns)),
+	}
+	if data.Title == "" {
+		data.Title = report.Name
+	}
+	for _, p := range report.Parameters {
+		label := p.Label
+		if label == "" {
+			label = p.Name
+		}
+		data.Parameters = append(data.Parameters, RenderParameter{Name: label, Value: formatParameterValue(job.Parameters[p.Name])})
+	}
+
+	types := outputColumnTypes(columns, rows)
+	formats := make([]string, len(columns))
+	for i, col := range columns {
+		label := layout.Columns[col].Label
+		if label == "" {
+			label = col
+		}
+		formats[i] = layout.Columns[col].Format
+		data.Columns[i] = RenderColumn{Name: col, Label: label, Numeric: isNumericType(types[i])}
+	}
+
+	for rows.Next() {
+		if len(data.Rows) == maxRows {
+			return nil, fmt.Errorf("结果超过 %d 行，无法生成 %s 报表，请使用 csv 等数据格式", maxRows, job.Format)
+		}
+		row := rows.Row()
+		cells := make([]string, len(columns))
+		for i, col := range columns {
+			cells[i] = formatDisplayValue(row[col], types[i], formats[i])
+		}
+		data.Rows = append(data.Rows, cells)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	data.RowCount = len(data.Rows)
+	return data, nil
+}
+
+// writeHTML 使用报表的 html 模板（未设置时使用默认模板）渲染整个报表
+func writeHTML(w io.Writer, job *models.ReportJob, report *models.Report, columns []string, rows RowIterator, cfg config.RenderConfig) error {
+	tmpl, err := parseHTMLTemplate(report.Layout)
+	if err != nil {
+		return err
+	}
+	header, footer, err := parsePageTemplates(report.Layout)
+	if err != nil {
+		return err
+	}
+	data, err := collectRenderData(job, report, columns, rows, cfg.MaxRows)
+	if err != nil {
+		return err
+	}
+	// html 输出只有一页
+	if data.Header, err = executePageTemplate(header, data, 1, "1"); err != nil {
+		return err
+	}
+	if data.Footer, err = executePageTemplate(footer, data, 1, "1"); err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+func isNumericType(columnType string) bool {
+	switch columnType {
+	case database.TypeInteger, database.TypeFloat, database.TypeDecimal:
+		return true
+	}
+	return false
+}
+
+// formatDisplayValue 将单元格值转换为显示文本。format 为空时数值按原样输出，
+// 时间按列类型输出日期、时间或日期时间
+func formatDisplayValue(value interface{}, columnType, format string) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if format != "" {
+			return v.Format(format)
+		}
+		switch columnType {
+		case database.TypeDate:
+			return v.Format("2006-01-02")
+		case database.TypeTime:
+			return v.Format("15:04:05")
+		}
+		return v.Format("2006-01-02 15:04:05")
+	case float64:
+		if format == "" {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	case float32:
+		if format == "" {
+			return strconv.FormatFloat(float64(v), 'f', -1, 32)
+		}
+	case bool, string, []byte:
+		return formatCSVValue(value)
+	}
+	if format != "" {
+		if s, ok := formatNumber(value, format); ok {
+			return s
+		}
+	}
+	return formatCSVValue(value)
+}
+
+// formatNumber 按数字格式输出数值：小数点后 0 的个数为保留位数，包含逗号时按千分位分组，
+// 以 % 结尾时按百分比输出
+func formatNumber(value interface{}, format string) (string, bool) {
+	pattern := strings.TrimSuffix(format, "%")
+	percent := pattern != format
+	decimals := 0
+	if i := strings.IndexByte(pattern, '.'); i >= 0 {
+		decimals = strings.Count(pattern[i+1:], "0")
+	}
+
+	var s string
+	if n, ok := value.(int64); ok && !percent && decimals == 0 {
+		// 整数直接格式化，避免大整数转换为浮点数丢失精度
+		s = strconv.FormatInt(n, 10)
+	} else {
+		f, ok := toFloat64(value)
+		if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", false
+		}
+		if percent {
+			f *= 100
+		}
+		s = strconv.FormatFloat(f, 'f', decimals, 64)
+	}
+	if strings.Contains(pattern, ",") {
+		s = groupThousands(s)
+	}
+	if percent {
+		s += "%"
+	}
+	return s, true
+}
+
+// groupThousands 为数字字符串的整数部分添加千分位分隔符
+func groupThousands(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i:]
+	}
+	var sb strings.Builder
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(c)
+	}
+	return sign + sb.String() + frac
+}
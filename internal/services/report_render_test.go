@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// newRenderTestReport 标题和列名需要转义、设置了列格式的报表
+func newRenderTestReport() *models.Report {
+	return &models.Report{Name: "<Sales> & Co", Layout: models.ReportLayout{
+		Header: "{{.Title}} #{{.JobID}}",
+		Columns: map[string]models.ColumnLayout{
+			"amount": {Label: "Amount <USD>", Format: "#,##0.00"},
+			"day":    {Format: "02/01/2006"},
+		},
+	}}
+}
+
+func TestWriteHTML(t *testing.T) {
+	job := &models.ReportJob{Format: "html"}
+	job.ID = 7
+	var buf bytes.Buffer
+	if err := writeHTML(&buf, job, newRenderTestReport(), []string{"id", "name", "amount", "day"}, newWriterTestRows(3), config.RenderConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"<title>&lt;Sales&gt; &amp; Co</title>",
+		"<header>&lt;Sales&gt; &amp; Co #7</header>",
+		"<th>Amount &lt;USD&gt;</th>",
+		"<td>&lt;b&gt;&#34;n1&#34; &amp; &#39;三&#39;&lt;/b&gt;</td>",
+		`<td class="num">1,000.25</td>`,
+		"<td>01/03/2024</td>",
+		"共 3 行",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+	if strings.Contains(out, "<b>") {
+		t.Error("cell value was not escaped")
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	job := &models.ReportJob{Format: "pdf"}
+	pages := regexp.MustCompile(`/Type /Pages\s*/Kids \[[^\]]*\]\s*/Count (\d+)`)
+	for _, tt := range []struct {
+		rows      int
+		landscape bool
+		multiPage bool
+	}{{3, false, false}, {200, true, true}} {
+		report := newRenderTestReport()
+		report.Layout.Landscape = tt.landscape
+		var buf bytes.Buffer
+		if err := writePDF(&buf, job, report, []string{"id", "name", "amount", "day"}, newWriterTestRows(tt.rows), config.RenderConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")) {
+			t.Fatalf("%d rows: output is not a PDF", tt.rows)
+		}
+		m := pages.FindSubmatch(buf.Bytes())
+		if m == nil {
+			t.Fatalf("%d rows: no page tree", tt.rows)
+		}
+		if n, _ := strconv.Atoi(string(m[1])); (n > 1) != tt.multiPage {
+			t.Errorf("%d rows: got %d pages", tt.rows, n)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writePDF(&buf, job, newRenderTestReport(), []string{"id"}, newWriterTestRows(1), config.RenderConfig{PageSize: "B5"}); err == nil {
+		t.Error("expected an error for an unknown page size")
+	}
+	report := newRenderTestReport()
+	report.Layout.Footer = "{{.Missing}}"
+	if err := writePDF(&buf, job, report, []string{"id"}, newWriterTestRows(1), config.RenderConfig{}); err == nil {
+		t.Error("expected an error for a failing footer template")
+	}
+}
+
+func TestRenderMaxRows(t *testing.T) {
+	columns := []string{"id", "name"}
+	for _, format := range []string{"html", "pdf"} {
+		job := &models.ReportJob{Format: format}
+		write := writeHTML
+		if format == "pdf" {
+			write = writePDF
+		}
+		var buf bytes.Buffer
+		if err := write(&buf, job, newRenderTestReport(), columns, newWriterTestRows(2), config.RenderConfig{MaxRows: 2}); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+		buf.Reset()
+		err := write(&buf, job, newRenderTestReport(), columns, newWriterTestRows(3), config.RenderConfig{MaxRows: 2})
+		if err == nil || !strings.Contains(err.Error(), "超过 2 行") {
+			t.Errorf("%s: got %v, want a row limit error", format, err)
+		}
+	}
+}
+
+func TestInvalidLayout(t *testing.T) {
+	for _, layout := range []models.ReportLayout{
+		{Header: "{{.Title"},
+		{Footer: "{{end}}"},
+		{Template: "{{range .Rows}}"},
+	} {
+		if err := ValidateReportLayout(layout); !errors.Is(err, ErrInvalidLayout) {
+			t.Errorf("%+v: got %v, want ErrInvalidLayout", layout, err)
+		}
+		var buf bytes.Buffer
+		err := writeHTML(&buf, &models.ReportJob{}, &models.Report{Layout: layout}, []string{"id"}, newWriterTestRows(1), config.RenderConfig{})
+		if !errors.Is(err, ErrInvalidLayout) {
+			t.Errorf("%+v: writeHTML got %v, want ErrInvalidLayout", layout, err)
+		}
+	}
+}
+
+func TestFormatDisplayValue(t *testing.T) {
+	at := time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		value      interface{}
+		columnType string
+		format     string
+		want       string
+	}{
+		{nil, database.TypeInteger, "#,##0", ""},
+		{int64(1234567), database.TypeInteger, "#,##0", "1,234,567"},
+		{int64(42), database.TypeInteger, "", "42"},
+		{1234.5, database.TypeDecimal, "#,##0.00", "1,234.50"},
+		{-1234.6, database.TypeFloat, "#,##0", "-1,235"},
+		{0.256, database.TypeFloat, "0.0%", "25.6%"},
+		{0.1, database.TypeFloat, "", "0.1"},
+		{"n/a", database.TypeString, "#,##0", "n/a"},
+		{true, database.TypeBoolean, "", "true"},
+		{at, database.TypeDate, "", "2024-03-05"},
+		{at, database.TypeTime, "", "10:20:30"},
+		{at, database.TypeDateTime, "", "2024-03-05 10:20:30"},
+		{at, database.TypeDateTime, "2006/01/02", "2024/03/05"},
+	}
+	for _, tt := range tests {
+		if got := formatDisplayValue(tt.value, tt.columnType, tt.format); got != tt.want {
+			t.Errorf("%v with %q: got %q, want %q", tt.value, tt.format, got, tt.want)
+		}
+	}
+}
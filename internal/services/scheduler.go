@@ -0,0 +1,182 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSchedulerPollInterval = 15 * time.Second
+	// schedulerBatchSize 每轮最多触发的计划数，其余的在下一轮继续
+	schedulerBatchSize = 100
+)
+
+// ErrInvalidSchedule cron 表达式或时区无法解析
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// ParseSchedule 解析五段式 cron 表达式（或 @daily 等描述符）和 IANA 时区名，时区为空时使用 UTC
+func ParseSchedule(expr, timezone string) (cron.Schedule, *time.Location, error) {
+	upper := strings.ToUpper(strings.TrimSpace(expr))
+	if strings.HasPrefix(upper, "TZ=") || strings.HasPrefix(upper, "CRON_TZ=") {
+		return nil, nil, fmt.Errorf("%w: set the time zone with the timezone field", ErrInvalidSchedule)
+	}
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: cron: %v", ErrInvalidSchedule, err)
+	}
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: timezone: %v", ErrInvalidSchedule, err)
+	}
+	return schedule, loc, nil
+}
+
+// NextScheduleRun 返回 after 之后计划的下一次触发时间
+func NextScheduleRun(expr, timezone string, after time.Time) (time.Time, error) {
+	schedule, loc, err := ParseSchedule(expr, timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := schedule.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: cron expression never fires", ErrInvalidSchedule)
+	}
+	return next, nil
+}
+
+// Scheduler 定期检查到期的定时计划并为其创建报表任务。下一次触发时间保存在数据库中，
+// 进程重启后停机期间错过的触发只补一次；多个实例同时运行时，以计划的 Revision
+// 为条件更新，每次触发只有一个实例能创建任务
+type Scheduler struct {
+	schedules repository.ReportScheduleRepository
+	reports   repository.ReportRepository
+	queue     *JobQueue
+	cfg       config.SchedulerConfig
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewScheduler 创建调度器，需调用 Start 启动
+func NewScheduler(schedules repository.ReportScheduleRepository, reports repository.ReportRepository, queue *JobQueue, cfg config.SchedulerConfig) *Scheduler {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultSchedulerPollInterval
+	}
+	return &Scheduler{
+		schedules: schedules,
+		reports:   reports,
+		queue:     queue,
+		cfg:       cfg,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start 启动调度循环，启动时立即检查一次到期的计划
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.cfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			s.tick(time.Now())
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止调度循环
+func (s *Scheduler) Stop() {
+	s.once.Do(func() { close(s.stop) })
+	s.wg.Wait()
+}
+
+func (s *Scheduler) tick(now time.Time) {
+	due, err := s.schedules.FindDue(now, schedulerBatchSize)
+	if err != nil {
+		log.Printf("Failed to load due report schedules: %v", err)
+		return
+	}
+	for i := range due {
+		if err := s.fire(&due[i], now); err != nil {
+			log.Printf("Failed to fire report schedule %d: %v", due[i].ID, err)
+		}
+	}
+}
+
+// fire 触发一个到期的计划：创建任务并把下一次触发时间推进到 now 之后。
+// 无法创建任务时（如报表参数已变化）记录原因，计划照常推进；读取报表遇到临时错误时
+// 不修改计划，计划仍然到期，下一轮重试
+func (s *Scheduler) fire(schedule *models.ReportSchedule, now time.Time) error {
+	report, err := s.reports.GetByID(schedule.ReportID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("读取报表失败: %w", err)
+	}
+
+	planned := *schedule.NextRunAt
+	schedule.LastRunAt = &planned
+	schedule.LastError = ""
+
+	var job *models.ReportJob
+	next, err := NextScheduleRun(schedule.Cron, schedule.Timezone, now)
+	if err == nil {
+		schedule.NextRunAt = &next
+		job, err = s.newJob(schedule, report)
+	}
+	if err != nil {
+		schedule.LastError = err.Error()
+		if errors.Is(err, ErrInvalidSchedule) || errors.Is(err, gorm.ErrRecordNotFound) {
+			// 计划本身已无法执行（表达式无效或报表已删除），停用以免反复触发
+			schedule.Enabled = false
+			schedule.NextRunAt = nil
+		}
+	}
+
+	err = s.schedules.Fire(schedule, job)
+	if errors.Is(err, repository.ErrScheduleConflict) {
+		// 其他实例已经处理了这次触发
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if job != nil {
+		s.queue.wake()
+		log.Printf("Report schedule %d created job %d for report %d", schedule.ID, job.ID, schedule.ReportID)
+	} else {
+		log.Printf("Report schedule %d skipped: %s", schedule.ID, schedule.LastError)
+	}
+	return nil
+}
+
+// newJob 按报表当前的参数定义解析计划的参数值，构造待创建的任务。report 为 nil 表示报表已删除
+func (s *Scheduler) newJob(schedule *models.ReportSchedule, report *models.Report) (*models.ReportJob, error) {
+	if report == nil {
+		return nil, fmt.Errorf("读取报表失败: %w", gorm.ErrRecordNotFound)
+	}
+	parameters, err := ResolveParameters(report.Parameters, schedule.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	job := s.queue.newJob(report.ID, schedule.Format, parameters)
+	job.ScheduleID = &schedule.ID
+	return job, nil
+}
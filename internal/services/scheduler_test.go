@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/models"
+	"github.com/foldn/bi-go/internal/repository"
+)
+
+// flakyReports 读取报表时返回 err，模拟元数据库的临时故障
+type flakyReports struct {
+	repository.ReportRepository
+	err error
+}
+
+func (r *flakyReports) GetByID(id uint) (*models.Report, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.ReportRepository.GetByID(id)
+}
+
+func TestSchedulerRetriesTransientReportErrors(t *testing.T) {
+	db := newTestDB(t)
+	schedules := repository.NewReportScheduleRepository(db)
+	jobs := repository.NewReportJobRepository(db)
+	reports := &flakyReports{ReportRepository: repository.NewReportRepository(db)}
+	s := NewScheduler(schedules, reports, newTestQueue(jobs, "instance-a", time.Minute), config.SchedulerConfig{})
+
+	report := &models.Report{Name: "orders", DataSourceID: 1, Query: "SELECT 1"}
+	if err := reports.Create(report); err != nil {
+		t.Fatal(err)
+	}
+	due := time.Now().Add(-time.Minute)
+	schedule := &models.ReportSchedule{ReportID: report.ID, Cron: "@hourly", Format: "csv", Enabled: true, NextRunAt: &due}
+	if err := schedules.Create(schedule); err != nil {
+		t.Fatal(err)
+	}
+
+	// 临时错误：计划不推进，仍然到期
+	reports.err = errors.New("connection refused")
+	s.tick(time.Now())
+	got, _ := schedules.GetByID(schedule.ID)
+	if !got.Enabled || got.NextRunAt == nil || !got.NextRunAt.Equal(due) || got.LastRunAt != nil || got.LastJobID != nil {
+		t.Fatalf("schedule advanced after a transient error: %+v", got)
+	}
+
+	// 恢复后下一轮创建任务并推进
+	reports.err = nil
+	now := time.Now()
+	s.tick(now)
+	got, _ = schedules.GetByID(schedule.ID)
+	if got.LastJobID == nil || got.NextRunAt == nil || !got.NextRunAt.After(now) || got.LastError != "" {
+		t.Fatalf("schedule was not fired: %+v", got)
+	}
+
+	// 报表删除后计划停用
+	if err := reports.Delete(report.ID); err != nil {
+		t.Fatal(err)
+	}
+	s.tick(got.NextRunAt.Add(time.Second))
+	got, _ = schedules.GetByID(schedule.ID)
+	if got.Enabled || got.NextRunAt != nil || got.LastError == "" {
+		t.Fatalf("schedule of a deleted report is still enabled: %+v", got)
+	}
+}
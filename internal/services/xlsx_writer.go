@@ -0,0 +1,313 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	xlsxSheetName = "Report"
+	// xlsxWidthSample 用于估算列宽的行数，之后的行不再影响列宽
+	xlsxWidthSample = 200
+	xlsxMinWidth    = 8
+	xlsxMaxWidth    = 60
+	// xlsxMaxCellText Excel 单元格文本的长度上限
+	xlsxMaxCellText = 32767
+)
+
+// xlsxStyles 各类单元格使用的样式ID
+type xlsxStyles struct {
+	header   int
+	integer  int
+	decimal  int
+	date     int
+	dateTime int
+	time     int
+}
+
+func newXLSXStyles(f *excelize.File) (*xlsxStyles, error) {
+	var s xlsxStyles
+	var err error
+	numFmt := func(format string) (int, error) {
+		return f.NewStyle(&excelize.Style{CustomNumFmt: &format})
+	}
+	if s.header, err = f.NewStyle(&excelize.Style{
+		Font:   &excelize.Font{Bold: true},
+		Fill:   excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"DDEBF7"}},
+		Border: []excelize.Border{{Type: "bottom", Color: "808080", Style: 1}},
+	}); err != nil {
+		return nil, err
+	}
+	if s.integer, err = numFmt("0"); err != nil {
+		return nil, err
+	}
+	if s.decimal, err = numFmt("#,##0.00"); err != nil {
+		return nil, err
+	}
+	if s.date, err = numFmt("yyyy-mm-dd"); err != nil {
+		return nil, err
+	}
+	if s.dateTime, err = numFmt("yyyy-mm-dd hh:mm:ss"); err != nil {
+		return nil, err
+	}
+	if s.time, err = numFmt("hh:mm:ss"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// xlsxSheetWriter 按行写出工作表，超过行数上限时新建工作表继续写入
+type xlsxSheetWriter struct {
+	file      *excelize.File
+	columns   []string
+	types     []string
+	styles    *xlsxStyles
+	widths    []float64
+	sheetRows int
+
+	sheets int
+	stream *excelize.StreamWriter
+	row    int // 当前工作表已写出的数据行数
+}
+
+// writeXLSX 逐行写出 Excel 工作簿：表头加粗并冻结，数值和日期按列类型写为原生单元格，
+// 列宽根据前若干行估算。数据行数超过 SheetRows 时写入后续工作表
+func writeXLSX(w io.Writer, columns []string, rows RowIterator, cfg config.XLSXConfig) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	styles, err := newXLSXStyles(f)
+	if err != nil {
+		return err
+	}
+	sheetRows := cfg.SheetRows
+	if sheetRows <= 0 || sheetRows > excelize.TotalRows-1 {
+		sheetRows = excelize.TotalRows - 1
+	}
+
+	sw := &xlsxSheetWriter{
+		file:      f,
+		columns:   columns,
+		types:     outputColumnTypes(columns, rows),
+		styles:    styles,
+		sheetRows: sheetRows,
+	}
+
+	// 先读取少量行估算列宽，流式写入要求在写入数据前设置列宽
+	sample := make([]DataRow, 0, xlsxWidthSample)
+	for len(sample) < xlsxWidthSample && rows.Next() {
+		sample = append(sample, rows.Row())
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	sw.widths = xlsxColumnWidths(columns, sample)
+
+	for _, row := range sample {
+		if err := sw.write(row); err != nil {
+			return err
+		}
+	}
+	for rows.Next() {
+		if err := sw.write(rows.Row()); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := sw.close(); err != nil {
+		return err
+	}
+
+	_, err = f.WriteTo(w)
+	return err
+}
+
+// outputColumnTypes 输出列对应的归一化类型，报表定义的列不在查询结果中时为 unknown
+func outputColumnTypes(columns []string, rows RowIterator) []string {
+	byName := make(map[string]string, len(rows.Columns()))
+	for i, name := range rows.Columns() {
+		byName[name] = rows.ColumnTypes()[i]
+	}
+	types := make([]string, len(columns))
+	for i, name := range columns {
+		if t, ok := byName[name]; ok {
+			types[i] = t
+		} else {
+			types[i] = database.TypeUnknown
+		}
+	}
+	return types
+}
+
+func (sw *xlsxSheetWriter) write(row DataRow) error {
+	if sw.stream == nil || sw.row >= sw.sheetRows {
+		if err := sw.nextSheet(); err != nil {
+			return err
+		}
+	}
+
+	values := make([]interface{}, len(sw.columns))
+	for i, col := range sw.columns {
+		values[i] = sw.cell(row[col], sw.types[i])
+	}
+	sw.row++
+	cell, err := excelize.CoordinatesToCellName(1, sw.row+1)
+	if err != nil {
+		return err
+	}
+	return sw.stream.SetRow(cell, values)
+}
+
+// nextSheet 结束当前工作表并创建下一个，写入列宽、冻结窗格和表头
+func (sw *xlsxSheetWriter) nextSheet() error {
+	if err := sw.flush(); err != nil {
+		return err
+	}
+
+	sw.sheets++
+	name := xlsxSheetName
+	if sw.sheets == 1 {
+		if err := sw.file.SetSheetName("Sheet1", name); err != nil {
+			return err
+		}
+	} else {
+		name = fmt.Sprintf("%s %d", xlsxSheetName, sw.sheets)
+		if _, err := sw.file.NewSheet(name); err != nil {
+			return err
+		}
+	}
+
+	stream, err := sw.file.NewStreamWriter(name)
+	if err != nil {
+		return err
+	}
+	for i, width := range sw.widths {
+		if err := stream.SetColWidth(i+1, i+1, width); err != nil {
+			return err
+		}
+	}
+	if err := stream.SetPanes(&excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	}); err != nil {
+		return err
+	}
+
+	header := make([]interface{}, len(sw.columns))
+	for i, col := range sw.columns {
+		header[i] = excelize.Cell{StyleID: sw.styles.header, Value: col}
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	sw.stream = stream
+	sw.row = 0
+	return nil
+}
+
+// close 结束写入；没有任何数据行时也要写出带表头的工作表
+func (sw *xlsxSheetWriter) close() error {
+	if sw.sheets == 0 {
+		if err := sw.nextSheet(); err != nil {
+			return err
+		}
+	}
+	return sw.flush()
+}
+
+// flush 刷新当前工作表
+func (sw *xlsxSheetWriter) flush() error {
+	if sw.stream == nil {
+		return nil
+	}
+	err := sw.stream.Flush()
+	sw.stream = nil
+	return err
+}
+
+// cell 根据值和列类型生成单元格：数值和时间写为原生类型并设置数字格式，其余写为文本
+func (sw *xlsxSheetWriter) cell(value interface{}, columnType string) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		style := sw.styles.dateTime
+		switch columnType {
+		case database.TypeDate:
+			style = sw.styles.date
+		case database.TypeTime:
+			style = sw.styles.time
+		}
+		return excelize.Cell{StyleID: style, Value: v}
+	case int64, int32, int, uint64, uint32, uint:
+		return excelize.Cell{StyleID: sw.styles.integer, Value: v}
+	case float64, float32:
+		if columnType == database.TypeDecimal {
+			return excelize.Cell{StyleID: sw.styles.decimal, Value: v}
+		}
+		return v
+	case bool:
+		return v
+	case []byte:
+		return truncateCellText(string(v))
+	case string:
+		return truncateCellText(v)
+	default:
+		return truncateCellText(fmt.Sprint(v))
+	}
+}
+
+func truncateCellText(s string) string {
+	if utf8.RuneCountInString(s) <= xlsxMaxCellText {
+		return s
+	}
+	return string([]rune(s)[:xlsxMaxCellText])
+}
+
+// xlsxColumnWidths 根据表头和样本行估算列宽，全角字符按两个字符宽度计算
+func xlsxColumnWidths(columns []string, sample []DataRow) []float64 {
+	widths := make([]float64, len(columns))
+	for i, col := range columns {
+		widths[i] = float64(displayWidth(col))
+	}
+	for _, row := range sample {
+		for i, col := range columns {
+			if n := float64(displayWidth(formatCSVValue(row[col]))); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for i := range widths {
+		widths[i] += 2
+		if widths[i] < xlsxMinWidth {
+			widths[i] = xlsxMinWidth
+		}
+		if widths[i] > xlsxMaxWidth {
+			widths[i] = xlsxMaxWidth
+		}
+	}
+	return widths
+}
+
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x1100 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+	"github.com/foldn/bi-go/internal/database"
+	"github.com/xuri/excelize/v2"
+)
+
+// newWriterTestRows 各输出格式测试使用的结果：n 行，第 2 行除 id 外全部为空值，
+// name 列包含需要转义的字符
+func newWriterTestRows(n int) *testRows {
+	r := &testRows{
+		columns: []string{"id", "name", "amount", "day", "at", "flag"},
+		types: []string{database.TypeInteger, database.TypeString, database.TypeDecimal, database.TypeDate,
+			database.TypeDateTime, database.TypeBoolean},
+	}
+	for i := 1; i <= n; i++ {
+		if i == 2 {
+			r.rows = append(r.rows, []interface{}{int64(i), nil, nil, nil, nil, nil})
+			continue
+		}
+		day := time.Date(2024, 3, i, 0, 0, 0, 0, time.UTC)
+		r.rows = append(r.rows, []interface{}{int64(i), fmt.Sprintf(`<b>"n%d" & '三'</b>`, i), float64(i) * 1000.25,
+			day, day.Add(10*time.Hour + 20*time.Minute + 30*time.Second), i%2 == 1})
+	}
+	return r
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, []string{"id", "name", "amount", "day", "at", "flag", "extra"}, newWriterTestRows(5), config.XLSXConfig{SheetRows: 2}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 每个工作表最多 2 行数据，超出的行写入后续工作表
+	sheets := f.GetSheetList()
+	if want := []string{"Report", "Report 2", "Report 3"}; !reflect.DeepEqual(sheets, want) {
+		t.Fatalf("got sheets %v, want %v", sheets, want)
+	}
+	header := []string{"id", "name", "amount", "day", "at", "flag", "extra"}
+	var ids []string
+	for _, sheet := range sheets {
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) == 0 || !reflect.DeepEqual(rows[0], header) {
+			t.Fatalf("%s: got header %v", sheet, rows)
+		}
+		for _, row := range rows[1:] {
+			ids = append(ids, row[0])
+		}
+		if panes, err := f.GetPanes(sheet); err != nil || !panes.Freeze || panes.YSplit != 1 {
+			t.Errorf("%s: got panes %+v, %v", sheet, panes, err)
+		}
+	}
+	if want := []string{"1", "2", "3", "4", "5"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got ids %v, want %v", ids, want)
+	}
+
+	rows, err := f.GetRows("Report 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 数值和日期按数字格式显示，文本原样保存
+	if want := []string{"3", `<b>"n3" & '三'</b>`, "3,000.75", "2024-03-03", "2024-03-03 10:20:30", "TRUE"}; !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("got row %q, want %q", rows[1], want)
+	}
+	// 数值单元格不写类型属性，即默认的数字类型
+	cells := map[string]excelize.CellType{"A2": excelize.CellTypeUnset, "C2": excelize.CellTypeUnset, "B2": excelize.CellTypeInlineString}
+	for cell, want := range cells {
+		if got, err := f.GetCellType("Report 2", cell); err != nil || got != want {
+			t.Errorf("%s: got cell type %v, %v, want %v", cell, got, err, want)
+		}
+	}
+	for cell, want := range map[string]string{"A2": "3", "C2": "3000.75", "D2": "45354"} {
+		if raw, _ := f.GetCellValue("Report 2", cell, excelize.Options{RawCellValue: true}); raw != want {
+			t.Errorf("%s: got raw value %q, want %q", cell, raw, want)
+		}
+	}
+	// 空值写为空单元格
+	if rows, _ := f.GetRows("Report"); len(rows[2]) != 1 {
+		t.Errorf("got row with nulls %q", rows[2])
+	}
+}
+
+func TestWriteXLSXEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, []string{"id", "name"}, &testRows{columns: []string{"id", "name"}}, config.XLSXConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows("Report")
+	if err != nil || !reflect.DeepEqual(f.GetSheetList(), []string{"Report"}) || !reflect.DeepEqual(rows, [][]string{{"id", "name"}}) {
+		t.Errorf("got sheets %v, rows %v, %v", f.GetSheetList(), rows, err)
+	}
+}
+
+func TestTruncateCellText(t *testing.T) {
+	long := strings.Repeat("三", xlsxMaxCellText+10)
+	if got := truncateCellText(long); len([]rune(got)) != xlsxMaxCellText {
+		t.Errorf("got %d characters", len([]rune(got)))
+	}
+	if got := truncateCellText("abc"); got != "abc" {
+		t.Errorf("got %q", got)
+	}
+}
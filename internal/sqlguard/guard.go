@@ -0,0 +1,418 @@
+// Package sqlguard 在保存和执行报表前校验查询语句：只允许单条只读的 SELECT/WITH 语句，
+// 并按数据源的配置检查 LIMIT 上限和禁止访问的表
+package sqlguard
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/foldn/bi-go/internal/models"
+)
+
+// Dialect 查询使用的 SQL 方言，决定注释、字符串和标识符的解析方式
+type Dialect string
+
+const (
+	MySQL      Dialect = "mysql"
+	PostgreSQL Dialect = "postgresql"
+	SQLite     Dialect = "sqlite"
+	ClickHouse Dialect = "clickhouse"
+)
+
+// ErrUnsafeQuery 查询不是只读语句或违反了数据源的查询限制
+var ErrUnsafeQuery = errors.New("unsafe report query")
+
+// Violation 描述查询中不允许的部分，Line 和 Column 从 1 开始，Column 按字符计数
+type Violation struct {
+	Offset  int
+	Line    int
+	Column  int
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", v.Message, v.Line, v.Column)
+}
+
+func (v *Violation) Unwrap() error {
+	return ErrUnsafeQuery
+}
+
+func violationAt(query string, offset int, format string, args ...interface{}) *Violation {
+	before := query[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+	return &Violation{Offset: offset, Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+// Policy 数据源对报表查询的额外限制
+type Policy struct {
+	// MaxRows 大于 0 时，查询最外层必须带有不超过该值的 LIMIT
+	MaxRows int
+	// ForbiddenTables 禁止访问的表，不带 schema 的表名匹配任意 schema 下的同名表
+	ForbiddenTables []string
+}
+
+// DialectOf 返回数据源类型对应的方言，csv 数据源在 SQLite 中查询
+func DialectOf(t models.DataSourceType) Dialect {
+	switch t {
+	case models.MySQL:
+		return MySQL
+	case models.PostgreSQL:
+		return PostgreSQL
+	case models.ClickHouse:
+		return ClickHouse
+	}
+	return SQLite
+}
+
+// CheckDataSourceQuery 按数据源的方言和限制校验查询
+func CheckDataSourceQuery(ds *models.DataSource, query string) error {
+	return Check(query, DialectOf(ds.Type), Policy{MaxRows: ds.MaxRows, ForbiddenTables: ds.ForbiddenTables})
+}
+
+// CheckDataSourceEntity 检查数据源的实体（可带以 . 分隔的 schema 前缀）是否允许访问，
+// 用于不经过查询语句直接读取实体的场景
+func CheckDataSourceEntity(ds *models.DataSource, entity string) error {
+	parts := strings.Split(strings.ToLower(entity), ".")
+	if table, ok := (Policy{ForbiddenTables: ds.ForbiddenTables}).forbidden(parts); ok {
+		return fmt.Errorf("%w: table %s is not allowed for this data source", ErrUnsafeQuery, table)
+	}
+	return nil
+}
+
+// 只读查询中不应出现的关键字，出现在 "." 之后（如 t.update）时视为列名
+var forbiddenKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "UPSERT": true,
+	"REPLACE": true, "TRUNCATE": true, "DROP": true, "ALTER": true, "CREATE": true,
+	"GRANT": true, "REVOKE": true, "INTO": true, "CALL": true, "COPY": true, "LOCK": true,
+}
+
+// 各方言中有副作用、会读写服务器文件或访问外部系统的函数
+var forbiddenFunctions = map[Dialect]map[string]bool{
+	PostgreSQL: toSet("pg_sleep", "pg_sleep_for", "pg_sleep_until", "pg_terminate_backend", "pg_cancel_backend",
+		"pg_reload_conf", "pg_rotate_logfile", "pg_promote", "set_config", "pg_read_file", "pg_read_binary_file",
+		"pg_ls_dir", "pg_stat_file", "lo_import", "lo_export", "lo_unlink", "lo_create", "lo_from_bytea", "lo_put",
+		"dblink", "dblink_exec", "dblink_connect", "nextval", "setval", "pg_advisory_lock", "pg_advisory_xact_lock",
+		"query_to_xml", "query_to_xmlschema", "query_to_xml_and_xmlschema", "cursor_to_xml"),
+	MySQL: toSet("sleep", "benchmark", "get_lock", "release_lock", "release_all_locks", "load_file",
+		"sys_exec", "sys_eval"),
+	SQLite: toSet("load_extension", "readfile", "writefile", "edit", "fts3_tokenizer"),
+	ClickHouse: toSet("sleep", "sleepeachrow", "file", "url", "s3", "s3cluster", "remote", "remotesecure",
+		"cluster", "clusterallreplicas", "mysql", "postgresql", "jdbc", "odbc", "hdfs", "executable", "sqlite",
+		"mongodb", "redis", "azureblobstorage", "gcs"),
+}
+
+// 结束 FROM 子句的关键字
+var fromEndKeywords = toSet("where", "group", "having", "order", "limit", "offset", "fetch", "window",
+	"union", "except", "intersect", "minus", "qualify", "prewhere", "settings", "format", "returning", "into")
+
+func toSet(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// Check 校验查询是否为单条只读语句并满足 policy，违反时返回 *Violation
+func Check(query string, dialect Dialect, policy Policy) error {
+	tokens, err := lex(query, dialect)
+	if err != nil {
+		return err
+	}
+	c := &checker{query: query, dialect: dialect, policy: policy}
+	return c.check(tokens)
+}
+
+type checker struct {
+	query   string
+	dialect Dialect
+	policy  Policy
+	tokens  []token
+}
+
+func (c *checker) at(t token, format string, args ...interface{}) error {
+	return violationAt(c.query, t.pos, format, args...)
+}
+
+func (c *checker) check(tokens []token) error {
+	// 只允许末尾的分号
+	for i, t := range tokens {
+		if !t.isPunct(";") {
+			continue
+		}
+		for _, rest := range tokens[i+1:] {
+			if !rest.isPunct(";") {
+				return c.at(rest, "only a single statement is allowed")
+			}
+		}
+		tokens = tokens[:i]
+		break
+	}
+	if len(tokens) == 0 {
+		return violationAt(c.query, 0, "query is empty")
+	}
+	c.tokens = tokens
+
+	first := 0
+	for first < len(tokens)-1 && tokens[first].isPunct("(") {
+		first++
+	}
+	if !tokens[first].is("SELECT") && !tokens[first].is("WITH") {
+		return c.at(tokens[first], "only SELECT or WITH queries are allowed, found %q", tokens[first].text)
+	}
+
+	var opens []int
+	// selects[d] 记录第 d 层括号内是否出现过 SELECT，用于区分 FROM 子句和 EXTRACT(x FROM y) 之类的函数参数
+	selects := []bool{false}
+	var limits []int
+	for i, t := range tokens {
+		switch {
+		case t.isPunct("("):
+			opens = append(opens, i)
+			selects = append(selects, false)
+			continue
+		case t.isPunct(")"):
+			if len(opens) == 0 {
+				return c.at(t, "unbalanced parenthesis")
+			}
+			opens = opens[:len(opens)-1]
+			selects = selects[:len(selects)-1]
+			continue
+		}
+		if t.kind != tokIdent && t.kind != tokQuotedIdent {
+			continue
+		}
+
+		name := strings.ToLower(strings.Trim(t.text, "\"`[]"))
+		if c.next(i).isPunct("(") && forbiddenFunctions[c.dialect][name] {
+			return c.at(t, "function %s is not allowed in report queries", name)
+		}
+		if t.kind != tokIdent || c.prev(i).isPunct(".") {
+			continue
+		}
+		upper := strings.ToUpper(t.text)
+		if forbiddenKeywords[upper] && !(upper == "REPLACE" && c.next(i).isPunct("(")) {
+			return c.at(t, "%s is not allowed in report queries", upper)
+		}
+		switch upper {
+		case "SELECT":
+			selects[len(selects)-1] = true
+		case "FOR":
+			if next := c.next(i); next.is("SHARE") || next.is("KEY") || next.is("NO") {
+				return c.at(t, "locking clause FOR %s is not allowed", strings.ToUpper(next.text))
+			}
+		case "FROM":
+			if selects[len(selects)-1] {
+				if err := c.checkTables(i + 1); err != nil {
+					return err
+				}
+			}
+		case "TABLE":
+			// PostgreSQL、MySQL 的 TABLE name 等同于 SELECT * FROM name，可以出现在子查询和 UNION 中
+			if next := c.next(i); next.kind == tokIdent || next.kind == tokQuotedIdent {
+				if err := c.checkTables(i + 1); err != nil {
+					return err
+				}
+			}
+		case "LIMIT", "FETCH":
+			if len(opens) == 0 {
+				limits = append(limits, i)
+			}
+		}
+	}
+	if len(opens) > 0 {
+		return c.at(tokens[opens[len(opens)-1]], "unbalanced parenthesis")
+	}
+	return c.checkLimit(limits)
+}
+
+// prev、next 返回相邻的 token，越界时返回不匹配任何类型的空 token
+func (c *checker) prev(i int) token {
+	if i > 0 {
+		return c.tokens[i-1]
+	}
+	return token{kind: -1}
+}
+
+func (c *checker) next(i int) token {
+	if i+1 < len(c.tokens) {
+		return c.tokens[i+1]
+	}
+	return token{kind: -1}
+}
+
+// skipParens 返回从 i 处的左括号开始、与之匹配的右括号之后的位置
+func (c *checker) skipParens(i int) int {
+	depth := 0
+	for ; i < len(c.tokens); i++ {
+		switch {
+		case c.tokens[i].isPunct("("):
+			depth++
+		case c.tokens[i].isPunct(")"):
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// checkTables 检查从 i 开始的 FROM 子句中的全部表引用，直到结束子句的关键字或外层的右括号为止。
+// 表引用出现在子句开头、逗号和 JOIN 之后；其后的别名、ON/USING 条件、索引提示、PARTITION、TABLESAMPLE 等都跳过。
+// 括号中的子查询只跳过，其中的 FROM 会在主循环中单独检查；括号中的其他内容是带括号的表引用
+// （如 (t) 或 (a CROSS JOIN b)），递归检查
+func (c *checker) checkTables(i int) error {
+	if len(c.policy.ForbiddenTables) == 0 {
+		return nil
+	}
+	// arrayJoin 为 true 时处于 ClickHouse 的 ARRAY JOIN 之后，逗号分隔的是数组表达式而不是表
+	expectTable, arrayJoin := true, false
+	for i < len(c.tokens) {
+		t := c.tokens[i]
+		switch {
+		case t.isPunct(")"):
+			return nil
+		case t.kind == tokIdent && fromEndKeywords[strings.ToLower(t.text)]:
+			return nil
+		case t.isPunct(","):
+			expectTable = !arrayJoin
+			i++
+			continue
+		case (t.is("JOIN") || t.is("STRAIGHT_JOIN")) && !c.prev(i).is("FOR"):
+			// MySQL 的索引提示 USE INDEX FOR JOIN (idx) 中的 JOIN 不是连接
+			arrayJoin = c.prev(i).is("ARRAY")
+			expectTable = !arrayJoin
+			i++
+			continue
+		}
+		if !expectTable {
+			if t.isPunct("(") {
+				i = c.skipParens(i)
+			} else {
+				i++
+			}
+			continue
+		}
+
+		expectTable = false
+		for c.tokens[i].is("LATERAL") || c.tokens[i].is("ONLY") {
+			if i++; i == len(c.tokens) {
+				return nil
+			}
+		}
+		t = c.tokens[i]
+		switch {
+		case t.isPunct("("):
+			if next := c.next(i); !next.is("SELECT") && !next.is("WITH") && !next.is("VALUES") && !next.is("TABLE") {
+				if err := c.checkTables(i + 1); err != nil {
+					return err
+				}
+			}
+			i = c.skipParens(i)
+		case t.kind == tokIdent || t.kind == tokQuotedIdent:
+			parts := []string{unquote(t.text)}
+			for i+2 < len(c.tokens) && c.tokens[i+1].isPunct(".") &&
+				(c.tokens[i+2].kind == tokIdent || c.tokens[i+2].kind == tokQuotedIdent) {
+				parts = append(parts, unquote(c.tokens[i+2].text))
+				i += 2
+			}
+			if table, ok := c.forbidden(parts); ok {
+				return c.at(t, "table %s is not allowed for this data source", table)
+			}
+			i++
+		}
+	}
+	return nil
+}
+
+func unquote(name string) string {
+	if len(name) >= 2 && strings.ContainsRune("\"`[", rune(name[0])) {
+		name = name[1 : len(name)-1]
+	}
+	return strings.ToLower(name)
+}
+
+func (c *checker) forbidden(parts []string) (string, bool) {
+	return c.policy.forbidden(parts)
+}
+
+// forbidden 判断限定名 parts（已转为小写）是否以某个禁止的表名结尾
+func (p Policy) forbidden(parts []string) (string, bool) {
+	for _, entry := range p.ForbiddenTables {
+		entryParts := strings.Split(strings.ToLower(strings.TrimSpace(entry)), ".")
+		if len(entryParts) > len(parts) {
+			continue
+		}
+		offset := len(parts) - len(entryParts)
+		matched := true
+		for k, part := range entryParts {
+			if unquote(part) != parts[offset+k] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return strings.Join(parts, "."), true
+		}
+	}
+	return "", false
+}
+
+// checkLimit 在设置了 MaxRows 时检查最外层的 LIMIT n、LIMIT offset, n 或 FETCH FIRST n ROWS
+func (c *checker) checkLimit(limits []int) error {
+	if c.policy.MaxRows <= 0 {
+		return nil
+	}
+	for _, i := range limits {
+		count, ok := c.limitCount(i)
+		if !ok {
+			continue
+		}
+		if count.kind != tokNumber {
+			return c.at(count, "LIMIT must be a number literal")
+		}
+		n, err := strconv.Atoi(count.text)
+		if err != nil || n > c.policy.MaxRows {
+			return c.at(count, "LIMIT %s exceeds the data source maximum of %d rows", count.text, c.policy.MaxRows)
+		}
+		return nil
+	}
+	return violationAt(c.query, len(c.query), "query must have a LIMIT of at most %d rows", c.policy.MaxRows)
+}
+
+// limitCount 返回 LIMIT 或 FETCH 子句中的行数 token，ClickHouse 的 LIMIT n BY 不限制结果行数
+func (c *checker) limitCount(i int) (token, bool) {
+	if c.tokens[i].is("FETCH") {
+		j := i + 1
+		if !c.next(i).is("FIRST") && !c.next(i).is("NEXT") {
+			return token{}, false
+		}
+		j++
+		if j < len(c.tokens) && (c.tokens[j].is("ROW") || c.tokens[j].is("ROWS")) {
+			// FETCH FIRST ROW ONLY 只返回一行
+			return token{kind: tokNumber, text: "1", pos: c.tokens[j].pos}, true
+		}
+		if j < len(c.tokens) {
+			return c.tokens[j], true
+		}
+		return token{}, false
+	}
+
+	if i+1 >= len(c.tokens) {
+		return token{}, false
+	}
+	count, j := c.tokens[i+1], i+2
+	if j+1 < len(c.tokens) && c.tokens[j].isPunct(",") {
+		count, j = c.tokens[j+1], j+2
+	}
+	if c.dialect == ClickHouse && j < len(c.tokens) && c.tokens[j].is("BY") {
+		return token{}, false
+	}
+	return count, true
+}
@@ -0,0 +1,122 @@
+package sqlguard
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		dialect Dialect
+		policy  Policy
+		ok      bool
+	}{
+		{"select", "SELECT id, name FROM users WHERE id = 1;", PostgreSQL, Policy{}, true},
+		{"with", "WITH t AS (SELECT 1 AS x) SELECT x FROM t", PostgreSQL, Policy{}, true},
+		{"insert", "INSERT INTO users VALUES (1)", PostgreSQL, Policy{}, false},
+		{"writable cte", "WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d", PostgreSQL, Policy{}, false},
+		{"second statement", "SELECT 1; DROP TABLE users", PostgreSQL, Policy{}, false},
+		{"keyword in string", "SELECT 'DROP TABLE users' FROM t", PostgreSQL, Policy{}, true},
+		{"keyword in comment", "SELECT 1 /* DELETE */ FROM t", PostgreSQL, Policy{}, true},
+		{"keyword as column", "SELECT t.update FROM t", PostgreSQL, Policy{}, true},
+		{"forbidden function", "SELECT pg_sleep(10)", PostgreSQL, Policy{}, false},
+		{"locking clause", "SELECT * FROM t FOR SHARE", PostgreSQL, Policy{}, false},
+		{"limit required", "SELECT * FROM t", MySQL, Policy{MaxRows: 100}, false},
+		{"limit too large", "SELECT * FROM t LIMIT 1000", MySQL, Policy{MaxRows: 100}, false},
+		{"limit within bound", "SELECT * FROM t LIMIT 50", MySQL, Policy{MaxRows: 100}, true},
+	}
+	for _, tt := range tests {
+		err := Check(tt.query, tt.dialect, tt.policy)
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrUnsafeQuery) {
+			t.Errorf("%s: got %v, want ErrUnsafeQuery", tt.name, err)
+		}
+	}
+}
+
+func TestCheckForbiddenTables(t *testing.T) {
+	policy := Policy{ForbiddenTables: []string{"secret"}}
+	denied := []string{
+		"SELECT * FROM secret",
+		"SELECT * FROM public.secret s",
+		`SELECT * FROM "secret"`,
+		"SELECT * FROM x JOIN secret ON x.id = secret.id",
+		"SELECT * FROM x, secret",
+		"SELECT * FROM (SELECT * FROM secret) s",
+		"SELECT * FROM x WHERE id IN (SELECT id FROM secret)",
+		// 带括号的表引用和 TABLE 语句
+		"SELECT * FROM (secret)",
+		"SELECT * FROM ((secret)) AS s",
+		"SELECT * FROM x, (secret)",
+		"SELECT * FROM (secret CROSS JOIN x)",
+		"SELECT * FROM (x CROSS JOIN secret)",
+		"SELECT * FROM ONLY (secret)",
+		"SELECT * FROM x JOIN (secret) ON true",
+		"SELECT 1 FROM x WHERE EXISTS (TABLE secret)",
+		"SELECT * FROM (TABLE secret) s",
+		"SELECT id FROM x UNION TABLE public.secret",
+	}
+	for _, query := range denied {
+		if err := Check(query, PostgreSQL, policy); !errors.Is(err, ErrUnsafeQuery) {
+			t.Errorf("%s: got %v, want ErrUnsafeQuery", query, err)
+		}
+	}
+
+	// 别名、连接条件、索引提示、PARTITION 和 TABLESAMPLE 之后逗号分隔的表
+	dialectDenied := []struct {
+		dialect Dialect
+		query   string
+	}{
+		{MySQL, "SELECT * FROM a JOIN b ON a.id = b.id, secret"},
+		{PostgreSQL, "SELECT * FROM a JOIN b ON a.id = b.id, secret"},
+		{PostgreSQL, "SELECT * FROM a JOIN b USING (id), secret s"},
+		{PostgreSQL, "SELECT * FROM a LEFT JOIN b ON a.id = b.id AND b.x IN (1, 2) JOIN secret ON true"},
+		{MySQL, "SELECT * FROM x USE INDEX (PRIMARY), secret"},
+		{MySQL, "SELECT * FROM x USE INDEX FOR JOIN (PRIMARY), secret"},
+		{MySQL, "SELECT * FROM x PARTITION (p0), secret"},
+		{PostgreSQL, "SELECT * FROM x TABLESAMPLE SYSTEM (10), secret"},
+		{PostgreSQL, "SELECT * FROM x TABLESAMPLE BERNOULLI (10) REPEATABLE (1) AS t, secret"},
+		{ClickHouse, "SELECT * FROM x FINAL SAMPLE 0.1, secret"},
+		{ClickHouse, "SELECT * FROM x ARRAY JOIN arr AS a, tags JOIN secret ON true"},
+	}
+	for _, tt := range dialectDenied {
+		if err := Check(tt.query, tt.dialect, policy); !errors.Is(err, ErrUnsafeQuery) {
+			t.Errorf("%s (%s): got %v, want ErrUnsafeQuery", tt.query, tt.dialect, err)
+		}
+	}
+
+	allowed := []string{
+		"SELECT * FROM x",
+		"SELECT * FROM (x) CROSS JOIN (y)",
+		"SELECT * FROM (SELECT 1 AS secret) s",
+		"SELECT EXTRACT(YEAR FROM secret) FROM x",
+		"SELECT x.secret FROM x",
+		"SELECT * FROM (VALUES (1), (2)) AS v (secret)",
+		"SELECT 1 FROM x WHERE EXISTS (TABLE y)",
+		"SELECT * FROM x JOIN y ON x.id = y.id, z WHERE x.secret IN (1, 2) ORDER BY x.secret",
+		"SELECT * FROM x TABLESAMPLE SYSTEM (10), y",
+	}
+	for _, query := range allowed {
+		if err := Check(query, PostgreSQL, policy); err != nil {
+			t.Errorf("%s: unexpected error %v", query, err)
+		}
+	}
+
+	dialectAllowed := []struct {
+		dialect Dialect
+		query   string
+	}{
+		{MySQL, "SELECT * FROM x USE INDEX FOR JOIN (secret)"},
+		{ClickHouse, "SELECT * FROM x ARRAY JOIN secret"},
+		{ClickHouse, "SELECT * FROM x ARRAY JOIN arr AS a, secret AS s"},
+	}
+	for _, tt := range dialectAllowed {
+		if err := Check(tt.query, tt.dialect, policy); err != nil {
+			t.Errorf("%s (%s): unexpected error %v", tt.query, tt.dialect, err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package sqlguard
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokIdent       tokenKind = iota // 未加引号的标识符或关键字
+	tokQuotedIdent                  // "name"、`name`、[name]
+	tokString
+	tokNumber
+	tokParam // ?、$1 以及报表参数 :name
+	tokPunct // 其余单个字符，以及 ::
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // 在查询中的字节偏移
+}
+
+// is 判断是否为指定的关键字（不区分大小写）
+func (t token) is(keyword string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (t token) isPunct(p string) bool {
+	return t.kind == tokPunct && t.text == p
+}
+
+// lexer 按方言切分查询。对各数据库解析方式不确定的写法，宁可多切出 token 也不把内容当作注释或字符串跳过，
+// 避免把数据库会执行的语句隐藏起来
+type lexer struct {
+	query   string
+	dialect Dialect
+	pos     int
+	tokens  []token
+}
+
+func lex(query string, dialect Dialect) ([]token, error) {
+	l := &lexer{query: query, dialect: dialect}
+	for l.pos < len(query) {
+		if err := l.next(); err != nil {
+			return nil, err
+		}
+	}
+	return l.tokens, nil
+}
+
+func (l *lexer) emit(kind tokenKind, start int) {
+	l.tokens = append(l.tokens, token{kind: kind, text: l.query[start:l.pos], pos: start})
+}
+
+func (l *lexer) peek(offset int) byte {
+	if l.pos+offset < len(l.query) {
+		return l.query[l.pos+offset]
+	}
+	return 0
+}
+
+func (l *lexer) next() error {
+	start := l.pos
+	c := l.query[l.pos]
+	r, size := utf8.DecodeRuneInString(l.query[l.pos:])
+
+	switch {
+	case unicode.IsSpace(r):
+		l.pos += size
+	case c == '-' && l.peek(1) == '-' && l.lineCommentAllowed():
+		l.skipLine()
+	case c == '#' && l.dialect == MySQL:
+		l.skipLine()
+	case c == '/' && l.peek(1) == '*':
+		return l.blockComment()
+	case c == '\'':
+		return l.quoted(start, '\'', tokString, l.backslashEscapes())
+	case (c == 'E' || c == 'e') && l.peek(1) == '\'' && l.dialect == PostgreSQL:
+		// E'...' 字符串支持反斜杠转义
+		l.pos++
+		return l.quoted(start, '\'', tokString, true)
+	case c == '"':
+		if l.dialect == MySQL {
+			return l.quoted(start, '"', tokString, true)
+		}
+		return l.quoted(start, '"', tokQuotedIdent, l.dialect == ClickHouse)
+	case c == '`' && l.dialect != PostgreSQL:
+		return l.quoted(start, '`', tokQuotedIdent, false)
+	case c == '[' && l.dialect == SQLite:
+		end := strings.IndexByte(l.query[l.pos+1:], ']')
+		if end < 0 {
+			return violationAt(l.query, start, "unterminated quoted identifier")
+		}
+		l.pos += end + 2
+		l.emit(tokQuotedIdent, start)
+	case c == '$' && l.dialect == PostgreSQL:
+		return l.dollar(start)
+	case c == '?':
+		l.pos++
+		l.emit(tokParam, start)
+	case c == ':' && l.peek(1) == ':':
+		l.pos += 2
+		l.emit(tokPunct, start)
+	case c == ':' && isIdentStart(rune(l.peek(1))):
+		// 报表参数 :name 或 :name.field
+		l.pos++
+		l.skipIdent()
+		if l.peek(0) == '.' && isIdentStart(rune(l.peek(1))) {
+			l.pos++
+			l.skipIdent()
+		}
+		l.emit(tokParam, start)
+	case c >= '0' && c <= '9' || c == '.' && l.peek(1) >= '0' && l.peek(1) <= '9':
+		l.number()
+		l.emit(tokNumber, start)
+	case isIdentStart(r):
+		l.skipIdent()
+		l.emit(tokIdent, start)
+	default:
+		l.pos += size
+		l.emit(tokPunct, start)
+	}
+	return nil
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func (l *lexer) skipIdent() {
+	for l.pos < len(l.query) {
+		r, size := utf8.DecodeRuneInString(l.query[l.pos:])
+		if !isIdentStart(r) && !unicode.IsDigit(r) && r != '$' {
+			return
+		}
+		l.pos += size
+	}
+}
+
+func (l *lexer) number() {
+	for l.pos < len(l.query) {
+		c := l.query[l.pos]
+		switch {
+		case c >= '0' && c <= '9', c == '.', c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			l.pos++
+		case (c == '+' || c == '-') && (l.query[l.pos-1] == 'e' || l.query[l.pos-1] == 'E'):
+			l.pos++
+		default:
+			return
+		}
+	}
+}
+
+// lineCommentAllowed MySQL 只把后跟空白的 -- 视为注释
+func (l *lexer) lineCommentAllowed() bool {
+	if l.dialect != MySQL {
+		return true
+	}
+	next := l.peek(2)
+	return next == 0 || next == ' ' || next == '\t' || next == '\n' || next == '\r'
+}
+
+func (l *lexer) skipLine() {
+	if end := strings.IndexByte(l.query[l.pos:], '\n'); end >= 0 {
+		l.pos += end + 1
+	} else {
+		l.pos = len(l.query)
+	}
+}
+
+// backslashEscapes MySQL 和 ClickHouse 的字符串默认支持反斜杠转义
+func (l *lexer) backslashEscapes() bool {
+	return l.dialect == MySQL || l.dialect == ClickHouse
+}
+
+// blockComment 跳过 /* */ 注释，PostgreSQL 的注释可以嵌套。
+// MySQL 的 /*! */ 注释中的内容会被执行，直接拒绝
+func (l *lexer) blockComment() error {
+	start := l.pos
+	if l.dialect == MySQL && (l.peek(2) == '!' || l.peek(2) == 'M' && l.peek(3) == '!') {
+		return violationAt(l.query, start, "MySQL executable comments are not allowed")
+	}
+	depth := 0
+	for l.pos < len(l.query) {
+		switch {
+		case strings.HasPrefix(l.query[l.pos:], "/*"):
+			if depth == 0 || l.dialect == PostgreSQL {
+				depth++
+			}
+			l.pos += 2
+		case strings.HasPrefix(l.query[l.pos:], "*/"):
+			depth--
+			l.pos += 2
+			if depth == 0 {
+				return nil
+			}
+		default:
+			l.pos++
+		}
+	}
+	return violationAt(l.query, start, "unterminated comment")
+}
+
+// quoted 读取以 quote 包围的字符串或标识符，两个连续的引号表示引号本身。
+// 支持反斜杠转义时拒绝 \ 加引号的写法：数据库关闭反斜杠转义时字符串会在此处结束
+func (l *lexer) quoted(start int, quote byte, kind tokenKind, backslash bool) error {
+	l.pos++
+	for l.pos < len(l.query) {
+		c := l.query[l.pos]
+		switch {
+		case backslash && c == '\\':
+			if next := l.peek(1); next == '\'' || next == '"' || next == '`' {
+				return violationAt(l.query, l.pos, "backslash-escaped quotes are not allowed, double the quote instead")
+			}
+			l.pos += 2
+		case c == quote && l.peek(1) == quote:
+			l.pos += 2
+		case c == quote:
+			l.pos++
+			l.emit(kind, start)
+			return nil
+		default:
+			l.pos++
+		}
+	}
+	return violationAt(l.query, start, "unterminated quoted string or identifier")
+}
+
+// dollar 处理 PostgreSQL 的 $1 占位符和 $tag$...$tag$ 字符串
+func (l *lexer) dollar(start int) error {
+	if c := l.peek(1); c >= '0' && c <= '9' {
+		l.pos++
+		for l.pos < len(l.query) && l.query[l.pos] >= '0' && l.query[l.pos] <= '9' {
+			l.pos++
+		}
+		l.emit(tokParam, start)
+		return nil
+	}
+	end := strings.IndexByte(l.query[l.pos+1:], '$')
+	if end < 0 {
+		l.pos++
+		l.emit(tokPunct, start)
+		return nil
+	}
+	tag := l.query[l.pos : l.pos+end+2]
+	for _, r := range tag[1 : len(tag)-1] {
+		if !isIdentStart(r) && !unicode.IsDigit(r) {
+			l.pos++
+			l.emit(tokPunct, start)
+			return nil
+		}
+	}
+	close := strings.Index(l.query[l.pos+len(tag):], tag)
+	if close < 0 {
+		return violationAt(l.query, start, "unterminated dollar-quoted string")
+	}
+	l.pos += len(tag) + close + len(tag)
+	l.emit(tokString, start)
+	return nil
+}
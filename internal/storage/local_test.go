@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	local := NewLocal(dir)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(path, []byte("id\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.PutFile(ctx, "2024/12_345.csv", path, "text/csv"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := local.Open(ctx, "2024/12_345.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(got) != "id\n1\n" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	if _, err := local.PresignGet(ctx, "2024/12_345.csv", "orders.csv", time.Hour); !errors.Is(err, ErrPresignUnsupported) {
+		t.Fatalf("got %v, want ErrPresignUnsupported", err)
+	}
+	for _, key := range []string{"../outside.csv", "/etc/passwd", `a\b.csv`, "."} {
+		if _, err := local.Open(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: got %v, want an invalid key error", key, err)
+		}
+	}
+
+	if err := local.Delete(ctx, "2024/12_345.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Open(ctx, "2024/12_345.csv"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if err := local.Delete(ctx, "2024/12_345.csv"); err != nil {
+		t.Fatalf("delete of a missing file: %v", err)
+	}
+}
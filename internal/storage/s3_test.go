@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
+)
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+// fakeS3 只实现报表存储用到的 path-style 请求：HEAD 存储桶，PUT、GET、HEAD、DELETE 对象
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, *httptest.Server) {
+	t.Helper()
+	f := &fakeS3{bucket: bucket, objects: make(map[string]fakeObject)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if key == "" {
+		if r.Method == http.MethodHead {
+			return
+		}
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = fakeObject{data: data, contentType: r.Header.Get("Content-Type")}
+		w.Header().Set("ETag", etag(data))
+	case http.MethodGet, http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(obj.data))
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) object(key string) (fakeObject, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[key]
+	return obj, ok
+}
+
+// readS3Body 读取请求体，aws-chunked 编码的请求体去掉分块签名和 trailer
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func newTestS3(t *testing.T, server *httptest.Server, bucket string) *S3 {
+	t.Helper()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3, err := NewS3(config.S3Config{Endpoint: u.Host, Region: "us-east-1", Bucket: bucket,
+		AccessKeyID: "test", SecretAccessKey: "test-secret", PathStyle: true, Prefix: "reports/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s3
+}
+
+func TestS3(t *testing.T) {
+	fake, server := newFakeS3(t, "bi-reports")
+	s3 := newTestS3(t, server, "bi-reports")
+	ctx := context.Background()
+
+	if err := s3.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestS3(t, server, "missing").Check(ctx); err == nil {
+		t.Fatal("check of a missing bucket succeeded")
+	}
+
+	path := filepath.Join(t.TempDir(), "report.csv")
+	content := []byte("id,region\n1,east\n")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.PutFile(ctx, "12_345.csv", path, "text/csv"); err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := fake.object("reports/12_345.csv")
+	if !ok || !bytes.Equal(obj.data, content) || obj.contentType != "text/csv" {
+		t.Fatalf("stored object %q, %v", obj.data, ok)
+	}
+
+	r, err := s3.Open(ctx, "12_345.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	u, err := s3.PresignGet(ctx, "12_345.csv", "orders.csv", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	presigned, err := url.Parse(u)
+	if err != nil || presigned.Path != "/bi-reports/reports/12_345.csv" || presigned.Query().Get("X-Amz-Signature") == "" ||
+		presigned.Query().Get("response-content-disposition") != "attachment; filename=orders.csv" {
+		t.Fatalf("presigned URL %s", u)
+	}
+
+	if err := s3.Delete(ctx, "12_345.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.object("reports/12_345.csv"); ok {
+		t.Fatal("object was not deleted")
+	}
+	if _, err := s3.Open(ctx, "12_345.csv"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if err := s3.Delete(ctx, "12_345.csv"); err != nil {
+		t.Fatalf("delete of a missing object: %v", err)
+	}
+	if _, err := s3.Open(ctx, "../12_345.csv"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want an invalid key error", err)
+	}
+}
@@ -1,170 +1,65 @@
+// Package storage 保存生成的报表文件。本地存储只适用于单实例部署，
+// 多个 API 实例共享报表文件时使用 S3 兼容的对象存储。
 package storage
 
 import (
+	"context"
 	"errors"
-	"github.com/foldn/bi-go/internal/models"
-	"sync"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/foldn/bi-go/internal/config"
 )
 
-// 内存存储实现
 var (
-	dataSources = make(map[string]*models.DataSource)
-	reports     = make(map[string]*models.Report)
-	reportJobs  = make(map[string]*models.ReportJob)
-	dsLock      = &sync.RWMutex{}
-	reportLock  = &sync.RWMutex{}
-	jobLock     = &sync.RWMutex{}
+	// ErrNotFound 对象不存在
+	ErrNotFound = errors.New("stored file not found")
+	// ErrPresignUnsupported 存储不支持预签名 URL，需由服务转发文件内容
+	ErrPresignUnsupported = errors.New("storage does not support pre-signed URLs")
 )
 
-// 数据源相关操作
-
-// SaveDataSource 保存数据源
-func SaveDataSource(ds *models.DataSource) error {
-	if ds == nil || ds.ID == "" {
-		return errors.New("无效的数据源")
-	}
-
-	dsLock.Lock()
-	defer dsLock.Unlock()
-
-	dataSources[ds.ID] = ds
-	return nil
-}
-
-// GetDataSource 获取数据源
-func GetDataSource(id string) (*models.DataSource, error) {
-	dsLock.RLock()
-	defer dsLock.RUnlock()
-
-	ds, exists := dataSources[id]
-	if !exists {
-		return nil, errors.New("数据源不存在")
-	}
-
-	return ds, nil
-}
-
-// ListDataSources 列出所有数据源
-func ListDataSources() []*models.DataSource {
-	dsLock.RLock()
-	defer dsLock.RUnlock()
-
-	result := make([]*models.DataSource, 0, len(dataSources))
-	for _, ds := range dataSources {
-		result = append(result, ds)
-	}
-
-	return result
-}
-
-// DeleteDataSource 删除数据源
-func DeleteDataSource(id string) error {
-	dsLock.Lock()
-	defer dsLock.Unlock()
-
-	_, exists := dataSources[id]
-	if !exists {
-		return errors.New("数据源不存在")
-	}
-
-	delete(dataSources, id)
-	return nil
-}
-
-// 报表相关操作
-
-// SaveReport 保存报表
-func SaveReport(report *models.Report) error {
-	if report == nil || report.ID == "" {
-		return errors.New("无效的报表")
-	}
-
-	reportLock.Lock()
-	defer reportLock.Unlock()
-
-	reports[report.ID] = report
-	return nil
-}
-
-// GetReport 获取报表
-func GetReport(id string) (*models.Report, error) {
-	reportLock.RLock()
-	defer reportLock.RUnlock()
-
-	report, exists := reports[id]
-	if !exists {
-		return nil, errors.New("报表不存在")
-	}
-
-	return report, nil
+// Storage 报表文件存储。key 为以 / 分隔的相对路径，如 12_345.csv
+type Storage interface {
+	// PutFile 将本地文件 path 保存为 key，已存在时覆盖。
+	// 本地存储可能直接移动该文件，调用方之后不应再使用 path
+	PutFile(ctx context.Context, key, path, contentType string) error
+	// Open 读取 key 的内容，不存在时返回 ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除 key，不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// PresignGet 返回在 expiry 内有效的下载地址，下载时的文件名为 fileName；
+	// 不支持时返回 ErrPresignUnsupported
+	PresignGet(ctx context.Context, key, fileName string, expiry time.Duration) (string, error)
 }
 
-// ListReports 列出所有报表
-func ListReports() []*models.Report {
-	reportLock.RLock()
-	defer reportLock.RUnlock()
-
-	result := make([]*models.Report, 0, len(reports))
-	for _, report := range reports {
-		result = append(result, report)
+// New 按配置创建存储，本地存储的目录为 report.outputdir；对象存储在创建时检查存储桶可以访问
+func New(cfg config.StorageConfig) (Storage, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "", "local":
+		return NewLocal(config.OutputDir), nil
+	case "s3":
+		s3, err := NewS3(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s3.Check(ctx); err != nil {
+			return nil, err
+		}
+		return s3, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage type %q", cfg.Type)
 	}
-
-	return result
 }
 
-// DeleteReport 删除报表
-func DeleteReport(id string) error {
-	reportLock.Lock()
-	defer reportLock.Unlock()
-
-	_, exists := reports[id]
-	if !exists {
-		return errors.New("报表不存在")
+// validKey 拒绝绝对路径和含 .. 的键，避免本地存储读写目录之外的文件
+func validKey(key string) error {
+	if !fs.ValidPath(key) || key == "." || strings.Contains(key, `\`) {
+		return fmt.Errorf("invalid storage key %q", key)
 	}
-
-	delete(reports, id)
 	return nil
 }
-
-// 报表任务相关操作
-
-// SaveReportJob 保存报表任务
-func SaveReportJob(job *models.ReportJob) error {
-	if job == nil || job.ID == "" {
-		return errors.New("无效的报表任务")
-	}
-
-	jobLock.Lock()
-	defer jobLock.Unlock()
-
-	reportJobs[job.ID] = job
-	return nil
-}
-
-// GetReportJob 获取报表任务
-func GetReportJob(id string) (*models.ReportJob, error) {
-	jobLock.RLock()
-	defer jobLock.RUnlock()
-
-	job, exists := reportJobs[id]
-	if !exists {
-		return nil, errors.New("报表任务不存在")
-	}
-
-	return job, nil
-}
-
-// ListReportJobs 列出特定报表的所有任务
-func ListReportJobs(reportID string) []*models.ReportJob {
-	jobLock.RLock()
-	defer jobLock.RUnlock()
-
-	result := make([]*models.ReportJob, 0)
-	for _, job := range reportJobs {
-		if job.ReportID == reportID {
-			result = append(result, job)
-		}
-	}
-
-	return result
-}
@@ -25,18 +25,38 @@ func PrettyJSON(v interface{}) (string, error) {
 	return string(bytes), nil
 }
 
+// reportFormats 支持的报表格式及下载时使用的 Content-Type
+var reportFormats = map[string]string{
+	"csv":     "text/csv",
+	"json":    "application/json",
+	"ndjson":  "application/x-ndjson",
+	"xlsx":    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"parquet": "application/vnd.apache.parquet",
+	"arrow":   "application/vnd.apache.arrow.file",
+	"html":    "text/html; charset=utf-8",
+	"pdf":     "application/pdf",
+}
+
 // ValidateFormat 验证报表格式是否支持
 func ValidateFormat(format string) bool {
-	validFormats := map[string]bool{
-		"csv":  true,
-		"json": true,
-	}
-	
-	_, ok := validFormats[format]
+	_, ok := reportFormats[format]
 	return ok
 }
 
+// ContentType 报表格式对应的 Content-Type，未知格式返回 application/octet-stream
+func ContentType(format string) string {
+	if contentType, ok := reportFormats[format]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// DownloadFileName 下载或投递报表文件时使用的文件名
+func DownloadFileName(reportID, jobID uint, format string) string {
+	return fmt.Sprintf("report_%d_%d.%s", reportID, jobID, format)
+}
+
 // GenerateFileName 生成报表文件名
 func GenerateFileName(reportID, jobID, format string) string {
 	return fmt.Sprintf("%s_%s_%s.%s", reportID, jobID, time.Now().Format("20060102150405"), format)
-}
\ No newline at end of file
+}